@@ -0,0 +1,679 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 用户角色（权限依次递增）
+const (
+	RoleViewer   = "viewer"   // 查看仪表板和数据接口，限于可见的设备
+	RoleOperator = "operator" // 另外可以查看入库和数据库状态
+	RoleAdmin    = "admin"    // 全部设备和管理接口
+)
+
+// validRoles 支持的角色
+var validRoles = []string{RoleViewer, RoleOperator, RoleAdmin}
+
+// API密钥前缀，与设备令牌区分
+const apiKeyPrefix = "slk_"
+
+// 密码的最小长度
+const minPasswordLength = 8
+
+var (
+	// errUserNotFound 用户不存在
+	errUserNotFound = errors.New("用户不存在")
+	// errUserExists 用户已存在
+	errUserExists = errors.New("用户已存在")
+	// errAPIKeyNotFound API密钥不存在
+	errAPIKeyNotFound = errors.New("API密钥不存在")
+)
+
+// 全局用户账户存储
+var userAccounts *AccountStore
+
+// APIKey 用户的API密钥（只保存SHA-256摘要）
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name,omitempty"`
+	Hash      string     `json:"hash"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// UserAccount 本地用户账户
+type UserAccount struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash"` // bcrypt
+	Role         string    `json:"role"`
+	Devices      []string  `json:"devices,omitempty"` // 可见的设备，"*"表示全部；管理员总是可以看到全部设备
+	Disabled     bool      `json:"disabled,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	APIKeys      []APIKey  `json:"apiKeys,omitempty"`
+}
+
+// Scope 用户可见的设备范围
+func (u UserAccount) Scope() DeviceScope {
+	if u.Role == RoleAdmin {
+		return allDevices
+	}
+	scope := DeviceScope{Devices: make([]string, 0, len(u.Devices))}
+	for _, deviceID := range u.Devices {
+		if deviceID == "*" {
+			return allDevices
+		}
+		scope.Devices = append(scope.Devices, deviceID)
+	}
+	return scope
+}
+
+// principal 由账户生成请求的身份
+func (u UserAccount) principal(method string) Principal {
+	return Principal{Username: u.Username, Role: u.Role, Scope: u.Scope(), Method: method}
+}
+
+// AccountStore 本地用户账户存储（数据目录中的JSON文件）。
+// 文件被命令行修改后由后台检查重新加载
+type AccountStore struct {
+	path string
+
+	mutex   sync.RWMutex
+	users   map[string]UserAccount
+	keys    map[string]string // API密钥摘要 -> 用户名
+	modTime time.Time
+
+	// 串行化文件的读写
+	fileMutex sync.Mutex
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewAccountStore 创建账户存储
+func NewAccountStore(path string) *AccountStore {
+	return &AccountStore{
+		path:  path,
+		users: make(map[string]UserAccount),
+		keys:  make(map[string]string),
+	}
+}
+
+// Start 加载账户，文件修改后在后台重新加载
+func (s *AccountStore) Start() {
+	if err := s.Reload(); err != nil {
+		LogError("加载用户账户", err)
+	}
+
+	s.stopCh = make(chan struct{})
+	s.doneCh = make(chan struct{})
+	go func() {
+		defer close(s.doneCh)
+
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stopCh:
+				return
+			case <-ticker.C:
+				if err := s.reloadIfChanged(); err != nil {
+					LogError("重新加载用户账户", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop 停止后台检查
+func (s *AccountStore) Stop() {
+	if s.stopCh != nil {
+		close(s.stopCh)
+		<-s.doneCh
+	}
+}
+
+// Reload 从文件加载账户
+func (s *AccountStore) Reload() error {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+
+	users, modTime, err := readAccountFile(s.path)
+	if err != nil {
+		return err
+	}
+	s.setUsers(users, modTime)
+	return nil
+}
+
+// reloadIfChanged 文件修改时间变化时重新加载
+func (s *AccountStore) reloadIfChanged() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mutex.RLock()
+	unchanged := info.ModTime().Equal(s.modTime)
+	s.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+	return s.Reload()
+}
+
+// setUsers 替换内存中的账户
+func (s *AccountStore) setUsers(users map[string]UserAccount, modTime time.Time) {
+	keys := make(map[string]string)
+	for _, user := range users {
+		for _, key := range user.APIKeys {
+			if key.RevokedAt == nil {
+				keys[key.Hash] = user.Username
+			}
+		}
+	}
+
+	s.mutex.Lock()
+	s.users, s.keys, s.modTime = users, keys, modTime
+	s.mutex.Unlock()
+}
+
+// Get 获取用户账户
+func (s *AccountStore) Get(username string) (UserAccount, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	user, ok := s.users[username]
+	return user, ok
+}
+
+// List 按用户名列出账户
+func (s *AccountStore) List() []UserAccount {
+	s.mutex.RLock()
+	users := make([]UserAccount, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+// Empty 是否还没有任何账户
+func (s *AccountStore) Empty() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.users) == 0
+}
+
+// dummyPasswordHash 用户不存在时也执行一次bcrypt比较，避免通过响应时间判断用户是否存在
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("sensor-logger-dummy-password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// CheckPassword 校验用户名和密码，返回未禁用的账户
+func (s *AccountStore) CheckPassword(username, password string) (UserAccount, bool) {
+	user, ok := s.Get(username)
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return UserAccount{}, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil || user.Disabled {
+		return UserAccount{}, false
+	}
+	return user, true
+}
+
+// AuthenticateAPIKey 校验API密钥，返回未禁用的账户
+func (s *AccountStore) AuthenticateAPIKey(key string) (UserAccount, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return UserAccount{}, false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	username, ok := s.keys[hashSecret(key)]
+	if !ok {
+		return UserAccount{}, false
+	}
+	user, ok := s.users[username]
+	if !ok || user.Disabled {
+		return UserAccount{}, false
+	}
+	return user, true
+}
+
+// AddUser 创建用户
+func (s *AccountStore) AddUser(username, password, role string, devices []string) error {
+	if username == "" || strings.ContainsAny(username, " \t\r\n") {
+		return errors.New("用户名不能为空或包含空白字符")
+	}
+	if !isValidRole(role) {
+		return fmt.Errorf("无效的角色: %s，支持的取值: %v", role, validRoles)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(users map[string]UserAccount) error {
+		if _, exists := users[username]; exists {
+			return errUserExists
+		}
+		users[username] = UserAccount{
+			Username:     username,
+			PasswordHash: hash,
+			Role:         role,
+			Devices:      devices,
+			CreatedAt:    time.Now().UTC().Truncate(time.Second),
+		}
+		return nil
+	})
+}
+
+// UpdateUser 修改用户的角色、可见设备或禁用状态（参数为nil表示不修改）
+func (s *AccountStore) UpdateUser(username string, role *string, devices []string, disabled *bool) error {
+	if role != nil && !isValidRole(*role) {
+		return fmt.Errorf("无效的角色: %s，支持的取值: %v", *role, validRoles)
+	}
+
+	return s.update(func(users map[string]UserAccount) error {
+		user, ok := users[username]
+		if !ok {
+			return errUserNotFound
+		}
+		if role != nil {
+			user.Role = *role
+		}
+		if devices != nil {
+			user.Devices = devices
+		}
+		if disabled != nil {
+			user.Disabled = *disabled
+		}
+		users[username] = user
+		return nil
+	})
+}
+
+// SetPassword 修改密码
+func (s *AccountStore) SetPassword(username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(users map[string]UserAccount) error {
+		user, ok := users[username]
+		if !ok {
+			return errUserNotFound
+		}
+		user.PasswordHash = hash
+		users[username] = user
+		return nil
+	})
+}
+
+// RemoveUser 删除用户（其API密钥同时失效）
+func (s *AccountStore) RemoveUser(username string) error {
+	return s.update(func(users map[string]UserAccount) error {
+		if _, ok := users[username]; !ok {
+			return errUserNotFound
+		}
+		delete(users, username)
+		return nil
+	})
+}
+
+// CreateAPIKey 为用户创建API密钥，返回的明文密钥只在创建时返回一次
+func (s *AccountStore) CreateAPIKey(username, name string) (string, APIKey, error) {
+	var secret [24]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return "", APIKey{}, fmt.Errorf("生成API密钥失败: %v", err)
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", APIKey{}, fmt.Errorf("生成API密钥失败: %v", err)
+	}
+
+	plain := apiKeyPrefix + hex.EncodeToString(secret[:])
+	key := APIKey{
+		ID:        hex.EncodeToString(id[:]),
+		Name:      name,
+		Hash:      hashSecret(plain),
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	err := s.update(func(users map[string]UserAccount) error {
+		user, ok := users[username]
+		if !ok {
+			return errUserNotFound
+		}
+		user.APIKeys = append(user.APIKeys, key)
+		users[username] = user
+		return nil
+	})
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	return plain, key, nil
+}
+
+// RevokeAPIKey 吊销API密钥
+func (s *AccountStore) RevokeAPIKey(id string) error {
+	return s.update(func(users map[string]UserAccount) error {
+		for username, user := range users {
+			for i, key := range user.APIKeys {
+				if key.ID != id {
+					continue
+				}
+				if key.RevokedAt == nil {
+					revokedAt := time.Now().UTC().Truncate(time.Second)
+					user.APIKeys[i].RevokedAt = &revokedAt
+					users[username] = user
+				}
+				return nil
+			}
+		}
+		return errAPIKeyNotFound
+	})
+}
+
+// update 读取最新的账户文件，修改后写回
+func (s *AccountStore) update(modify func(users map[string]UserAccount) error) error {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+
+	users, _, err := readAccountFile(s.path)
+	if err != nil {
+		return err
+	}
+	if err := modify(users); err != nil {
+		return err
+	}
+	modTime, err := writeAccountFile(s.path, users)
+	if err != nil {
+		return err
+	}
+	s.setUsers(users, modTime)
+	return nil
+}
+
+// isValidRole 判断角色是否有效
+func isValidRole(role string) bool {
+	for _, valid := range validRoles {
+		if role == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// roleLevel 角色的权限等级
+func roleLevel(role string) int {
+	for i, valid := range validRoles {
+		if role == valid {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// hashPassword 计算密码的bcrypt摘要
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("密码至少需要%d个字符", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("计算密码摘要失败: %v", err)
+	}
+	return string(hash), nil
+}
+
+// readAccountFile 读取账户文件，文件不存在时返回空集合
+func readAccountFile(path string) (map[string]UserAccount, time.Time, error) {
+	users := make(map[string]UserAccount)
+
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return users, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("读取用户账户文件失败: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("读取用户账户文件失败: %v", err)
+	}
+
+	var list []UserAccount
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, time.Time{}, fmt.Errorf("解析用户账户文件失败: %v", err)
+	}
+	for _, user := range list {
+		users[user.Username] = user
+	}
+	return users, info.ModTime(), nil
+}
+
+// writeAccountFile 写入账户文件（先写临时文件再替换），返回新的修改时间
+func writeAccountFile(path string, users map[string]UserAccount) (time.Time, error) {
+	list := make([]UserAccount, 0, len(users))
+	for _, user := range users {
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return time.Time{}, fmt.Errorf("创建数据目录失败: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return time.Time{}, fmt.Errorf("写入用户账户文件失败: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return time.Time{}, fmt.Errorf("写入用户账户文件失败: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// userAccountPath 账户文件的路径
+func userAccountPath() string {
+	return filepath.Join(AppConfig.DataDir, "users.json")
+}
+
+// parseDeviceList 解析逗号分隔的设备列表
+func parseDeviceList(value string) []string {
+	devices := make([]string, 0)
+	for _, deviceID := range strings.Split(value, ",") {
+		if deviceID = strings.TrimSpace(deviceID); deviceID != "" {
+			devices = append(devices, deviceID)
+		}
+	}
+	return devices
+}
+
+// readPassword 从标准输入读取一行密码（便于 echo "..." | sensor-logger-server user add ...）
+func readPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("未读取到密码")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// runUserCommand 管理本地用户和API密钥
+func runUserCommand(args []string) int {
+	usage := "用法: user add|update|passwd|remove|list|apikey-create|apikey-revoke [参数]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	username := flags.String("name", "", "用户名")
+	role := flags.String("role", "", "角色（viewer、operator、admin）")
+	devices := flags.String("devices", "", "可见的设备ID，逗号分隔，*表示全部")
+	disable := flags.Bool("disable", false, "禁用用户")
+	enable := flags.Bool("enable", false, "启用用户")
+	keyName := flags.String("key-name", "", "API密钥说明")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	AppConfig.EnableLogging = false
+	store := NewAccountStore(userAccountPath())
+	if err := store.Reload(); err != nil {
+		fmt.Fprintf(os.Stderr, "加载用户账户失败: %v\n", err)
+		return 1
+	}
+
+	requireName := func() bool {
+		if *username == "" {
+			fmt.Fprintln(os.Stderr, "请使用 -name 指定用户名")
+			return false
+		}
+		return true
+	}
+
+	var err error
+	switch args[0] {
+	case "add":
+		if !requireName() {
+			return 2
+		}
+		if *role == "" {
+			*role = RoleViewer
+		}
+		var password string
+		if password, err = readPassword("密码: "); err == nil {
+			err = store.AddUser(*username, password, *role, parseDeviceList(*devices))
+		}
+		if err == nil {
+			fmt.Printf("已创建用户 %s（%s）\n", *username, *role)
+		}
+
+	case "update":
+		if !requireName() {
+			return 2
+		}
+		var rolePtr *string
+		if *role != "" {
+			rolePtr = role
+		}
+		var deviceList []string
+		if *devices != "" {
+			deviceList = parseDeviceList(*devices)
+		}
+		var disabled *bool
+		if *disable || *enable {
+			disabled = disable
+		}
+		if err = store.UpdateUser(*username, rolePtr, deviceList, disabled); err == nil {
+			fmt.Printf("已更新用户 %s\n", *username)
+		}
+
+	case "passwd":
+		if !requireName() {
+			return 2
+		}
+		var password string
+		if password, err = readPassword("新密码: "); err == nil {
+			err = store.SetPassword(*username, password)
+		}
+		if err == nil {
+			fmt.Printf("已修改用户 %s 的密码\n", *username)
+		}
+
+	case "remove":
+		if !requireName() {
+			return 2
+		}
+		if err = store.RemoveUser(*username); err == nil {
+			fmt.Printf("已删除用户 %s\n", *username)
+		}
+
+	case "list":
+		for _, user := range store.List() {
+			status := ""
+			if user.Disabled {
+				status = "（已禁用）"
+			}
+			active := 0
+			for _, key := range user.APIKeys {
+				if key.RevokedAt == nil {
+					active++
+				}
+			}
+			fmt.Printf("%-16s  %-8s  设备: %-32s  API密钥: %d%s\n",
+				user.Username, user.Role, strings.Join(user.Devices, ","), active, status)
+		}
+
+	case "apikey-create":
+		if !requireName() {
+			return 2
+		}
+		var plain string
+		var key APIKey
+		if plain, key, err = store.CreateAPIKey(*username, *keyName); err == nil {
+			fmt.Printf("API密钥ID: %s\nAPI密钥: %s\n", key.ID, plain)
+			fmt.Println("请求时携带 Authorization: Bearer <API密钥> 或 X-API-Key 头，密钥只显示这一次")
+		}
+
+	case "apikey-revoke":
+		if flags.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "请指定要吊销的API密钥ID")
+			return 2
+		}
+		if err = store.RevokeAPIKey(flags.Arg(0)); err == nil {
+			fmt.Printf("已吊销API密钥 %s\n", flags.Arg(0))
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "操作失败: %v\n", err)
+		return 1
+	}
+	if args[0] != "list" {
+		Logger.Info("用户账户已修改",
+			slog.String("action", args[0]),
+			slog.String("username", *username))
+	}
+	return 0
+}
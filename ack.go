@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SinkStatus 单个存储的写入结果
+type SinkStatus string
+
+const (
+	SinkOK       SinkStatus = "ok"       // 写入成功
+	SinkFailed   SinkStatus = "failed"   // 写入失败
+	SinkQueued   SinkStatus = "queued"   // 已进入异步队列，尚未写入
+	SinkSpooled  SinkStatus = "spooled"  // MongoDB未确认，已写入写前缓冲等待回放
+	SinkDisabled SinkStatus = "disabled" // 未启用
+	SinkSkipped  SinkStatus = "skipped"  // 重复消息或不满足持久化要求，未写入
+)
+
+// SinkResults 各存储的写入结果
+type SinkResults struct {
+	Memory SinkStatus `json:"memory"`
+	Mongo  SinkStatus `json:"mongo"`
+	File   SinkStatus `json:"file"`
+}
+
+// IngestAck /data 的确认响应
+type IngestAck struct {
+	Message          string      `json:"message"`
+	IngestID         string      `json:"ingestId"`
+	MessageID        int64       `json:"messageId"`
+	SessionID        string      `json:"sessionId"`
+	DeviceID         string      `json:"deviceId"`
+	Duplicate        bool        `json:"duplicate"`
+	ReadingsAccepted int         `json:"readingsAccepted"`
+	ReadingsRejected int         `json:"readingsRejected"`
+	SensorTypes      []string    `json:"sensorTypes"`
+	Sinks            SinkResults `json:"sinks"`
+	ServerTime       time.Time   `json:"serverTime"`
+
+	// 校验未通过时的违规详情（warn/strip模式下消息仍被接收）或数据质量警告
+	Validation *ValidationReport `json:"validation,omitempty"`
+}
+
+// newIngestAck 根据解析后的数据生成确认响应
+func newIngestAck(ingestID string, parsedData *ParsedSensorData, sinks SinkResults) IngestAck {
+	sensorTypes := parsedData.SensorTypes
+	if sensorTypes == nil {
+		sensorTypes = []string{}
+	}
+
+	return IngestAck{
+		Message:          "数据接收成功",
+		IngestID:         ingestID,
+		MessageID:        parsedData.MessageID,
+		SessionID:        parsedData.SessionID,
+		DeviceID:         parsedData.DeviceID,
+		ReadingsAccepted: parsedData.TotalReadings,
+		SensorTypes:      sensorTypes,
+		Sinks:            sinks,
+		ServerTime:       time.Now(),
+	}
+}
+
+// newDuplicateAck 生成重复消息的确认响应
+func newDuplicateAck(ingestID string, parsedData *ParsedSensorData) IngestAck {
+	ack := newIngestAck(ingestID, parsedData, SinkResults{Memory: SinkSkipped, Mongo: SinkSkipped, File: SinkSkipped})
+	ack.Message = "数据接收成功（重复消息已忽略）"
+	ack.Duplicate = true
+	ack.ReadingsAccepted = 0
+	return ack
+}
+
+// newIngestID 生成服务端入库ID（时间前缀便于排序和检索日志）
+func newIngestID() string {
+	var random [6]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405"), time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405"), hex.EncodeToString(random[:]))
+}
+
+// writeAck 按Accept头返回确认响应：默认JSON，客户端明确偏好纯文本时返回原有的文本响应
+func writeAck(w http.ResponseWriter, r *http.Request, status int, ack IngestAck) {
+	w.Header().Set("X-Ingest-Id", ack.IngestID)
+
+	if prefersPlainText(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write([]byte(ack.Message))
+		return
+	}
+	writeJSON(w, status, ack)
+}
+
+// prefersPlainText 判断Accept头是否偏好text/plain而不是application/json
+func prefersPlainText(accept string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return false
+	}
+
+	jsonQuality, textQuality := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			jsonQuality = max(jsonQuality, quality)
+		}
+		switch mediaType {
+		case "text/plain", "text/*", "*/*":
+			textQuality = max(textQuality, quality)
+		}
+	}
+
+	return textQuality > jsonQuality
+}
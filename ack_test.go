@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrefersPlainText(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"text/plain", true},
+		{"text/*", true},
+		{"text/plain, application/json", false},
+		{"application/json;q=0.5, text/plain", true},
+		{"text/plain;q=0.2, */*;q=0.8", false},
+		{"text/html", false},
+	}
+
+	for _, tt := range tests {
+		if result := prefersPlainText(tt.accept); result != tt.expected {
+			t.Errorf("prefersPlainText(%q) = %v, 期望 %v", tt.accept, result, tt.expected)
+		}
+	}
+}
+
+func TestHandleSensorDataAck(t *testing.T) {
+	originalDedup, originalPipeline := messageDeduplicator, ingestPipeline
+	originalLogging := AppConfig.EnableLogging
+	defer func() {
+		messageDeduplicator, ingestPipeline = originalDedup, originalPipeline
+		AppConfig.EnableLogging = originalLogging
+	}()
+
+	messageDeduplicator = NewMessageDeduplicator(100)
+	ingestPipeline = nil
+	AppConfig.EnableLogging = false
+
+	req := httptest.NewRequest("POST", "/data", bytes.NewReader(buildTestMessage(3)))
+	rr := httptest.NewRecorder()
+	handleSensorData(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("期望状态码200，实际为%d", rr.Code)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("期望JSON响应，实际Content-Type为%s", contentType)
+	}
+
+	var ack IngestAck
+	if err := json.Unmarshal(rr.Body.Bytes(), &ack); err != nil {
+		t.Fatalf("响应不是JSON: %v", err)
+	}
+	if ack.IngestID == "" || ack.IngestID != rr.Header().Get("X-Ingest-Id") {
+		t.Errorf("入库ID缺失或与响应头不一致: %q / %q", ack.IngestID, rr.Header().Get("X-Ingest-Id"))
+	}
+	if ack.MessageID != 7 || ack.SessionID != "decode-session" || ack.ReadingsAccepted != 3 {
+		t.Errorf("确认内容不正确: %+v", ack)
+	}
+	if len(ack.SensorTypes) == 0 {
+		t.Error("确认响应应包含传感器类型")
+	}
+	if ack.Sinks.Memory != SinkOK || ack.Sinks.File != SinkOK {
+		t.Errorf("同步写入时内存和文件应成功: %+v", ack.Sinks)
+	}
+	if ack.ServerTime.IsZero() {
+		t.Error("确认响应应包含服务器时间")
+	}
+}
+
+func TestHandleSensorDataPlainTextAck(t *testing.T) {
+	originalDedup, originalPipeline := messageDeduplicator, ingestPipeline
+	originalLogging := AppConfig.EnableLogging
+	defer func() {
+		messageDeduplicator, ingestPipeline = originalDedup, originalPipeline
+		AppConfig.EnableLogging = originalLogging
+	}()
+
+	messageDeduplicator = NewMessageDeduplicator(100)
+	ingestPipeline = nil
+	AppConfig.EnableLogging = false
+
+	req := httptest.NewRequest("POST", "/data", bytes.NewReader(buildTestMessage(1)))
+	req.Header.Set("Accept", "text/plain")
+	rr := httptest.NewRecorder()
+	handleSensorData(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("期望状态码200，实际为%d", rr.Code)
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("期望纯文本响应，实际Content-Type为%s", rr.Header().Get("Content-Type"))
+	}
+	if rr.Body.String() != "数据接收成功" {
+		t.Errorf("期望响应为 数据接收成功，实际为 %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// withAdminAuth 管理接口鉴权：要求 Authorization: Bearer <ADMIN_TOKEN>，未配置令牌时管理接口禁用。
+// 启用用户鉴权时，admin角色的用户（登录会话或API密钥）也可以访问
+func withAdminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+
+		if AppConfig.EnableAuth {
+			if principal, ok := authenticateRequest(r); ok && principal.HasRole(RoleAdmin) {
+				next(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal)))
+				return
+			}
+		}
+
+		if AppConfig.AdminToken == "" {
+			http.Error(w, "管理接口未启用", http.StatusForbidden)
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusForbidden, time.Since(startTime))
+			return
+		}
+
+		token, ok := bearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(AppConfig.AdminToken)) != 1 {
+			Logger.Warn("管理接口鉴权失败",
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("path", r.URL.Path))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "未授权", http.StatusUnauthorized)
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusUnauthorized, time.Since(startTime))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// bearerToken 从Authorization头中取出Bearer令牌
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
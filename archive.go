@@ -0,0 +1,734 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 全局原始数据归档（为nil时不写入原始数据）
+var rawArchive *Archive
+
+// 归档段的压缩方式
+const (
+	ArchiveCompressNone = "none"
+	ArchiveCompressGzip = "gzip"
+	ArchiveCompressZstd = "zstd"
+)
+
+// validArchiveCompressions 支持的压缩方式
+var validArchiveCompressions = []string{ArchiveCompressNone, ArchiveCompressGzip, ArchiveCompressZstd}
+
+// 归档的同步策略
+const (
+	ArchiveFsyncAlways   = "always"   // 每条消息写入后同步到磁盘
+	ArchiveFsyncInterval = "interval" // 后台每秒同步一次（默认）
+	ArchiveFsyncNever    = "never"    // 由操作系统决定何时写入磁盘
+)
+
+// validArchiveFsyncModes 支持的同步策略
+var validArchiveFsyncModes = []string{ArchiveFsyncAlways, ArchiveFsyncInterval, ArchiveFsyncNever}
+
+const (
+	archiveSegmentPrefix = "segment-"
+	archiveSegmentExt    = ".ndjson"
+	archiveIndexDir      = "index"
+	// 正在写入的段带有该后缀，关闭时去掉；其他进程（服务器、命令行导入）只会压缩已关闭的段
+	archiveOpenExt = ".open"
+
+	// 后台检查间隔（interval同步、按时间轮转）
+	archiveTickInterval = time.Second
+)
+
+// ArchiveOptions 归档配置
+type ArchiveOptions struct {
+	Dir             string
+	MaxSegmentBytes int64         // 段达到该大小后轮转
+	MaxSegmentAge   time.Duration // 段打开超过该时间后轮转
+	Compression     string        // 关闭的段的压缩方式
+	Fsync           string        // 同步策略
+}
+
+// ArchiveRecord 归档中的一条记录（NDJSON的一行）
+type ArchiveRecord struct {
+	ReceivedAt time.Time       `json:"receivedAt"`
+	Message    json.RawMessage `json:"message"` // 解压后的原始请求体（已去除空白）
+}
+
+// ArchiveLocation 消息在归档中的位置（索引中的一行）
+type ArchiveLocation struct {
+	MessageID  int64     `json:"messageId"`
+	DeviceID   string    `json:"deviceId"`
+	Segment    string    `json:"segment"` // 相对归档目录的段路径（未压缩时的文件名）
+	Offset     int64     `json:"offset"`  // 记录在未压缩段中的字节偏移
+	Length     int       `json:"length"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// ArchiveStats 归档统计信息
+type ArchiveStats struct {
+	Dir          string `json:"dir"`
+	OpenSegments int    `json:"openSegments"`
+	Records      int64  `json:"records"`
+	Bytes        int64  `json:"bytes"`
+	Rotations    int64  `json:"rotations"`
+	Compressed   int64  `json:"compressed"`
+	Compression  string `json:"compression"`
+	Fsync        string `json:"fsync"`
+	LastError    string `json:"lastError,omitempty"`
+}
+
+// archiveSegment 正在写入的段（每个段只属于一个会话）
+type archiveSegment struct {
+	relPath  string
+	file     *os.File
+	index    *os.File
+	size     int64
+	openedAt time.Time
+	dirty    bool
+}
+
+// Archive 原始数据归档：按 日期/设备/会话 分区追加写入NDJSON段，
+// 段按大小和时间轮转，关闭后可压缩；每个会话有一个索引文件记录消息所在的段和偏移
+type Archive struct {
+	opts ArchiveOptions
+
+	mutex    sync.Mutex
+	segments map[string]*archiveSegment // 键为分区目录（相对路径）
+
+	records    atomic.Int64
+	bytes      atomic.Int64
+	rotations  atomic.Int64
+	compressed atomic.Int64
+
+	errorMutex sync.Mutex
+	lastError  string
+
+	compressWG sync.WaitGroup
+	stopCh     chan struct{}
+	doneCh     chan struct{}
+}
+
+// NewArchive 创建归档
+func NewArchive(opts ArchiveOptions) (*Archive, error) {
+	if err := os.MkdirAll(filepath.Join(opts.Dir, archiveIndexDir), 0755); err != nil {
+		return nil, fmt.Errorf("创建归档目录失败: %v", err)
+	}
+	if opts.Compression == "" {
+		opts.Compression = ArchiveCompressNone
+	}
+	if opts.Fsync == "" {
+		opts.Fsync = ArchiveFsyncInterval
+	}
+
+	return &Archive{
+		opts:     opts,
+		segments: make(map[string]*archiveSegment),
+	}, nil
+}
+
+// Start 启动后台协程（interval同步、按时间轮转），并压缩遗留的未压缩段
+// 同一目录可能有其他进程正在写入，只处理已关闭的段；崩溃遗留的未关闭段超过两倍轮转时间没有修改时才收尾
+func (a *Archive) Start() {
+	a.stopCh = make(chan struct{})
+	a.doneCh = make(chan struct{})
+
+	segments, err := archiveSegmentFiles(a.opts.Dir)
+	if err != nil {
+		a.recordError(err)
+	}
+	for _, path := range segments {
+		if filepath.Ext(path) == archiveOpenExt {
+			info, err := os.Stat(path)
+			if err != nil || time.Since(info.ModTime()) < 2*a.opts.MaxSegmentAge {
+				continue
+			}
+			closed := strings.TrimSuffix(path, archiveOpenExt)
+			if err := os.Rename(path, closed); err != nil {
+				a.recordError(fmt.Errorf("关闭遗留的归档段失败: %v", err))
+				continue
+			}
+			path = closed
+		}
+		if filepath.Ext(path) == archiveSegmentExt && a.opts.Compression != ArchiveCompressNone {
+			a.compressWG.Add(1)
+			go a.compressSegment(path)
+		}
+	}
+
+	go a.run()
+}
+
+// run 后台检查：同步有未落盘数据的段，关闭超时的段
+func (a *Archive) run() {
+	defer close(a.doneCh)
+
+	ticker := time.NewTicker(archiveTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stopCh:
+			return
+		case now := <-ticker.C:
+			a.maintain(now)
+		}
+	}
+}
+
+// maintain 执行一次后台检查
+func (a *Archive) maintain(now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for key, segment := range a.segments {
+		if now.Sub(segment.openedAt) >= a.opts.MaxSegmentAge {
+			a.closeSegment(key, segment)
+			continue
+		}
+		if segment.dirty && a.opts.Fsync == ArchiveFsyncInterval {
+			a.syncSegment(segment)
+		}
+	}
+}
+
+// Close 关闭所有段并等待压缩完成，返回同步或关闭段时遇到的错误（压缩失败时保留未压缩的段，不视为错误）
+func (a *Archive) Close() error {
+	if a.stopCh != nil {
+		close(a.stopCh)
+		<-a.doneCh
+	}
+
+	var errs []error
+	a.mutex.Lock()
+	for key, segment := range a.segments {
+		if err := a.closeSegment(key, segment); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	a.mutex.Unlock()
+
+	a.compressWG.Wait()
+	return errors.Join(errs...)
+}
+
+// Append 追加一条原始消息，返回其在归档中的位置
+func (a *Archive) Append(parsedData *ParsedSensorData, body []byte) (ArchiveLocation, error) {
+	line, err := encodeArchiveRecord(parsedData.ReceivedAt, body)
+	if err != nil {
+		return ArchiveLocation{}, err
+	}
+
+	partition := filepath.Join(
+		parsedData.ReceivedAt.UTC().Format("2006-01-02"),
+		safeFileComponent(parsedData.DeviceID),
+		safeFileComponent(parsedData.SessionID))
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	segment := a.segments[partition]
+	if segment != nil && segment.size > 0 &&
+		(segment.size+int64(len(line)) > a.opts.MaxSegmentBytes || time.Since(segment.openedAt) >= a.opts.MaxSegmentAge) {
+		a.closeSegment(partition, segment)
+		a.rotations.Add(1)
+		segment = nil
+	}
+	if segment == nil {
+		a.closeStalePartitions(partition)
+		if segment, err = a.openSegment(partition, parsedData.SessionID); err != nil {
+			a.recordError(err)
+			return ArchiveLocation{}, err
+		}
+		a.segments[partition] = segment
+	}
+
+	location := ArchiveLocation{
+		MessageID:  parsedData.MessageID,
+		DeviceID:   parsedData.DeviceID,
+		Segment:    filepath.ToSlash(segment.relPath),
+		Offset:     segment.size,
+		Length:     len(line),
+		ReceivedAt: parsedData.ReceivedAt,
+	}
+
+	n, err := segment.file.Write(line)
+	segment.size += int64(n)
+	if err != nil {
+		err = fmt.Errorf("写入归档段失败: %v", err)
+		a.recordError(err)
+		// 不完整的记录会在读取时跳过，后续消息写入新段
+		a.closeSegment(partition, segment)
+		return ArchiveLocation{}, err
+	}
+
+	indexLine, _ := json.Marshal(location)
+	if _, err := segment.index.Write(append(indexLine, '\n')); err != nil {
+		// 索引只用于定位，写入失败不影响数据本身
+		a.recordError(fmt.Errorf("写入归档索引失败: %v", err))
+	}
+
+	segment.dirty = true
+	if a.opts.Fsync == ArchiveFsyncAlways {
+		if err := a.syncSegment(segment); err != nil {
+			return ArchiveLocation{}, err
+		}
+	}
+
+	a.records.Add(1)
+	a.bytes.Add(int64(n))
+	return location, nil
+}
+
+// closeStalePartitions 会话进入新的日期分区时关闭其在其他日期分区中的段（调用方持有锁），
+// 不再写入的段不必等到轮转时间
+func (a *Archive) closeStalePartitions(partition string) {
+	session := archivePartitionSession(partition)
+	for key, segment := range a.segments {
+		if key != partition && archivePartitionSession(key) == session {
+			a.closeSegment(key, segment)
+		}
+	}
+}
+
+// archivePartitionSession 分区中 设备/会话 的部分（去掉日期）
+func archivePartitionSession(partition string) string {
+	_, session, _ := strings.Cut(partition, string(filepath.Separator))
+	return session
+}
+
+// encodeArchiveRecord 将原始消息编码为一行NDJSON（原始JSON去除空白后原样嵌入）
+func encodeArchiveRecord(receivedAt time.Time, body []byte) ([]byte, error) {
+	var message bytes.Buffer
+	if err := json.Compact(&message, body); err != nil {
+		return nil, fmt.Errorf("原始数据不是有效的JSON: %v", err)
+	}
+
+	line, err := json.Marshal(ArchiveRecord{ReceivedAt: receivedAt, Message: message.Bytes()})
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// openSegment 在分区目录中创建下一个段，并打开会话的索引文件
+func (a *Archive) openSegment(partition, sessionID string) (*archiveSegment, error) {
+	dir := filepath.Join(a.opts.Dir, partition)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建归档分区失败: %v", err)
+	}
+
+	seq, err := nextSegmentSeq(dir)
+	if err != nil {
+		return nil, err
+	}
+	// 其他进程可能同时在该分区创建段，序号被占用时顺延
+	var relPath string
+	var file *os.File
+	for {
+		relPath = filepath.Join(partition, fmt.Sprintf("%s%06d%s", archiveSegmentPrefix, seq, archiveSegmentExt))
+		file, err = os.OpenFile(filepath.Join(a.opts.Dir, relPath)+archiveOpenExt, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0644)
+		if !errors.Is(err, fs.ErrExist) {
+			break
+		}
+		seq++
+	}
+	if err != nil {
+		return nil, fmt.Errorf("创建归档段失败: %v", err)
+	}
+
+	index, err := os.OpenFile(archiveIndexPath(a.opts.Dir, sessionID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("打开归档索引失败: %v", err)
+	}
+
+	now := time.Now()
+	return &archiveSegment{relPath: relPath, file: file, index: index, openedAt: now}, nil
+}
+
+// nextSegmentSeq 返回分区目录中下一个段的序号（包括已压缩的段）
+func nextSegmentSeq(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("读取归档分区失败: %v", err)
+	}
+
+	next := 1
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, archiveSegmentPrefix) {
+			continue
+		}
+		digits := strings.TrimPrefix(name, archiveSegmentPrefix)
+		if end := strings.IndexByte(digits, '.'); end >= 0 {
+			digits = digits[:end]
+		}
+		if seq, err := strconv.Atoi(digits); err == nil && seq >= next {
+			next = seq + 1
+		}
+	}
+	return next, nil
+}
+
+// syncSegment 将段和索引同步到磁盘
+func (a *Archive) syncSegment(segment *archiveSegment) error {
+	segment.dirty = false
+	if err := segment.file.Sync(); err != nil {
+		err = fmt.Errorf("同步归档段失败: %v", err)
+		a.recordError(err)
+		return err
+	}
+	segment.index.Sync()
+	return nil
+}
+
+// closeSegment 关闭段（调用方持有锁）并去掉未关闭的后缀，需要压缩时在后台压缩；返回同步或关闭段时遇到的第一个错误
+func (a *Archive) closeSegment(key string, segment *archiveSegment) error {
+	delete(a.segments, key)
+
+	var firstErr error
+	if segment.dirty && a.opts.Fsync != ArchiveFsyncNever {
+		firstErr = a.syncSegment(segment)
+	}
+	if err := segment.file.Close(); err != nil && firstErr == nil {
+		firstErr = fmt.Errorf("关闭归档段失败: %v", err)
+		a.recordError(firstErr)
+	}
+	if err := segment.index.Close(); err != nil && firstErr == nil {
+		firstErr = fmt.Errorf("关闭归档索引失败: %v", err)
+		a.recordError(firstErr)
+	}
+
+	path := filepath.Join(a.opts.Dir, segment.relPath)
+	if err := os.Rename(path+archiveOpenExt, path); err != nil {
+		// 保留未关闭的后缀，段仍可读取，只是不会被压缩
+		err = fmt.Errorf("关闭归档段失败: %v", err)
+		a.recordError(err)
+		if firstErr == nil {
+			firstErr = err
+		}
+		return firstErr
+	}
+
+	if a.opts.Compression != ArchiveCompressNone && segment.size > 0 {
+		a.compressWG.Add(1)
+		go a.compressSegment(path)
+	}
+	return firstErr
+}
+
+// compressSegment 压缩已关闭的段：写入临时文件并同步后再替换原文件
+func (a *Archive) compressSegment(path string) {
+	defer a.compressWG.Done()
+
+	if err := compressFile(path, a.opts.Compression); err != nil {
+		a.recordError(fmt.Errorf("压缩归档段失败: %v", err))
+		Logger.Warn("压缩归档段失败，保留未压缩的段",
+			slog.String("path", path),
+			slog.String("error", err.Error()))
+		return
+	}
+	a.compressed.Add(1)
+}
+
+// compressFile 将文件压缩为 path+扩展名，成功后删除原文件
+func compressFile(path, compression string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	target := path + archiveCompressionExt(compression)
+	tmp := target + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	var encoder io.WriteCloser
+	switch compression {
+	case ArchiveCompressGzip:
+		encoder = gzip.NewWriter(dst)
+	case ArchiveCompressZstd:
+		if encoder, err = zstd.NewWriter(dst); err != nil {
+			dst.Close()
+			return err
+		}
+	default:
+		dst.Close()
+		return fmt.Errorf("不支持的压缩方式: %s", compression)
+	}
+
+	if _, err := io.Copy(encoder, src); err != nil {
+		encoder.Close()
+		dst.Close()
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// archiveCompressionExt 压缩后的文件扩展名
+func archiveCompressionExt(compression string) string {
+	switch compression {
+	case ArchiveCompressGzip:
+		return ".gz"
+	case ArchiveCompressZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// recordError 记录最近一次错误
+func (a *Archive) recordError(err error) {
+	a.errorMutex.Lock()
+	a.lastError = err.Error()
+	a.errorMutex.Unlock()
+}
+
+// Stats 返回归档统计信息
+func (a *Archive) Stats() ArchiveStats {
+	a.mutex.Lock()
+	openSegments := len(a.segments)
+	a.mutex.Unlock()
+
+	a.errorMutex.Lock()
+	lastError := a.lastError
+	a.errorMutex.Unlock()
+
+	return ArchiveStats{
+		Dir:          a.opts.Dir,
+		OpenSegments: openSegments,
+		Records:      a.records.Load(),
+		Bytes:        a.bytes.Load(),
+		Rotations:    a.rotations.Load(),
+		Compressed:   a.compressed.Load(),
+		Compression:  a.opts.Compression,
+		Fsync:        a.opts.Fsync,
+		LastError:    lastError,
+	}
+}
+
+// archiveIndexPath 会话索引文件的路径
+func archiveIndexPath(dir, sessionID string) string {
+	return filepath.Join(dir, archiveIndexDir, safeFileComponent(sessionID)+archiveSegmentExt)
+}
+
+// LocateArchivedMessage 通过会话索引查找消息在归档中的位置（同一消息多次写入时返回最后一次）
+func LocateArchivedMessage(dir, sessionID string, messageID int64) (ArchiveLocation, bool, error) {
+	file, err := os.Open(archiveIndexPath(dir, sessionID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ArchiveLocation{}, false, nil
+	}
+	if err != nil {
+		return ArchiveLocation{}, false, err
+	}
+	defer file.Close()
+
+	var found ArchiveLocation
+	ok := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var location ArchiveLocation
+		if json.Unmarshal(scanner.Bytes(), &location) != nil {
+			continue // 崩溃时写了一半的索引行
+		}
+		if location.MessageID == messageID {
+			found, ok = location, true
+		}
+	}
+	return found, ok, scanner.Err()
+}
+
+// ReadArchivedRecord 读取指定位置的记录（段已压缩时解压后定位）
+func ReadArchivedRecord(dir string, location ArchiveLocation) (*ArchiveRecord, error) {
+	reader, err := openArchiveSegment(filepath.Join(dir, filepath.FromSlash(location.Segment)))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	if _, err := io.CopyN(io.Discard, reader, location.Offset); err != nil {
+		return nil, fmt.Errorf("定位记录失败: %v", err)
+	}
+	line := make([]byte, location.Length)
+	if _, err := io.ReadFull(reader, line); err != nil {
+		return nil, fmt.Errorf("读取记录失败: %v", err)
+	}
+
+	var record ArchiveRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, fmt.Errorf("解析记录失败: %v", err)
+	}
+	return &record, nil
+}
+
+// openArchiveSegment 打开段用于读取；path为未压缩的文件名，段正在写入时读取未关闭的文件，否则依次尝试压缩后的文件
+func openArchiveSegment(path string) (io.ReadCloser, error) {
+	path = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), ".zst"), archiveOpenExt)
+	if file, err := os.Open(path + archiveOpenExt); err == nil {
+		return file, nil
+	}
+	for _, compression := range validArchiveCompressions {
+		file, err := os.Open(path + archiveCompressionExt(compression))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return newSegmentReader(file, compression)
+	}
+	return nil, fmt.Errorf("归档段不存在: %s", path)
+}
+
+// segmentReader 解压读取段文件，关闭时同时关闭底层文件
+type segmentReader struct {
+	io.Reader
+	closers []func() error
+}
+
+func (r *segmentReader) Close() error {
+	var firstErr error
+	for _, closeFn := range r.closers {
+		if err := closeFn(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// newSegmentReader 按压缩方式包装段文件
+func newSegmentReader(file *os.File, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case ArchiveCompressGzip:
+		decoder, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &segmentReader{Reader: decoder, closers: []func() error{decoder.Close, file.Close}}, nil
+	case ArchiveCompressZstd:
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &segmentReader{Reader: decoder, closers: []func() error{
+			func() error { decoder.Close(); return nil },
+			file.Close,
+		}}, nil
+	default:
+		return file, nil
+	}
+}
+
+// archiveSegmentFiles 按路径顺序（即日期/设备/会话/序号）列出归档中的所有段
+func archiveSegmentFiles(dir string) ([]string, error) {
+	segments := make([]string, 0)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == archiveIndexDir && filepath.Dir(path) == filepath.Clean(dir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := entry.Name()
+		if strings.HasPrefix(name, archiveSegmentPrefix) && !strings.HasSuffix(name, ".tmp") {
+			segments = append(segments, path)
+		}
+		return nil
+	})
+	sort.Strings(segments)
+	return segments, err
+}
+
+// readArchiveSegment 逐条读取段中的记录；无法解析的行（例如崩溃时写了一半的记录）计入返回的损坏数量
+func readArchiveSegment(path string, handle func(record *ArchiveRecord)) (int, error) {
+	reader, err := openArchiveSegment(path)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	corrupt := 0
+	buffered := bufio.NewReader(reader)
+	for {
+		line, err := buffered.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var record ArchiveRecord
+			if json.Unmarshal(line, &record) != nil || len(record.Message) == 0 {
+				corrupt++
+			} else {
+				handle(&record)
+			}
+		}
+		if err == io.EOF {
+			return corrupt, nil
+		}
+		if err != nil {
+			return corrupt, err
+		}
+	}
+}
+
+// currentArchiveOptions 根据配置生成归档选项
+func currentArchiveOptions() ArchiveOptions {
+	return ArchiveOptions{
+		Dir:             filepath.Join(AppConfig.DataDir, "archive"),
+		MaxSegmentBytes: AppConfig.ArchiveSegmentMaxBytes,
+		MaxSegmentAge:   time.Duration(AppConfig.ArchiveSegmentMaxAge) * time.Second,
+		Compression:     AppConfig.ArchiveCompression,
+		Fsync:           AppConfig.ArchiveFsync,
+	}
+}
+
+// startRawArchive 按配置创建并启动全局原始数据归档，失败时记录日志（不写入原始数据）
+func startRawArchive() {
+	archive, err := NewArchive(currentArchiveOptions())
+	if err != nil {
+		Logger.Error("原始数据归档初始化失败", slog.String("error", err.Error()))
+		return
+	}
+	rawArchive = archive
+	rawArchive.Start()
+}
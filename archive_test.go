@@ -0,0 +1,241 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestArchive 创建临时目录中的归档
+func newTestArchive(t *testing.T, maxBytes int64, compression string) *Archive {
+	t.Helper()
+
+	archive, err := NewArchive(ArchiveOptions{
+		Dir:             t.TempDir(),
+		MaxSegmentBytes: maxBytes,
+		MaxSegmentAge:   time.Hour,
+		Compression:     compression,
+		Fsync:           ArchiveFsyncAlways,
+	})
+	if err != nil {
+		t.Fatalf("创建归档失败: %v", err)
+	}
+	return archive
+}
+
+// appendTestMessage 向归档追加一条消息
+func appendTestMessage(t *testing.T, archive *Archive, deviceID, sessionID string, messageID int64) ArchiveLocation {
+	t.Helper()
+
+	parsedData := &ParsedSensorData{
+		MessageID:  messageID,
+		DeviceID:   deviceID,
+		SessionID:  sessionID,
+		ReceivedAt: time.Date(2025, 7, 5, 15, 39, 47, 0, time.UTC),
+	}
+	location, err := archive.Append(parsedData, buildTestMessage(2))
+	if err != nil {
+		t.Fatalf("追加消息失败: %v", err)
+	}
+	return location
+}
+
+func TestArchiveAppendAndLocate(t *testing.T) {
+	archive := newTestArchive(t, 64<<20, ArchiveCompressNone)
+
+	appendTestMessage(t, archive, "device-a", "session-1", 1)
+	appendTestMessage(t, archive, "device-b", "session-2", 1)
+	second := appendTestMessage(t, archive, "device-a", "session-1", 2)
+	archive.Close()
+
+	// 同一秒内的消息不会互相覆盖，按 日期/设备/会话 分区
+	if !strings.HasPrefix(second.Segment, "2025-07-05/device-a_") || second.Offset == 0 {
+		t.Errorf("分区或偏移不正确: %+v", second)
+	}
+	if stats := archive.Stats(); stats.Records != 3 || stats.OpenSegments != 0 {
+		t.Errorf("统计不正确: %+v", stats)
+	}
+
+	location, found, err := LocateArchivedMessage(archive.opts.Dir, "session-1", 2)
+	if err != nil || !found {
+		t.Fatalf("查找消息失败: found=%t err=%v", found, err)
+	}
+	if location != second {
+		t.Errorf("索引中的位置不正确: %+v != %+v", location, second)
+	}
+	if _, found, _ := LocateArchivedMessage(archive.opts.Dir, "session-1", 3); found {
+		t.Error("不存在的消息不应找到")
+	}
+
+	record, err := ReadArchivedRecord(archive.opts.Dir, location)
+	if err != nil {
+		t.Fatalf("读取记录失败: %v", err)
+	}
+	parsedData, err := parseSensorMessage(record.Message)
+	if err != nil || parsedData.TotalReadings != 2 {
+		t.Errorf("归档中的原始消息不完整: %v", err)
+	}
+}
+
+func TestArchiveRotationAndCompression(t *testing.T) {
+	for _, compression := range []string{ArchiveCompressGzip, ArchiveCompressZstd} {
+		t.Run(compression, func(t *testing.T) {
+			// 每段只能容纳一条记录
+			archive := newTestArchive(t, 100, compression)
+			for i := int64(1); i <= 3; i++ {
+				appendTestMessage(t, archive, "device", "session", i)
+			}
+			archive.Close()
+
+			segments, err := archiveSegmentFiles(archive.opts.Dir)
+			if err != nil {
+				t.Fatalf("列出归档段失败: %v", err)
+			}
+			if len(segments) != 3 {
+				t.Fatalf("期望轮转为3个段，实际为%v", segments)
+			}
+			for _, path := range segments {
+				if !strings.HasSuffix(path, archiveSegmentExt+archiveCompressionExt(compression)) {
+					t.Errorf("关闭的段未压缩: %s", path)
+				}
+			}
+			if stats := archive.Stats(); stats.Rotations != 2 || stats.Compressed != 3 {
+				t.Errorf("统计不正确: %+v", stats)
+			}
+
+			// 压缩后仍可通过索引读取
+			location, found, _ := LocateArchivedMessage(archive.opts.Dir, "session", 3)
+			if !found || !strings.HasSuffix(location.Segment, "segment-000003.ndjson") {
+				t.Fatalf("索引中的位置不正确: %+v", location)
+			}
+			if _, err := ReadArchivedRecord(archive.opts.Dir, location); err != nil {
+				t.Errorf("读取压缩段中的记录失败: %v", err)
+			}
+
+			// 重新打开后序号在已有的段之后继续
+			reopened, _ := NewArchive(archive.opts)
+			next := appendTestMessage(t, reopened, "device", "session", 4)
+			reopened.Close()
+			if !strings.HasSuffix(next.Segment, "segment-000004.ndjson") {
+				t.Errorf("重新打开后段序号应继续递增: %s", next.Segment)
+			}
+		})
+	}
+}
+
+func TestArchiveKeepsIdleSegmentsOpen(t *testing.T) {
+	archive := newTestArchive(t, 64<<20, ArchiveCompressNone)
+	defer archive.Close()
+
+	// 推送间隔较长的设备仍写入同一个段，直到达到轮转时间
+	first := appendTestMessage(t, archive, "device", "session", 1)
+	archive.maintain(time.Now().Add(10 * time.Minute))
+	second := appendTestMessage(t, archive, "device", "session", 2)
+	if second.Segment != first.Segment {
+		t.Errorf("空闲的段不应关闭: %s != %s", second.Segment, first.Segment)
+	}
+	archive.maintain(time.Now().Add(archive.opts.MaxSegmentAge))
+	if stats := archive.Stats(); stats.OpenSegments != 0 {
+		t.Errorf("超过轮转时间的段应关闭: %+v", stats)
+	}
+
+	// 会话进入新的日期分区时关闭前一天的段
+	appendTestMessage(t, archive, "device", "session", 3)
+	appendTestMessage(t, archive, "device", "other", 1)
+	nextDay := &ParsedSensorData{
+		MessageID:  4,
+		DeviceID:   "device",
+		SessionID:  "session",
+		ReceivedAt: time.Date(2025, 7, 6, 0, 0, 1, 0, time.UTC),
+	}
+	if _, err := archive.Append(nextDay, buildTestMessage(1)); err != nil {
+		t.Fatalf("追加消息失败: %v", err)
+	}
+	if stats := archive.Stats(); stats.OpenSegments != 2 {
+		t.Errorf("期望只剩新分区和其他会话的段，实际为%d", stats.OpenSegments)
+	}
+}
+
+func TestArchiveStartSkipsOpenSegments(t *testing.T) {
+	writer := newTestArchive(t, 64<<20, ArchiveCompressGzip)
+	live := appendTestMessage(t, writer, "device", "live", 1)
+
+	// 模拟上次崩溃遗留的未关闭段
+	crashed, _ := NewArchive(writer.opts)
+	stale := appendTestMessage(t, crashed, "device", "stale", 1)
+	for _, segment := range crashed.segments {
+		segment.file.Close()
+		segment.index.Close()
+	}
+	stalePath := filepath.Join(writer.opts.Dir, filepath.FromSlash(stale.Segment)) + archiveOpenExt
+	old := time.Now().Add(-3 * writer.opts.MaxSegmentAge)
+	if err := os.Chtimes(stalePath, old, old); err != nil {
+		t.Fatalf("修改段时间失败: %v", err)
+	}
+
+	// 另一个进程在同一目录启动归档，不能压缩仍在写入的段
+	other, _ := NewArchive(writer.opts)
+	other.Start()
+	if err := other.Close(); err != nil {
+		t.Fatalf("关闭归档失败: %v", err)
+	}
+
+	livePath := filepath.Join(writer.opts.Dir, filepath.FromSlash(live.Segment)) + archiveOpenExt
+	if _, err := os.Stat(livePath); err != nil {
+		t.Fatalf("正在写入的段被处理: %v", err)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(stalePath, archiveOpenExt) + ".gz"); err != nil {
+		t.Errorf("遗留的段未压缩: %v", err)
+	}
+
+	// 原进程继续写入，读取时仍能找到所有消息
+	next := appendTestMessage(t, writer, "device", "live", 2)
+	if err := writer.Close(); err != nil {
+		t.Fatalf("关闭归档失败: %v", err)
+	}
+	for _, location := range []ArchiveLocation{live, next, stale} {
+		if _, err := ReadArchivedRecord(writer.opts.Dir, location); err != nil {
+			t.Errorf("读取记录失败: %+v: %v", location, err)
+		}
+	}
+}
+
+func TestForEachRawMessage(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "sensor_messages_20250705_153947.json"), buildTestMessage(1), 0644)
+
+	archive, err := NewArchive(ArchiveOptions{
+		Dir:             filepath.Join(dir, "archive"),
+		MaxSegmentBytes: 64 << 20,
+		MaxSegmentAge:   time.Hour,
+		Compression:     ArchiveCompressNone,
+		Fsync:           ArchiveFsyncNever,
+	})
+	if err != nil {
+		t.Fatalf("创建归档失败: %v", err)
+	}
+	location := appendTestMessage(t, archive, "device", "session", 1)
+	appendTestMessage(t, archive, "device", "session", 2)
+	archive.Close()
+
+	// 模拟崩溃时写了一半的最后一行
+	segment, _ := os.OpenFile(filepath.Join(archive.opts.Dir, location.Segment), os.O_WRONLY|os.O_APPEND, 0644)
+	segment.WriteString(`{"receivedAt":"2025-07-05T15:39:47Z","message":{"messageId":3,`)
+	segment.Close()
+
+	var sources []string
+	files, corrupt, err := forEachRawMessage(dir, func(message rawMessage) {
+		sources = append(sources, message.Source)
+		if message.ReceivedAt.IsZero() {
+			t.Errorf("缺少接收时间: %s", message.Source)
+		}
+	})
+	if err != nil {
+		t.Fatalf("读取原始数据失败: %v", err)
+	}
+	if files != 2 || corrupt != 1 || len(sources) != 3 {
+		t.Errorf("期望2个文件、3条消息、1条损坏记录，实际为%d个文件、%v、%d条损坏", files, sources, corrupt)
+	}
+}
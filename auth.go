@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 登录会话的Cookie名称
+const sessionCookieName = "sensor_logger_session"
+
+// DeviceScope 可见的设备范围
+type DeviceScope struct {
+	All     bool
+	Devices []string
+}
+
+// allDevices 不限制设备（未启用鉴权或管理员）
+var allDevices = DeviceScope{All: true}
+
+// Allows 判断设备是否可见
+func (s DeviceScope) Allows(deviceID string) bool {
+	if s.All {
+		return true
+	}
+	for _, allowed := range s.Devices {
+		if allowed == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal 已认证的用户
+type Principal struct {
+	Username string
+	Role     string
+	Scope    DeviceScope
+	Method   string // session 或 api_key
+}
+
+// HasRole 判断是否具有指定角色的权限（高级角色包含低级角色的权限）
+func (p Principal) HasRole(role string) bool {
+	return roleLevel(p.Role) >= roleLevel(role)
+}
+
+// principalContextKey 请求上下文中已认证用户的键
+type principalContextKey struct{}
+
+// requestPrincipal 取出请求上下文中已认证的用户
+func requestPrincipal(r *http.Request) (Principal, bool) {
+	principal, ok := r.Context().Value(principalContextKey{}).(Principal)
+	return principal, ok
+}
+
+// requestDeviceScope 请求可见的设备范围，未启用鉴权时不限制
+func requestDeviceScope(r *http.Request) DeviceScope {
+	if principal, ok := requestPrincipal(r); ok {
+		return principal.Scope
+	}
+	return allDevices
+}
+
+// session 登录会话
+type session struct {
+	Username  string
+	ExpiresAt time.Time
+}
+
+// SessionStore 内存中的登录会话（服务重启后需要重新登录）
+type SessionStore struct {
+	mutex    sync.Mutex
+	sessions map[string]session
+}
+
+// 全局登录会话
+var loginSessions = NewSessionStore()
+
+// NewSessionStore 创建会话存储
+func NewSessionStore() *SessionStore {
+	return &SessionStore{sessions: make(map[string]session)}
+}
+
+// Create 为用户创建会话，返回会话ID
+func (s *SessionStore) Create(username string, ttl time.Duration) (string, error) {
+	var id [32]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	sessionID := hex.EncodeToString(id[:])
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// 顺便清理过期的会话
+	now := time.Now()
+	for key, existing := range s.sessions {
+		if now.After(existing.ExpiresAt) {
+			delete(s.sessions, key)
+		}
+	}
+	s.sessions[sessionID] = session{Username: username, ExpiresAt: now.Add(ttl)}
+	return sessionID, nil
+}
+
+// Get 获取未过期的会话对应的用户名
+func (s *SessionStore) Get(sessionID string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	existing, ok := s.sessions[sessionID]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(existing.ExpiresAt) {
+		delete(s.sessions, sessionID)
+		return "", false
+	}
+	return existing.Username, true
+}
+
+// Delete 删除会话
+func (s *SessionStore) Delete(sessionID string) {
+	s.mutex.Lock()
+	delete(s.sessions, sessionID)
+	s.mutex.Unlock()
+}
+
+// authenticateRequest 通过API密钥（Authorization: Bearer 或 X-API-Key）或登录会话认证请求。
+// 每次请求都重新读取账户，角色、可见设备或禁用状态的修改立即生效
+func authenticateRequest(r *http.Request) (Principal, bool) {
+	if userAccounts == nil {
+		return Principal{}, false
+	}
+
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key, _ = bearerToken(r)
+	}
+	if strings.HasPrefix(key, apiKeyPrefix) {
+		user, ok := userAccounts.AuthenticateAPIKey(key)
+		if !ok {
+			return Principal{}, false
+		}
+		return user.principal("api_key"), true
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return Principal{}, false
+	}
+	username, ok := loginSessions.Get(cookie.Value)
+	if !ok {
+		return Principal{}, false
+	}
+	user, ok := userAccounts.Get(username)
+	if !ok || user.Disabled {
+		loginSessions.Delete(cookie.Value)
+		return Principal{}, false
+	}
+	return user.principal("session"), true
+}
+
+// withAuth 要求请求的用户至少具有指定角色。未登录时页面跳转到登录页，接口返回401；权限不足返回403
+func withAuth(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !AppConfig.EnableAuth {
+			next(w, r)
+			return
+		}
+
+		startTime := time.Now()
+		principal, ok := authenticateRequest(r)
+		if !ok {
+			if wantsHTML(r) {
+				http.Redirect(w, r, "/login?next="+template.URLQueryEscaper(r.URL.RequestURI()), http.StatusSeeOther)
+				LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusSeeOther, time.Since(startTime))
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			http.Error(w, "未登录或API密钥无效", http.StatusUnauthorized)
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusUnauthorized, time.Since(startTime))
+			return
+		}
+		if !principal.HasRole(role) {
+			Logger.Warn("权限不足",
+				slog.String("username", principal.Username),
+				slog.String("role", principal.Role),
+				slog.String("required_role", role),
+				slog.String("path", r.URL.Path))
+			http.Error(w, "权限不足", http.StatusForbidden)
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusForbidden, time.Since(startTime))
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal)))
+	}
+}
+
+// wantsHTML 判断是否为浏览器的页面请求
+func wantsHTML(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// setCORSHeaders 为读取接口设置跨域头：配置了 CORS_ALLOWED_ORIGINS 时只允许列出的来源；
+// 未配置时，未启用鉴权的服务保持允许任意来源，启用鉴权后不允许跨域读取
+func setCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	if len(AppConfig.CORSAllowedOrigins) == 0 {
+		if !AppConfig.EnableAuth {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+		return
+	}
+
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	for _, allowed := range AppConfig.CORSAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			return
+		}
+	}
+}
+
+// safeRedirectTarget 登录后跳转的地址，只允许站内路径
+func safeRedirectTarget(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/dashboard"
+	}
+	return next
+}
+
+// loginTemplate 登录页面
+var loginTemplate = template.Must(template.New("login").Parse(`
+<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>登录 - 传感器日志服务器</title>
+    <style>
+        body {
+            font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background-color: #f5f5f5;
+        }
+        .container {
+            max-width: 360px;
+            margin: 80px auto;
+            background-color: white;
+            padding: 30px;
+            border-radius: 10px;
+            box-shadow: 0 2px 10px rgba(0,0,0,0.1);
+        }
+        h1 {
+            color: #333;
+            text-align: center;
+            font-size: 1.4em;
+            border-bottom: 3px solid #4CAF50;
+            padding-bottom: 10px;
+        }
+        label {
+            display: block;
+            margin-top: 15px;
+            color: #495057;
+        }
+        input[type=text], input[type=password] {
+            width: 100%;
+            box-sizing: border-box;
+            padding: 10px;
+            margin-top: 5px;
+            border: 1px solid #ced4da;
+            border-radius: 5px;
+        }
+        button {
+            width: 100%;
+            margin-top: 20px;
+            padding: 12px;
+            background-color: #4CAF50;
+            color: white;
+            border: none;
+            border-radius: 5px;
+            cursor: pointer;
+        }
+        .error {
+            background-color: #f8d7da;
+            color: #721c24;
+            padding: 10px;
+            border-radius: 5px;
+            margin-top: 15px;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>🔐 登录</h1>
+        {{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+        <form method="POST" action="/login">
+            <input type="hidden" name="next" value="{{.Next}}">
+            <label>用户名<input type="text" name="username" value="{{.Username}}" autofocus required></label>
+            <label>密码<input type="password" name="password" required></label>
+            <button type="submit">登录</button>
+        </form>
+    </div>
+</body>
+</html>
+`))
+
+// handleLogin 登录页面（GET）和登录（POST，表单字段 username、password、next）
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	page := struct {
+		Username string
+		Next     string
+		Error    string
+	}{Next: safeRedirectTarget(r.URL.Query().Get("next"))}
+
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "无效的表单", http.StatusBadRequest)
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+			return
+		}
+		page.Username = r.PostForm.Get("username")
+		page.Next = safeRedirectTarget(r.PostForm.Get("next"))
+
+		user, ok := UserAccount{}, false
+		if userAccounts != nil {
+			user, ok = userAccounts.CheckPassword(page.Username, r.PostForm.Get("password"))
+		}
+		if !ok {
+			Logger.Warn("登录失败",
+				slog.String("username", page.Username),
+				slog.String("remote_addr", r.RemoteAddr))
+			page.Error = "用户名或密码错误"
+			status = http.StatusUnauthorized
+			break
+		}
+
+		sessionID, err := loginSessions.Create(user.Username, time.Duration(AppConfig.AuthSessionTTL)*time.Hour)
+		if err != nil {
+			LogError("创建登录会话", err)
+			http.Error(w, "登录失败", http.StatusInternalServerError)
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusInternalServerError, time.Since(startTime))
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    sessionID,
+			Path:     "/",
+			MaxAge:   AppConfig.AuthSessionTTL * 3600,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		Logger.Info("用户登录",
+			slog.String("username", user.Username),
+			slog.String("role", user.Role),
+			slog.String("remote_addr", r.RemoteAddr))
+		http.Redirect(w, r, page.Next, http.StatusSeeOther)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusSeeOther, time.Since(startTime))
+		return
+	default:
+		http.Error(w, "只支持GET和POST方法", http.StatusMethodNotAllowed)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusMethodNotAllowed, time.Since(startTime))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := loginTemplate.Execute(w, page); err != nil {
+		LogError("登录页面模板执行", err)
+	}
+	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, status, time.Since(startTime))
+}
+
+// handleLogout 退出登录（POST）
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusMethodNotAllowed, time.Since(startTime))
+		return
+	}
+
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		loginSessions.Delete(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusSeeOther, time.Since(startTime))
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupTestAccounts 启用鉴权并创建测试账户
+func setupTestAccounts(t *testing.T) {
+	t.Helper()
+
+	originalEnabled, originalTTL, originalAccounts := AppConfig.EnableAuth, AppConfig.AuthSessionTTL, userAccounts
+	originalOrigins := AppConfig.CORSAllowedOrigins
+	t.Cleanup(func() {
+		AppConfig.EnableAuth, AppConfig.AuthSessionTTL, userAccounts = originalEnabled, originalTTL, originalAccounts
+		AppConfig.CORSAllowedOrigins = originalOrigins
+	})
+	AppConfig.EnableAuth, AppConfig.AuthSessionTTL = true, 1
+
+	userAccounts = NewAccountStore(filepath.Join(t.TempDir(), "users.json"))
+	if err := userAccounts.AddUser("alice", "alice-password", RoleViewer, []string{"device-a"}); err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	if err := userAccounts.AddUser("root", "root-password", RoleAdmin, nil); err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+}
+
+func TestAccountStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	store := NewAccountStore(path)
+
+	if err := store.AddUser("bob", "short", RoleViewer, nil); err == nil {
+		t.Error("过短的密码应被拒绝")
+	}
+	if err := store.AddUser("bob", "bob-password", "owner", nil); err == nil {
+		t.Error("无效的角色应被拒绝")
+	}
+	if err := store.AddUser("bob", "bob-password", RoleOperator, []string{"device-a"}); err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	if err := store.AddUser("bob", "bob-password", RoleOperator, nil); err != errUserExists {
+		t.Errorf("重复的用户应返回errUserExists，实际为%v", err)
+	}
+
+	if _, ok := store.CheckPassword("bob", "wrong-password"); ok {
+		t.Error("错误的密码不应通过校验")
+	}
+	if _, ok := store.CheckPassword("nobody", "bob-password"); ok {
+		t.Error("不存在的用户不应通过校验")
+	}
+
+	plain, key, err := store.CreateAPIKey("bob", "script")
+	if err != nil {
+		t.Fatalf("创建API密钥失败: %v", err)
+	}
+
+	// 其他进程（命令行）的修改在重新加载后可见
+	reloaded := NewAccountStore(path)
+	if err := reloaded.Reload(); err != nil {
+		t.Fatalf("重新加载失败: %v", err)
+	}
+	if user, ok := reloaded.CheckPassword("bob", "bob-password"); !ok || user.Role != RoleOperator {
+		t.Fatalf("从文件加载的账户应通过校验: %+v", user)
+	}
+	if user, ok := reloaded.AuthenticateAPIKey(plain); !ok || user.Username != "bob" {
+		t.Fatalf("API密钥应通过认证: %+v", user)
+	}
+
+	disabled := true
+	if err := reloaded.UpdateUser("bob", nil, nil, &disabled); err != nil {
+		t.Fatalf("禁用用户失败: %v", err)
+	}
+	if _, ok := reloaded.CheckPassword("bob", "bob-password"); ok {
+		t.Error("已禁用的用户不应通过校验")
+	}
+	if _, ok := reloaded.AuthenticateAPIKey(plain); ok {
+		t.Error("已禁用用户的API密钥不应通过认证")
+	}
+
+	disabled = false
+	reloaded.UpdateUser("bob", nil, nil, &disabled)
+	if err := reloaded.RevokeAPIKey(key.ID); err != nil {
+		t.Fatalf("吊销API密钥失败: %v", err)
+	}
+	if _, ok := reloaded.AuthenticateAPIKey(plain); ok {
+		t.Error("已吊销的API密钥不应通过认证")
+	}
+	if err := reloaded.RevokeAPIKey("missing"); err != errAPIKeyNotFound {
+		t.Errorf("吊销不存在的API密钥应返回errAPIKeyNotFound，实际为%v", err)
+	}
+}
+
+func TestWithAuth(t *testing.T) {
+	setupTestAccounts(t)
+	aliceKey, _, _ := userAccounts.CreateAPIKey("alice", "")
+
+	handler := withAuth(RoleOperator, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	request := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/ingest/stats", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	if rr := request("", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("未登录的接口请求期望401，实际为%d", rr.Code)
+	}
+	if rr := request("Accept", "text/html"); rr.Code != http.StatusSeeOther ||
+		rr.Header().Get("Location") != "/login?next="+url.QueryEscape("/api/ingest/stats") {
+		t.Errorf("未登录的页面请求应跳转到登录页，实际为%d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if rr := request("X-API-Key", "slk_invalid"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("无效的API密钥期望401，实际为%d", rr.Code)
+	}
+	// viewer 不能访问 operator 接口
+	if rr := request("Authorization", "Bearer "+aliceKey); rr.Code != http.StatusForbidden {
+		t.Errorf("权限不足期望403，实际为%d", rr.Code)
+	}
+
+	// 登录后通过会话访问
+	form := url.Values{"username": {"root"}, "password": {"root-password"}, "next": {"/api/ingest/stats"}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handleLogin(rr, req)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/api/ingest/stats" {
+		t.Fatalf("登录应跳转到原页面，实际为%d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookieName || !cookies[0].HttpOnly {
+		t.Fatalf("登录应设置HttpOnly会话Cookie: %+v", cookies)
+	}
+	if rr := request("Cookie", cookies[0].Name+"="+cookies[0].Value); rr.Code != http.StatusOK {
+		t.Errorf("已登录的管理员期望200，实际为%d", rr.Code)
+	}
+
+	// 错误的密码不创建会话
+	form.Set("password", "wrong-password")
+	req = httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handleLogin(rr, req)
+	if rr.Code != http.StatusUnauthorized || len(rr.Result().Cookies()) != 0 {
+		t.Errorf("错误的密码期望401且不设置Cookie，实际为%d", rr.Code)
+	}
+
+	// 退出后会话失效
+	req = httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.AddCookie(cookies[0])
+	handleLogout(httptest.NewRecorder(), req)
+	if rr := request("Cookie", cookies[0].Name+"="+cookies[0].Value); rr.Code != http.StatusUnauthorized {
+		t.Errorf("退出登录后期望401，实际为%d", rr.Code)
+	}
+}
+
+func TestDeviceScopedReadAPI(t *testing.T) {
+	setupTestAccounts(t)
+	aliceKey, _, _ := userAccounts.CreateAPIKey("alice", "")
+	rootKey, _, _ := userAccounts.CreateAPIKey("root", "")
+
+	originalStore := parsedDataStore
+	defer func() { parsedDataStore = originalStore }()
+	parsedDataStore = NewThreadSafeDataStore()
+	parsedDataStore.Add(ParsedSensorData{MessageID: 1, DeviceID: "device-a"})
+	parsedDataStore.Add(ParsedSensorData{MessageID: 2, DeviceID: "device-b"})
+
+	handler := withAuth(RoleViewer, handleAPIData)
+	devicesFor := func(key string) []string {
+		req := httptest.NewRequest(http.MethodGet, "/api/data", nil)
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("期望200，实际为%d", rr.Code)
+		}
+		var data []ParsedSensorData
+		json.Unmarshal(rr.Body.Bytes(), &data)
+		devices := make([]string, 0, len(data))
+		for _, parsedData := range data {
+			devices = append(devices, parsedData.DeviceID)
+		}
+		return devices
+	}
+
+	if devices := devicesFor(aliceKey); len(devices) != 1 || devices[0] != "device-a" {
+		t.Errorf("viewer只应看到可见设备的数据，实际为%v", devices)
+	}
+	if devices := devicesFor(rootKey); len(devices) != 2 {
+		t.Errorf("管理员应看到全部设备的数据，实际为%v", devices)
+	}
+
+	// 查询不可见设备的数据库数据返回403
+	req := httptest.NewRequest(http.MethodGet, "/api/db/data?device=device-b", nil)
+	req.Header.Set("X-API-Key", aliceKey)
+	rr := httptest.NewRecorder()
+	withAuth(RoleViewer, handleDBData)(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("查询不可见设备期望403，实际为%d", rr.Code)
+	}
+
+	dashboard := prepareDashboardData(DeviceScope{Devices: []string{"device-b"}}, AngleUnitRadian)
+	if dashboard.TotalMessages != 1 || dashboard.DeviceCount != 1 {
+		t.Errorf("仪表板只应统计可见设备: %+v", dashboard)
+	}
+}
+
+func TestSetCORSHeaders(t *testing.T) {
+	setupTestAccounts(t)
+
+	check := func(origin, want string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/data", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rr := httptest.NewRecorder()
+		setCORSHeaders(rr, req)
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("Origin=%q: 期望%q，实际为%q", origin, want, got)
+		}
+	}
+
+	// 启用鉴权且未配置来源时不允许跨域读取
+	check("https://evil.example", "")
+
+	AppConfig.CORSAllowedOrigins = []string{"https://grafana.example"}
+	check("https://grafana.example", "https://grafana.example")
+	check("https://evil.example", "")
+
+	AppConfig.EnableAuth, AppConfig.CORSAllowedOrigins = false, nil
+	check("https://evil.example", "*")
+}
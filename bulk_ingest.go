@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// 批量导入每一行的处理结果
+const (
+	BulkStatusAccepted  = "accepted"  // 已接收
+	BulkStatusDuplicate = "duplicate" // 重复消息，已忽略
+	BulkStatusRejected  = "rejected"  // 解析失败或未通过校验
+	BulkStatusFailed    = "failed"    // 持久化未满足要求或服务器繁忙
+)
+
+// BulkLineResult 批量导入中单条消息的结果，每处理完一条输出一行
+type BulkLineResult struct {
+	Line       int               `json:"line"` // NDJSON行号或JSON数组元素序号（从1开始）
+	Status     string            `json:"status"`
+	HTTPStatus int               `json:"httpStatus"` // 与单条提交到 /data 时的状态码一致
+	Error      string            `json:"error,omitempty"`
+	Ack        *IngestAck        `json:"ack,omitempty"`
+	Validation *ValidationReport `json:"validation,omitempty"` // 被拒绝时的校验详情
+}
+
+// BulkSummary 批量导入的汇总，作为响应的最后一行输出
+type BulkSummary struct {
+	Summary    bool   `json:"summary"` // 固定为true，用于区分汇总行和结果行
+	Format     string `json:"format"`  // ndjson 或 array
+	Total      int    `json:"total"`
+	Accepted   int    `json:"accepted"`
+	Duplicates int    `json:"duplicates"`
+	Rejected   int    `json:"rejected"`
+	Failed     int    `json:"failed"`
+	Readings   int    `json:"readings"` // 已接收的读数总数
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"` // 导致导入中止的错误（例如请求体超限或JSON数组格式错误）
+}
+
+// bulkStream 逐行输出批量导入结果
+type bulkStream struct {
+	w       http.ResponseWriter
+	encoder *json.Encoder
+	flusher http.Flusher
+	summary BulkSummary
+}
+
+// handleBulkIngest 批量导入历史数据：请求体为NDJSON（每行一条消息）或消息组成的JSON数组
+// 每条消息与 /data 使用相同的解析、校验、去重和持久化流程，处理结果以NDJSON流式返回
+func handleBulkIngest(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusMethodNotAllowed, time.Since(startTime))
+		return
+	}
+
+	bodyReader, err := newLimitedBodyReader(r, bodyLimits{
+		BodyLimit:         "bulk_max_body_bytes",
+		MaxBody:           AppConfig.BulkMaxBodyBytes,
+		DecompressedLimit: "bulk_max_decompressed_bytes",
+		MaxDecompressed:   AppConfig.BulkMaxDecompressedBytes,
+	})
+	if err != nil {
+		status, message := http.StatusBadRequest, "解压请求体失败"
+		if errors.Is(err, errUnsupportedEncoding) {
+			status, message = http.StatusUnsupportedMediaType, "不支持的内容编码"
+		}
+		http.Error(w, message, status)
+		LogError("解压批量导入请求体", err,
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("content_encoding", r.Header.Get("Content-Encoding")))
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, status, time.Since(startTime))
+		return
+	}
+	defer bodyReader.Close()
+
+	reader := bufio.NewReader(bodyReader)
+	format, err := detectBulkFormat(reader)
+	if err != nil {
+		var tooLarge *BodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, newLimitErrorResponse(tooLarge))
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusRequestEntityTooLarge, time.Since(startTime))
+			return
+		}
+		http.Error(w, "读取请求体失败", http.StatusBadRequest)
+		LogError("读取批量导入请求体", err, slog.String("remote_addr", r.RemoteAddr))
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	stream := &bulkStream{w: w, encoder: json.NewEncoder(w), summary: BulkSummary{Summary: true, Format: format}}
+	stream.flusher, _ = w.(http.Flusher)
+
+	opts := backfillIngestOptions()
+
+	if format == "array" {
+		err = readBulkArray(reader, func(line int, raw []byte) { stream.ingest(r, line, raw, opts) })
+	} else {
+		err = readBulkLines(reader, func(line int, raw []byte) { stream.ingest(r, line, raw, opts) })
+	}
+	if err != nil {
+		stream.summary.Error = err.Error()
+		LogError("批量导入中止", err, slog.String("remote_addr", r.RemoteAddr))
+	}
+
+	stream.summary.DurationMs = time.Since(startTime).Milliseconds()
+	stream.write(stream.summary)
+
+	Logger.Info("批量导入完成",
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("format", format),
+		slog.Int("total", stream.summary.Total),
+		slog.Int("accepted", stream.summary.Accepted),
+		slog.Int("duplicates", stream.summary.Duplicates),
+		slog.Int("rejected", stream.summary.Rejected),
+		slog.Int("failed", stream.summary.Failed))
+	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
+}
+
+// backfillIngestOptions 导入历史数据时的入库选项：不检查时间戳是否过旧，其余校验规则与 /data 相同；
+// 同步持久化，使每条消息的结果反映实际写入情况
+func backfillIngestOptions() ingestOptions {
+	opts := ingestOptions{Validation: currentValidationOptions()}
+	opts.Validation.MaxAge = 0
+	return opts
+}
+
+// ingest 处理一条消息并输出结果行
+func (s *bulkStream) ingest(r *http.Request, line int, raw []byte, opts ingestOptions) {
+	result := BulkLineResult{Line: line}
+	s.summary.Total++
+
+	// 单条消息与 /data 使用相同的大小限制
+	if int64(len(raw)) > AppConfig.MaxDecompressedBytes {
+		tooLarge := &BodyTooLargeError{Limit: "max_decompressed_bytes", Max: AppConfig.MaxDecompressedBytes}
+		result.Status, result.HTTPStatus = BulkStatusRejected, http.StatusRequestEntityTooLarge
+		result.Error = newLimitErrorResponse(tooLarge).Message
+		s.summary.Rejected++
+		s.write(result)
+		return
+	}
+
+	message, err := decodeSensorMessage(bytes.NewReader(raw), AppConfig.MaxReadingsPerMessage)
+	if err != nil {
+		result.Status, result.HTTPStatus = BulkStatusRejected, http.StatusBadRequest
+		result.Error = "解析传感器数据失败: " + err.Error()
+		var tooLarge *BodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			result.HTTPStatus = http.StatusRequestEntityTooLarge
+			result.Error = newLimitErrorResponse(tooLarge).Message
+		}
+		s.summary.Rejected++
+		s.write(result)
+		return
+	}
+
+	if err := checkUnsignedMessage(r, message.DeviceID); err != nil {
+		result.Status, result.HTTPStatus, result.Error = BulkStatusRejected, http.StatusUnauthorized, err.Error()
+		s.summary.Rejected++
+		s.write(result)
+		return
+	}
+
+	outcome := ingestSensorMessage(r.Context(), message, raw, opts)
+	result.HTTPStatus = outcome.Status
+	switch {
+	case outcome.Rejection != nil:
+		result.Status, result.Error = BulkStatusRejected, outcome.Rejection.Message
+		result.Validation = &outcome.Rejection.Report
+		s.summary.Rejected++
+	case outcome.Status == http.StatusForbidden:
+		result.Status, result.Error = BulkStatusRejected, outcome.Error
+		s.summary.Rejected++
+	case outcome.Status == http.StatusOK && outcome.Ack.Duplicate:
+		result.Status, result.Ack = BulkStatusDuplicate, outcome.Ack
+		s.summary.Duplicates++
+	case outcome.Status == http.StatusOK || outcome.Status == http.StatusAccepted:
+		result.Status, result.Ack = BulkStatusAccepted, outcome.Ack
+		s.summary.Accepted++
+		s.summary.Readings += outcome.Ack.ReadingsAccepted
+	default:
+		result.Status, result.Ack, result.Error = BulkStatusFailed, outcome.Ack, outcome.Error
+		if outcome.Ack != nil {
+			result.Error = outcome.Ack.Message
+		}
+		s.summary.Failed++
+	}
+	s.write(result)
+}
+
+// write 输出一行并立即发送给客户端，便于客户端跟踪进度
+func (s *bulkStream) write(v interface{}) {
+	if err := s.encoder.Encode(v); err != nil {
+		LogError("批量导入结果编码", err)
+		return
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+// detectBulkFormat 根据第一个非空白字符判断请求体格式：'[' 为JSON数组，否则为NDJSON
+// 只预读不消费，NDJSON的行号从请求体开头计算
+func detectBulkFormat(reader *bufio.Reader) (string, error) {
+	for n := 1; n <= reader.Size(); n++ {
+		peeked, err := reader.Peek(n)
+		if err == io.EOF {
+			return "ndjson", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		switch peeked[n-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '[':
+			return "array", nil
+		default:
+			return "ndjson", nil
+		}
+	}
+	return "ndjson", nil
+}
+
+// readBulkLines 逐行读取NDJSON，空行跳过
+func readBulkLines(reader *bufio.Reader, handle func(line int, raw []byte)) error {
+	for line := 1; ; line++ {
+		raw, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 {
+			handle(line, trimmed)
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// readBulkArray 逐个读取JSON数组中的元素；数组本身格式错误时无法继续，返回错误
+func readBulkArray(reader io.Reader, handle func(line int, raw []byte)) error {
+	dec := json.NewDecoder(reader)
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+
+	for index := 1; dec.More(); index++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("第%d个元素格式错误: %w", index, err)
+		}
+		handle(index, raw)
+	}
+	return expectDelim(dec, ']')
+}
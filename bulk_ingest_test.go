@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postBulk 提交批量导入请求，返回结果行和汇总行
+func postBulk(t *testing.T, body string) ([]BulkLineResult, BulkSummary) {
+	t.Helper()
+
+	original := AppConfig
+	originalDedup, originalPipeline := messageDeduplicator, ingestPipeline
+	t.Cleanup(func() {
+		AppConfig = original
+		messageDeduplicator, ingestPipeline = originalDedup, originalPipeline
+	})
+	AppConfig.DataDir = t.TempDir()
+	AppConfig.EnableFileLog = false
+	AppConfig.EnableLogging = false
+	AppConfig.MaxDataStore = 1000
+	AppConfig.ValidationMode = ValidationReject
+	messageDeduplicator = NewMessageDeduplicator(100)
+	ingestPipeline = nil
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/ingest/bulk", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handleBulkIngest(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("期望状态码200，实际为%d: %s", rr.Code, rr.Body.String())
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("期望Content-Type为application/x-ndjson，实际为%s", contentType)
+	}
+	if origin := rr.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("写入接口不应允许跨域调用，实际为%s", origin)
+	}
+
+	var results []BulkLineResult
+	var summary BulkSummary
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if strings.Contains(string(line), `"summary":true`) {
+			if err := json.Unmarshal(line, &summary); err != nil {
+				t.Fatalf("解析汇总行失败: %v", err)
+			}
+			continue
+		}
+		var result BulkLineResult
+		if err := json.Unmarshal(line, &result); err != nil {
+			t.Fatalf("解析结果行失败: %v", err)
+		}
+		results = append(results, result)
+	}
+	if !summary.Summary {
+		t.Fatal("响应缺少汇总行")
+	}
+	return results, summary
+}
+
+// bulkTestMessage 生成一条批量导入用的消息（时间戳为历史时间）
+func bulkTestMessage(messageID int) string {
+	return fmt.Sprintf(`{"messageId":%d,"sessionId":"bulk-session","deviceId":"bulk-device","payload":[{"name":"accelerometer","time":1600000000000000000,"values":{"x":1,"y":2,"z":3}}]}`, messageID)
+}
+
+func TestHandleBulkIngestNDJSON(t *testing.T) {
+	body := strings.Join([]string{
+		bulkTestMessage(1),
+		"",
+		bulkTestMessage(2),
+		"{not json",
+		bulkTestMessage(1),
+		`{"messageId":3,"sessionId":"bulk-session","deviceId":"","payload":[]}`,
+	}, "\n")
+
+	results, summary := postBulk(t, body)
+
+	expected := []struct {
+		line   int
+		status string
+	}{
+		{1, BulkStatusAccepted},
+		{3, BulkStatusAccepted},
+		{4, BulkStatusRejected},
+		{5, BulkStatusDuplicate},
+		{6, BulkStatusRejected},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("期望%d条结果，实际为%d", len(expected), len(results))
+	}
+	for i, want := range expected {
+		if results[i].Line != want.line || results[i].Status != want.status {
+			t.Errorf("第%d条结果期望为第%d行%s，实际为第%d行%s", i, want.line, want.status, results[i].Line, results[i].Status)
+		}
+	}
+	if results[4].Validation == nil || results[4].HTTPStatus != http.StatusUnprocessableEntity {
+		t.Errorf("未通过校验的行应返回422和校验详情: %+v", results[4])
+	}
+
+	if summary.Format != "ndjson" || summary.Total != 5 || summary.Accepted != 2 ||
+		summary.Duplicates != 1 || summary.Rejected != 2 || summary.Readings != 2 {
+		t.Errorf("汇总不正确: %+v", summary)
+	}
+}
+
+func TestHandleBulkIngestArray(t *testing.T) {
+	body := "\n [" + bulkTestMessage(1) + "," + bulkTestMessage(2) + "]"
+
+	results, summary := postBulk(t, body)
+
+	if len(results) != 2 || results[0].Line != 1 || results[1].Line != 2 {
+		t.Fatalf("期望2条结果，实际为%+v", results)
+	}
+	if summary.Format != "array" || summary.Accepted != 2 || summary.Error != "" {
+		t.Errorf("汇总不正确: %+v", summary)
+	}
+}
+
+func TestHandleBulkIngestMalformedArray(t *testing.T) {
+	body := "[" + bulkTestMessage(1) + ", {broken"
+
+	results, summary := postBulk(t, body)
+
+	// 格式错误之前的元素已处理，之后无法继续
+	if len(results) != 1 || results[0].Status != BulkStatusAccepted {
+		t.Fatalf("期望1条已接收的结果，实际为%+v", results)
+	}
+	if summary.Error == "" {
+		t.Error("数组格式错误时汇总应包含错误")
+	}
+}
+
+func TestHandleBulkIngestMethodNotAllowed(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/ingest/bulk", nil)
+	rr := httptest.NewRecorder()
+	handleBulkIngest(rr, req)
+
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("期望状态码405，实际为%d", rr.Code)
+	}
+}
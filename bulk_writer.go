@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 待写入消息数量超过批量大小的该倍数时拒绝新消息，避免数据库缓慢时内存无限增长
+const bulkWriterMaxPendingBatches = 10
+
+var (
+	errBulkWriterFull   = errors.New("批量写入缓冲区已满")
+	errBulkWriterClosed = errors.New("批量写入器已关闭")
+	// errWriteConcernUnsatisfied 文档已写入主节点，但未按写关注确认（例如副本同步超时）
+	errWriteConcernUnsatisfied = errors.New("写入未满足写关注要求")
+)
+
+// 全局批量写入器（为nil时逐条写入）
+var bulkWriter *BulkWriter
+
+// BulkWriteCallback 消息写入完成后的回调，err为nil表示写入成功
+type BulkWriteCallback func(err error)
+
+// bulkItem 等待批量写入的消息
+type bulkItem struct {
+	parsed   *ParsedSensorData
+	callback BulkWriteCallback
+}
+
+// BulkWriterStats 批量写入统计信息
+type BulkWriterStats struct {
+	Pending       int     `json:"pending"`
+	BatchSize     int     `json:"batchSize"`
+	FlushInterval string  `json:"flushInterval"`
+	Batches       int64   `json:"batches"`
+	Written       int64   `json:"written"`
+	Duplicates    int64   `json:"duplicates"`
+	Failed        int64   `json:"failed"`
+	WriteConcern  int64   `json:"writeConcernErrors"` // 未按写关注确认的消息（已转入写前缓冲，回放时按重复处理）
+	LastBatchSize int64   `json:"lastBatchSize"`
+	AvgBatchMs    float64 `json:"avgBatchMs"`
+}
+
+// BulkWriter 累积传感器消息和设备信息增量，按数量或时间阈值用InsertMany/BulkWrite批量写入
+type BulkWriter struct {
+	batchSize     int
+	flushInterval time.Duration
+
+	mutex   sync.Mutex
+	pending []*bulkItem
+	closed  bool
+
+	flushCh chan struct{}
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+
+	batches       atomic.Int64
+	written       atomic.Int64
+	duplicates    atomic.Int64
+	failed        atomic.Int64
+	writeConcern  atomic.Int64
+	lastBatchSize atomic.Int64
+	batchNs       atomic.Int64
+}
+
+// NewBulkWriter 创建批量写入器
+func NewBulkWriter(batchSize int, flushInterval time.Duration) *BulkWriter {
+	return &BulkWriter{
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		pending:       make([]*bulkItem, 0, batchSize),
+		flushCh:       make(chan struct{}, 1),
+		stopCh:        make(chan struct{}),
+		doneCh:        make(chan struct{}),
+	}
+}
+
+// Start 启动后台刷新协程
+func (b *BulkWriter) Start() {
+	go b.run()
+	Logger.Info("MongoDB批量写入已启动",
+		slog.Int("batch_size", b.batchSize),
+		slog.Duration("flush_interval", b.flushInterval))
+}
+
+// Add 加入待写入消息，写入完成（或失败）后调用callback
+func (b *BulkWriter) Add(parsedData *ParsedSensorData, callback BulkWriteCallback) {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		callback(errBulkWriterClosed)
+		return
+	}
+	if len(b.pending) >= b.batchSize*bulkWriterMaxPendingBatches {
+		b.mutex.Unlock()
+		b.failed.Add(1)
+		callback(errBulkWriterFull)
+		return
+	}
+
+	b.pending = append(b.pending, &bulkItem{parsed: parsedData, callback: callback})
+	full := len(b.pending) >= b.batchSize
+	b.mutex.Unlock()
+
+	if full {
+		select {
+		case b.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Write 加入待写入消息并等待所在批次写入完成，返回该消息的写入结果
+func (b *BulkWriter) Write(parsedData *ParsedSensorData) error {
+	result := make(chan error, 1)
+	b.Add(parsedData, func(err error) {
+		result <- err
+	})
+	return <-result
+}
+
+// Close 停止接收新消息并写入所有剩余消息；有消息写入失败时返回错误（失败的消息由回调处理）
+func (b *BulkWriter) Close() error {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return nil
+	}
+	b.closed = true
+	failedBefore := b.failed.Load()
+	b.mutex.Unlock()
+
+	close(b.stopCh)
+	<-b.doneCh
+	Logger.Info("MongoDB批量写入已关闭", slog.Int64("written", b.written.Load()))
+	if failed := b.failed.Load() - failedBefore; failed > 0 {
+		return fmt.Errorf("写完剩余消息时有%d条写入失败", failed)
+	}
+	return nil
+}
+
+// Stats 获取统计信息
+func (b *BulkWriter) Stats() BulkWriterStats {
+	b.mutex.Lock()
+	pending := len(b.pending)
+	b.mutex.Unlock()
+
+	stats := BulkWriterStats{
+		Pending:       pending,
+		BatchSize:     b.batchSize,
+		FlushInterval: b.flushInterval.String(),
+		Batches:       b.batches.Load(),
+		Written:       b.written.Load(),
+		Duplicates:    b.duplicates.Load(),
+		Failed:        b.failed.Load(),
+		WriteConcern:  b.writeConcern.Load(),
+		LastBatchSize: b.lastBatchSize.Load(),
+	}
+	if stats.Batches > 0 {
+		stats.AvgBatchMs = float64(b.batchNs.Load()) / float64(stats.Batches) / 1e6
+	}
+	return stats
+}
+
+// run 后台协程：按时间或数量阈值触发写入，关闭时写完剩余消息
+func (b *BulkWriter) run() {
+	defer close(b.doneCh)
+
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.Flush()
+		case <-b.flushCh:
+			b.Flush()
+		case <-b.stopCh:
+			for b.Flush() > 0 {
+			}
+			return
+		}
+	}
+}
+
+// Flush 写入一批消息，返回本批次的消息数量
+func (b *BulkWriter) Flush() int {
+	b.mutex.Lock()
+	if len(b.pending) == 0 {
+		b.mutex.Unlock()
+		return 0
+	}
+	count := len(b.pending)
+	if count > b.batchSize {
+		count = b.batchSize
+	}
+	batch := b.pending[:count:count]
+	b.pending = b.pending[count:]
+	b.mutex.Unlock()
+
+	start := time.Now()
+	errs := b.writeBatch(batch)
+	b.batchNs.Add(int64(time.Since(start)))
+	b.batches.Add(1)
+	b.lastBatchSize.Store(int64(len(batch)))
+
+	for i, item := range batch {
+		switch {
+		case errs[i] == nil:
+			b.written.Add(1)
+		case errors.Is(errs[i], errDuplicateMessage):
+			b.duplicates.Add(1)
+		case errors.Is(errs[i], errWriteConcernUnsatisfied):
+			b.writeConcern.Add(1)
+		default:
+			b.failed.Add(1)
+		}
+		item.callback(errs[i])
+	}
+	return len(batch)
+}
+
+// bulkInsertErrors 将InsertMany的错误拆分为与批次一一对应的错误：有单条写入错误的消息按各自的错误处理，
+// 只有写关注错误的消息返回 errWriteConcernUnsatisfied；其他错误（如网络错误）时整批失败
+func bulkInsertErrors(err error, count int) []error {
+	errs := make([]error, count)
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) {
+		for i := range errs {
+			errs[i] = fmt.Errorf("批量保存传感器消息失败: %v", err)
+		}
+		return errs
+	}
+
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Index < 0 || writeErr.Index >= count {
+			continue
+		}
+		if mongo.IsDuplicateKeyError(writeErr) {
+			errs[writeErr.Index] = errDuplicateMessage
+		} else {
+			errs[writeErr.Index] = fmt.Errorf("保存传感器消息失败: %v", writeErr)
+		}
+	}
+	if bulkErr.WriteConcernError != nil {
+		concernErr := fmt.Errorf("%w: %v", errWriteConcernUnsatisfied, bulkErr.WriteConcernError)
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = concernErr
+			}
+		}
+	}
+	return errs
+}
+
+// writeBatch 批量插入消息文档并更新设备信息，返回与batch一一对应的错误
+func (b *BulkWriter) writeBatch(batch []*bulkItem) []error {
+	errs := make([]error, len(batch))
+
+	sensorColl, deviceColl, err := mongoCollections()
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	readings := 0
+	docs := make([]interface{}, len(batch))
+	for i, item := range batch {
+		docs[i] = newSensorMessageDocument(item.parsed)
+		readings += item.parsed.TotalReadings
+	}
+
+	// 无序插入：单条失败（如重复）不影响同批次的其他消息
+	insertStart := time.Now()
+	_, err = sensorColl.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		errs = bulkInsertErrors(err, len(batch))
+	}
+
+	inserted := 0
+	for _, itemErr := range errs {
+		if itemErr == nil {
+			inserted++
+		}
+	}
+	LogDatabaseOperation("bulk_insert_sensor_messages", err == nil, len(batch), time.Since(insertStart))
+	Logger.Debug("批量写入传感器消息",
+		slog.Int("batch", len(batch)),
+		slog.Int("inserted", inserted),
+		slog.Int("readings", readings),
+		slog.Duration("duration", time.Since(insertStart)))
+
+	// 只为成功插入的消息累加设备信息
+	deltas := make(map[string]*deviceInfoDelta)
+	order := make([]string, 0)
+	for i, item := range batch {
+		// 未按写关注确认的消息已写入主节点，回放时会被识别为重复而不再累加，因此这里同样累加
+		if errs[i] != nil && !errors.Is(errs[i], errWriteConcernUnsatisfied) {
+			continue
+		}
+		delta, ok := deltas[item.parsed.DeviceID]
+		if !ok {
+			delta = newDeviceInfoDelta(item.parsed.DeviceID)
+			deltas[item.parsed.DeviceID] = delta
+			order = append(order, item.parsed.DeviceID)
+		}
+		delta.Add(item.parsed)
+	}
+	if len(order) == 0 {
+		return errs
+	}
+
+	models := make([]mongo.WriteModel, 0, len(order))
+	for _, deviceID := range order {
+		delta := deltas[deviceID]
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(delta.Filter()).
+			SetUpdate(delta.Update()).
+			SetUpsert(true))
+	}
+
+	deviceStart := time.Now()
+	result, err := deviceColl.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	LogDatabaseOperation("bulk_update_device_info", err == nil, len(models), time.Since(deviceStart))
+	if err != nil {
+		// 设备信息是派生统计，失败不影响消息本身的写入结果
+		LogError("批量更新设备信息", err, slog.Int("devices", len(models)))
+	} else if result.UpsertedCount > 0 {
+		Logger.Info("创建新设备记录", slog.Int64("count", result.UpsertedCount))
+	}
+
+	return errs
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestBulkWriterFlushOnSizeAndClose(t *testing.T) {
+	originalClient := mongoClient
+	mongoClient = nil
+	defer func() { mongoClient = originalClient }()
+
+	writer := NewBulkWriter(3, time.Hour)
+	writer.Start()
+
+	var mutex sync.Mutex
+	results := make([]error, 0)
+	callback := func(err error) {
+		mutex.Lock()
+		results = append(results, err)
+		mutex.Unlock()
+	}
+
+	for i := 0; i < 3; i++ {
+		writer.Add(newTestIngestJob(int64(i)).Parsed, callback)
+	}
+
+	// 达到批量大小后应立即写入，无需等待定时器
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if writer.Stats().Batches == 1 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if batches := writer.Stats().Batches; batches != 1 {
+		t.Fatalf("期望写入1个批次，实际为%d", batches)
+	}
+
+	// 剩余消息在关闭时写入
+	writer.Add(newTestIngestJob(10).Parsed, callback)
+	if err := writer.Close(); err == nil {
+		t.Error("剩余消息写入失败时关闭应返回错误")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(results) != 4 {
+		t.Fatalf("期望4次回调，实际为%d", len(results))
+	}
+	for _, err := range results {
+		// 没有MongoDB连接时每条消息都应得到错误
+		if err == nil {
+			t.Error("无数据库连接时不应报告写入成功")
+		}
+	}
+
+	stats := writer.Stats()
+	if stats.Batches != 2 || stats.Failed != 4 || stats.Pending != 0 {
+		t.Errorf("统计信息不正确: %+v", stats)
+	}
+}
+
+func TestBulkWriterRejectsWhenFullOrClosed(t *testing.T) {
+	// 未启动后台协程，缓冲区不会被消费
+	writer := NewBulkWriter(1, time.Hour)
+
+	var lastErr error
+	for i := 0; i <= bulkWriterMaxPendingBatches; i++ {
+		writer.Add(newTestIngestJob(int64(i)).Parsed, func(err error) { lastErr = err })
+	}
+	if !errors.Is(lastErr, errBulkWriterFull) {
+		t.Errorf("缓冲区已满时应返回errBulkWriterFull，实际为%v", lastErr)
+	}
+
+	writer.mutex.Lock()
+	writer.closed = true
+	writer.mutex.Unlock()
+	writer.Add(newTestIngestJob(99).Parsed, func(err error) { lastErr = err })
+	if !errors.Is(lastErr, errBulkWriterClosed) {
+		t.Errorf("关闭后应返回errBulkWriterClosed，实际为%v", lastErr)
+	}
+}
+
+func TestDeviceInfoDelta(t *testing.T) {
+	base := time.Date(2025, 7, 5, 12, 0, 0, 0, time.UTC)
+	delta := newDeviceInfoDelta("device-1")
+
+	delta.Add(&ParsedSensorData{DeviceID: "device-1", SessionID: "s1", TotalReadings: 5,
+		SensorTypes: []string{"gyroscope", "accelerometer"}, ReceivedAt: base.Add(time.Minute)})
+	delta.Add(&ParsedSensorData{DeviceID: "device-1", SessionID: "s2", TotalReadings: 3,
+		SensorTypes: []string{"accelerometer"}, ReceivedAt: base})
+
+	if delta.Messages != 2 || delta.Records != 8 {
+		t.Errorf("累计数量不正确: messages=%d records=%d", delta.Messages, delta.Records)
+	}
+	if !delta.FirstSeen.Equal(base) || !delta.LastSeen.Equal(base.Add(time.Minute)) {
+		t.Errorf("时间范围不正确: %v - %v", delta.FirstSeen, delta.LastSeen)
+	}
+
+	update := delta.Update()
+	inc := update["$inc"].(bson.M)
+	if inc["totalMessages"] != int64(2) || inc["totalRecords"] != int64(8) {
+		t.Errorf("$inc不正确: %v", inc)
+	}
+	addToSet := update["$addToSet"].(bson.M)
+	sensorTypes := addToSet["sensorTypes"].(bson.M)["$each"].([]string)
+	if len(sensorTypes) != 2 || sensorTypes[0] != "accelerometer" {
+		t.Errorf("传感器类型不正确: %v", sensorTypes)
+	}
+	if delta.Filter()["deviceId"] != "device-1" {
+		t.Errorf("查询条件不正确: %v", delta.Filter())
+	}
+}
+
+func TestBulkInsertErrors(t *testing.T) {
+	// 单条写入错误按索引对应到消息，写关注错误只影响没有单条错误的消息
+	err := mongo.BulkWriteException{
+		WriteErrors: []mongo.BulkWriteError{
+			{WriteError: mongo.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}},
+			{WriteError: mongo.WriteError{Index: 2, Code: 121, Message: "Document failed validation"}},
+		},
+		WriteConcernError: &mongo.WriteConcernError{Code: 64, Message: "waiting for replication timed out"},
+	}
+	errs := bulkInsertErrors(err, 4)
+	if !errors.Is(errs[0], errDuplicateMessage) {
+		t.Errorf("重复键错误应返回errDuplicateMessage，实际为%v", errs[0])
+	}
+	if errs[2] == nil || errors.Is(errs[2], errWriteConcernUnsatisfied) {
+		t.Errorf("单条写入错误应保留原错误，实际为%v", errs[2])
+	}
+	for _, i := range []int{1, 3} {
+		if !errors.Is(errs[i], errWriteConcernUnsatisfied) {
+			t.Errorf("第%d条只有写关注错误，期望errWriteConcernUnsatisfied，实际为%v", i, errs[i])
+		}
+	}
+
+	// 只有单条写入错误时其余消息视为成功
+	errs = bulkInsertErrors(mongo.BulkWriteException{WriteErrors: err.WriteErrors[:1]}, 2)
+	if errs[1] != nil {
+		t.Errorf("没有错误的消息应视为写入成功，实际为%v", errs[1])
+	}
+
+	// 其他错误时整批失败
+	for _, itemErr := range bulkInsertErrors(errors.New("connection reset"), 2) {
+		if itemErr == nil || errors.Is(itemErr, errWriteConcernUnsatisfied) {
+			t.Errorf("连接错误时整批应失败，实际为%v", itemErr)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Package client 向传感器日志服务器推送数据的Go客户端，
+// 按服务器的要求为请求签名（HMAC-SHA256，见 Sign）
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 签名相关的请求头
+const (
+	TimestampHeader = "X-Sensor-Timestamp" // Unix时间戳（秒）
+	SignatureHeader = "X-Sensor-Signature" // v1=<十六进制的HMAC-SHA256>
+)
+
+// SignatureVersion 签名格式的版本
+const SignatureVersion = "v1"
+
+// Reading 单条传感器读数
+type Reading struct {
+	Name     string                 `json:"name"`
+	Time     int64                  `json:"time"` // 纳秒时间戳
+	Accuracy int                    `json:"accuracy,omitempty"`
+	Values   map[string]interface{} `json:"values"`
+}
+
+// Message 与Sensor Logger应用推送格式相同的消息
+type Message struct {
+	MessageID int64     `json:"messageId"`
+	SessionID string    `json:"sessionId"`
+	DeviceID  string    `json:"deviceId"`
+	Payload   []Reading `json:"payload"`
+}
+
+// Ack 服务器的确认响应（只包含常用字段）
+type Ack struct {
+	StatusCode int               `json:"-"` // 200表示已持久化，202表示已写入服务器的写前缓冲，稍后写入数据库
+	Message    string            `json:"message"`
+	IngestID   string            `json:"ingestId"`
+	MessageID  int64             `json:"messageId"`
+	Duplicate  bool              `json:"duplicate"`
+	Sinks      map[string]string `json:"sinks"`
+}
+
+// StatusError 服务器返回的非2xx响应
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // 429和503响应建议的重试等待时间
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("服务器返回 %d: %s", e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// StringToSign 待签名的字符串：时间戳和请求体（传输编码后的字节）SHA-256摘要的十六进制，以换行分隔
+func StringToSign(timestamp int64, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strconv.FormatInt(timestamp, 10) + "\n" + hex.EncodeToString(sum[:])
+}
+
+// Signature 计算签名头的值
+func Signature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(StringToSign(timestamp, body)))
+	return SignatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign 为请求设置时间戳和签名头。body必须与实际发送的字节完全一致（压缩时为压缩后的字节）
+func Sign(req *http.Request, secret string, body []byte, now time.Time) {
+	timestamp := now.Unix()
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Signature(secret, timestamp, body))
+}
+
+// Client 推送数据的客户端
+type Client struct {
+	URL    string // 数据接收地址，例如 http://localhost:18000/data
+	Token  string // 设备令牌（可选，启用DEVICE_AUTH_MODE时需要）
+	Secret string // 签名密钥（可选，为设备配置了签名密钥时需要）
+	Gzip   bool   // 是否压缩请求体
+
+	HTTPClient *http.Client // 为空时使用 http.DefaultClient
+}
+
+// Send 推送一条消息，2xx响应返回确认（Ack.StatusCode为实际状态码），其他响应返回 *StatusError
+func (c *Client) Send(ctx context.Context, message Message) (*Ack, error) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	if c.Gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if c.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.Secret != "" {
+		Sign(req, c.Secret, body, time.Now())
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		statusErr := &StatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			statusErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, statusErr
+	}
+
+	ack := Ack{StatusCode: resp.StatusCode}
+	if err := json.Unmarshal(respBody, &ack); err != nil {
+		return nil, fmt.Errorf("解析确认响应失败: %v", err)
+	}
+	return &ack, nil
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"messageId":1}`)
+
+	// 与服务器的算法一致：HMAC-SHA256(密钥, 时间戳 + "\n" + hex(SHA-256(请求体)))
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte("sls_secret"))
+	mac.Write([]byte("1751729987\n" + hex.EncodeToString(sum[:])))
+	expected := "v1=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := Signature("sls_secret", 1751729987, body); got != expected {
+		t.Errorf("签名不正确: %s != %s", got, expected)
+	}
+}
+
+func TestClientSend(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		raw, _ := io.ReadAll(r.Body)
+
+		timestamp, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		if r.Header.Get(SignatureHeader) != Signature("sls_secret", timestamp, raw) {
+			t.Error("签名应覆盖实际发送的字节")
+		}
+		if r.Header.Get("Authorization") != "Bearer slt_token" {
+			t.Errorf("缺少设备令牌: %q", r.Header.Get("Authorization"))
+		}
+		zr, err := gzip.NewReader(strings.NewReader(string(raw)))
+		if err != nil {
+			t.Fatalf("请求体应为gzip压缩: %v", err)
+		}
+		decoded, _ := io.ReadAll(zr)
+		if !strings.Contains(string(decoded), `"deviceId":"device-a"`) {
+			t.Errorf("请求体不正确: %s", decoded)
+		}
+
+		if attempts > 1 {
+			w.Header().Set("Retry-After", "3")
+			http.Error(w, `{"error":"rate_limited"}`, http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"message":"数据接收成功","ingestId":"abc","messageId":1}`))
+	}))
+	defer server.Close()
+
+	c := &Client{URL: server.URL, Token: "slt_token", Secret: "sls_secret", Gzip: true}
+	message := Message{
+		MessageID: 1,
+		SessionID: "session",
+		DeviceID:  "device-a",
+		Payload:   []Reading{{Name: "accelerometer", Time: time.Now().UnixNano(), Values: map[string]interface{}{"x": 0.1}}},
+	}
+
+	ack, err := c.Send(context.Background(), message)
+	if err != nil || ack.IngestID != "abc" || ack.StatusCode != http.StatusOK {
+		t.Fatalf("发送失败: %v %+v", err, ack)
+	}
+
+	_, err = c.Send(context.Background(), message)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests || statusErr.RetryAfter != 3*time.Second {
+		t.Errorf("期望429和Retry-After，实际为%v", err)
+	}
+}
+
+func TestClientSendAccepted(t *testing.T) {
+	// require_db模式下数据库不可用时，消息写入写前缓冲后返回202
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"message":"数据已写入缓冲，将在数据库恢复后写入","ingestId":"abc","messageId":1,"sinks":{"mongo":"spooled"}}`))
+	}))
+	defer server.Close()
+
+	c := &Client{URL: server.URL}
+	ack, err := c.Send(context.Background(), Message{MessageID: 1, SessionID: "session", DeviceID: "device-a"})
+	if err != nil {
+		t.Fatalf("202响应不应视为失败: %v", err)
+	}
+	if ack.StatusCode != http.StatusAccepted || ack.Sinks["mongo"] != "spooled" {
+		t.Errorf("确认响应不正确: %+v", ack)
+	}
+}
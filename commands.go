@@ -0,0 +1,196 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// command 命令行子命令
+type command struct {
+	Name        string
+	Usage       string
+	Description string
+	Run         func(args []string) int
+}
+
+// commands 支持的子命令（不带子命令时启动服务器）
+var commands = []command{
+	{
+		Name:        "import",
+		Usage:       "import [-device ID] [-session ID] [-batch N] <导出.zip>...",
+		Description: "导入Sensor Logger导出的录制文件（CSV压缩包）",
+		Run:         runImportCommand,
+	},
+	{
+		Name:        "replay",
+		Usage:       "replay [-dir 目录] [-device ID] [-from 时间] [-to 时间] [-dry-run]",
+		Description: "将数据目录中的原始数据（归档和旧版本文件）写回MongoDB（已存在的消息跳过）",
+		Run:         runReplayCommand,
+	},
+	{
+		Name:        "repair-payload",
+		Usage:       "repair-payload [-dir 目录] [-dry-run]",
+		Description: "用数据目录中的原始数据修复MongoDB中旧版本保存的payload",
+		Run:         runRepairCommand,
+	},
+	{
+		Name:        "token",
+		Usage:       "token create -device ID [-name 名称] | token list [-device ID] | token revoke <令牌ID>",
+		Description: "创建、列出或吊销设备令牌（DEVICE_AUTH_MODE启用后/data等接收接口需要携带）",
+		Run:         runTokenCommand,
+	},
+	{
+		Name:        "signing-key",
+		Usage:       "signing-key create -device ID | signing-key list [-device ID] | signing-key revoke <密钥ID>",
+		Description: "创建、列出或吊销设备的请求签名密钥（SIGNATURE_MODE启用后该设备推送到/data的请求必须签名）",
+		Run:         runSigningKeyCommand,
+	},
+	{
+		Name:        "user",
+		Usage:       "user add|update|passwd|remove|list|apikey-create|apikey-revoke [-name 用户名] [-role 角色] [-devices ID,...]",
+		Description: "管理仪表板和读取接口的本地用户及API密钥（密码从标准输入读取）",
+		Run:         runUserCommand,
+	},
+}
+
+// runCommand 执行子命令，返回进程退出码
+func runCommand(args []string) int {
+	for _, cmd := range commands {
+		if cmd.Name == args[0] {
+			return cmd.Run(args[1:])
+		}
+	}
+
+	switch args[0] {
+	case "help", "-h", "--help":
+		printCommandUsage(os.Stdout)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "未知命令: %s\n\n", args[0])
+	printCommandUsage(os.Stderr)
+	return 2
+}
+
+// printCommandUsage 输出子命令列表
+func printCommandUsage(w io.Writer) {
+	fmt.Fprintln(w, "用法: sensor-logger-server [命令] [参数]")
+	fmt.Fprintln(w, "\n不带命令时启动服务器。可用命令:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\n      %s\n", cmd.Usage, cmd.Description)
+	}
+}
+
+// startCommandMongo 为只读写数据库的命令行工具连接MongoDB，返回清理函数
+func startCommandMongo() func() {
+	mongoSupervisor = NewMongoSupervisor(
+		time.Duration(AppConfig.MongoRetryInitial)*time.Second,
+		time.Duration(AppConfig.MongoRetryMax)*time.Second,
+		time.Duration(AppConfig.MongoHealthInterval)*time.Second)
+	mongoSupervisor.Start()
+
+	return func() {
+		if err := mongoSupervisor.Close(); err != nil {
+			Logger.Error("关闭MongoDB连接失败", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// startCommandPersistence 为写入消息的命令行工具连接MongoDB并准备写前缓冲、原始数据归档和去重器，返回清理函数
+// MongoDB不可用时消息写入写前缓冲，退出后由服务器回放
+func startCommandPersistence() func() {
+	closeMongo := startCommandMongo()
+	if !mongoAvailable() {
+		Logger.Warn("MongoDB不可用，消息将写入写前缓冲，退出后由服务器回放")
+	}
+
+	// 服务器可能同时在运行，使用独立的缓冲目录，退出后交给服务器回放
+	if AppConfig.EnableSpool {
+		spool, err := NewCommandSpool(filepath.Join(AppConfig.DataDir, "spool"))
+		if err != nil {
+			Logger.Error("写前缓冲初始化失败", slog.String("error", err.Error()))
+		} else {
+			messageSpool = spool
+		}
+	}
+
+	if AppConfig.EnableFileLog {
+		startRawArchive()
+	}
+
+	messageDeduplicator = NewMessageDeduplicator(AppConfig.DedupCacheSize)
+
+	return func() {
+		if rawArchive != nil {
+			if err := rawArchive.Close(); err != nil {
+				Logger.Error("关闭原始数据归档失败", slog.String("error", err.Error()))
+			}
+		}
+		if messageSpool != nil {
+			if err := messageSpool.Handoff(); err != nil {
+				Logger.Error("交出写前缓冲失败", slog.String("error", err.Error()))
+			}
+		}
+		closeMongo()
+	}
+}
+
+// runImportCommand 导入Sensor Logger导出的zip文件
+func runImportCommand(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	deviceID := flags.String("device", "", "设备ID（默认使用Metadata.csv中的device id）")
+	sessionID := flags.String("session", "", "会话ID（默认由设备ID和录制时间生成）")
+	batchSize := flags.Int("batch", defaultImportBatchSize, "每条消息包含的读数数量")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "请指定要导入的zip文件")
+		flags.Usage()
+		return 2
+	}
+	if *sessionID != "" && flags.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "指定-session时只能导入一个文件")
+		return 2
+	}
+
+	// 命令行导入时不在控制台逐条显示数据
+	AppConfig.EnableLogging = false
+	cleanup := startCommandPersistence()
+	defer cleanup()
+
+	opts := ImportOptions{DeviceID: *deviceID, SessionID: *sessionID, BatchSize: *batchSize}
+	exitCode := 0
+	for _, path := range flags.Args() {
+		summary, err := importRecordingFile(path, opts)
+		if summary != nil {
+			output, _ := json.MarshalIndent(summary, "", "  ")
+			fmt.Printf("%s:\n%s\n", path, output)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "导入 %s 失败: %v\n", path, err)
+			exitCode = 1
+		} else if summary.Rejected > 0 || summary.Failed > 0 {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// importRecordingFile 导入单个zip文件
+func importRecordingFile(path string, opts ImportOptions) (*ImportSummary, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	return importRecordingExport(context.Background(), &archive.Reader, opts)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 最多允许叠加的内容编码层数，防止 gzip(gzip(gzip(...))) 式的嵌套攻击
+const maxContentEncodingLayers = 2
+
+// errUnsupportedEncoding 不支持的Content-Encoding
+var errUnsupportedEncoding = errors.New("不支持的内容编码")
+
+// BodyTooLargeError 请求体超出限制
+type BodyTooLargeError struct {
+	Limit string // 触发的限制名称
+	Max   int64  // 限制值
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("请求体超出限制 %s=%d", e.Limit, e.Max)
+}
+
+// limitedReadCloser 超出上限时返回错误的读取器（io.LimitReader 只会静默截断）
+type limitedReadCloser struct {
+	reader    io.Reader
+	closers   []io.Closer
+	remaining int64
+	limit     string
+	max       int64
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, &BodyTooLargeError{Limit: l.limit, Max: l.max}
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n - int(-l.remaining), &BodyTooLargeError{Limit: l.limit, Max: l.max}
+	}
+	return n, err
+}
+
+func (l *limitedReadCloser) Close() error {
+	var firstErr error
+	for i := len(l.closers) - 1; i >= 0; i-- {
+		if err := l.closers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// parseContentEncodings 解析Content-Encoding头，返回按应用顺序排列的编码列表
+func parseContentEncodings(header string) ([]string, error) {
+	encodings := make([]string, 0)
+	for _, part := range strings.Split(header, ",") {
+		encoding := strings.ToLower(strings.TrimSpace(part))
+		if encoding == "" || encoding == "identity" {
+			continue
+		}
+		switch encoding {
+		case "gzip", "x-gzip", "deflate", "zstd":
+			encodings = append(encodings, encoding)
+		default:
+			return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, encoding)
+		}
+	}
+
+	if len(encodings) > maxContentEncodingLayers {
+		return nil, fmt.Errorf("%w: 编码层数过多(%d)", errUnsupportedEncoding, len(encodings))
+	}
+	return encodings, nil
+}
+
+// bodyLimits 请求体的大小限制，超限时以 BodyLimit/DecompressedLimit 作为限制名称报告
+type bodyLimits struct {
+	BodyLimit         string
+	MaxBody           int64
+	DecompressedLimit string
+	MaxDecompressed   int64
+}
+
+// newDecodedBodyReader 根据Content-Encoding返回解压后的请求体读取器
+// 传输的字节数受 MaxBodyBytes 限制，解压后的数据量受 MaxDecompressedBytes 限制（用于防御解压炸弹）
+func newDecodedBodyReader(r *http.Request) (io.ReadCloser, error) {
+	return newLimitedBodyReader(r, bodyLimits{
+		BodyLimit:         "max_body_bytes",
+		MaxBody:           AppConfig.MaxBodyBytes,
+		DecompressedLimit: "max_decompressed_bytes",
+		MaxDecompressed:   AppConfig.MaxDecompressedBytes,
+	})
+}
+
+// newLimitedBodyReader 按指定的限制返回解压后的请求体读取器
+func newLimitedBodyReader(r *http.Request, limits bodyLimits) (io.ReadCloser, error) {
+	encodings, err := parseContentEncodings(r.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader = r.Body
+	if limits.MaxBody > 0 {
+		reader = &limitedReadCloser{
+			reader:    r.Body,
+			remaining: limits.MaxBody,
+			limit:     limits.BodyLimit,
+			max:       limits.MaxBody,
+		}
+	}
+	closers := make([]io.Closer, 0, len(encodings))
+
+	// 编码按应用顺序列出，解码时需要逆序
+	for i := len(encodings) - 1; i >= 0; i-- {
+		decoder, err := newEncodingDecoder(encodings[i], reader)
+		if err != nil {
+			for j := len(closers) - 1; j >= 0; j-- {
+				closers[j].Close()
+			}
+			return nil, fmt.Errorf("初始化%s解码器失败: %v", encodings[i], err)
+		}
+		closers = append(closers, decoder)
+		reader = decoder
+	}
+
+	return &limitedReadCloser{
+		reader:    reader,
+		closers:   closers,
+		remaining: limits.MaxDecompressed,
+		limit:     limits.DecompressedLimit,
+		max:       limits.MaxDecompressed,
+	}, nil
+}
+
+// newEncodingDecoder 创建单层编码的解码器
+func newEncodingDecoder(encoding string, reader io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(reader)
+	case "deflate":
+		// HTTP规范中的deflate是zlib封装格式，但不少客户端直接发送原始deflate流
+		buffered := bufio.NewReader(reader)
+		header, err := buffered.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		if isZlibHeader(header) {
+			return zlib.NewReader(buffered)
+		}
+		return flate.NewReader(buffered), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(reader,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(uint64(AppConfig.MaxDecompressedBytes)))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, encoding)
+	}
+}
+
+// isZlibHeader 判断是否为zlib头（CMF/FLG校验）
+func isZlibHeader(header []byte) bool {
+	if len(header) < 2 {
+		return false
+	}
+	return header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const compressionTestBody = `{"messageId":1,"sessionId":"s","deviceId":"d","payload":[{"name":"accelerometer","time":1751729987437545000,"accuracy":3,"values":{"x":1,"y":2,"z":3}}]}`
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write(data)
+	if err := writer.Close(); err != nil {
+		t.Fatalf("gzip压缩失败: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseContentEncodings(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected []string
+		wantErr  bool
+	}{
+		{"", []string{}, false},
+		{"identity", []string{}, false},
+		{"gzip", []string{"gzip"}, false},
+		{"GZIP, zstd", []string{"gzip", "zstd"}, false},
+		{"br", nil, true},
+		{"gzip, gzip, gzip", nil, true},
+	}
+
+	for _, test := range tests {
+		result, err := parseContentEncodings(test.header)
+		if test.wantErr {
+			if !errors.Is(err, errUnsupportedEncoding) {
+				t.Errorf("编码%q期望返回不支持错误，实际为%v", test.header, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("编码%q解析失败: %v", test.header, err)
+			continue
+		}
+		if strings.Join(result, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("编码%q期望%v，实际为%v", test.header, test.expected, result)
+		}
+	}
+}
+
+func TestNewDecodedBodyReader(t *testing.T) {
+	plain := []byte(compressionTestBody)
+
+	var zlibBuf, rawDeflateBuf bytes.Buffer
+	zw := zlib.NewWriter(&zlibBuf)
+	zw.Write(plain)
+	zw.Close()
+	fw, _ := flate.NewWriter(&rawDeflateBuf, flate.DefaultCompression)
+	fw.Write(plain)
+	fw.Close()
+
+	zstdEncoder, _ := zstd.NewWriter(nil)
+	zstdBody := zstdEncoder.EncodeAll(plain, nil)
+	zstdEncoder.Close()
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{"无编码", "", plain},
+		{"gzip", "gzip", gzipBytes(t, plain)},
+		{"deflate(zlib)", "deflate", zlibBuf.Bytes()},
+		{"deflate(原始)", "deflate", rawDeflateBuf.Bytes()},
+		{"zstd", "zstd", zstdBody},
+		{"嵌套", "zstd, gzip", gzipBytes(t, zstdBody)},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/data", bytes.NewReader(test.body))
+		if test.encoding != "" {
+			req.Header.Set("Content-Encoding", test.encoding)
+		}
+
+		reader, err := newDecodedBodyReader(req)
+		if err != nil {
+			t.Fatalf("%s: 创建解码器失败: %v", test.name, err)
+		}
+		decoded, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("%s: 读取失败: %v", test.name, err)
+		}
+		if !bytes.Equal(decoded, plain) {
+			t.Errorf("%s: 解压结果不一致", test.name)
+		}
+	}
+}
+
+func TestDecompressionBombProtection(t *testing.T) {
+	originalMax := AppConfig.MaxDecompressedBytes
+	AppConfig.MaxDecompressedBytes = 1024
+	defer func() { AppConfig.MaxDecompressedBytes = originalMax }()
+
+	// 合法JSON前缀后跟大量填充，确保解码器会持续读取
+	padding := append([]byte(`{"messageId":1,"padding":"`), bytes.Repeat([]byte("0"), 1<<20)...)
+	bomb := gzipBytes(t, append(padding, `"}`...))
+	req := httptest.NewRequest("POST", "/data", bytes.NewReader(bomb))
+	req.Header.Set("Content-Encoding", "gzip")
+
+	rr := httptest.NewRecorder()
+	handleSensorData(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("期望状态码413，实际为%d", rr.Code)
+	}
+}
+
+func TestHandleSensorDataCompressed(t *testing.T) {
+	req := httptest.NewRequest("POST", "/data", bytes.NewReader(gzipBytes(t, []byte(compressionTestBody))))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	rr := httptest.NewRecorder()
+	handleSensorData(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("期望状态码200，实际为%d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/data", strings.NewReader(compressionTestBody))
+	req.Header.Set("Content-Encoding", "br")
+	rr = httptest.NewRecorder()
+	handleSensorData(rr, req)
+
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("期望状态码415，实际为%d", rr.Code)
+	}
+}
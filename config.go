@@ -12,14 +12,32 @@ import (
 // Config 应用配置结构
 type Config struct {
 	// 服务器配置
-	ServerPort string
-	ServerHost string
+	ServerPort      string
+	ServerHost      string
+	ShutdownTimeout int // 优雅关闭的最长等待时间（秒）
+
+	// HTTPS配置
+	EnableTLS       bool
+	TLSCertFile     string // 证书路径，为空时使用 DataDir/tls/server.crt
+	TLSKeyFile      string // 私钥路径，为空时使用 DataDir/tls/server.key
+	TLSSelfSigned   bool   // 证书不存在时生成自签名证书
+	TLSClientAuth   string // 客户端证书：none、optional、require
+	TLSClientCAFile string // 用于校验客户端证书的CA
 
 	// 数据库配置
 	MongoURI      string
 	MongoDatabase string
 	MongoTimeout  int
 
+	// MongoDB连接监控配置（秒）
+	MongoRetryInitial   int // 重连的初始退避时间
+	MongoRetryMax       int // 重连的最大退避时间
+	MongoHealthInterval int // 连接正常时的健康检查间隔
+
+	// MongoDB批量写入配置
+	MongoBulkBatchSize int // 每批最多写入的消息数量
+	MongoBulkFlushMs   int // 未达到批量大小时的最长等待时间（毫秒）
+
 	// 应用配置
 	MaxDataStore  int
 	EnableLogging bool
@@ -29,6 +47,68 @@ type Config struct {
 	// 文件存储配置
 	DataDir       string
 	EnableFileLog bool
+
+	// 原始数据归档配置（DataDir/archive）
+	ArchiveSegmentMaxBytes int64  // 段达到该大小后轮转
+	ArchiveSegmentMaxAge   int    // 段打开超过该时间后轮转（秒）
+	ArchiveCompression     string // 关闭的段的压缩方式：none、gzip、zstd
+	ArchiveFsync           string // 同步策略：always、interval、never
+
+	// 写前缓冲配置（MongoDB不可用时将消息暂存到 DataDir/spool）
+	EnableSpool         bool
+	SpoolReplayInterval int // 回放缓冲数据的检查间隔（秒）
+
+	// 请求体配置
+	MaxBodyBytes          int64 // 请求体（传输编码后）的最大字节数
+	MaxDecompressedBytes  int64 // 解压后请求体的最大字节数
+	MaxReadingsPerMessage int   // 单条消息中读数的最大数量
+
+	// 批量导入配置（/api/v1/ingest/bulk）
+	BulkMaxBodyBytes         int64 // 批量导入请求体（传输编码后）的最大字节数
+	BulkMaxDecompressedBytes int64 // 批量导入解压后请求体的最大字节数
+
+	// 入库流水线配置
+	IngestWorkers    int // 工作协程数量
+	IngestQueueSize  int // 队列容量
+	IngestRetryAfter int // 队列已满时建议客户端重试的秒数
+	DedupCacheSize   int // 内存中用于去重的最近消息数量
+
+	// 数据接收限流配置（令牌桶，速率为0表示不限流）
+	RateLimitDeviceRate      float64 // 每个设备每秒允许的请求数
+	RateLimitDeviceBurst     int     // 每个设备允许的突发请求数
+	RateLimitIPRate          float64 // 每个来源IP每秒允许的请求数
+	RateLimitIPBurst         int     // 每个来源IP允许的突发请求数
+	RateLimitDeviceOverrides string  // 按设备覆盖：设备ID=速率[:突发]，逗号分隔
+
+	// 持久化要求（best_effort/require_db/require_any_sink）
+	DurabilityMode string
+
+	// 数据校验配置
+	ValidationMode           string // 校验模式（reject/strip/warn）
+	ValidationMaxAgeHours    int    // 读数时间早于接收时间的最大小时数，0表示不检查
+	ValidationMaxSkewSeconds int    // 读数时间晚于接收时间的最大秒数，0表示不检查
+
+	// 传感器描述配置
+	SensorDescriptorsFile string // 覆盖或补充内置传感器描述的JSON/YAML文件，为空时只使用内置描述
+
+	// 定位精度配置
+	LocationMaxHorizontalAccuracy float64 // 定位水平精度的阈值（米），超过时按LocationAccuracyMode处理，0表示不检查
+	LocationAccuracyMode          string  // 低精度定位的处理方式（flag/drop）
+
+	// 管理接口配置（/api/admin/*，未设置令牌时禁用）
+	AdminToken string
+
+	// 设备令牌配置
+	DeviceAuthMode string // off、warn、enforce
+
+	// 请求签名配置（HMAC-SHA256）
+	SignatureMode    string // off、warn、enforce
+	SignatureMaxSkew int    // 签名时间戳与服务器时间的最大偏差（秒），即防重放的时间窗口
+
+	// 用户鉴权配置（仪表板和读取接口）
+	EnableAuth         bool
+	AuthSessionTTL     int      // 登录会话有效期（小时）
+	CORSAllowedOrigins []string // 读取接口允许的跨域来源
 }
 
 // 默认配置
@@ -38,12 +118,68 @@ var defaultConfig = Config{
 	MongoURI:      "mongodb://localhost:27017",
 	MongoDatabase: "sensor_logger",
 	MongoTimeout:  10,
+
+	ShutdownTimeout: 30,
+
+	EnableTLS:     false,
+	TLSSelfSigned: false,
+	TLSClientAuth: TLSClientAuthNone,
+
+	MongoRetryInitial:   1,
+	MongoRetryMax:       60,
+	MongoHealthInterval: 10,
+
+	MongoBulkBatchSize: 200,
+	MongoBulkFlushMs:   1000,
+
 	MaxDataStore:  100,
 	EnableLogging: true,
 	LogLevel:      "info",
 	Environment:   "dev",
 	DataDir:       "./data",
 	EnableFileLog: true,
+
+	ArchiveSegmentMaxBytes: 64 << 20,
+	ArchiveSegmentMaxAge:   3600,
+	ArchiveCompression:     ArchiveCompressGzip,
+	ArchiveFsync:           ArchiveFsyncInterval,
+
+	EnableSpool:         true,
+	SpoolReplayInterval: 10,
+
+	MaxBodyBytes:          16 << 20,
+	MaxDecompressedBytes:  64 << 20,
+	MaxReadingsPerMessage: 50000,
+
+	BulkMaxBodyBytes:         256 << 20,
+	BulkMaxDecompressedBytes: 1 << 30,
+
+	IngestWorkers:    4,
+	IngestQueueSize:  1000,
+	IngestRetryAfter: 5,
+	DedupCacheSize:   defaultDedupCacheSize,
+
+	RateLimitDeviceRate:  5,
+	RateLimitDeviceBurst: 20,
+	RateLimitIPRate:      20,
+	RateLimitIPBurst:     50,
+
+	DurabilityMode: DurabilityBestEffort,
+
+	ValidationMode:           ValidationWarn,
+	ValidationMaxAgeHours:    168,
+	ValidationMaxSkewSeconds: 300,
+
+	LocationMaxHorizontalAccuracy: 0,
+	LocationAccuracyMode:          LocationAccuracyFlag,
+
+	DeviceAuthMode: DeviceAuthOff,
+
+	SignatureMode:    SignatureOff,
+	SignatureMaxSkew: 300,
+
+	EnableAuth:     false,
+	AuthSessionTTL: 12,
 }
 
 // 全局配置实例
@@ -102,8 +238,8 @@ func loadEnvFile(filename string) error {
 		if len(parts) != 2 {
 			// 在日志系统初始化前使用slog.Warn，但需要检查Logger是否已初始化
 			if Logger != nil {
-				Logger.Warn("env文件格式错误", 
-					slog.Int("line", lineNum), 
+				Logger.Warn("env文件格式错误",
+					slog.Int("line", lineNum),
 					slog.String("content", line))
 			} else {
 				fmt.Printf("警告: .env文件第%d行格式错误: %s\n", lineNum, line)
@@ -125,8 +261,8 @@ func loadEnvFile(filename string) error {
 		// 设置环境变量
 		if err := os.Setenv(key, value); err != nil {
 			if Logger != nil {
-				Logger.Warn("设置环境变量失败", 
-					slog.String("key", key), 
+				Logger.Warn("设置环境变量失败",
+					slog.String("key", key),
 					slog.String("error", err.Error()))
 			} else {
 				fmt.Printf("警告: 无法设置环境变量 %s: %v\n", key, err)
@@ -145,6 +281,30 @@ func loadFromEnv() {
 	if val := os.Getenv("SERVER_HOST"); val != "" {
 		AppConfig.ServerHost = val
 	}
+	if val := os.Getenv("SHUTDOWN_TIMEOUT"); val != "" {
+		if timeout, err := strconv.Atoi(val); err == nil {
+			AppConfig.ShutdownTimeout = timeout
+		}
+	}
+
+	if val := os.Getenv("ENABLE_TLS"); val != "" {
+		AppConfig.EnableTLS = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("TLS_CERT_FILE"); val != "" {
+		AppConfig.TLSCertFile = val
+	}
+	if val := os.Getenv("TLS_KEY_FILE"); val != "" {
+		AppConfig.TLSKeyFile = val
+	}
+	if val := os.Getenv("TLS_SELF_SIGNED"); val != "" {
+		AppConfig.TLSSelfSigned = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("TLS_CLIENT_AUTH"); val != "" {
+		AppConfig.TLSClientAuth = strings.ToLower(val)
+	}
+	if val := os.Getenv("TLS_CLIENT_CA_FILE"); val != "" {
+		AppConfig.TLSClientCAFile = val
+	}
 
 	if val := os.Getenv("MONGO_URI"); val != "" {
 		AppConfig.MongoURI = val
@@ -157,6 +317,32 @@ func loadFromEnv() {
 			AppConfig.MongoTimeout = timeout
 		}
 	}
+	if val := os.Getenv("MONGO_RETRY_INITIAL"); val != "" {
+		if retry, err := strconv.Atoi(val); err == nil {
+			AppConfig.MongoRetryInitial = retry
+		}
+	}
+	if val := os.Getenv("MONGO_RETRY_MAX"); val != "" {
+		if retry, err := strconv.Atoi(val); err == nil {
+			AppConfig.MongoRetryMax = retry
+		}
+	}
+	if val := os.Getenv("MONGO_HEALTH_INTERVAL"); val != "" {
+		if interval, err := strconv.Atoi(val); err == nil {
+			AppConfig.MongoHealthInterval = interval
+		}
+	}
+
+	if val := os.Getenv("MONGO_BULK_BATCH_SIZE"); val != "" {
+		if batchSize, err := strconv.Atoi(val); err == nil {
+			AppConfig.MongoBulkBatchSize = batchSize
+		}
+	}
+	if val := os.Getenv("MONGO_BULK_FLUSH_MS"); val != "" {
+		if flushMs, err := strconv.Atoi(val); err == nil {
+			AppConfig.MongoBulkFlushMs = flushMs
+		}
+	}
 
 	if val := os.Getenv("MAX_DATA_STORE"); val != "" {
 		if maxStore, err := strconv.Atoi(val); err == nil {
@@ -176,9 +362,168 @@ func loadFromEnv() {
 	if val := os.Getenv("ENABLE_FILE_LOG"); val != "" {
 		AppConfig.EnableFileLog = strings.ToLower(val) == "true"
 	}
+	if val := os.Getenv("ARCHIVE_SEGMENT_MAX_BYTES"); val != "" {
+		if maxBytes, err := strconv.ParseInt(val, 10, 64); err == nil {
+			AppConfig.ArchiveSegmentMaxBytes = maxBytes
+		}
+	}
+	if val := os.Getenv("ARCHIVE_SEGMENT_MAX_AGE"); val != "" {
+		if maxAge, err := strconv.Atoi(val); err == nil {
+			AppConfig.ArchiveSegmentMaxAge = maxAge
+		}
+	}
+	if val := os.Getenv("ARCHIVE_COMPRESSION"); val != "" {
+		AppConfig.ArchiveCompression = strings.ToLower(val)
+	}
+	if val := os.Getenv("ARCHIVE_FSYNC"); val != "" {
+		AppConfig.ArchiveFsync = strings.ToLower(val)
+	}
+	if val := os.Getenv("ENABLE_SPOOL"); val != "" {
+		AppConfig.EnableSpool = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("SPOOL_REPLAY_INTERVAL"); val != "" {
+		if interval, err := strconv.Atoi(val); err == nil {
+			AppConfig.SpoolReplayInterval = interval
+		}
+	}
 	if val := os.Getenv("ENVIRONMENT"); val != "" {
 		AppConfig.Environment = val
 	}
+
+	if val := os.Getenv("MAX_BODY_BYTES"); val != "" {
+		if maxBytes, err := strconv.ParseInt(val, 10, 64); err == nil {
+			AppConfig.MaxBodyBytes = maxBytes
+		}
+	}
+	if val := os.Getenv("MAX_DECOMPRESSED_BYTES"); val != "" {
+		if maxBytes, err := strconv.ParseInt(val, 10, 64); err == nil {
+			AppConfig.MaxDecompressedBytes = maxBytes
+		}
+	}
+	if val := os.Getenv("MAX_READINGS_PER_MESSAGE"); val != "" {
+		if maxReadings, err := strconv.Atoi(val); err == nil {
+			AppConfig.MaxReadingsPerMessage = maxReadings
+		}
+	}
+
+	if val := os.Getenv("BULK_MAX_BODY_BYTES"); val != "" {
+		if maxBytes, err := strconv.ParseInt(val, 10, 64); err == nil {
+			AppConfig.BulkMaxBodyBytes = maxBytes
+		}
+	}
+	if val := os.Getenv("BULK_MAX_DECOMPRESSED_BYTES"); val != "" {
+		if maxBytes, err := strconv.ParseInt(val, 10, 64); err == nil {
+			AppConfig.BulkMaxDecompressedBytes = maxBytes
+		}
+	}
+
+	if val := os.Getenv("INGEST_WORKERS"); val != "" {
+		if workers, err := strconv.Atoi(val); err == nil {
+			AppConfig.IngestWorkers = workers
+		}
+	}
+	if val := os.Getenv("INGEST_QUEUE_SIZE"); val != "" {
+		if queueSize, err := strconv.Atoi(val); err == nil {
+			AppConfig.IngestQueueSize = queueSize
+		}
+	}
+	if val := os.Getenv("INGEST_RETRY_AFTER"); val != "" {
+		if retryAfter, err := strconv.Atoi(val); err == nil {
+			AppConfig.IngestRetryAfter = retryAfter
+		}
+	}
+	if val := os.Getenv("DEDUP_CACHE_SIZE"); val != "" {
+		if cacheSize, err := strconv.Atoi(val); err == nil {
+			AppConfig.DedupCacheSize = cacheSize
+		}
+	}
+	if val := os.Getenv("RATE_LIMIT_DEVICE_RATE"); val != "" {
+		if rate, err := strconv.ParseFloat(val, 64); err == nil {
+			AppConfig.RateLimitDeviceRate = rate
+		}
+	}
+	if val := os.Getenv("RATE_LIMIT_DEVICE_BURST"); val != "" {
+		if burst, err := strconv.Atoi(val); err == nil {
+			AppConfig.RateLimitDeviceBurst = burst
+		}
+	}
+	if val := os.Getenv("RATE_LIMIT_IP_RATE"); val != "" {
+		if rate, err := strconv.ParseFloat(val, 64); err == nil {
+			AppConfig.RateLimitIPRate = rate
+		}
+	}
+	if val := os.Getenv("RATE_LIMIT_IP_BURST"); val != "" {
+		if burst, err := strconv.Atoi(val); err == nil {
+			AppConfig.RateLimitIPBurst = burst
+		}
+	}
+	if val := os.Getenv("RATE_LIMIT_DEVICE_OVERRIDES"); val != "" {
+		AppConfig.RateLimitDeviceOverrides = val
+	}
+	if val := os.Getenv("DURABILITY_MODE"); val != "" {
+		AppConfig.DurabilityMode = strings.ToLower(val)
+	}
+
+	if val := os.Getenv("VALIDATION_MODE"); val != "" {
+		AppConfig.ValidationMode = strings.ToLower(val)
+	}
+	if val := os.Getenv("VALIDATION_MAX_AGE_HOURS"); val != "" {
+		if hours, err := strconv.Atoi(val); err == nil {
+			AppConfig.ValidationMaxAgeHours = hours
+		}
+	}
+	if val := os.Getenv("VALIDATION_MAX_SKEW_SECONDS"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil {
+			AppConfig.ValidationMaxSkewSeconds = seconds
+		}
+	}
+
+	if val := os.Getenv("SENSOR_DESCRIPTORS_FILE"); val != "" {
+		AppConfig.SensorDescriptorsFile = val
+	}
+
+	if val := os.Getenv("LOCATION_MAX_HORIZONTAL_ACCURACY"); val != "" {
+		if accuracy, err := strconv.ParseFloat(val, 64); err == nil {
+			AppConfig.LocationMaxHorizontalAccuracy = accuracy
+		}
+	}
+	if val := os.Getenv("LOCATION_ACCURACY_MODE"); val != "" {
+		AppConfig.LocationAccuracyMode = strings.ToLower(val)
+	}
+
+	if val := os.Getenv("ADMIN_TOKEN"); val != "" {
+		AppConfig.AdminToken = val
+	}
+
+	if val := os.Getenv("DEVICE_AUTH_MODE"); val != "" {
+		AppConfig.DeviceAuthMode = strings.ToLower(val)
+	}
+
+	if val := os.Getenv("SIGNATURE_MODE"); val != "" {
+		AppConfig.SignatureMode = strings.ToLower(val)
+	}
+	if val := os.Getenv("SIGNATURE_MAX_SKEW"); val != "" {
+		if seconds, err := strconv.Atoi(val); err == nil {
+			AppConfig.SignatureMaxSkew = seconds
+		}
+	}
+
+	if val := os.Getenv("ENABLE_AUTH"); val != "" {
+		AppConfig.EnableAuth = strings.ToLower(val) == "true"
+	}
+	if val := os.Getenv("AUTH_SESSION_TTL"); val != "" {
+		if hours, err := strconv.Atoi(val); err == nil {
+			AppConfig.AuthSessionTTL = hours
+		}
+	}
+	if val := os.Getenv("CORS_ALLOWED_ORIGINS"); val != "" {
+		AppConfig.CORSAllowedOrigins = nil
+		for _, origin := range strings.Split(val, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				AppConfig.CORSAllowedOrigins = append(AppConfig.CORSAllowedOrigins, origin)
+			}
+		}
+	}
 }
 
 // validateConfig 验证配置
@@ -188,16 +533,218 @@ func validateConfig() error {
 		return fmt.Errorf("无效的服务器端口: %s", AppConfig.ServerPort)
 	}
 
+	// 验证优雅关闭超时
+	if AppConfig.ShutdownTimeout < 1 {
+		return fmt.Errorf("优雅关闭超时时间必须大于0: %d", AppConfig.ShutdownTimeout)
+	}
+
+	// 验证HTTPS配置
+	isValidTLSClientAuth := false
+	for _, mode := range validTLSClientAuthModes {
+		if AppConfig.TLSClientAuth == mode {
+			isValidTLSClientAuth = true
+			break
+		}
+	}
+	if !isValidTLSClientAuth {
+		return fmt.Errorf("无效的客户端证书模式: %s，支持的取值: %v", AppConfig.TLSClientAuth, validTLSClientAuthModes)
+	}
+	if AppConfig.EnableTLS {
+		if !AppConfig.TLSSelfSigned && (AppConfig.TLSCertFile == "" || AppConfig.TLSKeyFile == "") {
+			return fmt.Errorf("启用HTTPS时需要设置TLS_CERT_FILE和TLS_KEY_FILE，或设置TLS_SELF_SIGNED=true")
+		}
+		if AppConfig.TLSClientAuth != TLSClientAuthNone && AppConfig.TLSClientCAFile == "" {
+			return fmt.Errorf("客户端证书模式为%s时需要设置TLS_CLIENT_CA_FILE", AppConfig.TLSClientAuth)
+		}
+	}
+
 	// 验证MongoDB超时
 	if AppConfig.MongoTimeout < 1 {
 		return fmt.Errorf("MongoDB超时时间必须大于0: %d", AppConfig.MongoTimeout)
 	}
 
+	// 验证连接监控配置
+	if AppConfig.MongoRetryInitial < 1 {
+		return fmt.Errorf("MongoDB重连初始退避时间必须大于0: %d", AppConfig.MongoRetryInitial)
+	}
+	if AppConfig.MongoRetryMax < AppConfig.MongoRetryInitial {
+		return fmt.Errorf("MongoDB重连最大退避时间不能小于初始退避时间: %d", AppConfig.MongoRetryMax)
+	}
+	if AppConfig.MongoHealthInterval < 1 {
+		return fmt.Errorf("MongoDB健康检查间隔必须大于0: %d", AppConfig.MongoHealthInterval)
+	}
+
+	// 验证批量写入配置
+	if AppConfig.MongoBulkBatchSize < 1 {
+		return fmt.Errorf("MongoDB批量写入大小必须大于0: %d", AppConfig.MongoBulkBatchSize)
+	}
+	if AppConfig.MongoBulkFlushMs < 1 {
+		return fmt.Errorf("MongoDB批量写入间隔必须大于0: %d", AppConfig.MongoBulkFlushMs)
+	}
+
 	// 验证最大数据存储数量
 	if AppConfig.MaxDataStore < 1 {
 		return fmt.Errorf("最大数据存储数量必须大于0: %d", AppConfig.MaxDataStore)
 	}
 
+	// 验证写前缓冲配置
+	if AppConfig.SpoolReplayInterval < 1 {
+		return fmt.Errorf("缓冲回放间隔必须大于0: %d", AppConfig.SpoolReplayInterval)
+	}
+
+	// 验证请求体上限
+	if AppConfig.MaxBodyBytes < 1 {
+		return fmt.Errorf("请求体上限必须大于0: %d", AppConfig.MaxBodyBytes)
+	}
+	if AppConfig.MaxDecompressedBytes < 1 {
+		return fmt.Errorf("解压后请求体上限必须大于0: %d", AppConfig.MaxDecompressedBytes)
+	}
+	if AppConfig.MaxReadingsPerMessage < 1 {
+		return fmt.Errorf("单条消息读数上限必须大于0: %d", AppConfig.MaxReadingsPerMessage)
+	}
+	if AppConfig.BulkMaxBodyBytes < 1 {
+		return fmt.Errorf("批量导入请求体上限必须大于0: %d", AppConfig.BulkMaxBodyBytes)
+	}
+	if AppConfig.BulkMaxDecompressedBytes < 1 {
+		return fmt.Errorf("批量导入解压后请求体上限必须大于0: %d", AppConfig.BulkMaxDecompressedBytes)
+	}
+
+	// 验证入库流水线
+	if AppConfig.IngestWorkers < 1 {
+		return fmt.Errorf("入库工作协程数量必须大于0: %d", AppConfig.IngestWorkers)
+	}
+	if AppConfig.IngestQueueSize < 1 {
+		return fmt.Errorf("入库队列容量必须大于0: %d", AppConfig.IngestQueueSize)
+	}
+	if AppConfig.IngestRetryAfter < 1 {
+		return fmt.Errorf("重试等待时间必须大于0: %d", AppConfig.IngestRetryAfter)
+	}
+	if AppConfig.DedupCacheSize < 1 {
+		return fmt.Errorf("去重缓存容量必须大于0: %d", AppConfig.DedupCacheSize)
+	}
+
+	// 验证限流配置
+	if AppConfig.RateLimitDeviceRate < 0 {
+		return fmt.Errorf("设备限流速率不能为负数: %g", AppConfig.RateLimitDeviceRate)
+	}
+	if AppConfig.RateLimitDeviceBurst < 1 {
+		return fmt.Errorf("设备限流突发数量必须大于0: %d", AppConfig.RateLimitDeviceBurst)
+	}
+	if AppConfig.RateLimitIPRate < 0 {
+		return fmt.Errorf("IP限流速率不能为负数: %g", AppConfig.RateLimitIPRate)
+	}
+	if AppConfig.RateLimitIPBurst < 1 {
+		return fmt.Errorf("IP限流突发数量必须大于0: %d", AppConfig.RateLimitIPBurst)
+	}
+	if _, err := parseRateLimitOverrides(AppConfig.RateLimitDeviceOverrides); err != nil {
+		return err
+	}
+
+	// 验证原始数据归档配置
+	if AppConfig.ArchiveSegmentMaxBytes < 1 {
+		return fmt.Errorf("归档段大小上限必须大于0: %d", AppConfig.ArchiveSegmentMaxBytes)
+	}
+	if AppConfig.ArchiveSegmentMaxAge < 1 {
+		return fmt.Errorf("归档段轮转时间必须大于0: %d", AppConfig.ArchiveSegmentMaxAge)
+	}
+	isValidArchiveCompression := false
+	for _, compression := range validArchiveCompressions {
+		if AppConfig.ArchiveCompression == compression {
+			isValidArchiveCompression = true
+			break
+		}
+	}
+	if !isValidArchiveCompression {
+		return fmt.Errorf("无效的归档压缩方式: %s，支持的取值: %v", AppConfig.ArchiveCompression, validArchiveCompressions)
+	}
+	isValidArchiveFsync := false
+	for _, mode := range validArchiveFsyncModes {
+		if AppConfig.ArchiveFsync == mode {
+			isValidArchiveFsync = true
+			break
+		}
+	}
+	if !isValidArchiveFsync {
+		return fmt.Errorf("无效的归档同步策略: %s，支持的取值: %v", AppConfig.ArchiveFsync, validArchiveFsyncModes)
+	}
+
+	// 验证持久化要求
+	isValidDurabilityMode := false
+	for _, mode := range validDurabilityModes {
+		if AppConfig.DurabilityMode == mode {
+			isValidDurabilityMode = true
+			break
+		}
+	}
+	if !isValidDurabilityMode {
+		return fmt.Errorf("无效的持久化要求: %s，支持的取值: %v", AppConfig.DurabilityMode, validDurabilityModes)
+	}
+
+	// 验证数据校验配置
+	isValidValidationMode := false
+	for _, mode := range validValidationModes {
+		if AppConfig.ValidationMode == mode {
+			isValidValidationMode = true
+			break
+		}
+	}
+	if !isValidValidationMode {
+		return fmt.Errorf("无效的校验模式: %s，支持的取值: %v", AppConfig.ValidationMode, validValidationModes)
+	}
+	if AppConfig.ValidationMaxAgeHours < 0 {
+		return fmt.Errorf("时间窗口小时数不能为负数: %d", AppConfig.ValidationMaxAgeHours)
+	}
+	if AppConfig.ValidationMaxSkewSeconds < 0 {
+		return fmt.Errorf("时间偏差秒数不能为负数: %d", AppConfig.ValidationMaxSkewSeconds)
+	}
+
+	// 验证定位精度配置
+	if AppConfig.LocationMaxHorizontalAccuracy < 0 {
+		return fmt.Errorf("定位水平精度阈值不能为负数: %g", AppConfig.LocationMaxHorizontalAccuracy)
+	}
+	isValidLocationAccuracyMode := false
+	for _, mode := range validLocationAccuracyModes {
+		if AppConfig.LocationAccuracyMode == mode {
+			isValidLocationAccuracyMode = true
+			break
+		}
+	}
+	if !isValidLocationAccuracyMode {
+		return fmt.Errorf("无效的低精度定位处理方式: %s，支持的取值: %v", AppConfig.LocationAccuracyMode, validLocationAccuracyModes)
+	}
+
+	// 验证设备令牌配置
+	isValidDeviceAuthMode := false
+	for _, mode := range validDeviceAuthModes {
+		if AppConfig.DeviceAuthMode == mode {
+			isValidDeviceAuthMode = true
+			break
+		}
+	}
+	if !isValidDeviceAuthMode {
+		return fmt.Errorf("无效的设备令牌鉴权模式: %s，支持的取值: %v", AppConfig.DeviceAuthMode, validDeviceAuthModes)
+	}
+
+	// 验证请求签名配置
+	isValidSignatureMode := false
+	for _, mode := range validSignatureModes {
+		if AppConfig.SignatureMode == mode {
+			isValidSignatureMode = true
+			break
+		}
+	}
+	if !isValidSignatureMode {
+		return fmt.Errorf("无效的签名校验模式: %s，支持的取值: %v", AppConfig.SignatureMode, validSignatureModes)
+	}
+	if AppConfig.SignatureMaxSkew < 1 {
+		return fmt.Errorf("签名时间窗口必须大于0: %d", AppConfig.SignatureMaxSkew)
+	}
+
+	// 验证用户鉴权配置
+	if AppConfig.AuthSessionTTL < 1 {
+		return fmt.Errorf("登录会话有效期必须大于0: %d", AppConfig.AuthSessionTTL)
+	}
+
 	// 验证日志级别
 	validLogLevels := []string{"debug", "info", "warn", "error"}
 	isValidLogLevel := false
@@ -234,7 +781,7 @@ func createDirectories() error {
 			return fmt.Errorf("创建数据目录失败: %v", err)
 		}
 	}
-	
+
 	// 创建日志目录
 	logDir := AppConfig.DataDir + "/logs"
 	if err := os.MkdirAll(logDir, 0755); err != nil {
@@ -257,16 +804,57 @@ func PrintConfig() {
 	fmt.Println("=== 当前配置 ===")
 	fmt.Printf("服务器端口: %s\n", AppConfig.ServerPort)
 	fmt.Printf("服务器主机: %s\n", AppConfig.ServerHost)
+	fmt.Printf("优雅关闭超时: %d秒\n", AppConfig.ShutdownTimeout)
+	if AppConfig.EnableTLS {
+		certFile, _ := tlsFiles()
+		fmt.Printf("HTTPS: 已启用 (证书: %s, 自签名: %t, 客户端证书: %s)\n", certFile, AppConfig.TLSSelfSigned, AppConfig.TLSClientAuth)
+	} else {
+		fmt.Println("HTTPS: 未启用")
+	}
 	fmt.Printf("MongoDB URI: %s\n", AppConfig.MongoURI)
 	fmt.Printf("MongoDB 数据库: %s\n", AppConfig.MongoDatabase)
 	fmt.Printf("MongoDB 超时: %d秒\n", AppConfig.MongoTimeout)
+	fmt.Printf("MongoDB 重连退避: %d-%d秒，健康检查间隔: %d秒\n", AppConfig.MongoRetryInitial, AppConfig.MongoRetryMax, AppConfig.MongoHealthInterval)
+	fmt.Printf("MongoDB 批量写入: 每批%d条 / %d毫秒\n", AppConfig.MongoBulkBatchSize, AppConfig.MongoBulkFlushMs)
 	fmt.Printf("最大数据存储: %d条\n", AppConfig.MaxDataStore)
 	fmt.Printf("启用日志: %t\n", AppConfig.EnableLogging)
 	fmt.Printf("日志级别: %s\n", AppConfig.LogLevel)
 	fmt.Printf("运行环境: %s\n", AppConfig.Environment)
 	fmt.Printf("数据目录: %s\n", AppConfig.DataDir)
 	fmt.Printf("启用文件日志: %t\n", AppConfig.EnableFileLog)
+	fmt.Printf("原始数据归档: 每段%d字节 / %d秒, 压缩: %s, 同步: %s\n",
+		AppConfig.ArchiveSegmentMaxBytes, AppConfig.ArchiveSegmentMaxAge, AppConfig.ArchiveCompression, AppConfig.ArchiveFsync)
+	fmt.Printf("启用写前缓冲: %t (回放间隔%d秒)\n", AppConfig.EnableSpool, AppConfig.SpoolReplayInterval)
+	fmt.Printf("请求体上限: %d字节\n", AppConfig.MaxBodyBytes)
+	fmt.Printf("解压后请求体上限: %d字节\n", AppConfig.MaxDecompressedBytes)
+	fmt.Printf("单条消息读数上限: %d条\n", AppConfig.MaxReadingsPerMessage)
+	fmt.Printf("批量导入请求体上限: %d字节 (解压后%d字节)\n", AppConfig.BulkMaxBodyBytes, AppConfig.BulkMaxDecompressedBytes)
+	fmt.Printf("入库工作协程: %d\n", AppConfig.IngestWorkers)
+	fmt.Printf("入库队列容量: %d\n", AppConfig.IngestQueueSize)
+	fmt.Printf("去重缓存容量: %d\n", AppConfig.DedupCacheSize)
+	fmt.Printf("设备限流: %s\n", formatRateLimit(RateLimit{Rate: AppConfig.RateLimitDeviceRate, Burst: AppConfig.RateLimitDeviceBurst}))
+	fmt.Printf("IP限流: %s\n", formatRateLimit(RateLimit{Rate: AppConfig.RateLimitIPRate, Burst: AppConfig.RateLimitIPBurst}))
+	if AppConfig.RateLimitDeviceOverrides != "" {
+		fmt.Printf("按设备覆盖的限流: %s\n", AppConfig.RateLimitDeviceOverrides)
+	}
+	fmt.Printf("持久化要求: %s\n", AppConfig.DurabilityMode)
+	fmt.Printf("校验模式: %s (时间窗口: 过去%d小时 / 未来%d秒)\n", AppConfig.ValidationMode, AppConfig.ValidationMaxAgeHours, AppConfig.ValidationMaxSkewSeconds)
+	if AppConfig.SensorDescriptorsFile != "" {
+		fmt.Printf("传感器描述文件: %s\n", AppConfig.SensorDescriptorsFile)
+	}
+	if AppConfig.LocationMaxHorizontalAccuracy > 0 {
+		fmt.Printf("定位水平精度阈值: %g米 (%s)\n", AppConfig.LocationMaxHorizontalAccuracy, AppConfig.LocationAccuracyMode)
+	}
+	if AppConfig.AdminToken != "" {
+		fmt.Println("管理接口: 已启用")
+	} else {
+		fmt.Println("管理接口: 未启用（未设置ADMIN_TOKEN）")
+	}
+	fmt.Printf("设备令牌鉴权: %s\n", AppConfig.DeviceAuthMode)
+	fmt.Printf("请求签名校验: %s (时间窗口%d秒)\n", AppConfig.SignatureMode, AppConfig.SignatureMaxSkew)
+	fmt.Printf("用户鉴权: %t (会话有效期%d小时)\n", AppConfig.EnableAuth, AppConfig.AuthSessionTTL)
+	if len(AppConfig.CORSAllowedOrigins) > 0 {
+		fmt.Printf("允许跨域读取的来源: %s\n", strings.Join(AppConfig.CORSAllowedOrigins, ", "))
+	}
 	fmt.Println("===============")
 }
-
-
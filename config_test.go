@@ -123,6 +123,44 @@ func TestValidateConfig(t *testing.T) {
 	if err := validateConfig(); err == nil {
 		t.Error("期望无效超时时间验证失败，但验证通过了")
 	}
+
+	// 测试无效的设备限流配置
+	AppConfig = defaultConfig
+	AppConfig.RateLimitDeviceOverrides = "device-a=fast"
+	if err := validateConfig(); err == nil {
+		t.Error("期望无效设备限流配置验证失败，但验证通过了")
+	}
+
+	// 测试无效的优雅关闭超时
+	AppConfig = defaultConfig
+	AppConfig.ShutdownTimeout = 0
+	if err := validateConfig(); err == nil {
+		t.Error("期望无效优雅关闭超时验证失败，但验证通过了")
+	}
+
+	// 测试无效的定位精度配置
+	AppConfig = defaultConfig
+	AppConfig.LocationMaxHorizontalAccuracy = -1
+	if err := validateConfig(); err == nil {
+		t.Error("期望负数定位精度阈值验证失败，但验证通过了")
+	}
+	AppConfig = defaultConfig
+	AppConfig.LocationAccuracyMode = "ignore"
+	if err := validateConfig(); err == nil {
+		t.Error("期望无效低精度定位处理方式验证失败，但验证通过了")
+	}
+
+	// 测试启用HTTPS但没有证书
+	AppConfig = defaultConfig
+	AppConfig.EnableTLS = true
+	if err := validateConfig(); err == nil {
+		t.Error("期望缺少证书验证失败，但验证通过了")
+	}
+	AppConfig.TLSSelfSigned = true
+	AppConfig.TLSClientAuth = TLSClientAuthRequire
+	if err := validateConfig(); err == nil {
+		t.Error("期望缺少客户端CA验证失败，但验证通过了")
+	}
 }
 
 func TestGetServerAddr(t *testing.T) {
@@ -2,23 +2,31 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"strconv"
-	"strings"
+	"reflect"
+	"sort"
+	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// MongoDB客户端和集合
+// errMongoUnavailable MongoDB未连接或连接不健康
+var errMongoUnavailable = errors.New("MongoDB未连接")
+
+// MongoDB客户端和集合（由连接监控器在连接健康时设置，通过 mongoCollections 读取）
 var (
-	mongoClient    *mongo.Client
-	sensorDataColl *mongo.Collection
-	deviceInfoColl *mongo.Collection
+	mongoMutex      sync.RWMutex
+	mongoClient     *mongo.Client
+	sensorDataColl  *mongo.Collection
+	deviceInfoColl  *mongo.Collection
+	deviceTokenColl *mongo.Collection
 )
 
 // SensorMessageDocument MongoDB中的传感器消息文档结构（整个消息作为一个文档）
@@ -39,6 +47,20 @@ type SensorMessageDocument struct {
 
 	// 解析后的可读数据
 	ParsedReadings []HumanReadableSensorData `bson:"parsedReadings"`
+
+	// 标注事件生成的会话标记
+	Markers []SessionMarker `bson:"markers,omitempty"`
+
+	// 水平精度超过阈值的定位数
+	LowAccuracyFixes int `bson:"lowAccuracyFixes,omitempty"`
+}
+
+// SessionMarkerDocument 会话标记查询结果（每个标记一条）
+type SessionMarkerDocument struct {
+	MessageID int64         `bson:"messageId"`
+	SessionID string        `bson:"sessionId"`
+	DeviceID  string        `bson:"deviceId"`
+	Marker    SessionMarker `bson:"markers"`
 }
 
 // DeviceInfoDocument 设备信息文档结构
@@ -51,45 +73,75 @@ type DeviceInfoDocument struct {
 	TotalRecords  int64              `bson:"totalRecords"`
 	SensorTypes   []string           `bson:"sensorTypes"`
 	Sessions      []string           `bson:"sessions"`
+
+	// 重复消息统计
+	DuplicateMessages int64     `bson:"duplicateMessages"`
+	LastDuplicateAt   time.Time `bson:"lastDuplicateAt,omitempty"`
+
+	// 最近一小时被限流的请求（内存中的滚动统计，不写入MongoDB）
+	ThrottledRequests int64     `bson:"-"`
+	LastThrottledAt   time.Time `bson:"-"`
 }
 
-// InitMongoDB 初始化MongoDB连接
-func InitMongoDB() error {
+// connectMongoDB 创建MongoDB客户端（不进行网络连接，由调用方Ping确认可用）
+func connectMongoDB() (*mongo.Client, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(AppConfig.MongoTimeout)*time.Second)
 	defer cancel()
 
-	// 创建MongoDB客户端
-	clientOptions := options.Client().ApplyURI(AppConfig.MongoURI)
-
-	var err error
-	mongoClient, err = mongo.Connect(ctx, clientOptions)
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(AppConfig.MongoURI))
 	if err != nil {
-		return fmt.Errorf("连接MongoDB失败: %v", err)
+		return nil, fmt.Errorf("连接MongoDB失败: %v", err)
 	}
+	return client, nil
+}
+
+// setMongoConnection 设置对外暴露的客户端和集合；client为nil时表示数据库不可用
+func setMongoConnection(client *mongo.Client) {
+	mongoMutex.Lock()
+	defer mongoMutex.Unlock()
 
-	// 测试连接
-	if err = mongoClient.Ping(ctx, nil); err != nil {
-		return fmt.Errorf("MongoDB连接测试失败: %v", err)
+	if client == nil {
+		mongoClient, sensorDataColl, deviceInfoColl, deviceTokenColl = nil, nil, nil, nil
+		return
 	}
 
-	// 获取数据库和集合
-	db := mongoClient.Database(AppConfig.MongoDatabase)
+	db := client.Database(AppConfig.MongoDatabase)
+	mongoClient = client
 	sensorDataColl = db.Collection("sensor_messages") // 改名为sensor_messages更合适
 	deviceInfoColl = db.Collection("device_info")
+	deviceTokenColl = db.Collection("device_tokens")
+}
+
+// mongoCollections 获取当前可用的传感器消息集合和设备信息集合，数据库不可用时返回错误
+func mongoCollections() (*mongo.Collection, *mongo.Collection, error) {
+	mongoMutex.RLock()
+	defer mongoMutex.RUnlock()
 
-	// 创建索引
-	if err = createIndexes(); err != nil {
-		return fmt.Errorf("创建索引失败: %v", err)
+	if mongoClient == nil || sensorDataColl == nil || deviceInfoColl == nil {
+		return nil, nil, errMongoUnavailable
 	}
+	return sensorDataColl, deviceInfoColl, nil
+}
 
-	Logger.Info("MongoDB连接成功",
-		slog.String("uri", AppConfig.MongoURI),
-		slog.String("database", AppConfig.MongoDatabase))
-	return nil
+// mongoDeviceTokenCollection 获取设备令牌集合，数据库不可用时返回错误
+func mongoDeviceTokenCollection() (*mongo.Collection, error) {
+	mongoMutex.RLock()
+	defer mongoMutex.RUnlock()
+
+	if mongoClient == nil || deviceTokenColl == nil {
+		return nil, errMongoUnavailable
+	}
+	return deviceTokenColl, nil
+}
+
+// mongoAvailable 判断MongoDB当前是否可用
+func mongoAvailable() bool {
+	_, _, err := mongoCollections()
+	return err == nil
 }
 
-// createIndexes 创建数据库索引
-func createIndexes() error {
+// createIndexes 创建数据库索引（索引已存在时为空操作，每次重新连接后都会执行）
+func createIndexes(sensorColl, deviceColl *mongo.Collection) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -125,9 +177,22 @@ func createIndexes() error {
 				{Key: "sessionId", Value: 1},
 			},
 		},
+		{
+			Keys: bson.D{
+				{Key: "sessionId", Value: 1},
+				{Key: "markers.time", Value: 1},
+			},
+		},
+		{
+			// 不指定会话的定位查询按时间范围过滤
+			Keys: bson.D{
+				{Key: "sensorTypes", Value: 1},
+				{Key: "timeRange.end", Value: -1},
+			},
+		},
 	}
 
-	if _, err := sensorDataColl.Indexes().CreateMany(ctx, messageIndexes); err != nil {
+	if _, err := sensorColl.Indexes().CreateMany(ctx, messageIndexes); err != nil {
 		return fmt.Errorf("创建传感器消息索引失败: %v", err)
 	}
 
@@ -146,7 +211,7 @@ func createIndexes() error {
 		},
 	}
 
-	if _, err := deviceInfoColl.Indexes().CreateMany(ctx, deviceIndexes); err != nil {
+	if _, err := deviceColl.Indexes().CreateMany(ctx, deviceIndexes); err != nil {
 		return fmt.Errorf("创建设备信息索引失败: %v", err)
 	}
 
@@ -156,31 +221,20 @@ func createIndexes() error {
 
 // SaveSensorData 保存传感器数据到MongoDB（整个消息作为一个文档）
 func SaveSensorData(parsedData *ParsedSensorData) error {
-	if mongoClient == nil {
-		return fmt.Errorf("MongoDB未初始化")
+	sensorColl, _, err := mongoCollections()
+	if err != nil {
+		return err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// 创建传感器消息文档
-	messageDoc := SensorMessageDocument{
-		MessageID:      parsedData.MessageID,
-		SessionID:      parsedData.SessionID,
-		DeviceID:       parsedData.DeviceID,
-		Payload:        extractOriginalPayload(parsedData),
-		ReceivedAt:     parsedData.ReceivedAt,
-		ProcessedAt:    time.Now(),
-		TotalReadings:  parsedData.TotalReadings,
-		SensorTypes:    parsedData.SensorTypes,
-		SensorCounts:   parsedData.SensorCounts,
-		TimeRange:      parsedData.TimeRange,
-		ParsedReadings: parsedData.ParsedReadings,
-	}
-
 	// 插入传感器消息文档
-	result, err := sensorDataColl.InsertOne(ctx, messageDoc)
+	result, err := sensorColl.InsertOne(ctx, newSensorMessageDocument(parsedData))
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errDuplicateMessage
+		}
 		return fmt.Errorf("保存传感器消息失败: %v", err)
 	}
 
@@ -200,136 +254,291 @@ func SaveSensorData(parsedData *ParsedSensorData) error {
 	return nil
 }
 
-// extractOriginalPayload 从解析后的数据中提取原始payload结构
-func extractOriginalPayload(parsedData *ParsedSensorData) []SensorReading {
-	// 这里我们需要重新构造原始的SensorReading结构
-	// 由于我们已经有了ParsedReadings，我们可以从中提取信息
-	payload := make([]SensorReading, 0, len(parsedData.ParsedReadings))
-
-	for _, reading := range parsedData.ParsedReadings {
-		// 重新构造values map
-		values := make(map[string]interface{})
-		for _, value := range reading.Values {
-			// 尝试解析回原始格式
-			switch value.Name {
-			case "X轴加速度", "Y轴加速度", "Z轴加速度":
-				axisName := string(value.Name[0]) // 取第一个字符
-				values[strings.ToLower(axisName)] = parseFloat(value.Value)
-			case "X轴角速度", "Y轴角速度", "Z轴角速度":
-				axisName := string(value.Name[0])
-				values[strings.ToLower(axisName)] = parseFloat(value.Value)
-			case "X轴磁场", "Y轴磁场", "Z轴磁场":
-				axisName := string(value.Name[0])
-				values[strings.ToLower(axisName)] = parseFloat(value.Value)
-			case "磁方位角":
-				values["magneticBearing"] = parseFloat(value.Value)
-			case "指南针方位":
-				values["magneticBearing"] = parseFloat(value.Value)
-			case "步数":
-				values["steps"] = parseFloat(value.Value)
-			default:
-				// 对于其他类型，使用通用方法
-				values[value.Name] = parseFloat(value.Value)
-			}
-		}
+// InsertSpooledMessage 写入缓冲中的消息文档（已序列化的BSON）并更新设备信息
+func InsertSpooledMessage(raw bson.Raw) error {
+	sensorColl, _, err := mongoCollections()
+	if err != nil {
+		return err
+	}
+
+	var doc SensorMessageDocument
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return fmt.Errorf("解析缓冲消息失败: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-		sensorReading := SensorReading{
-			Name:     reading.SensorType,
-			Time:     reading.Timestamp.UnixNano(),
-			Values:   values,
-			Accuracy: getAccuracyInt(reading.Accuracy),
+	if _, err := sensorColl.InsertOne(ctx, raw); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errDuplicateMessage
 		}
+		return fmt.Errorf("保存传感器消息失败: %v", err)
+	}
 
-		payload = append(payload, sensorReading)
+	parsedData := &ParsedSensorData{
+		MessageID:     doc.MessageID,
+		SessionID:     doc.SessionID,
+		DeviceID:      doc.DeviceID,
+		ReceivedAt:    doc.ReceivedAt,
+		TotalReadings: doc.TotalReadings,
+		SensorTypes:   doc.SensorTypes,
 	}
+	if err := updateDeviceInfo(parsedData); err != nil {
+		Logger.Error("更新设备信息失败",
+			slog.String("error", err.Error()),
+			slog.String("device_id", doc.DeviceID))
+	}
+	return nil
+}
 
-	return payload
+// newSensorMessageDocument 创建传感器消息文档
+func newSensorMessageDocument(parsedData *ParsedSensorData) SensorMessageDocument {
+	return SensorMessageDocument{
+		MessageID:      parsedData.MessageID,
+		SessionID:      parsedData.SessionID,
+		DeviceID:       parsedData.DeviceID,
+		Payload:        parsedData.Payload,
+		ReceivedAt:     parsedData.ReceivedAt,
+		ProcessedAt:    time.Now(),
+		TotalReadings:  parsedData.TotalReadings,
+		SensorTypes:    parsedData.SensorTypes,
+		SensorCounts:   parsedData.SensorCounts,
+		TimeRange:      parsedData.TimeRange,
+		ParsedReadings: parsedData.ParsedReadings,
+		Markers:        parsedData.Markers,
+
+		LowAccuracyFixes: parsedData.LowAccuracyFixes,
+	}
+}
+
+// deviceInfoDelta 单个设备的信息增量（可累积多条消息）
+type deviceInfoDelta struct {
+	DeviceID    string
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	Messages    int64
+	Records     int64
+	SensorTypes map[string]struct{}
+	Sessions    map[string]struct{}
+}
+
+// newDeviceInfoDelta 创建设备信息增量
+func newDeviceInfoDelta(deviceID string) *deviceInfoDelta {
+	return &deviceInfoDelta{
+		DeviceID:    deviceID,
+		SensorTypes: make(map[string]struct{}),
+		Sessions:    make(map[string]struct{}),
+	}
 }
 
-// parseFloat 安全地解析字符串为float64
-func parseFloat(s string) float64 {
-	if val, err := strconv.ParseFloat(s, 64); err == nil {
-		return val
+// Add 累加一条消息
+func (d *deviceInfoDelta) Add(parsedData *ParsedSensorData) {
+	if d.FirstSeen.IsZero() || parsedData.ReceivedAt.Before(d.FirstSeen) {
+		d.FirstSeen = parsedData.ReceivedAt
+	}
+	if parsedData.ReceivedAt.After(d.LastSeen) {
+		d.LastSeen = parsedData.ReceivedAt
 	}
-	return 0.0
+	d.Messages++
+	d.Records += int64(parsedData.TotalReadings)
+	for _, sensorType := range parsedData.SensorTypes {
+		d.SensorTypes[sensorType] = struct{}{}
+	}
+	d.Sessions[parsedData.SessionID] = struct{}{}
+}
+
+// Filter 返回设备文档的查询条件
+func (d *deviceInfoDelta) Filter() bson.M {
+	return bson.M{"deviceId": d.DeviceID}
 }
 
-// updateDeviceInfo 更新设备信息
+// Update 返回upsert更新语句，新设备和已有设备使用同一条语句
+func (d *deviceInfoDelta) Update() bson.M {
+	return bson.M{
+		"$min": bson.M{"firstSeen": d.FirstSeen},
+		"$max": bson.M{"lastSeen": d.LastSeen},
+		"$inc": bson.M{
+			"totalMessages": d.Messages,
+			"totalRecords":  d.Records,
+		},
+		"$addToSet": bson.M{
+			"sensorTypes": bson.M{"$each": sortedKeys(d.SensorTypes)},
+			"sessions":    bson.M{"$each": sortedKeys(d.Sessions)},
+		},
+	}
+}
+
+// sortedKeys 返回集合中排序后的元素
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// updateDeviceInfo 更新设备信息（不存在时创建）
 func updateDeviceInfo(parsedData *ParsedSensorData) error {
+	_, deviceColl, err := mongoCollections()
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	filter := bson.M{"deviceId": parsedData.DeviceID}
-
-	// 检查设备是否已存在
-	var existingDevice DeviceInfoDocument
-	err := deviceInfoColl.FindOne(ctx, filter).Decode(&existingDevice)
-
-	if err == mongo.ErrNoDocuments {
-		// 创建新设备记录
-		newDevice := DeviceInfoDocument{
-			DeviceID:      parsedData.DeviceID,
-			FirstSeen:     parsedData.ReceivedAt,
-			LastSeen:      parsedData.ReceivedAt,
-			TotalMessages: 1,
-			TotalRecords:  int64(parsedData.TotalReadings),
-			SensorTypes:   parsedData.SensorTypes,
-			Sessions:      []string{parsedData.SessionID},
-		}
+	delta := newDeviceInfoDelta(parsedData.DeviceID)
+	delta.Add(parsedData)
 
-		_, err = deviceInfoColl.InsertOne(ctx, newDevice)
-		if err != nil {
-			return fmt.Errorf("创建设备信息失败: %v", err)
-		}
-		Logger.Info("创建新设备记录", slog.String("device_id", parsedData.DeviceID))
-	} else if err == nil {
-		// 更新现有设备记录
-		update := bson.M{
-			"$set": bson.M{
-				"lastSeen": parsedData.ReceivedAt,
-			},
-			"$inc": bson.M{
-				"totalMessages": 1,
-				"totalRecords":  int64(parsedData.TotalReadings),
-			},
-			"$addToSet": bson.M{
-				"sensorTypes": bson.M{"$each": parsedData.SensorTypes},
-				"sessions":    parsedData.SessionID,
-			},
-		}
+	result, err := deviceColl.UpdateOne(ctx, delta.Filter(), delta.Update(), options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("更新设备信息失败: %v", err)
+	}
 
-		_, err = deviceInfoColl.UpdateOne(ctx, filter, update)
-		if err != nil {
-			return fmt.Errorf("更新设备信息失败: %v", err)
-		}
-		Logger.Debug("设备信息更新成功", slog.String("device_id", parsedData.DeviceID))
+	if result.UpsertedCount > 0 {
+		Logger.Info("创建新设备记录", slog.String("device_id", parsedData.DeviceID))
 	} else {
-		return fmt.Errorf("查询设备信息失败: %v", err)
+		Logger.Debug("设备信息更新成功", slog.String("device_id", parsedData.DeviceID))
+	}
+	return nil
+}
+
+// SensorMessageExists 检查指定会话中的消息是否已保存
+func SensorMessageExists(sessionID string, messageID int64) (bool, error) {
+	sensorColl, _, err := mongoCollections()
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"sessionId": sessionID, "messageId": messageID}
+	count, err := sensorColl.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("查询消息是否存在失败: %v", err)
+	}
+	return count > 0, nil
+}
+
+// IncrementDuplicateCount 增加设备的重复消息计数
+func IncrementDuplicateCount(deviceID string) error {
+	_, deviceColl, err := mongoCollections()
+	if err != nil {
+		return err
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$inc": bson.M{"duplicateMessages": 1},
+		"$set": bson.M{"lastDuplicateAt": time.Now()},
+	}
+	if _, err := deviceColl.UpdateOne(ctx, bson.M{"deviceId": deviceID}, update); err != nil {
+		return fmt.Errorf("更新重复消息计数失败: %v", err)
+	}
 	return nil
 }
 
-// getAccuracyInt 将精度描述转换为数字
-func getAccuracyInt(accuracy string) int {
-	switch accuracy {
-	case "不可靠":
-		return 0
-	case "低精度":
-		return 1
-	case "中等精度":
-		return 2
-	case "高精度":
-		return 3
-	default:
-		return -1
+// payload修复结果
+const (
+	RepairUpdated   = "updated"   // 已替换为原始读数
+	RepairUnchanged = "unchanged" // 已保存的payload与原始读数一致
+	RepairNotFound  = "not_found" // 数据库中没有该消息
+	RepairMismatch  = "mismatch"  // 读数数量不一致（例如strip模式丢弃过读数），不修改
+)
+
+// RepairMessagePayload 用原始数据中的读数替换已保存消息的payload，dryRun时只判断不修改
+func RepairMessagePayload(message *SensorMessage, dryRun bool) (string, error) {
+	sensorColl, _, err := mongoCollections()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"sessionId": message.SessionID, "messageId": message.MessageID}
+	var stored struct {
+		TotalReadings int           `bson:"totalReadings"`
+		Payload       bson.RawValue `bson:"payload"`
+	}
+	projection := options.FindOne().SetProjection(bson.M{"totalReadings": 1, "payload": 1})
+	if err := sensorColl.FindOne(ctx, filter, projection).Decode(&stored); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return RepairNotFound, nil
+		}
+		return "", fmt.Errorf("查询传感器消息失败: %v", err)
+	}
+	if stored.TotalReadings != len(message.Payload) {
+		return RepairMismatch, nil
+	}
+
+	unchanged, err := samePayload(stored.Payload, message.Payload)
+	if err != nil {
+		return "", err
+	}
+	if unchanged {
+		return RepairUnchanged, nil
+	}
+	if dryRun {
+		return RepairUpdated, nil
+	}
+
+	update := bson.M{"$set": bson.M{"payload": message.Payload, "payloadRepairedAt": time.Now()}}
+	if _, err := sensorColl.UpdateOne(ctx, filter, update); err != nil {
+		return "", fmt.Errorf("更新传感器消息失败: %v", err)
 	}
+	return RepairUpdated, nil
+}
+
+// samePayload 判断已保存的payload与读数是否一致。读数的values是map，序列化后的字段顺序不固定，
+// 因此两边都解码为map后按值比较，而不是比较BSON字节
+func samePayload(stored bson.RawValue, payload []SensorReading) (bool, error) {
+	valueType, value, err := bson.MarshalValue(payload)
+	if err != nil {
+		return false, fmt.Errorf("序列化读数失败: %v", err)
+	}
+	expected, err := decodePayloadValue(bson.RawValue{Type: valueType, Value: value})
+	if err != nil {
+		return false, err
+	}
+	actual, err := decodePayloadValue(stored)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(expected, actual), nil
+}
+
+// decodePayloadValue 将payload解码为通用值，嵌套文档统一解码为bson.M
+func decodePayloadValue(value bson.RawValue) (interface{}, error) {
+	raw, err := bson.Marshal(bson.D{{Key: "payload", Value: value}})
+	if err != nil {
+		return nil, fmt.Errorf("序列化payload失败: %v", err)
+	}
+	decoder, err := bson.NewDecoder(bsonrw.NewBSONDocumentReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("解析payload失败: %v", err)
+	}
+	decoder.DefaultDocumentM()
+
+	var doc struct {
+		Payload interface{} `bson:"payload"`
+	}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("解析payload失败: %v", err)
+	}
+	return doc.Payload, nil
 }
 
 // GetSensorDataFromDB 从数据库获取传感器消息
-func GetSensorDataFromDB(limit int, deviceID string, sensorType string) ([]SensorMessageDocument, error) {
-	if mongoClient == nil {
-		return nil, fmt.Errorf("MongoDB未初始化")
+func GetSensorDataFromDB(limit int, deviceID string, sensorType string, scope DeviceScope) ([]SensorMessageDocument, error) {
+	sensorColl, _, err := mongoCollections()
+	if err != nil {
+		return nil, err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -343,13 +552,18 @@ func GetSensorDataFromDB(limit int, deviceID string, sensorType string) ([]Senso
 	if sensorType != "" {
 		filter["sensorTypes"] = sensorType
 	}
+	if deviceID == "" {
+		filter = scopeFilter(filter, scope)
+	} else if !scope.Allows(deviceID) {
+		return []SensorMessageDocument{}, nil
+	}
 
 	// 设置查询选项
 	opts := options.Find().
 		SetSort(bson.D{{Key: "receivedAt", Value: -1}}).
 		SetLimit(int64(limit))
 
-	cursor, err := sensorDataColl.Find(ctx, filter, opts)
+	cursor, err := sensorColl.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, fmt.Errorf("查询传感器消息失败: %v", err)
 	}
@@ -368,17 +582,176 @@ func GetSensorDataFromDB(limit int, deviceID string, sensorType string) ([]Senso
 	return results, nil
 }
 
-// GetDeviceInfo 获取设备信息
-func GetDeviceInfo() ([]DeviceInfoDocument, error) {
-	if mongoClient == nil {
-		return nil, fmt.Errorf("MongoDB未初始化")
+// GetSessionMarkers 按时间顺序获取会话标记，sessionID和deviceID为空时不限制
+func GetSessionMarkers(sessionID, deviceID string, limit int, scope DeviceScope) ([]SessionMarkerDocument, error) {
+	sensorColl, _, err := mongoCollections()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"markers.0": bson.M{"$exists": true}}
+	if sessionID != "" {
+		filter["sessionId"] = sessionID
+	}
+	if deviceID != "" {
+		if !scope.Allows(deviceID) {
+			return []SessionMarkerDocument{}, nil
+		}
+		filter["deviceId"] = deviceID
+	} else {
+		filter = scopeFilter(filter, scope)
+	}
+
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$project": bson.M{"messageId": 1, "sessionId": 1, "deviceId": 1, "markers": 1}},
+		{"$unwind": "$markers"},
+		{"$sort": bson.D{{Key: "markers.time", Value: 1}}},
+		{"$limit": limit},
+	}
+	cursor, err := sensorColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("查询会话标记失败: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	results := []SessionMarkerDocument{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("解析会话标记失败: %v", err)
+	}
+
+	Logger.Debug("会话标记查询完成",
+		slog.Int("count", len(results)),
+		slog.String("session", sessionID),
+		slog.String("device", deviceID))
+
+	return results, nil
+}
+
+// locationReadingDocument 展开payload后的位置读数
+type locationReadingDocument struct {
+	MessageID int64         `bson:"messageId"`
+	SessionID string        `bson:"sessionId"`
+	DeviceID  string        `bson:"deviceId"`
+	Reading   SensorReading `bson:"payload"`
+}
+
+// GetLocationFixes 按时间顺序获取定位，超过limit时返回最近的定位。from/to为零值时不限制该端，
+// 调用方需指定会话或时间范围，避免展开整个集合。maxAccuracy大于0时只返回水平精度不超过该值的定位
+// （没有报告水平精度的定位照常返回），LowAccuracy按配置的阈值标记
+func GetLocationFixes(sessionID, deviceID string, from, to time.Time, maxAccuracy float64, limit int, scope DeviceScope) ([]LocationFix, error) {
+	sensorColl, _, err := mongoCollections()
+	if err != nil {
+		return nil, err
+	}
+	if deviceID != "" && !scope.Allows(deviceID) {
+		return []LocationFix{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	pipeline := locationFixesPipeline(sessionID, deviceID, from, to, maxAccuracy, limit, scope)
+	cursor, err := sensorColl.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, fmt.Errorf("查询定位失败: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var docs []locationReadingDocument
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("解析定位失败: %v", err)
+	}
+
+	// 查询按时间倒序取最近的定位，返回前恢复为时间顺序
+	fixes := make([]LocationFix, 0, len(docs))
+	for i := len(docs) - 1; i >= 0; i-- {
+		fix, ok := newLocationFix(docs[i].Reading, AppConfig.LocationMaxHorizontalAccuracy)
+		if !ok {
+			continue
+		}
+		fix.MessageID, fix.SessionID, fix.DeviceID = docs[i].MessageID, docs[i].SessionID, docs[i].DeviceID
+		fixes = append(fixes, fix)
+	}
+
+	Logger.Debug("定位查询完成",
+		slog.Int("count", len(fixes)),
+		slog.String("session", sessionID),
+		slog.String("device", deviceID))
+
+	return fixes, nil
+}
+
+// locationFixesPipeline 构造定位查询：时间范围先按消息的timeRange过滤（可使用索引，避免展开无关的消息），
+// 展开后再按读数时间过滤，按时间倒序取前limit条
+func locationFixesPipeline(sessionID, deviceID string, from, to time.Time, maxAccuracy float64, limit int, scope DeviceScope) []bson.M {
+	filter := bson.M{"sensorTypes": "location"}
+	if sessionID != "" {
+		filter["sessionId"] = sessionID
+	}
+	if deviceID != "" {
+		filter["deviceId"] = deviceID
+	} else {
+		filter = scopeFilter(filter, scope)
+	}
+
+	readingFilter := bson.M{"payload.name": "location"}
+	readingTime := bson.M{}
+	if !from.IsZero() {
+		filter["timeRange.end"] = bson.M{"$gte": from}
+		readingTime["$gte"] = from.UnixNano()
+	}
+	if !to.IsZero() {
+		filter["timeRange.start"] = bson.M{"$lte": to}
+		readingTime["$lte"] = to.UnixNano()
+	}
+	if len(readingTime) > 0 {
+		readingFilter["payload.time"] = readingTime
+	}
+	if maxAccuracy > 0 {
+		readingFilter["$nor"] = bson.A{
+			bson.M{"payload.values.horizontalAccuracy": bson.M{"$lt": 0}},
+			bson.M{"payload.values.horizontalAccuracy": bson.M{"$gt": maxAccuracy}},
+		}
+	}
+
+	return []bson.M{
+		{"$match": filter},
+		{"$project": bson.M{"messageId": 1, "sessionId": 1, "deviceId": 1, "payload": 1}},
+		{"$unwind": "$payload"},
+		{"$match": readingFilter},
+		{"$sort": bson.D{{Key: "payload.time", Value: -1}}},
+		{"$limit": limit},
+	}
+}
+
+// scopeFilter 将查询限制在可见的设备范围内
+func scopeFilter(filter bson.M, scope DeviceScope) bson.M {
+	if !scope.All {
+		devices := scope.Devices
+		if devices == nil {
+			devices = []string{}
+		}
+		filter["deviceId"] = bson.M{"$in": devices}
+	}
+	return filter
+}
+
+// GetDeviceInfo 获取可见设备的信息
+func GetDeviceInfo(scope DeviceScope) ([]DeviceInfoDocument, error) {
+	_, deviceColl, err := mongoCollections()
+	if err != nil {
+		return nil, err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	opts := options.Find().SetSort(bson.D{{Key: "lastSeen", Value: -1}})
-	cursor, err := deviceInfoColl.Find(ctx, bson.M{}, opts)
+	cursor, err := deviceColl.Find(ctx, scopeFilter(bson.M{}, scope), opts)
 	if err != nil {
 		return nil, fmt.Errorf("查询设备信息失败: %v", err)
 	}
@@ -393,19 +766,21 @@ func GetDeviceInfo() ([]DeviceInfoDocument, error) {
 	return results, nil
 }
 
-// GetDashboardStats 获取仪表板统计信息
-func GetDashboardStats() (map[string]interface{}, error) {
-	if mongoClient == nil {
-		return nil, fmt.Errorf("MongoDB未初始化")
+// GetDashboardStats 获取仪表板统计信息（只统计可见设备的数据）
+func GetDashboardStats(scope DeviceScope) (map[string]interface{}, error) {
+	sensorColl, deviceColl, err := mongoCollections()
+	if err != nil {
+		return nil, err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
 	stats := make(map[string]interface{})
+	filter := scopeFilter(bson.M{}, scope)
 
 	// 总消息数
-	totalMessages, err := sensorDataColl.CountDocuments(ctx, bson.M{})
+	totalMessages, err := sensorColl.CountDocuments(ctx, filter)
 	if err != nil {
 		return nil, fmt.Errorf("查询总消息数失败: %v", err)
 	}
@@ -413,12 +788,13 @@ func GetDashboardStats() (map[string]interface{}, error) {
 
 	// 总记录数（所有消息中的传感器读数总和）
 	pipeline := []bson.M{
+		{"$match": filter},
 		{"$group": bson.M{
 			"_id":          nil,
 			"totalRecords": bson.M{"$sum": "$totalReadings"},
 		}},
 	}
-	cursor, err := sensorDataColl.Aggregate(ctx, pipeline)
+	cursor, err := sensorColl.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, fmt.Errorf("统计总记录数失败: %v", err)
 	}
@@ -432,14 +808,14 @@ func GetDashboardStats() (map[string]interface{}, error) {
 	}
 
 	// 设备数量
-	deviceCount, err := deviceInfoColl.CountDocuments(ctx, bson.M{})
+	deviceCount, err := deviceColl.CountDocuments(ctx, filter)
 	if err != nil {
 		return nil, fmt.Errorf("查询设备数量失败: %v", err)
 	}
 	stats["deviceCount"] = deviceCount
 
 	// 传感器类型数量
-	sensorTypes, err := sensorDataColl.Distinct(ctx, "sensorTypes", bson.M{})
+	sensorTypes, err := sensorColl.Distinct(ctx, "sensorTypes", filter)
 	if err != nil {
 		return nil, fmt.Errorf("查询传感器类型失败: %v", err)
 	}
@@ -464,7 +840,7 @@ func GetDashboardStats() (map[string]interface{}, error) {
 	// 最新数据时间
 	var latestMessage SensorMessageDocument
 	opts := options.FindOne().SetSort(bson.D{{Key: "receivedAt", Value: -1}})
-	err = sensorDataColl.FindOne(ctx, bson.M{}, opts).Decode(&latestMessage)
+	err = sensorColl.FindOne(ctx, filter, opts).Decode(&latestMessage)
 	if err == nil {
 		stats["latestDataTime"] = latestMessage.ReceivedAt
 	}
@@ -476,18 +852,3 @@ func GetDashboardStats() (map[string]interface{}, error) {
 
 	return stats, nil
 }
-
-// CloseMongoDB 关闭MongoDB连接
-func CloseMongoDB() error {
-	if mongoClient != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		if err := mongoClient.Disconnect(ctx); err != nil {
-			return fmt.Errorf("关闭MongoDB连接失败: %v", err)
-		}
-
-		Logger.Info("MongoDB连接已关闭")
-	}
-	return nil
-}
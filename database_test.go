@@ -3,28 +3,9 @@ package main
 import (
 	"testing"
 	"time"
-)
 
-func TestGetAccuracyInt(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int
-	}{
-		{"不可靠", 0},
-		{"低精度", 1},
-		{"中等精度", 2},
-		{"高精度", 3},
-		{"未知", -1},
-		{"", -1},
-	}
-
-	for _, test := range tests {
-		result := getAccuracyInt(test.input)
-		if result != test.expected {
-			t.Errorf("getAccuracyInt(%s) = %d, 期望 %d", test.input, result, test.expected)
-		}
-	}
-}
+	"go.mongodb.org/mongo-driver/bson"
+)
 
 func TestSensorMessageDocument(t *testing.T) {
 	// 测试SensorMessageDocument结构
@@ -61,6 +42,34 @@ func TestSensorMessageDocument(t *testing.T) {
 	}
 }
 
+func TestSensorMessageDocumentKeepsOriginalPayload(t *testing.T) {
+	data := `{"messageId":1,"sessionId":"s","deviceId":"d","payload":[` +
+		`{"name":"location","time":1751729987437545000,"values":{"latitude":35.681236789012345,"longitude":139.767125,"horizontalAccuracy":5}},` +
+		`{"name":"custom","time":1751729987437545001,"accuracy":7,"values":{"label":"abc","count":9007199254740993}}]}`
+	parsed, err := parseSensorMessage([]byte(data))
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+
+	raw, err := bson.Marshal(newSensorMessageDocument(parsed))
+	if err != nil {
+		t.Fatalf("序列化失败: %v", err)
+	}
+	var doc SensorMessageDocument
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf("反序列化失败: %v", err)
+	}
+
+	location := doc.Payload[0].Values
+	if location["latitude"] != 35.681236789012345 || location["longitude"] != 139.767125 || location["horizontalAccuracy"] != int64(5) {
+		t.Errorf("位置数据未原样保存: %#v", location)
+	}
+	custom := doc.Payload[1]
+	if custom.Accuracy != 7 || custom.Values["label"] != "abc" || custom.Values["count"] != int64(9007199254740993) {
+		t.Errorf("通用数据未原样保存: %#v", custom)
+	}
+}
+
 func TestDeviceInfoDocument(t *testing.T) {
 	// 测试DeviceInfoDocument结构
 	now := time.Now()
@@ -91,26 +100,6 @@ func TestDeviceInfoDocument(t *testing.T) {
 	}
 }
 
-func TestParseFloat(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected float64
-	}{
-		{"123.456", 123.456},
-		{"0", 0.0},
-		{"-123.456", -123.456},
-		{"invalid", 0.0},
-		{"", 0.0},
-	}
-
-	for _, test := range tests {
-		result := parseFloat(test.input)
-		if result != test.expected {
-			t.Errorf("parseFloat(%s) = %f, 期望 %f", test.input, result, test.expected)
-		}
-	}
-}
-
 // 注意：这些测试不需要实际的MongoDB连接
 // 实际的数据库操作测试需要在集成测试中进行
 func TestMongoDBFunctionsWithoutConnection(t *testing.T) {
@@ -136,19 +125,19 @@ func TestMongoDBFunctionsWithoutConnection(t *testing.T) {
 	}
 
 	// 测试GetSensorDataFromDB
-	_, err = GetSensorDataFromDB(10, "", "")
+	_, err = GetSensorDataFromDB(10, "", "", allDevices)
 	if err == nil {
 		t.Error("期望GetSensorDataFromDB在没有MongoDB连接时返回错误")
 	}
 
 	// 测试GetDeviceInfo
-	_, err = GetDeviceInfo()
+	_, err = GetDeviceInfo(allDevices)
 	if err == nil {
 		t.Error("期望GetDeviceInfo在没有MongoDB连接时返回错误")
 	}
 
 	// 测试GetDashboardStats
-	_, err = GetDashboardStats()
+	_, err = GetDashboardStats(allDevices)
 	if err == nil {
 		t.Error("期望GetDashboardStats在没有MongoDB连接时返回错误")
 	}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// decodeSensorMessage 流式解码传感器消息
+// payload 中的读数逐条解码，超过 maxReadings 时立即停止（maxReadings<=0 表示不限制）
+func decodeSensorMessage(reader io.Reader, maxReadings int) (*SensorMessage, error) {
+	dec := json.NewDecoder(reader)
+	// 数值先解码为json.Number，再按字面量转换为整数或浮点数，避免大整数经float64丢失精度
+	dec.UseNumber()
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	message := &SensorMessage{}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return nil, fmt.Errorf("无效的字段名: %v", token)
+		}
+
+		switch {
+		case strings.EqualFold(key, "messageId"):
+			err = dec.Decode(&message.MessageID)
+		case strings.EqualFold(key, "sessionId"):
+			err = dec.Decode(&message.SessionID)
+		case strings.EqualFold(key, "deviceId"):
+			err = dec.Decode(&message.DeviceID)
+		case strings.EqualFold(key, "payload"):
+			message.Payload, err = decodePayload(dec, maxReadings)
+		default:
+			var skipped json.RawMessage
+			err = dec.Decode(&skipped)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+
+	// 与json.Unmarshal保持一致：对象之后不允许有其他内容
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			return nil, errors.New("JSON对象之后存在多余内容")
+		}
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// decodePayload 逐条解码payload数组
+func decodePayload(dec *json.Decoder, maxReadings int) ([]SensorReading, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("payload必须是数组，实际为: %v", token)
+	}
+
+	payload := make([]SensorReading, 0)
+	for dec.More() {
+		if maxReadings > 0 && len(payload) >= maxReadings {
+			return nil, &BodyTooLargeError{Limit: "max_readings_per_message", Max: int64(maxReadings)}
+		}
+
+		var reading SensorReading
+		if err := dec.Decode(&reading); err != nil {
+			return nil, fmt.Errorf("解码第%d条读数失败: %w", len(payload), err)
+		}
+		for key, value := range reading.Values {
+			reading.Values[key] = normalizeJSONNumbers(value)
+		}
+		payload = append(payload, reading)
+	}
+
+	if err := expectDelim(dec, ']'); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// normalizeJSONNumbers 将json.Number转换为int64（整数字面量且不溢出时）或float64，递归处理嵌套的对象和数组
+func normalizeJSONNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if integer, err := v.Int64(); err == nil {
+			return integer
+		}
+		if number, err := v.Float64(); err == nil {
+			return number
+		}
+		return v.String()
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeJSONNumbers(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeJSONNumbers(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+// expectDelim 读取下一个token并确认是指定的分隔符
+func expectDelim(dec *json.Decoder, expected json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != expected {
+		return fmt.Errorf("期望 %q，实际为 %v", expected, token)
+	}
+	return nil
+}
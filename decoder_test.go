@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// buildTestMessage 生成包含指定数量读数的消息JSON
+func buildTestMessage(readings int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"messageId":7,"sessionId":"decode-session","deviceId":"decode-device","payload":[`)
+	for i := 0; i < readings; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `{"name":"accelerometer","time":%d,"accuracy":3,"values":{"x":%d,"y":0.5,"z":-1}}`,
+			1751729987437545000+int64(i), i)
+	}
+	buf.WriteString(`]}`)
+	return buf.Bytes()
+}
+
+func TestDecodeSensorMessage(t *testing.T) {
+	data := buildTestMessage(3)
+
+	message, err := decodeSensorMessage(bytes.NewReader(data), 0)
+	if err != nil {
+		t.Fatalf("流式解码失败: %v", err)
+	}
+
+	// 与json.Unmarshal的结果保持一致
+	var expected SensorMessage
+	if err := json.Unmarshal(data, &expected); err != nil {
+		t.Fatalf("json.Unmarshal失败: %v", err)
+	}
+
+	if message.MessageID != expected.MessageID || message.SessionID != expected.SessionID || message.DeviceID != expected.DeviceID {
+		t.Errorf("消息头不一致: %+v", message)
+	}
+	if len(message.Payload) != len(expected.Payload) {
+		t.Fatalf("期望%d条读数，实际为%d", len(expected.Payload), len(message.Payload))
+	}
+	// 整数字面量保留为int64，数值与json.Unmarshal一致
+	if getFloat64(message.Payload[2].Values["x"]) != getFloat64(expected.Payload[2].Values["x"]) {
+		t.Errorf("读数值不一致")
+	}
+}
+
+func TestDecodeSensorMessagePreservesValues(t *testing.T) {
+	data := `{"messageId":1,"sessionId":"s","deviceId":"d","payload":[{"name":"custom","time":1,"values":{` +
+		`"big":9007199254740993,"precise":0.12345678901234567,"count":42,"label":"abc","flag":true,"none":null,` +
+		`"nested":{"list":[1,2.5]}}}]}`
+
+	message, err := decodeSensorMessage(strings.NewReader(data), 0)
+	if err != nil {
+		t.Fatalf("解码失败: %v", err)
+	}
+
+	values := message.Payload[0].Values
+	if values["big"] != int64(9007199254740993) {
+		t.Errorf("大整数丢失精度: %v (%T)", values["big"], values["big"])
+	}
+	if values["precise"] != 0.12345678901234567 {
+		t.Errorf("浮点数精度丢失: %v", values["precise"])
+	}
+	if values["count"] != int64(42) || values["label"] != "abc" || values["flag"] != true || values["none"] != nil {
+		t.Errorf("值类型不正确: %#v", values)
+	}
+	nested := values["nested"].(map[string]interface{})["list"].([]interface{})
+	if nested[0] != int64(1) || nested[1] != 2.5 {
+		t.Errorf("嵌套值不正确: %#v", nested)
+	}
+}
+
+func TestDecodeSensorMessageUnknownFieldsAndOrder(t *testing.T) {
+	data := `{"payload":[{"name":"gravity","time":1,"values":{"x":1}}],"extra":{"nested":[1,2]},"deviceId":"d","messageId":3,"sessionId":"s"}`
+
+	message, err := decodeSensorMessage(strings.NewReader(data), 0)
+	if err != nil {
+		t.Fatalf("解码失败: %v", err)
+	}
+	if message.MessageID != 3 || message.DeviceID != "d" || len(message.Payload) != 1 {
+		t.Errorf("解码结果不正确: %+v", message)
+	}
+}
+
+func TestDecodeSensorMessageErrors(t *testing.T) {
+	invalid := []string{
+		`{"messageId": 1, "invalid": }`,
+		`{"payload": {"name": "x"}}`,
+		`[]`,
+		`{"messageId": 1} trailing`,
+		`{"messageId": 1`,
+	}
+
+	for _, data := range invalid {
+		if _, err := decodeSensorMessage(strings.NewReader(data), 0); err == nil {
+			t.Errorf("期望解码%q失败", data)
+		}
+	}
+}
+
+func TestDecodeSensorMessageMaxReadings(t *testing.T) {
+	_, err := decodeSensorMessage(bytes.NewReader(buildTestMessage(11)), 10)
+
+	var tooLarge *BodyTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("期望返回BodyTooLargeError，实际为%v", err)
+	}
+	if tooLarge.Limit != "max_readings_per_message" || tooLarge.Max != 10 {
+		t.Errorf("限制信息不正确: %+v", tooLarge)
+	}
+
+	if _, err := decodeSensorMessage(bytes.NewReader(buildTestMessage(10)), 10); err != nil {
+		t.Errorf("恰好达到上限时不应失败: %v", err)
+	}
+}
+
+func TestHandleSensorDataLimits(t *testing.T) {
+	original := AppConfig
+	defer func() { AppConfig = original }()
+
+	tests := []struct {
+		name  string
+		setup func()
+		limit string
+	}{
+		{"读数上限", func() { AppConfig.MaxReadingsPerMessage = 5 }, "max_readings_per_message"},
+		{"请求体上限", func() { AppConfig.MaxBodyBytes = 256 }, "max_body_bytes"},
+	}
+
+	for _, test := range tests {
+		AppConfig = original
+		test.setup()
+
+		req := httptest.NewRequest("POST", "/data", bytes.NewReader(buildTestMessage(20)))
+		rr := httptest.NewRecorder()
+		handleSensorData(rr, req)
+
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: 期望状态码413，实际为%d", test.name, rr.Code)
+			continue
+		}
+
+		var response LimitErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: 错误响应不是JSON: %v", test.name, err)
+		}
+		if response.Limit != test.limit {
+			t.Errorf("%s: 期望触发%s，实际为%s", test.name, test.limit, response.Limit)
+		}
+	}
+}
+
+// BenchmarkDecodeSensorMessage 基准测试：流式解码大消息
+func BenchmarkDecodeSensorMessage(b *testing.B) {
+	data := buildTestMessage(1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeSensorMessage(bytes.NewReader(data), 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
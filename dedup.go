@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// errDuplicateMessage 消息已存在（同一会话中的消息ID重复）
+var errDuplicateMessage = errors.New("重复的传感器消息")
+
+// 默认去重缓存容量
+const defaultDedupCacheSize = 100000
+
+// 全局消息去重器
+var messageDeduplicator = NewMessageDeduplicator(defaultDedupCacheSize)
+
+// MessageKey 消息唯一标识，与MongoDB中 (sessionId, messageId) 唯一索引一致
+type MessageKey struct {
+	SessionID string
+	MessageID int64
+}
+
+// messageKeyOf 获取解析后数据的消息标识
+func messageKeyOf(parsedData *ParsedSensorData) MessageKey {
+	return MessageKey{SessionID: parsedData.SessionID, MessageID: parsedData.MessageID}
+}
+
+// dedupSlot 淘汰队列中的一个槽位；generation用于识别撤销后重新登记的同一消息标识
+type dedupSlot struct {
+	key        MessageKey
+	generation uint64
+}
+
+// MessageDeduplicator 最近消息的内存去重器，超出容量时按先进先出淘汰
+type MessageDeduplicator struct {
+	mutex      sync.Mutex
+	seen       map[MessageKey]uint64 // 消息标识 -> 登记时的generation
+	order      []dedupSlot
+	next       int
+	capacity   int
+	generation uint64
+}
+
+// NewMessageDeduplicator 创建去重器
+func NewMessageDeduplicator(capacity int) *MessageDeduplicator {
+	if capacity < 1 {
+		capacity = defaultDedupCacheSize
+	}
+	return &MessageDeduplicator{
+		seen:     make(map[MessageKey]uint64),
+		order:    make([]dedupSlot, 0),
+		capacity: capacity,
+	}
+}
+
+// Reserve 登记消息标识；若已登记过则返回false
+func (d *MessageDeduplicator) Reserve(key MessageKey) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, exists := d.seen[key]; exists {
+		return false
+	}
+
+	d.generation++
+	slot := dedupSlot{key: key, generation: d.generation}
+	if len(d.order) < d.capacity {
+		d.order = append(d.order, slot)
+	} else {
+		// 只淘汰槽位对应的那次登记；撤销后重新登记的标识有更新的generation，不受旧槽位影响
+		evicted := d.order[d.next]
+		if d.seen[evicted.key] == evicted.generation {
+			delete(d.seen, evicted.key)
+		}
+		d.order[d.next] = slot
+		d.next = (d.next + 1) % d.capacity
+	}
+	d.seen[key] = d.generation
+	return true
+}
+
+// Release 撤销登记（消息最终未被接收时调用，使客户端重试不被误判为重复）
+func (d *MessageDeduplicator) Release(key MessageKey) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// 槽位保留在order中，淘汰到它时generation不匹配，不会影响之后重新登记的同一标识
+	delete(d.seen, key)
+}
+
+// Len 当前登记的消息数量
+func (d *MessageDeduplicator) Len() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return len(d.seen)
+}
+
+// checkDuplicate 在产生任何副作用之前判断消息是否重复
+// 未命中内存缓存时会查询MongoDB（服务重启后缓存为空）。返回true时消息已登记，调用方
+// 若最终未接收该消息需调用 messageDeduplicator.Release
+func checkDuplicate(parsedData *ParsedSensorData) bool {
+	key := messageKeyOf(parsedData)
+	if !messageDeduplicator.Reserve(key) {
+		return true
+	}
+
+	if mongoAvailable() {
+		exists, err := SensorMessageExists(key.SessionID, key.MessageID)
+		if err != nil {
+			// 查询失败时放行，由唯一索引兜底
+			LogError("查询重复消息", err,
+				slog.String("session_id", key.SessionID),
+				slog.Int64("message_id", key.MessageID))
+			return false
+		}
+		if exists {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordDuplicate 记录一次重复消息（异步更新设备的重复计数）
+func recordDuplicate(parsedData *ParsedSensorData) {
+	Logger.Info("收到重复消息",
+		slog.String("device_id", parsedData.DeviceID),
+		slog.String("session_id", parsedData.SessionID),
+		slog.Int64("message_id", parsedData.MessageID))
+
+	if !mongoAvailable() {
+		return
+	}
+
+	deviceID := parsedData.DeviceID
+	go func() {
+		dbStart := time.Now()
+		if err := IncrementDuplicateCount(deviceID); err != nil {
+			LogDatabaseOperation("increment_duplicate_count", false, 1, time.Since(dbStart))
+			LogError("更新重复消息计数", err, slog.String("device_id", deviceID))
+			return
+		}
+		LogDatabaseOperation("increment_duplicate_count", true, 1, time.Since(dbStart))
+	}()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMessageDeduplicator(t *testing.T) {
+	dedup := NewMessageDeduplicator(10)
+	key := MessageKey{SessionID: "s1", MessageID: 1}
+
+	if !dedup.Reserve(key) {
+		t.Fatal("首次登记应成功")
+	}
+	if dedup.Reserve(key) {
+		t.Error("重复登记应返回false")
+	}
+	if dedup.Reserve(MessageKey{SessionID: "s2", MessageID: 1}) == false {
+		t.Error("不同会话的相同消息ID不应视为重复")
+	}
+
+	dedup.Release(key)
+	if !dedup.Reserve(key) {
+		t.Error("撤销登记后应能再次登记")
+	}
+}
+
+func TestMessageDeduplicatorEviction(t *testing.T) {
+	dedup := NewMessageDeduplicator(3)
+
+	for i := int64(1); i <= 5; i++ {
+		dedup.Reserve(MessageKey{SessionID: "s", MessageID: i})
+	}
+
+	if dedup.Len() != 3 {
+		t.Errorf("期望缓存3条，实际为%d", dedup.Len())
+	}
+	// 最早的两条已被淘汰
+	if !dedup.Reserve(MessageKey{SessionID: "s", MessageID: 1}) {
+		t.Error("被淘汰的消息应能再次登记")
+	}
+	if dedup.Reserve(MessageKey{SessionID: "s", MessageID: 5}) {
+		t.Error("最近的消息仍应被识别为重复")
+	}
+}
+
+func TestMessageDeduplicatorReleaseThenReserve(t *testing.T) {
+	dedup := NewMessageDeduplicator(3)
+	key := MessageKey{SessionID: "s", MessageID: 1}
+
+	// 撤销后重新登记：旧槽位被淘汰时不能删除新的登记
+	dedup.Reserve(key)
+	dedup.Release(key)
+	dedup.Reserve(key)
+	dedup.Reserve(MessageKey{SessionID: "s", MessageID: 2})
+	dedup.Reserve(MessageKey{SessionID: "s", MessageID: 3})
+
+	if dedup.Reserve(key) {
+		t.Error("重新登记的消息在淘汰旧槽位后仍应被识别为重复")
+	}
+}
+
+func TestHandleSensorDataDuplicate(t *testing.T) {
+	original := AppConfig
+	originalDedup, originalPipeline := messageDeduplicator, ingestPipeline
+	defer func() {
+		AppConfig = original
+		messageDeduplicator, ingestPipeline = originalDedup, originalPipeline
+	}()
+
+	AppConfig.DataDir = t.TempDir()
+	AppConfig.MaxDataStore = 1000
+	AppConfig.EnableLogging = false
+	messageDeduplicator = NewMessageDeduplicator(100)
+	ingestPipeline = nil
+
+	body := []byte(`{"messageId":42,"sessionId":"dup-session","deviceId":"dup-device","payload":[{"name":"accelerometer","time":1751729987437545000,"accuracy":3,"values":{"x":1,"y":2,"z":3}}]}`)
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/data", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		handleSensorData(rr, req)
+		return rr
+	}
+
+	if rr := post(); rr.Code != http.StatusOK {
+		t.Fatalf("首次提交期望状态码200，实际为%d", rr.Code)
+	}
+	storeLen := parsedDataStore.Len()
+	files, _ := os.ReadDir(AppConfig.DataDir)
+
+	rr := post()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("重复提交期望状态码200，实际为%d", rr.Code)
+	}
+
+	var response IngestAck
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("重复响应不是JSON: %v", err)
+	}
+	if !response.Duplicate || response.MessageID != 42 || response.SessionID != "dup-session" {
+		t.Errorf("重复响应不正确: %+v", response)
+	}
+
+	if parsedDataStore.Len() != storeLen {
+		t.Errorf("重复消息不应写入内存存储: %d -> %d", storeLen, parsedDataStore.Len())
+	}
+	if filesAfter, _ := os.ReadDir(AppConfig.DataDir); len(filesAfter) != len(files) {
+		t.Errorf("重复消息不应写入文件: %d -> %d", len(files), len(filesAfter))
+	}
+}
+
+func TestHandleSensorDataQueueFullReleasesKey(t *testing.T) {
+	originalDedup, originalPipeline := messageDeduplicator, ingestPipeline
+	defer func() { messageDeduplicator, ingestPipeline = originalDedup, originalPipeline }()
+
+	messageDeduplicator = NewMessageDeduplicator(100)
+	ingestPipeline = NewIngestPipeline(1, 0)
+
+	req := httptest.NewRequest("POST", "/data", bytes.NewReader(buildTestMessage(1)))
+	rr := httptest.NewRecorder()
+	handleSensorData(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("期望状态码503，实际为%d", rr.Code)
+	}
+	// 被拒绝的消息重试时不能被判为重复
+	if messageDeduplicator.Len() != 0 {
+		t.Errorf("被拒绝的消息应撤销去重登记")
+	}
+}
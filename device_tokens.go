@@ -0,0 +1,627 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 设备令牌鉴权模式
+const (
+	DeviceAuthOff     = "off"     // 不检查令牌（默认）
+	DeviceAuthWarn    = "warn"    // 检查令牌，未通过时只记录日志，便于逐台设备配置令牌
+	DeviceAuthEnforce = "enforce" // 缺少或无效的令牌返回401，deviceId与令牌不一致返回403
+)
+
+// validDeviceAuthModes 支持的设备令牌鉴权模式
+var validDeviceAuthModes = []string{DeviceAuthOff, DeviceAuthWarn, DeviceAuthEnforce}
+
+// 设备令牌前缀，便于在日志和配置中识别
+const deviceTokenPrefix = "slt_"
+
+// 后台从MongoDB和文件重新加载令牌的间隔（使命令行创建或吊销的令牌在运行中的服务器生效）
+const deviceTokenReloadInterval = 30 * time.Second
+
+var (
+	// errTokenNotFound 令牌不存在
+	errTokenNotFound = errors.New("令牌不存在")
+	// errDeviceMismatch 消息中的deviceId与令牌绑定的设备不一致
+	errDeviceMismatch = errors.New("deviceId与令牌绑定的设备不一致")
+)
+
+// 全局设备令牌注册表
+var deviceTokens *TokenRegistry
+
+// DeviceToken 绑定到设备的API令牌（只保存令牌的SHA-256摘要）
+type DeviceToken struct {
+	ID        string     `json:"id" bson:"_id"`
+	DeviceID  string     `json:"deviceId" bson:"deviceId"`
+	Name      string     `json:"name,omitempty" bson:"name,omitempty"`
+	Hash      string     `json:"hash,omitempty" bson:"hash"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// Revoked 令牌是否已吊销
+func (t DeviceToken) Revoked() bool {
+	return t.RevokedAt != nil
+}
+
+// public 返回不含摘要的副本，用于接口响应
+func (t DeviceToken) public() DeviceToken {
+	t.Hash = ""
+	return t
+}
+
+// CreatedDeviceToken 新建令牌的结果，Token只在创建时返回一次
+type CreatedDeviceToken struct {
+	DeviceToken
+	Token string `json:"token"`
+}
+
+// TokenRegistry 设备令牌注册表：MongoDB可用时以 device_tokens 集合为准，
+// 同时镜像到数据目录中的文件，MongoDB不可用时使用文件中的令牌。
+// 令牌只会新增或吊销，不会删除，因此两处的数据可以直接合并（吊销状态优先）
+type TokenRegistry struct {
+	path string
+
+	mutex  sync.RWMutex
+	byHash map[string]DeviceToken
+	byID   map[string]DeviceToken
+
+	// 串行化文件和MongoDB的读写
+	storeMutex sync.Mutex
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewTokenRegistry 创建令牌注册表，path为文件镜像的路径
+func NewTokenRegistry(path string) *TokenRegistry {
+	return &TokenRegistry{
+		path:   path,
+		byHash: make(map[string]DeviceToken),
+		byID:   make(map[string]DeviceToken),
+	}
+}
+
+// Start 加载令牌并在后台定期重新加载
+func (r *TokenRegistry) Start() {
+	if err := r.Reload(); err != nil {
+		LogError("加载设备令牌", err)
+	}
+
+	r.stopCh = make(chan struct{})
+	r.doneCh = make(chan struct{})
+	go func() {
+		defer close(r.doneCh)
+
+		ticker := time.NewTicker(deviceTokenReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stopCh:
+				return
+			case <-ticker.C:
+				if err := r.Reload(); err != nil {
+					LogError("重新加载设备令牌", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop 停止后台重新加载
+func (r *TokenRegistry) Stop() {
+	if r.stopCh != nil {
+		close(r.stopCh)
+		<-r.doneCh
+	}
+}
+
+// Reload 合并文件和MongoDB中的令牌：只存在于文件中的令牌（MongoDB不可用时创建）写回MongoDB，
+// 合并结果写回文件
+func (r *TokenRegistry) Reload() error {
+	r.storeMutex.Lock()
+	defer r.storeMutex.Unlock()
+
+	return r.reloadLocked()
+}
+
+func (r *TokenRegistry) reloadLocked() error {
+	tokens, err := readTokenFile(r.path)
+	if err != nil {
+		return err
+	}
+	merged := make(map[string]DeviceToken, len(tokens))
+	for _, token := range tokens {
+		mergeDeviceToken(merged, token)
+	}
+
+	if coll, err := mongoDeviceTokenCollection(); err == nil {
+		stored, err := findDeviceTokens(coll)
+		if err != nil {
+			r.setTokens(merged)
+			return err
+		}
+		storedByID := make(map[string]DeviceToken, len(stored))
+		for _, token := range stored {
+			storedByID[token.ID] = token
+			mergeDeviceToken(merged, token)
+		}
+		for id, token := range merged {
+			if existing, ok := storedByID[id]; !ok || existing.Revoked() != token.Revoked() {
+				if err := upsertDeviceToken(coll, token); err != nil {
+					LogError("同步设备令牌到MongoDB", err, slog.String("token_id", id))
+				}
+			}
+		}
+		if len(merged) != len(tokens) || hasRevocationChanges(tokens, merged) {
+			if err := writeTokenFile(r.path, merged); err != nil {
+				LogError("写入设备令牌文件", err)
+			}
+		}
+	}
+
+	r.setTokens(merged)
+	return nil
+}
+
+// mergeDeviceToken 合并同一令牌的两份记录，吊销状态优先
+func mergeDeviceToken(tokens map[string]DeviceToken, token DeviceToken) {
+	existing, ok := tokens[token.ID]
+	if !ok || (!existing.Revoked() && token.Revoked()) {
+		tokens[token.ID] = token
+	}
+}
+
+// hasRevocationChanges 判断合并结果中是否有文件中尚未记录的吊销
+func hasRevocationChanges(fileTokens []DeviceToken, merged map[string]DeviceToken) bool {
+	for _, token := range fileTokens {
+		if merged[token.ID].Revoked() != token.Revoked() {
+			return true
+		}
+	}
+	return false
+}
+
+// setTokens 替换内存中的令牌
+func (r *TokenRegistry) setTokens(tokens map[string]DeviceToken) {
+	byHash := make(map[string]DeviceToken, len(tokens))
+	for _, token := range tokens {
+		byHash[token.Hash] = token
+	}
+
+	r.mutex.Lock()
+	r.byID, r.byHash = tokens, byHash
+	r.mutex.Unlock()
+}
+
+// Authenticate 校验令牌，返回有效（未吊销）令牌的记录
+func (r *TokenRegistry) Authenticate(token string) (DeviceToken, bool) {
+	if !strings.HasPrefix(token, deviceTokenPrefix) {
+		return DeviceToken{}, false
+	}
+
+	r.mutex.RLock()
+	record, ok := r.byHash[hashSecret(token)]
+	r.mutex.RUnlock()
+	if !ok || record.Revoked() {
+		return DeviceToken{}, false
+	}
+	return record, true
+}
+
+// List 按创建时间列出令牌，deviceID不为空时只列出该设备的令牌
+func (r *TokenRegistry) List(deviceID string) []DeviceToken {
+	r.mutex.RLock()
+	tokens := make([]DeviceToken, 0, len(r.byID))
+	for _, token := range r.byID {
+		if deviceID == "" || token.DeviceID == deviceID {
+			tokens = append(tokens, token.public())
+		}
+	}
+	r.mutex.RUnlock()
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens
+}
+
+// Create 为设备创建令牌，写入MongoDB（可用时）和文件
+func (r *TokenRegistry) Create(deviceID, name string) (*CreatedDeviceToken, error) {
+	if deviceID == "" {
+		return nil, errors.New("deviceId不能为空")
+	}
+
+	var secret [24]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return nil, fmt.Errorf("生成令牌失败: %v", err)
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, fmt.Errorf("生成令牌失败: %v", err)
+	}
+
+	plain := deviceTokenPrefix + hex.EncodeToString(secret[:])
+	token := DeviceToken{
+		ID:        hex.EncodeToString(id[:]),
+		DeviceID:  deviceID,
+		Name:      name,
+		Hash:      hashSecret(plain),
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if err := r.store(token); err != nil {
+		return nil, err
+	}
+
+	Logger.Info("已创建设备令牌",
+		slog.String("token_id", token.ID),
+		slog.String("device_id", deviceID))
+	return &CreatedDeviceToken{DeviceToken: token.public(), Token: plain}, nil
+}
+
+// Revoke 吊销令牌，已吊销的令牌保持原吊销时间
+func (r *TokenRegistry) Revoke(id string) (DeviceToken, error) {
+	r.mutex.RLock()
+	token, ok := r.byID[id]
+	r.mutex.RUnlock()
+	if !ok {
+		return DeviceToken{}, errTokenNotFound
+	}
+	if token.Revoked() {
+		return token.public(), nil
+	}
+
+	revokedAt := time.Now().UTC().Truncate(time.Millisecond)
+	token.RevokedAt = &revokedAt
+	if err := r.store(token); err != nil {
+		return DeviceToken{}, err
+	}
+
+	Logger.Info("已吊销设备令牌",
+		slog.String("token_id", token.ID),
+		slog.String("device_id", token.DeviceID))
+	return token.public(), nil
+}
+
+// store 保存新建或吊销的令牌：先重新加载以合并其他进程的修改，再写入MongoDB和文件
+func (r *TokenRegistry) store(token DeviceToken) error {
+	r.storeMutex.Lock()
+	defer r.storeMutex.Unlock()
+
+	if err := r.reloadLocked(); err != nil {
+		LogError("加载设备令牌", err)
+	}
+
+	r.mutex.RLock()
+	tokens := make(map[string]DeviceToken, len(r.byID)+1)
+	for id, existing := range r.byID {
+		tokens[id] = existing
+	}
+	r.mutex.RUnlock()
+	tokens[token.ID] = token
+
+	if err := writeTokenFile(r.path, tokens); err != nil {
+		return err
+	}
+	if coll, err := mongoDeviceTokenCollection(); err == nil {
+		if err := upsertDeviceToken(coll, token); err != nil {
+			// 文件中已保存，下次重新加载时同步到MongoDB
+			LogError("保存设备令牌到MongoDB", err, slog.String("token_id", token.ID))
+		}
+	}
+
+	r.setTokens(tokens)
+	return nil
+}
+
+// hashSecret 计算令牌或API密钥的SHA-256摘要
+func hashSecret(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// readTokenFile 读取令牌文件，文件不存在时返回空列表
+func readTokenFile(path string) ([]DeviceToken, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取设备令牌文件失败: %v", err)
+	}
+
+	var tokens []DeviceToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("解析设备令牌文件失败: %v", err)
+	}
+	return tokens, nil
+}
+
+// writeTokenFile 写入令牌文件（先写临时文件再替换，避免写入一半时被读取）
+func writeTokenFile(path string, tokens map[string]DeviceToken) error {
+	list := make([]DeviceToken, 0, len(tokens))
+	for _, token := range tokens {
+		list = append(list, token)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建数据目录失败: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("写入设备令牌文件失败: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("写入设备令牌文件失败: %v", err)
+	}
+	return nil
+}
+
+// findDeviceTokens 读取MongoDB中的全部令牌
+func findDeviceTokens(coll *mongo.Collection) ([]DeviceToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("查询设备令牌失败: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var tokens []DeviceToken
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, fmt.Errorf("解析设备令牌失败: %v", err)
+	}
+	return tokens, nil
+}
+
+// upsertDeviceToken 写入或更新MongoDB中的令牌
+func upsertDeviceToken(coll *mongo.Collection, token DeviceToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": token.ID}, token, options.Replace().SetUpsert(true))
+	return err
+}
+
+// deviceTokenPath 令牌文件的路径
+func deviceTokenPath() string {
+	return filepath.Join(AppConfig.DataDir, "device_tokens.json")
+}
+
+// deviceAuthContextKey 请求上下文中已认证令牌的键
+type deviceAuthContextKey struct{}
+
+// authenticatedDeviceToken 取出请求上下文中已认证的令牌
+func authenticatedDeviceToken(ctx context.Context) (DeviceToken, bool) {
+	token, ok := ctx.Value(deviceAuthContextKey{}).(DeviceToken)
+	return token, ok
+}
+
+// checkDeviceBinding 检查消息的deviceId是否与请求使用的令牌一致；warn模式下只记录日志
+func checkDeviceBinding(ctx context.Context, deviceID string) error {
+	token, ok := authenticatedDeviceToken(ctx)
+	if !ok || token.DeviceID == deviceID {
+		return nil
+	}
+
+	Logger.Warn("deviceId与令牌绑定的设备不一致",
+		slog.String("token_id", token.ID),
+		slog.String("token_device_id", token.DeviceID),
+		slog.String("device_id", deviceID),
+		slog.String("mode", AppConfig.DeviceAuthMode))
+	if AppConfig.DeviceAuthMode == DeviceAuthEnforce {
+		return errDeviceMismatch
+	}
+	return nil
+}
+
+// withDeviceAuth 数据接收接口鉴权：要求 Authorization: Bearer <设备令牌> 或已通过校验的客户端证书，
+// 认证通过的令牌放入请求上下文，由入库流程检查消息中的deviceId
+func withDeviceAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if AppConfig.DeviceAuthMode == DeviceAuthOff || r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+
+		// 双向TLS的客户端证书等同于绑定到证书CommonName的令牌
+		if token, ok := clientCertificateDevice(r); ok {
+			next(w, r.WithContext(context.WithValue(r.Context(), deviceAuthContextKey{}, token)))
+			return
+		}
+
+		startTime := time.Now()
+		plain, ok := bearerToken(r)
+		var token DeviceToken
+		if ok && deviceTokens != nil {
+			token, ok = deviceTokens.Authenticate(plain)
+		} else {
+			ok = false
+		}
+		if ok {
+			next(w, r.WithContext(context.WithValue(r.Context(), deviceAuthContextKey{}, token)))
+			return
+		}
+
+		Logger.Warn("设备令牌鉴权失败",
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("path", r.URL.Path),
+			slog.Bool("token_present", plain != ""),
+			slog.String("mode", AppConfig.DeviceAuthMode))
+		if AppConfig.DeviceAuthMode == DeviceAuthWarn {
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ingest"`)
+		http.Error(w, "缺少或无效的设备令牌", http.StatusUnauthorized)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusUnauthorized, time.Since(startTime))
+	}
+}
+
+// handleAdminTokens 管理接口：GET列出令牌（可按device过滤），POST创建令牌
+// 创建请求体：{"deviceId": "...", "name": "..."}，响应中的token只返回这一次
+func handleAdminTokens(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"tokens": deviceTokens.List(r.URL.Query().Get("device")),
+		})
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
+
+	case http.MethodPost:
+		var request struct {
+			DeviceID string `json:"deviceId"`
+			Name     string `json:"name"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&request); err != nil || request.DeviceID == "" {
+			http.Error(w, "请求体应为包含deviceId的JSON", http.StatusBadRequest)
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+			return
+		}
+
+		created, err := deviceTokens.Create(request.DeviceID, request.Name)
+		if err != nil {
+			LogError("创建设备令牌", err, slog.String("device_id", request.DeviceID))
+			http.Error(w, "创建令牌失败", http.StatusInternalServerError)
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusInternalServerError, time.Since(startTime))
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusCreated, time.Since(startTime))
+
+	default:
+		http.Error(w, "只支持GET和POST方法", http.StatusMethodNotAllowed)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusMethodNotAllowed, time.Since(startTime))
+	}
+}
+
+// handleAdminRevokeToken 管理接口：DELETE /api/admin/tokens/{id} 吊销令牌
+func handleAdminRevokeToken(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "只支持DELETE方法", http.StatusMethodNotAllowed)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusMethodNotAllowed, time.Since(startTime))
+		return
+	}
+
+	token, err := deviceTokens.Revoke(r.PathValue("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errTokenNotFound) {
+			status = http.StatusNotFound
+		} else {
+			LogError("吊销设备令牌", err, slog.String("token_id", r.PathValue("id")))
+		}
+		http.Error(w, err.Error(), status)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, status, time.Since(startTime))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, token)
+	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
+}
+
+// runTokenCommand 管理设备令牌：token create|list|revoke
+func runTokenCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "用法: token create -device ID [-name 名称] | token list [-device ID] | token revoke <令牌ID>")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("token "+args[0], flag.ContinueOnError)
+	deviceID := flags.String("device", "", "设备ID")
+	name := flags.String("name", "", "令牌说明（例如设备名称）")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	// 令牌只保存到文件和MongoDB，不需要写前缓冲和原始数据归档
+	AppConfig.EnableLogging = false
+	cleanup := startCommandMongo()
+	defer cleanup()
+	if !mongoAvailable() {
+		Logger.Warn("MongoDB不可用，令牌只保存到文件，服务器连接MongoDB后自动同步")
+	}
+
+	registry := NewTokenRegistry(deviceTokenPath())
+	if err := registry.Reload(); err != nil {
+		fmt.Fprintf(os.Stderr, "加载令牌失败: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "create":
+		if *deviceID == "" {
+			fmt.Fprintln(os.Stderr, "请使用 -device 指定设备ID")
+			return 2
+		}
+		created, err := registry.Create(*deviceID, *name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "创建令牌失败: %v\n", err)
+			return 1
+		}
+		fmt.Printf("令牌ID: %s\n设备ID: %s\n令牌: %s\n", created.ID, created.DeviceID, created.Token)
+		fmt.Println("请在Sensor Logger的HTTP推送中添加请求头 Authorization: Bearer <令牌>，令牌只显示这一次")
+
+	case "list":
+		for _, token := range registry.List(*deviceID) {
+			status := "有效"
+			if token.Revoked() {
+				status = "已吊销 " + token.RevokedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%s  %-24s  %-16s  %s  %s\n",
+				token.ID, token.DeviceID, token.Name, token.CreatedAt.Local().Format("2006-01-02 15:04:05"), status)
+		}
+
+	case "revoke":
+		if flags.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "请指定要吊销的令牌ID")
+			return 2
+		}
+		token, err := registry.Revoke(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "吊销令牌失败: %v\n", err)
+			return 1
+		}
+		fmt.Printf("已吊销令牌 %s（设备 %s）\n", token.ID, token.DeviceID)
+
+	default:
+		fmt.Fprintf(os.Stderr, "未知的token子命令: %s\n", args[0])
+		return 2
+	}
+	return 0
+}
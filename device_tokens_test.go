@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestTokenRegistry(t *testing.T) {
+	originalClient := mongoClient
+	setMongoConnection(nil)
+	defer func() { mongoClient = originalClient }()
+
+	path := filepath.Join(t.TempDir(), "device_tokens.json")
+	registry := NewTokenRegistry(path)
+
+	created, err := registry.Create("device-a", "pixel")
+	if err != nil {
+		t.Fatalf("创建令牌失败: %v", err)
+	}
+	if created.Hash != "" {
+		t.Error("创建结果不应包含令牌摘要")
+	}
+	if token, ok := registry.Authenticate(created.Token); !ok || token.DeviceID != "device-a" {
+		t.Fatalf("新建的令牌应通过认证: %+v", token)
+	}
+	if _, ok := registry.Authenticate(created.Token + "x"); ok {
+		t.Error("错误的令牌不应通过认证")
+	}
+
+	// MongoDB不可用时从文件加载
+	reloaded := NewTokenRegistry(path)
+	if err := reloaded.Reload(); err != nil {
+		t.Fatalf("重新加载失败: %v", err)
+	}
+	if _, ok := reloaded.Authenticate(created.Token); !ok {
+		t.Fatal("从文件加载的令牌应通过认证")
+	}
+
+	if _, err := reloaded.Revoke(created.ID); err != nil {
+		t.Fatalf("吊销令牌失败: %v", err)
+	}
+	if _, ok := reloaded.Authenticate(created.Token); ok {
+		t.Error("已吊销的令牌不应通过认证")
+	}
+	if _, err := reloaded.Revoke("missing"); err != errTokenNotFound {
+		t.Errorf("吊销不存在的令牌应返回errTokenNotFound，实际为%v", err)
+	}
+
+	// 其他进程吊销的令牌在重新加载后生效
+	registry.Reload()
+	if _, ok := registry.Authenticate(created.Token); ok {
+		t.Error("重新加载后已吊销的令牌不应通过认证")
+	}
+	if tokens := registry.List("device-a"); len(tokens) != 1 || !tokens[0].Revoked() || tokens[0].Hash != "" {
+		t.Errorf("令牌列表不正确: %+v", tokens)
+	}
+}
+
+func TestWithDeviceAuth(t *testing.T) {
+	originalMode, originalRegistry := AppConfig.DeviceAuthMode, deviceTokens
+	originalClient := mongoClient
+	originalDedup, originalPipeline := messageDeduplicator, ingestPipeline
+	originalLogging := AppConfig.EnableLogging
+	defer func() {
+		AppConfig.DeviceAuthMode, deviceTokens = originalMode, originalRegistry
+		mongoClient = originalClient
+		messageDeduplicator, ingestPipeline = originalDedup, originalPipeline
+		AppConfig.EnableLogging = originalLogging
+	}()
+	setMongoConnection(nil)
+	messageDeduplicator, ingestPipeline = NewMessageDeduplicator(100), nil
+	AppConfig.EnableLogging = false
+
+	deviceTokens = NewTokenRegistry(filepath.Join(t.TempDir(), "device_tokens.json"))
+	matching, _ := deviceTokens.Create("decode-device", "")
+	other, _ := deviceTokens.Create("other-device", "")
+
+	handler := withDeviceAuth(handleSensorData)
+	post := func(authorization string) int {
+		req := httptest.NewRequest(http.MethodPost, "/data", bytes.NewReader(buildTestMessage(1)))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+
+	AppConfig.DeviceAuthMode = DeviceAuthEnforce
+	tests := map[string]int{
+		"":                         http.StatusUnauthorized,
+		"Bearer slt_invalid":       http.StatusUnauthorized,
+		"Bearer " + other.Token:    http.StatusForbidden,
+		"Bearer " + matching.Token: http.StatusOK,
+	}
+	for authorization, want := range tests {
+		if code := post(authorization); code != want {
+			t.Errorf("Authorization=%q: 期望%d，实际为%d", authorization, want, code)
+		}
+	}
+
+	// 吊销后立即失效
+	deviceTokens.Revoke(matching.ID)
+	if code := post("Bearer " + matching.Token); code != http.StatusUnauthorized {
+		t.Errorf("已吊销的令牌期望401，实际为%d", code)
+	}
+
+	// warn模式只记录日志
+	AppConfig.DeviceAuthMode = DeviceAuthWarn
+	if code := post(""); code != http.StatusOK {
+		t.Errorf("warn模式下缺少令牌期望200，实际为%d", code)
+	}
+	if code := post("Bearer " + other.Token); code != http.StatusOK {
+		t.Errorf("warn模式下设备不一致期望200，实际为%d", code)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+// 持久化要求：决定哪些存储失败需要返回5xx，让Sensor Logger应用自行重试
+const (
+	DurabilityBestEffort     = "best_effort"      // 尽力写入，始终返回成功（默认）
+	DurabilityRequireDB      = "require_db"       // MongoDB确认写入（或写入写前缓冲，返回202）后才返回成功
+	DurabilityRequireAnySink = "require_any_sink" // MongoDB、写前缓冲或原始文件至少一个写入成功
+)
+
+// validDurabilityModes 支持的持久化要求
+var validDurabilityModes = []string{DurabilityBestEffort, DurabilityRequireDB, DurabilityRequireAnySink}
+
+// durabilityRequiresResult 判断是否需要等待实际写入结果后再响应客户端
+func durabilityRequiresResult(mode string) bool {
+	return mode == DurabilityRequireDB || mode == DurabilityRequireAnySink
+}
+
+// evaluateDurability 根据各存储的写入结果判断是否满足持久化要求，不满足时返回原因
+// 内存存储不算持久化存储
+func evaluateDurability(mode string, sinks SinkResults) (bool, string) {
+	switch mode {
+	case DurabilityRequireDB:
+		// 写前缓冲中的消息由后台回放写入数据库；拒绝它们会让客户端在缓冲排空前反复重试
+		if sinks.Mongo == SinkOK || sinks.Mongo == SinkSpooled {
+			return true, ""
+		}
+		return false, "MongoDB未确认写入"
+	case DurabilityRequireAnySink:
+		if sinks.Mongo == SinkOK || sinks.Mongo == SinkSpooled || sinks.File == SinkOK {
+			return true, ""
+		}
+		return false, "没有任何持久化存储写入成功"
+	default:
+		return true, ""
+	}
+}
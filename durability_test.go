@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEvaluateDurability(t *testing.T) {
+	tests := []struct {
+		mode     string
+		sinks    SinkResults
+		expected bool
+	}{
+		{DurabilityBestEffort, SinkResults{Memory: SinkOK, Mongo: SinkFailed, File: SinkFailed}, true},
+		{DurabilityRequireDB, SinkResults{Memory: SinkOK, Mongo: SinkOK, File: SinkFailed}, true},
+		{DurabilityRequireDB, SinkResults{Memory: SinkOK, Mongo: SinkSpooled, File: SinkOK}, true},
+		{DurabilityRequireDB, SinkResults{Memory: SinkOK, Mongo: SinkFailed, File: SinkOK}, false},
+		{DurabilityRequireAnySink, SinkResults{Memory: SinkOK, Mongo: SinkFailed, File: SinkOK}, true},
+		{DurabilityRequireAnySink, SinkResults{Memory: SinkOK, Mongo: SinkSpooled, File: SinkDisabled}, true},
+		{DurabilityRequireAnySink, SinkResults{Memory: SinkOK, Mongo: SinkFailed, File: SinkDisabled}, false},
+	}
+
+	for _, tt := range tests {
+		accepted, reason := evaluateDurability(tt.mode, tt.sinks)
+		if accepted != tt.expected {
+			t.Errorf("evaluateDurability(%s, %+v) = %v, 期望 %v", tt.mode, tt.sinks, accepted, tt.expected)
+		}
+		if !accepted && reason == "" {
+			t.Errorf("evaluateDurability(%s, %+v) 未通过时应返回原因", tt.mode, tt.sinks)
+		}
+	}
+}
+
+// postWithDurability 在指定持久化要求下提交一条消息（无数据库连接）
+func postWithDurability(t *testing.T, mode string, fileLog bool, pipeline *IngestPipeline) *httptest.ResponseRecorder {
+	t.Helper()
+
+	originalMode, originalFileLog, originalLogging := AppConfig.DurabilityMode, AppConfig.EnableFileLog, AppConfig.EnableLogging
+	originalClient, originalSpool := mongoClient, messageSpool
+	originalDedup, originalPipeline := messageDeduplicator, ingestPipeline
+	t.Cleanup(func() {
+		AppConfig.DurabilityMode, AppConfig.EnableFileLog, AppConfig.EnableLogging = originalMode, originalFileLog, originalLogging
+		mongoClient, messageSpool = originalClient, originalSpool
+		messageDeduplicator, ingestPipeline = originalDedup, originalPipeline
+	})
+
+	AppConfig.DurabilityMode, AppConfig.EnableFileLog, AppConfig.EnableLogging = mode, fileLog, false
+	mongoClient, messageSpool = nil, nil
+	messageDeduplicator = NewMessageDeduplicator(100)
+	ingestPipeline = pipeline
+
+	req := httptest.NewRequest("POST", "/data", bytes.NewReader(buildTestMessage(1)))
+	rr := httptest.NewRecorder()
+	handleSensorData(rr, req)
+	return rr
+}
+
+func TestHandleSensorDataRequireDB(t *testing.T) {
+	rr := postWithDurability(t, DurabilityRequireDB, true, nil)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("数据库不可用时期望状态码503，实际为%d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("503响应应带有Retry-After头")
+	}
+
+	var ack IngestAck
+	if err := json.Unmarshal(rr.Body.Bytes(), &ack); err != nil {
+		t.Fatalf("响应不是JSON: %v", err)
+	}
+	if ack.Sinks.Mongo != SinkFailed || ack.Sinks.File != SinkSkipped || ack.Sinks.Memory != SinkSkipped {
+		t.Errorf("写入结果不正确: %+v", ack.Sinks)
+	}
+
+	// 去重登记已撤销，客户端重试不应被当作重复消息
+	if !messageDeduplicator.Reserve(MessageKey{SessionID: "decode-session", MessageID: 7}) {
+		t.Error("持久化失败后应撤销去重登记")
+	}
+}
+
+func TestHandleSensorDataRequireAnySink(t *testing.T) {
+	if rr := postWithDurability(t, DurabilityRequireAnySink, true, nil); rr.Code != http.StatusOK {
+		t.Errorf("文件写入成功时期望状态码200，实际为%d", rr.Code)
+	}
+}
+
+func TestHandleSensorDataRequireAnySinkWithoutSinks(t *testing.T) {
+	if rr := postWithDurability(t, DurabilityRequireAnySink, false, nil); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("没有持久化存储时期望状态码503，实际为%d", rr.Code)
+	}
+}
+
+func TestHandleSensorDataRequireDBWaitsForPipeline(t *testing.T) {
+	pipeline := NewIngestPipeline(1, 10)
+	pipeline.Start()
+	defer pipeline.Close()
+
+	rr := postWithDurability(t, DurabilityRequireDB, false, pipeline)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("期望状态码503，实际为%d", rr.Code)
+	}
+
+	var ack IngestAck
+	if err := json.Unmarshal(rr.Body.Bytes(), &ack); err != nil {
+		t.Fatalf("响应不是JSON: %v", err)
+	}
+	if ack.Sinks.Mongo == SinkQueued {
+		t.Error("等待持久化结果时不应返回queued")
+	}
+}
+
+func TestRequireDBRetryDoesNotDuplicate(t *testing.T) {
+	originalMode, originalFileLog, originalLogging := AppConfig.DurabilityMode, AppConfig.EnableFileLog, AppConfig.EnableLogging
+	originalClient, originalSpool, originalArchive := mongoClient, messageSpool, rawArchive
+	originalDedup, originalPipeline, originalStore := messageDeduplicator, ingestPipeline, parsedDataStore
+	t.Cleanup(func() {
+		AppConfig.DurabilityMode, AppConfig.EnableFileLog, AppConfig.EnableLogging = originalMode, originalFileLog, originalLogging
+		mongoClient, messageSpool, rawArchive = originalClient, originalSpool, originalArchive
+		messageDeduplicator, ingestPipeline, parsedDataStore = originalDedup, originalPipeline, originalStore
+	})
+
+	AppConfig.DurabilityMode, AppConfig.EnableFileLog, AppConfig.EnableLogging = DurabilityRequireDB, true, false
+	mongoClient, messageSpool, ingestPipeline = nil, nil, nil
+	messageDeduplicator = NewMessageDeduplicator(100)
+	parsedDataStore = NewThreadSafeDataStore()
+	rawArchive = newTestArchive(t, 1<<20, ArchiveCompressNone)
+	defer rawArchive.Close()
+
+	post := func() int {
+		rr := httptest.NewRecorder()
+		handleSensorData(rr, httptest.NewRequest("POST", "/data", bytes.NewReader(buildTestMessage(1))))
+		return rr.Code
+	}
+
+	// 数据库不可用时每次重试都返回503，且不写入内存和归档
+	for i := 0; i < 2; i++ {
+		if code := post(); code != http.StatusServiceUnavailable {
+			t.Fatalf("第%d次提交期望503，实际为%d", i+1, code)
+		}
+	}
+	if parsedDataStore.Len() != 0 || rawArchive.Stats().Records != 0 {
+		t.Fatalf("未接收的消息不应写入内存或归档: 内存%d条，归档%d条", parsedDataStore.Len(), rawArchive.Stats().Records)
+	}
+
+	// 写入写前缓冲后返回202，重试被识别为重复消息
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建写前缓冲失败: %v", err)
+	}
+	messageSpool = spool
+	if code := post(); code != http.StatusAccepted {
+		t.Fatalf("写入缓冲后期望202，实际为%d", code)
+	}
+	if code := post(); code != http.StatusOK {
+		t.Fatalf("重试期望200（重复消息），实际为%d", code)
+	}
+	if parsedDataStore.Len() != 1 || rawArchive.Stats().Records != 1 {
+		t.Errorf("重试后应只保存一次: 内存%d条，归档%d条", parsedDataStore.Len(), rawArchive.Stats().Records)
+	}
+}
@@ -1,13 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log/slog"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -173,7 +174,7 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 		MaxDataStore:    AppConfig.MaxDataStore,
 		FileLogStatus:   map[bool]string{true: "启用", false: "禁用"}[AppConfig.EnableFileLog],
 		ServerAddr:      GetServerAddr(),
-		MongoStatus:     map[bool]string{true: "已连接", false: "未连接"}[mongoClient != nil],
+		MongoStatus:     mongoStateText(currentMongoStatus().State),
 		ServerPort:      AppConfig.ServerPort,
 	}
 
@@ -205,83 +206,157 @@ func handleSensorData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 读取请求体
-	body, err := io.ReadAll(r.Body)
+	// 按来源IP限流，在读取请求体之前拒绝
+	if ingestRateLimiter != nil {
+		if allowed, wait := ingestRateLimiter.AllowIP(r.RemoteAddr); !allowed {
+			writeRateLimited(w, "ip", wait)
+			Logger.Warn("请求被限流",
+				slog.String("scope", "ip"),
+				slog.String("remote_addr", r.RemoteAddr))
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusTooManyRequests, time.Since(startTime))
+			return
+		}
+	}
+
+	// 启用签名校验时在读取请求体的同时计算摘要
+	var digest *bodyDigest
+	if AppConfig.SignatureMode != SignatureOff {
+		digest = newBodyDigest(r)
+	}
+
+	// 读取请求体（限制大小并按Content-Encoding解压）
+	bodyReader, err := newDecodedBodyReader(r)
 	if err != nil {
-		http.Error(w, "读取请求体失败", http.StatusBadRequest)
-		LogError("读取请求体", err, slog.String("remote_addr", r.RemoteAddr))
-		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+		status, message := http.StatusBadRequest, "解压请求体失败"
+		if errors.Is(err, errUnsupportedEncoding) {
+			status, message = http.StatusUnsupportedMediaType, "不支持的内容编码"
+		}
+		http.Error(w, message, status)
+		LogError("解压请求体", err,
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("content_encoding", r.Header.Get("Content-Encoding")))
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, status, time.Since(startTime))
 		return
 	}
+	defer bodyReader.Close()
+
+	// 流式解码传感器数据；启用文件日志时同时保留解压后的JSON用于落盘
+	var rawBody bytes.Buffer
+	var source io.Reader = bodyReader
+	if AppConfig.EnableFileLog {
+		source = io.TeeReader(bodyReader, &rawBody)
+	}
 
-	// 解析传感器数据
-	parsedData, err := parseSensorMessage(body)
+	message, err := decodeSensorMessage(source, AppConfig.MaxReadingsPerMessage)
 	if err != nil {
+		var tooLarge *BodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, newLimitErrorResponse(tooLarge))
+			LogError("解析传感器数据", err,
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("limit", tooLarge.Limit))
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusRequestEntityTooLarge, time.Since(startTime))
+			return
+		}
+
 		http.Error(w, "解析传感器数据失败", http.StatusBadRequest)
 		LogError("解析传感器数据", err, slog.String("remote_addr", r.RemoteAddr))
 		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
 		return
 	}
 
-	// 记录传感器数据接收日志
-	LogSensorData(parsedData.MessageID, parsedData.DeviceID, parsedData.SessionID, parsedData.TotalReadings)
-
-	// 保存到MongoDB
-	if mongoClient != nil {
-		dbStart := time.Now()
-		if err := SaveSensorData(parsedData); err != nil {
-			LogDatabaseOperation("save_sensor_messages", false, parsedData.TotalReadings, time.Since(dbStart))
-			LogError("保存到MongoDB", err,
-				slog.String("device_id", parsedData.DeviceID),
-				slog.Int64("message_id", parsedData.MessageID))
-		} else {
-			LogDatabaseOperation("save_sensor_messages", true, parsedData.TotalReadings, time.Since(dbStart))
+	// 校验请求签名（设备配置了签名密钥时）
+	if digest != nil {
+		if err := checkRequestSignature(r, message.DeviceID, digest); err != nil {
+			w.Header().Set("WWW-Authenticate", `HMAC realm="ingest"`)
+			writeJSON(w, http.StatusUnauthorized, SignatureErrorResponse{Error: "invalid_signature", Message: err.Error()})
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusUnauthorized, time.Since(startTime))
+			return
 		}
 	}
 
-	// 存储解析后的数据到内存（用于快速访问）
-	parsedDataStore.Add(*parsedData)
-
-	// 只保留最近的配置数量条记录
-	parsedDataStore.TrimToSize(AppConfig.MaxDataStore)
-
-	// 保存原始数据到文件
-	if AppConfig.EnableFileLog {
-		if err := saveToFile(body, parsedData.ReceivedAt); err != nil {
-			LogError("保存文件", err, slog.String("device_id", parsedData.DeviceID))
+	// 按设备限流，在校验和写入之前拒绝；已认证的请求按令牌绑定的设备计数，
+	// 避免未经校验的deviceId绕过限流或耗尽其他设备的配额
+	if ingestRateLimiter != nil {
+		deviceID := message.DeviceID
+		if token, ok := authenticatedDeviceToken(r.Context()); ok {
+			deviceID = token.DeviceID
+		}
+		if allowed, wait := ingestRateLimiter.AllowDevice(deviceID); !allowed {
+			writeRateLimited(w, "device", wait)
+			Logger.Warn("请求被限流",
+				slog.String("scope", "device"),
+				slog.String("device_id", deviceID),
+				slog.String("remote_addr", r.RemoteAddr))
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusTooManyRequests, time.Since(startTime))
+			return
 		}
 	}
 
-	// 显示解析结果
-	if AppConfig.EnableLogging {
-		displayParsedData(parsedData)
+	// 校验、去重并持久化；未启用流水线时同步处理
+	outcome := ingestSensorMessage(r.Context(), message, rawBody.Bytes(), ingestOptions{
+		Validation:  currentValidationOptions(),
+		UsePipeline: ingestPipeline != nil,
+	})
+
+	if outcome.Status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", strconv.Itoa(AppConfig.IngestRetryAfter))
+	}
+	switch {
+	case outcome.Rejection != nil:
+		writeJSON(w, outcome.Status, outcome.Rejection)
+	case outcome.Ack != nil:
+		writeAck(w, r, outcome.Status, *outcome.Ack)
+	case outcome.Status == 0:
+		// 客户端已断开，无需响应
+	default:
+		http.Error(w, outcome.Error, outcome.Status)
 	}
 
-	// 响应成功
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("数据接收成功"))
+	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, outcome.Status, time.Since(startTime))
+}
 
-	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
+// LimitErrorResponse 请求超出限制时的错误响应
+type LimitErrorResponse struct {
+	Error   string `json:"error"`
+	Limit   string `json:"limit"`
+	Max     int64  `json:"max"`
+	Message string `json:"message"`
 }
 
-// saveToFile 保存原始数据到文件
-func saveToFile(data []byte, timestamp time.Time) error {
-	// 确保数据目录存在
-	if err := os.MkdirAll(AppConfig.DataDir, 0755); err != nil {
-		return fmt.Errorf("创建数据目录失败: %v", err)
+// newLimitErrorResponse 根据超限错误生成响应
+func newLimitErrorResponse(err *BodyTooLargeError) LimitErrorResponse {
+	var message string
+	switch err.Limit {
+	case "max_body_bytes":
+		message = fmt.Sprintf("请求体超过%d字节", err.Max)
+	case "max_decompressed_bytes":
+		message = fmt.Sprintf("解压后的请求体超过%d字节", err.Max)
+	case "bulk_max_body_bytes":
+		message = fmt.Sprintf("批量导入请求体超过%d字节", err.Max)
+	case "bulk_max_decompressed_bytes":
+		message = fmt.Sprintf("解压后的批量导入请求体超过%d字节", err.Max)
+	case "max_readings_per_message":
+		message = fmt.Sprintf("单条消息的读数超过%d条", err.Max)
+	default:
+		message = err.Error()
 	}
 
-	// 生成文件名
-	filename := fmt.Sprintf("sensor_messages_%s.json", timestamp.Format("20060102_150405"))
-	filepath := fmt.Sprintf("%s/%s", AppConfig.DataDir, filename)
-
-	// 写入文件
-	if err := os.WriteFile(filepath, data, 0644); err != nil {
-		return fmt.Errorf("写入文件失败: %v", err)
+	return LimitErrorResponse{
+		Error:   "payload_too_large",
+		Limit:   err.Limit,
+		Max:     err.Max,
+		Message: message,
 	}
+}
 
-	Logger.Debug("数据已保存到文件", slog.String("filepath", filepath))
-	return nil
+// writeJSON 写入JSON响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		LogError("JSON响应编码", err)
+	}
 }
 
 // displayParsedData 显示解析后的数据
@@ -298,10 +373,9 @@ func displayParsedData(data *ParsedSensorData) {
 		fmt.Printf("传感器类型: %s\n", strings.Join(data.SensorTypes, ", "))
 	}
 
-	// 显示文件保存信息
-	if AppConfig.EnableFileLog {
-		filename := fmt.Sprintf("sensor_messages_%s.json", data.ReceivedAt.Format("20060102_150405"))
-		fmt.Printf("数据已保存到文件: %s/%s\n", AppConfig.DataDir, filename)
+	// 显示归档位置
+	if AppConfig.EnableFileLog && rawArchive != nil {
+		fmt.Printf("原始数据已归档: %s\n", rawArchive.opts.Dir)
 	}
 
 	fmt.Println("========================")
@@ -419,11 +493,27 @@ func handleDashboard(w http.ResponseWriter, r *http.Request) {
         .back-link:hover {
             text-decoration: underline;
         }
+        .user-info {
+            float: right;
+            color: #666;
+        }
+        .user-info button {
+            background: none;
+            border: none;
+            color: #4CAF50;
+            cursor: pointer;
+            font-weight: bold;
+        }
     </style>
 </head>
 <body>
     <div class="container">
         <a href="/" class="back-link">← 返回首页</a>
+        {{if .Username}}
+        <form class="user-info" method="POST" action="/logout">
+            👤 {{.Username}} <button type="submit">退出登录</button>
+        </form>
+        {{end}}
         <h1>📊 传感器数据仪表板</h1>
         
         <div class="stats">
@@ -452,6 +542,8 @@ func handleDashboard(w http.ResponseWriter, r *http.Request) {
                 <div class="sensor-data">
                     <div class="sensor-header">
                         {{.SensorType}} - {{.ReadableTime}} ({{.Accuracy}})
+                        {{with .Orientation}}{{if .Unnormalized}}⚠️ 四元数未归一化（模为 {{printf "%.4f" .Norm}}）{{end}}{{end}}
+                        {{if .LowAccuracy}}⚠️ 低精度定位{{end}}
                     </div>
                     <div class="sensor-values">
                         {{range .Values}}
@@ -484,8 +576,18 @@ func handleDashboard(w http.ResponseWriter, r *http.Request) {
 </html>
 `
 
+	angleUnit, err := requestAngleUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+		return
+	}
+
 	// 准备仪表板数据
-	dashboardData := prepareDashboardData()
+	dashboardData := prepareDashboardData(requestDeviceScope(r), angleUnit)
+	if principal, ok := requestPrincipal(r); ok {
+		dashboardData.Username = principal.Username
+	}
 
 	tmpl, err := template.New("dashboard").Parse(html)
 	if err != nil {
@@ -505,14 +607,14 @@ func handleDashboard(w http.ResponseWriter, r *http.Request) {
 	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
 }
 
-// prepareDashboardData 准备仪表板数据
-func prepareDashboardData() DashboardData {
+// prepareDashboardData 准备仪表板数据（只统计可见设备的数据，方向角按angleUnit显示）
+func prepareDashboardData(scope DeviceScope, angleUnit string) DashboardData {
 	data := DashboardData{
-		TotalMessages:   parsedDataStore.Len(),
+		TotalMessages:   0,
 		TotalReadings:   0,
 		SensorTypeCount: 0,
 		DeviceCount:     0,
-		HasData:         !parsedDataStore.IsEmpty(),
+		HasData:         false,
 		LatestData:      []HumanReadableSensorData{},
 	}
 
@@ -521,13 +623,19 @@ func prepareDashboardData() DashboardData {
 	}
 
 	// 获取所有数据用于计算统计信息
-	allData := parsedDataStore.GetAllForRead()
+	allData := parsedDataStore.Get()
 
 	// 计算统计信息
 	sensorTypes := make(map[string]bool)
 	devices := make(map[string]bool)
 
-	for _, parsedData := range allData {
+	var latestData *ParsedSensorData
+	for i, parsedData := range allData {
+		if !scope.Allows(parsedData.DeviceID) {
+			continue
+		}
+		latestData = &allData[i]
+		data.TotalMessages++
 		data.TotalReadings += parsedData.TotalReadings
 		devices[parsedData.DeviceID] = true
 
@@ -539,13 +647,15 @@ func prepareDashboardData() DashboardData {
 	data.SensorTypeCount = len(sensorTypes)
 	data.DeviceCount = len(devices)
 
+	data.HasData = data.TotalMessages > 0
+
 	// 获取最新数据的前20条读数
-	if latestData, exists := parsedDataStore.GetLatestOne(); exists {
+	if latestData != nil {
 		maxReadings := 20
 		if len(latestData.ParsedReadings) < maxReadings {
 			maxReadings = len(latestData.ParsedReadings)
 		}
-		data.LatestData = latestData.ParsedReadings[:maxReadings]
+		data.LatestData = presentReadings(latestData.ParsedReadings[:maxReadings], angleUnit)
 	}
 
 	return data
@@ -555,9 +665,24 @@ func prepareDashboardData() DashboardData {
 func handleAPIData(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	setCORSHeaders(w, r)
 
-	data := parsedDataStore.Get()
+	angleUnit, err := requestAngleUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+		return
+	}
+
+	// 只返回可见设备的数据
+	scope := requestDeviceScope(r)
+	data := make([]ParsedSensorData, 0)
+	for _, parsedData := range parsedDataStore.Get() {
+		if scope.Allows(parsedData.DeviceID) {
+			parsedData.ParsedReadings = presentReadings(parsedData.ParsedReadings, angleUnit)
+			data = append(data, parsedData)
+		}
+	}
 
 	if err := json.NewEncoder(w).Encode(data); err != nil {
 		LogError("API数据编码", err)
@@ -573,7 +698,7 @@ func handleAPIData(w http.ResponseWriter, r *http.Request) {
 func handleDBData(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	setCORSHeaders(w, r)
 
 	// 获取查询参数
 	query := r.URL.Query()
@@ -586,10 +711,30 @@ func handleDBData(w http.ResponseWriter, r *http.Request) {
 
 	deviceID := query.Get("device")
 	sensorType := query.Get("sensor")
+	angleUnit, err := requestAngleUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+		return
+	}
+
+	scope := requestDeviceScope(r)
+	if deviceID != "" && !scope.Allows(deviceID) {
+		http.Error(w, "无权查看该设备的数据", http.StatusForbidden)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusForbidden, time.Since(startTime))
+		return
+	}
+
+	// 数据库不可用时直接返回，不等待查询超时
+	if !mongoAvailable() {
+		http.Error(w, "数据库不可用", http.StatusServiceUnavailable)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusServiceUnavailable, time.Since(startTime))
+		return
+	}
 
 	// 从数据库获取数据
 	dbStart := time.Now()
-	data, err := GetSensorDataFromDB(limit, deviceID, sensorType)
+	data, err := GetSensorDataFromDB(limit, deviceID, sensorType, scope)
 	if err != nil {
 		LogDatabaseOperation("get_sensor_messages", false, 0, time.Since(dbStart))
 		LogError("数据库查询", err,
@@ -602,6 +747,9 @@ func handleDBData(w http.ResponseWriter, r *http.Request) {
 	}
 
 	LogDatabaseOperation("get_sensor_messages", true, len(data), time.Since(dbStart))
+	for i := range data {
+		data[i].ParsedReadings = presentReadings(data[i].ParsedReadings, angleUnit)
+	}
 
 	if err := json.NewEncoder(w).Encode(data); err != nil {
 		LogError("数据库API编码", err)
@@ -613,14 +761,164 @@ func handleDBData(w http.ResponseWriter, r *http.Request) {
 	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
 }
 
+// handleDBMarkers 处理会话标记查询请求（按时间顺序返回标注事件）
+func handleDBMarkers(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	setCORSHeaders(w, r)
+
+	query := r.URL.Query()
+	limit := 100 // 默认限制
+	if l := query.Get("limit"); l != "" {
+		if parsedLimit, err := strconv.Atoi(l); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	sessionID := query.Get("session")
+	deviceID := query.Get("device")
+
+	scope := requestDeviceScope(r)
+	if deviceID != "" && !scope.Allows(deviceID) {
+		http.Error(w, "无权查看该设备的数据", http.StatusForbidden)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusForbidden, time.Since(startTime))
+		return
+	}
+
+	// 数据库不可用时直接返回，不等待查询超时
+	if !mongoAvailable() {
+		http.Error(w, "数据库不可用", http.StatusServiceUnavailable)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusServiceUnavailable, time.Since(startTime))
+		return
+	}
+
+	dbStart := time.Now()
+	markers, err := GetSessionMarkers(sessionID, deviceID, limit, scope)
+	if err != nil {
+		LogDatabaseOperation("get_session_markers", false, 0, time.Since(dbStart))
+		LogError("数据库查询", err,
+			slog.String("session", sessionID),
+			slog.String("device", deviceID),
+			slog.Int("limit", limit))
+		http.Error(w, "数据库查询失败", http.StatusInternalServerError)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusInternalServerError, time.Since(startTime))
+		return
+	}
+
+	LogDatabaseOperation("get_session_markers", true, len(markers), time.Since(dbStart))
+
+	if err := json.NewEncoder(w).Encode(markers); err != nil {
+		LogError("数据库API编码", err)
+		http.Error(w, "数据编码失败", http.StatusInternalServerError)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusInternalServerError, time.Since(startTime))
+		return
+	}
+
+	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
+}
+
+// handleDBLocations 处理定位查询请求（包括各项精度）
+func handleDBLocations(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	setCORSHeaders(w, r)
+
+	query := r.URL.Query()
+	limit := 1000 // 默认限制
+	if l := query.Get("limit"); l != "" {
+		if parsedLimit, err := strconv.Atoi(l); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	// drop模式下默认不返回低精度定位，maxAccuracy可以指定其他阈值
+	maxAccuracy := 0.0
+	if AppConfig.LocationAccuracyMode == LocationAccuracyDrop {
+		maxAccuracy = AppConfig.LocationMaxHorizontalAccuracy
+	}
+	if m := query.Get("maxAccuracy"); m != "" {
+		parsed, err := strconv.ParseFloat(m, 64)
+		if err != nil || parsed < 0 {
+			http.Error(w, "maxAccuracy必须是非负数", http.StatusBadRequest)
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+			return
+		}
+		maxAccuracy = parsed
+	}
+
+	// 必须指定会话或起始时间，避免展开整个集合中的消息
+	sessionID := query.Get("session")
+	deviceID := query.Get("device")
+	from, err := parseTimeFilter(query.Get("from"))
+	var to time.Time
+	if err == nil {
+		to, err = parseTimeFilter(query.Get("to"))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+		return
+	}
+	if sessionID == "" && from.IsZero() {
+		http.Error(w, "请指定session或from", http.StatusBadRequest)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+		return
+	}
+
+	scope := requestDeviceScope(r)
+	if deviceID != "" && !scope.Allows(deviceID) {
+		http.Error(w, "无权查看该设备的数据", http.StatusForbidden)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusForbidden, time.Since(startTime))
+		return
+	}
+
+	// 数据库不可用时直接返回，不等待查询超时
+	if !mongoAvailable() {
+		http.Error(w, "数据库不可用", http.StatusServiceUnavailable)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusServiceUnavailable, time.Since(startTime))
+		return
+	}
+
+	dbStart := time.Now()
+	fixes, err := GetLocationFixes(sessionID, deviceID, from, to, maxAccuracy, limit, scope)
+	if err != nil {
+		LogDatabaseOperation("get_location_fixes", false, 0, time.Since(dbStart))
+		LogError("数据库查询", err,
+			slog.String("session", sessionID),
+			slog.String("device", deviceID),
+			slog.Int("limit", limit))
+		http.Error(w, "数据库查询失败", http.StatusInternalServerError)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusInternalServerError, time.Since(startTime))
+		return
+	}
+
+	LogDatabaseOperation("get_location_fixes", true, len(fixes), time.Since(dbStart))
+
+	if err := json.NewEncoder(w).Encode(fixes); err != nil {
+		LogError("数据库API编码", err)
+		http.Error(w, "数据编码失败", http.StatusInternalServerError)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusInternalServerError, time.Since(startTime))
+		return
+	}
+
+	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
+}
+
 // handleDeviceInfo 处理设备信息请求
 func handleDeviceInfo(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	setCORSHeaders(w, r)
+
+	// 数据库不可用时直接返回，不等待查询超时
+	if !mongoAvailable() {
+		http.Error(w, "数据库不可用", http.StatusServiceUnavailable)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusServiceUnavailable, time.Since(startTime))
+		return
+	}
 
 	dbStart := time.Now()
-	devices, err := GetDeviceInfo()
+	devices, err := GetDeviceInfo(requestDeviceScope(r))
 	if err != nil {
 		LogDatabaseOperation("get_device_info", false, 0, time.Since(dbStart))
 		LogError("设备信息查询", err)
@@ -631,6 +929,14 @@ func handleDeviceInfo(w http.ResponseWriter, r *http.Request) {
 
 	LogDatabaseOperation("get_device_info", true, len(devices), time.Since(dbStart))
 
+	// 补充内存中的限流统计
+	if ingestRateLimiter != nil {
+		for i := range devices {
+			throttle := ingestRateLimiter.DeviceThrottleInfo(devices[i].DeviceID)
+			devices[i].ThrottledRequests, devices[i].LastThrottledAt = throttle.Requests, throttle.LastThrottledAt
+		}
+	}
+
 	if err := json.NewEncoder(w).Encode(devices); err != nil {
 		LogError("设备信息API编码", err)
 		http.Error(w, "数据编码失败", http.StatusInternalServerError)
@@ -645,10 +951,17 @@ func handleDeviceInfo(w http.ResponseWriter, r *http.Request) {
 func handleDBStats(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	setCORSHeaders(w, r)
+
+	// 数据库不可用时直接返回，不等待查询超时
+	if !mongoAvailable() {
+		http.Error(w, "数据库不可用", http.StatusServiceUnavailable)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusServiceUnavailable, time.Since(startTime))
+		return
+	}
 
 	dbStart := time.Now()
-	stats, err := GetDashboardStats()
+	stats, err := GetDashboardStats(requestDeviceScope(r))
 	if err != nil {
 		LogDatabaseOperation("get_dashboard_stats", false, 0, time.Since(dbStart))
 		LogError("统计信息查询", err)
@@ -668,3 +981,66 @@ func handleDBStats(w http.ResponseWriter, r *http.Request) {
 
 	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
 }
+
+// handleDBStatus 处理数据库连接状态请求
+func handleDBStatus(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	setCORSHeaders(w, r)
+
+	if err := json.NewEncoder(w).Encode(currentMongoStatus()); err != nil {
+		LogError("数据库状态API编码", err)
+		http.Error(w, "数据编码失败", http.StatusInternalServerError)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusInternalServerError, time.Since(startTime))
+		return
+	}
+
+	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
+}
+
+// mongoStateText 连接状态的中文描述
+func mongoStateText(state MongoState) string {
+	switch state {
+	case MongoStateConnected:
+		return "已连接"
+	case MongoStateDegraded:
+		return "降级"
+	default:
+		return "未连接"
+	}
+}
+
+// handleIngestStats 处理入库流水线状态请求
+func handleIngestStats(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	setCORSHeaders(w, r)
+
+	response := map[string]interface{}{
+		"enabled": ingestPipeline != nil,
+	}
+	if ingestPipeline != nil {
+		response["stats"] = ingestPipeline.Stats()
+	}
+	if bulkWriter != nil {
+		response["bulkWriter"] = bulkWriter.Stats()
+	}
+	if messageSpool != nil {
+		response["spool"] = messageSpool.Stats()
+	}
+	if rawArchive != nil {
+		response["archive"] = rawArchive.Stats()
+	}
+	if ingestRateLimiter != nil {
+		response["rateLimit"] = ingestRateLimiter.Stats()
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		LogError("入库状态API编码", err)
+		http.Error(w, "数据编码失败", http.StatusInternalServerError)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusInternalServerError, time.Since(startTime))
+		return
+	}
+
+	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
+}
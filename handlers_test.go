@@ -9,6 +9,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 // 初始化测试环境
@@ -21,6 +22,13 @@ func init() {
 		EnableLogging: true,
 		LogLevel:      "info",
 		Environment:   "dev",
+
+		MaxBodyBytes:             16 << 20,
+		MaxDecompressedBytes:     64 << 20,
+		MaxReadingsPerMessage:    50000,
+		BulkMaxBodyBytes:         256 << 20,
+		BulkMaxDecompressedBytes: 1 << 30,
+		DurabilityMode:           DurabilityBestEffort,
 	}
 
 	// 初始化Logger
@@ -37,6 +45,19 @@ func init() {
 
 	// 确保测试数据目录存在
 	os.MkdirAll(AppConfig.DataDir, 0755)
+
+	// 原始数据归档（不压缩，测试中不启动后台协程）
+	archive, err := NewArchive(ArchiveOptions{
+		Dir:             AppConfig.DataDir + "/archive",
+		MaxSegmentBytes: 64 << 20,
+		MaxSegmentAge:   time.Hour,
+		Compression:     ArchiveCompressNone,
+		Fsync:           ArchiveFsyncNever,
+	})
+	if err != nil {
+		panic("初始化原始数据归档失败: " + err.Error())
+	}
+	rawArchive = archive
 }
 
 // TestHandleSensorData 测试传感器数据接收处理程序
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errIngestQueueFull = errors.New("入库队列已满")
+	errIngestClosed    = errors.New("入库流水线已关闭")
+)
+
+// 全局入库流水线（为nil时在请求内同步入库）
+var ingestPipeline *IngestPipeline
+
+// IngestJob 待持久化的传感器消息
+type IngestJob struct {
+	IngestID   string
+	Parsed     *ParsedSensorData
+	RawBody    []byte
+	EnqueuedAt time.Time
+
+	// Done 不为nil时，处理完成后发送持久化结果（需带缓冲，工作协程不会阻塞等待）
+	Done chan IngestResult
+}
+
+// IngestResult 消息的持久化结果
+type IngestResult struct {
+	Sinks SinkResults
+	Err   error
+}
+
+// IngestStats 入库流水线统计信息
+type IngestStats struct {
+	QueueDepth      int     `json:"queueDepth"`
+	QueueCapacity   int     `json:"queueCapacity"`
+	Workers         int     `json:"workers"`
+	BusyWorkers     int64   `json:"busyWorkers"`
+	Utilization     float64 `json:"utilization"`
+	Enqueued        int64   `json:"enqueued"`
+	Processed       int64   `json:"processed"`
+	Failed          int64   `json:"failed"`
+	Duplicates      int64   `json:"duplicates"`
+	Rejected        int64   `json:"rejected"`
+	AvgQueueWaitMs  float64 `json:"avgQueueWaitMs"`
+	AvgProcessingMs float64 `json:"avgProcessingMs"`
+}
+
+// IngestPipeline 异步入库流水线：有界队列 + 固定数量的工作协程
+type IngestPipeline struct {
+	queue   chan *IngestJob
+	workers int
+	wg      sync.WaitGroup
+
+	mutex  sync.RWMutex
+	closed bool
+
+	busy         atomic.Int64
+	enqueued     atomic.Int64
+	processed    atomic.Int64
+	failed       atomic.Int64
+	duplicates   atomic.Int64
+	rejected     atomic.Int64
+	queueWaitNs  atomic.Int64
+	processingNs atomic.Int64
+}
+
+// NewIngestPipeline 创建入库流水线
+func NewIngestPipeline(workers, queueSize int) *IngestPipeline {
+	return &IngestPipeline{
+		queue:   make(chan *IngestJob, queueSize),
+		workers: workers,
+	}
+}
+
+// Start 启动工作协程
+func (p *IngestPipeline) Start() {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.worker(i)
+	}
+	Logger.Info("入库流水线已启动",
+		slog.Int("workers", p.workers),
+		slog.Int("queue_size", cap(p.queue)))
+}
+
+// Enqueue 将消息放入队列，队列已满时立即返回错误而不阻塞
+func (p *IngestPipeline) Enqueue(job *IngestJob) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.closed {
+		return errIngestClosed
+	}
+
+	job.EnqueuedAt = time.Now()
+	select {
+	case p.queue <- job:
+		p.enqueued.Add(1)
+		return nil
+	default:
+		p.rejected.Add(1)
+		return errIngestQueueFull
+	}
+}
+
+// Close 停止接收新消息，并等待队列中已有的消息处理完毕；期间有消息持久化失败时返回错误
+func (p *IngestPipeline) Close() error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.queue)
+	failedBefore := p.failed.Load()
+	p.mutex.Unlock()
+
+	p.wg.Wait()
+	Logger.Info("入库流水线已关闭", slog.Int64("processed", p.processed.Load()))
+	if failed := p.failed.Load() - failedBefore; failed > 0 {
+		return fmt.Errorf("排空队列时有%d条消息持久化失败", failed)
+	}
+	return nil
+}
+
+// Stats 获取统计信息
+func (p *IngestPipeline) Stats() IngestStats {
+	stats := IngestStats{
+		QueueDepth:    len(p.queue),
+		QueueCapacity: cap(p.queue),
+		Workers:       p.workers,
+		BusyWorkers:   p.busy.Load(),
+		Enqueued:      p.enqueued.Load(),
+		Processed:     p.processed.Load(),
+		Failed:        p.failed.Load(),
+		Duplicates:    p.duplicates.Load(),
+		Rejected:      p.rejected.Load(),
+	}
+
+	if p.workers > 0 {
+		stats.Utilization = float64(stats.BusyWorkers) / float64(p.workers)
+	}
+	if stats.Processed > 0 {
+		stats.AvgQueueWaitMs = float64(p.queueWaitNs.Load()) / float64(stats.Processed) / 1e6
+		stats.AvgProcessingMs = float64(p.processingNs.Load()) / float64(stats.Processed) / 1e6
+	}
+	return stats
+}
+
+// worker 工作协程：从队列取出消息并持久化
+func (p *IngestPipeline) worker(id int) {
+	defer p.wg.Done()
+
+	for job := range p.queue {
+		p.busy.Add(1)
+		start := time.Now()
+		p.queueWaitNs.Add(int64(start.Sub(job.EnqueuedAt)))
+
+		sinks, err := persistSensorData(job.Parsed, job.RawBody)
+		if job.Done != nil {
+			job.Done <- IngestResult{Sinks: sinks, Err: err}
+		}
+
+		if errors.Is(err, errDuplicateMessage) {
+			p.duplicates.Add(1)
+		} else if err != nil {
+			p.failed.Add(1)
+			Logger.Warn("消息持久化未完全成功",
+				slog.Int("worker", id),
+				slog.String("ingest_id", job.IngestID),
+				slog.String("device_id", job.Parsed.DeviceID),
+				slog.Int64("message_id", job.Parsed.MessageID),
+				slog.String("error", err.Error()))
+		}
+
+		p.processingNs.Add(int64(time.Since(start)))
+		p.processed.Add(1)
+		p.busy.Add(-1)
+	}
+}
+
+// persistSensorData 将解析后的消息写入MongoDB、原始数据归档和内存存储，返回各存储的写入结果
+// 任一存储失败都会记录日志，返回遇到的第一个错误；MongoDB判定为重复时不再写入其他存储。
+// 启用批量写入时MongoDB写入异步完成，结果在回调中处理；MongoDB未确认的消息写入写前缓冲。
+// 不满足持久化要求时（客户端会重试）不写入归档和内存，避免重试时重复写入
+func persistSensorData(parsedData *ParsedSensorData, body []byte) (SinkResults, error) {
+	var firstErr error
+	sinks := SinkResults{Memory: SinkSkipped, Mongo: SinkFailed, File: SinkDisabled}
+
+	// 保存到MongoDB；MongoDB不可用或该会话仍有待回放的缓冲消息时写入缓冲（保持会话内顺序）
+	if !mongoAvailable() || (messageSpool != nil && messageSpool.HasPending(parsedData.SessionID)) {
+		if messageSpool != nil {
+			if firstErr = spoolSensorData(parsedData); firstErr == nil {
+				sinks.Mongo = SinkSpooled
+			}
+		}
+	} else if bulkWriter != nil && !durabilityRequiresResult(AppConfig.DurabilityMode) {
+		sinks.Mongo = SinkQueued
+		bulkWriter.Add(parsedData, func(err error) {
+			handleMongoWriteResult(parsedData, err)
+		})
+	} else {
+		// 需要向客户端报告写入结果时，批量写入也要等待所在批次完成
+		dbStart := time.Now()
+		var err error
+		if bulkWriter != nil {
+			err = bulkWriter.Write(parsedData)
+		} else {
+			err = SaveSensorData(parsedData)
+		}
+
+		if errors.Is(err, errDuplicateMessage) {
+			// 与其他请求并发写入同一消息时，由唯一索引兜底识别
+			LogDatabaseOperation("save_sensor_messages", true, 0, time.Since(dbStart))
+			recordDuplicate(parsedData)
+			return SinkResults{Memory: SinkSkipped, Mongo: SinkSkipped, File: SinkSkipped}, errDuplicateMessage
+		} else if err != nil {
+			LogDatabaseOperation("save_sensor_messages", false, parsedData.TotalReadings, time.Since(dbStart))
+			LogError("保存到MongoDB", err,
+				slog.String("device_id", parsedData.DeviceID),
+				slog.Int64("message_id", parsedData.MessageID))
+			firstErr = err
+			if messageSpool != nil && spoolSensorData(parsedData) == nil {
+				// 已写入缓冲，稍后回放，不视为失败
+				firstErr = nil
+				sinks.Mongo = SinkSpooled
+			}
+		} else {
+			LogDatabaseOperation("save_sensor_messages", true, parsedData.TotalReadings, time.Since(dbStart))
+			sinks.Mongo = SinkOK
+		}
+	}
+
+	// require_db模式下MongoDB未接收时只有数据库能满足要求，不再写入其他存储
+	fileLog := AppConfig.EnableFileLog && rawArchive != nil
+	if AppConfig.DurabilityMode == DurabilityRequireDB && sinks.Mongo != SinkOK && sinks.Mongo != SinkSpooled {
+		if fileLog {
+			sinks.File = SinkSkipped
+		}
+		return sinks, firstErr
+	}
+
+	// 追加原始数据到归档（解压后的JSON，便于回放）
+	if fileLog {
+		sinks.File = SinkOK
+		if _, err := rawArchive.Append(parsedData, body); err != nil {
+			LogError("写入原始数据归档", err,
+				slog.String("device_id", parsedData.DeviceID),
+				slog.String("session_id", parsedData.SessionID),
+				slog.Int64("message_id", parsedData.MessageID))
+			sinks.File = SinkFailed
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	// 没有任何存储满足持久化要求时，消息不会被接收
+	if accepted, _ := evaluateDurability(AppConfig.DurabilityMode, sinks); !accepted {
+		return sinks, firstErr
+	}
+
+	// 存储解析后的数据到内存（用于快速访问）
+	sinks.Memory = SinkOK
+	parsedDataStore.Add(*parsedData)
+
+	// 只保留最近的配置数量条记录
+	parsedDataStore.TrimToSize(AppConfig.MaxDataStore)
+
+	// 显示解析结果
+	if AppConfig.EnableLogging {
+		displayParsedData(parsedData)
+	}
+
+	return sinks, firstErr
+}
+
+// handleMongoWriteResult 处理批量写入MongoDB的结果
+func handleMongoWriteResult(parsedData *ParsedSensorData, err error) {
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, errDuplicateMessage):
+		recordDuplicate(parsedData)
+	default:
+		LogError("保存到MongoDB", err,
+			slog.String("device_id", parsedData.DeviceID),
+			slog.Int64("message_id", parsedData.MessageID))
+		if messageSpool != nil {
+			spoolSensorData(parsedData)
+		}
+	}
+}
+
+// spoolSensorData 将MongoDB未确认的消息写入写前缓冲
+func spoolSensorData(parsedData *ParsedSensorData) error {
+	if err := messageSpool.Append(parsedData); err != nil {
+		LogError("写入缓冲", err,
+			slog.String("device_id", parsedData.DeviceID),
+			slog.String("session_id", parsedData.SessionID),
+			slog.Int64("message_id", parsedData.MessageID))
+		return err
+	}
+	return nil
+}
+
+// ingestOptions 单条消息的入库选项
+type ingestOptions struct {
+	Validation  ValidationOptions
+	UsePipeline bool // 交给入库流水线处理，否则在当前协程同步写入
+}
+
+// ingestOutcome 单条消息的处理结果，Status为0表示客户端已断开
+type ingestOutcome struct {
+	Status    int
+	Ack       *IngestAck               // 已接收（含重复消息）或持久化未满足要求时的确认
+	Rejection *ValidationErrorResponse // 未通过校验时的响应
+	Error     string                   // 其他错误的说明
+}
+
+// ingestSensorMessage 校验、去重并持久化一条已解码的消息，/data 和批量导入共用
+func ingestSensorMessage(ctx context.Context, message *SensorMessage, body []byte, opts ingestOptions) ingestOutcome {
+	// 使用设备令牌时，消息只能属于令牌绑定的设备
+	if err := checkDeviceBinding(ctx, message.DeviceID); err != nil {
+		return ingestOutcome{Status: http.StatusForbidden, Error: err.Error()}
+	}
+
+	// 按配置的模式校验消息：reject模式拒绝不合规的消息，strip模式丢弃不合规的读数
+	receivedAt := time.Now()
+	report := validateSensorMessage(message, receivedAt, opts.Validation)
+	report.LogViolations(message)
+	if report.Rejects() {
+		return ingestOutcome{
+			Status: http.StatusUnprocessableEntity,
+			Rejection: &ValidationErrorResponse{
+				Error:   "validation_failed",
+				Message: "传感器数据校验失败",
+				Report:  *report,
+			},
+		}
+	}
+	rejectedReadings := report.Apply(message)
+	if rejectedReadings > 0 && body != nil {
+		// 归档丢弃违规读数后的消息，避免回放时重新写入被丢弃的读数
+		stripped, err := json.Marshal(message)
+		if err != nil {
+			LogError("编码丢弃违规读数后的消息", err,
+				slog.String("device_id", message.DeviceID),
+				slog.Int64("message_id", message.MessageID))
+		} else {
+			body = stripped
+		}
+	}
+
+	parsedData := buildParsedData(message)
+	parsedData.ReceivedAt = receivedAt
+
+	// 记录传感器数据接收日志
+	LogSensorData(parsedData.MessageID, parsedData.DeviceID, parsedData.SessionID, parsedData.TotalReadings)
+
+	ingestID := newIngestID()
+
+	// 重复消息在产生任何副作用之前识别，直接确认以免客户端继续重试
+	if checkDuplicate(parsedData) {
+		recordDuplicate(parsedData)
+		ack := newDuplicateAck(ingestID, parsedData)
+		return ingestOutcome{Status: http.StatusOK, Ack: &ack}
+	}
+
+	// 交给入库流水线异步持久化，或同步处理。
+	// 持久化要求需要实际写入结果时，等待工作协程处理完成再响应
+	waitForResult := durabilityRequiresResult(AppConfig.DurabilityMode)
+	var sinks SinkResults
+	var persistErr error
+	if opts.UsePipeline && ingestPipeline != nil {
+		job := &IngestJob{IngestID: ingestID, Parsed: parsedData, RawBody: body}
+		if waitForResult {
+			job.Done = make(chan IngestResult, 1)
+		}
+		if err := ingestPipeline.Enqueue(job); err != nil {
+			messageDeduplicator.Release(messageKeyOf(parsedData))
+			Logger.Warn("入库队列拒绝消息",
+				slog.String("ingest_id", ingestID),
+				slog.String("device_id", parsedData.DeviceID),
+				slog.Int64("message_id", parsedData.MessageID),
+				slog.String("reason", err.Error()))
+			return ingestOutcome{Status: http.StatusServiceUnavailable, Error: "服务器繁忙，请稍后重试"}
+		}
+
+		if waitForResult {
+			select {
+			case result := <-job.Done:
+				sinks, persistErr = result.Sinks, result.Err
+			case <-ctx.Done():
+				// 客户端已断开，消息仍会在后台写入
+				Logger.Warn("等待持久化结果时客户端断开",
+					slog.String("ingest_id", ingestID),
+					slog.String("device_id", parsedData.DeviceID),
+					slog.Int64("message_id", parsedData.MessageID))
+				return ingestOutcome{}
+			}
+		} else {
+			sinks = SinkResults{Memory: SinkQueued, Mongo: SinkQueued, File: SinkDisabled}
+			if AppConfig.EnableFileLog && rawArchive != nil {
+				sinks.File = SinkQueued
+			}
+		}
+	} else {
+		sinks, persistErr = persistSensorData(parsedData, body)
+	}
+
+	// 并发提交的同一消息由唯一索引识别为重复
+	if errors.Is(persistErr, errDuplicateMessage) {
+		ack := newDuplicateAck(ingestID, parsedData)
+		return ingestOutcome{Status: http.StatusOK, Ack: &ack}
+	}
+
+	// 按持久化要求判断是否需要让客户端重试
+	ack := newIngestAck(ingestID, parsedData, sinks)
+	ack.ReadingsRejected = rejectedReadings
+	if !report.Valid || report.TotalWarnings > 0 {
+		ack.Validation = report
+	}
+	accepted, reason := evaluateDurability(AppConfig.DurabilityMode, sinks)
+	LogDurabilityDecision(ingestID, AppConfig.DurabilityMode, accepted, sinks, reason)
+	if !accepted {
+		messageDeduplicator.Release(messageKeyOf(parsedData))
+		ack.Message = "数据持久化失败，请稍后重试: " + reason
+		return ingestOutcome{Status: http.StatusServiceUnavailable, Ack: &ack}
+	}
+
+	// require_db模式下已写入写前缓冲的消息会在数据库恢复后写入，返回202而不是让客户端重试
+	if AppConfig.DurabilityMode == DurabilityRequireDB && sinks.Mongo == SinkSpooled {
+		ack.Message = "数据已写入缓冲，将在数据库恢复后写入"
+		return ingestOutcome{Status: http.StatusAccepted, Ack: &ack}
+	}
+
+	return ingestOutcome{Status: http.StatusOK, Ack: &ack}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestIngestJob(messageID int64) *IngestJob {
+	return &IngestJob{
+		Parsed: &ParsedSensorData{
+			MessageID:     messageID,
+			SessionID:     "ingest-session",
+			DeviceID:      "ingest-device",
+			TotalReadings: 1,
+			ReceivedAt:    time.Now(),
+		},
+	}
+}
+
+func TestIngestPipelineProcessesAllJobs(t *testing.T) {
+	originalFileLog, originalLogging := AppConfig.EnableFileLog, AppConfig.EnableLogging
+	AppConfig.EnableFileLog, AppConfig.EnableLogging = false, false
+	defer func() { AppConfig.EnableFileLog, AppConfig.EnableLogging = originalFileLog, originalLogging }()
+
+	pipeline := NewIngestPipeline(3, 50)
+	pipeline.Start()
+
+	for i := 0; i < 20; i++ {
+		if err := pipeline.Enqueue(newTestIngestJob(int64(i))); err != nil {
+			t.Fatalf("入队失败: %v", err)
+		}
+	}
+
+	// Close会等待队列排空
+	pipeline.Close()
+
+	stats := pipeline.Stats()
+	if stats.Processed != 20 {
+		t.Errorf("期望处理20条消息，实际为%d", stats.Processed)
+	}
+	if stats.QueueDepth != 0 || stats.BusyWorkers != 0 {
+		t.Errorf("关闭后队列应为空且无忙碌协程: %+v", stats)
+	}
+
+	if err := pipeline.Enqueue(newTestIngestJob(99)); !errors.Is(err, errIngestClosed) {
+		t.Errorf("关闭后入队应返回errIngestClosed，实际为%v", err)
+	}
+}
+
+func TestIngestPipelineBackpressure(t *testing.T) {
+	// 未启动工作协程，队列不会被消费
+	pipeline := NewIngestPipeline(1, 2)
+
+	for i := 0; i < 2; i++ {
+		if err := pipeline.Enqueue(newTestIngestJob(int64(i))); err != nil {
+			t.Fatalf("入队失败: %v", err)
+		}
+	}
+	if err := pipeline.Enqueue(newTestIngestJob(3)); !errors.Is(err, errIngestQueueFull) {
+		t.Errorf("队列已满时应返回errIngestQueueFull，实际为%v", err)
+	}
+
+	stats := pipeline.Stats()
+	if stats.QueueDepth != 2 || stats.QueueCapacity != 2 || stats.Rejected != 1 {
+		t.Errorf("统计信息不正确: %+v", stats)
+	}
+}
+
+func TestHandleSensorDataQueueFull(t *testing.T) {
+	originalPipeline, originalRetry := ingestPipeline, AppConfig.IngestRetryAfter
+	defer func() { ingestPipeline, AppConfig.IngestRetryAfter = originalPipeline, originalRetry }()
+
+	ingestPipeline = NewIngestPipeline(1, 1)
+	ingestPipeline.Enqueue(newTestIngestJob(1))
+	AppConfig.IngestRetryAfter = 7
+
+	req := httptest.NewRequest("POST", "/data", bytes.NewReader(buildTestMessage(1)))
+	rr := httptest.NewRecorder()
+	handleSensorData(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("期望状态码503，实际为%d", rr.Code)
+	}
+	if retryAfter := rr.Header().Get("Retry-After"); retryAfter != "7" {
+		t.Errorf("期望Retry-After为7，实际为%q", retryAfter)
+	}
+}
+
+func TestHandleIngestStats(t *testing.T) {
+	originalPipeline := ingestPipeline
+	defer func() { ingestPipeline = originalPipeline }()
+
+	ingestPipeline = NewIngestPipeline(2, 10)
+	ingestPipeline.Enqueue(newTestIngestJob(1))
+
+	req := httptest.NewRequest("GET", "/api/ingest/stats", nil)
+	rr := httptest.NewRecorder()
+	handleIngestStats(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("期望状态码200，实际为%d", rr.Code)
+	}
+
+	var response struct {
+		Enabled bool        `json:"enabled"`
+		Stats   IngestStats `json:"stats"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("JSON解析失败: %v", err)
+	}
+	if !response.Enabled || response.Stats.QueueDepth != 1 || response.Stats.Workers != 2 {
+		t.Errorf("入库状态不正确: %+v", response)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// 水平精度超过阈值的定位的处理方式
+const (
+	LocationAccuracyFlag = "flag" // 保留并标记为低精度（默认）
+	LocationAccuracyDrop = "drop" // 不进入可读数据（仪表板、/api/data），原始数据照常保存
+)
+
+// validLocationAccuracyModes 支持的低精度定位处理方式
+var validLocationAccuracyModes = []string{LocationAccuracyFlag, LocationAccuracyDrop}
+
+// LocationFix 一次定位（由位置读数转换而来，缺少的字段为空）
+type LocationFix struct {
+	MessageID int64
+	SessionID string
+	DeviceID  string
+	Time      time.Time
+
+	Latitude                  float64
+	Longitude                 float64
+	Altitude                  *float64 // 相对于WGS84椭球的高度（米）
+	AltitudeAboveMeanSeaLevel *float64 // 相对于平均海平面的高度（米）
+	Speed                     *float64 // 米/秒
+	Bearing                   *float64 // 度
+
+	HorizontalAccuracy *float64 // 米
+	VerticalAccuracy   *float64 // 米
+	SpeedAccuracy      *float64 // 米/秒
+	BearingAccuracy    *float64 // 度
+
+	LowAccuracy bool // 水平精度超过阈值或无效
+}
+
+// isLocationReading 判断读数是否为位置读数
+func isLocationReading(reading SensorReading) bool {
+	return sensorKey(reading.Name) == "location"
+}
+
+// lowAccuracyReason 位置读数的水平精度超过阈值或无效时返回原因。maxAccuracy为0时不检查，
+// 没有报告水平精度的读数无法判断，视为正常
+func lowAccuracyReason(values map[string]interface{}, maxAccuracy float64) (string, bool) {
+	if maxAccuracy <= 0 {
+		return "", false
+	}
+	accuracy, ok := toNumber(values["horizontalAccuracy"])
+	switch {
+	case !ok:
+		return "", false
+	case accuracy < 0:
+		return fmt.Sprintf("水平精度无效: %v", accuracy), true
+	case accuracy > maxAccuracy:
+		return fmt.Sprintf("水平精度 %v 米超过阈值 %v 米", accuracy, maxAccuracy), true
+	}
+	return "", false
+}
+
+// newLocationFix 由位置读数生成定位，缺少经纬度时返回false
+func newLocationFix(reading SensorReading, maxAccuracy float64) (LocationFix, bool) {
+	latitude, latOK := toNumber(reading.Values["latitude"])
+	longitude, lonOK := toNumber(reading.Values["longitude"])
+	if !latOK || !lonOK {
+		return LocationFix{}, false
+	}
+
+	optional := func(key string) *float64 {
+		if number, ok := toNumber(reading.Values[key]); ok {
+			return &number
+		}
+		return nil
+	}
+
+	_, lowAccuracy := lowAccuracyReason(reading.Values, maxAccuracy)
+	return LocationFix{
+		Time:                      time.Unix(0, reading.Time),
+		Latitude:                  latitude,
+		Longitude:                 longitude,
+		Altitude:                  optional("altitude"),
+		AltitudeAboveMeanSeaLevel: optional("altitudeAboveMeanSeaLevel"),
+		Speed:                     optional("speed"),
+		Bearing:                   optional("bearing"),
+		HorizontalAccuracy:        optional("horizontalAccuracy"),
+		VerticalAccuracy:          optional("verticalAccuracy"),
+		SpeedAccuracy:             optional("speedAccuracy"),
+		BearingAccuracy:           optional("bearingAccuracy"),
+		LowAccuracy:               lowAccuracy,
+	}, true
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// newLocationTestMessage 一条包含精确和低精度定位的消息（字段与Sensor Logger推送的一致）
+func newLocationTestMessage(now time.Time) *SensorMessage {
+	return &SensorMessage{
+		MessageID: 3,
+		SessionID: "location-session",
+		DeviceID:  "location-device",
+		Payload: []SensorReading{
+			{Name: "location", Time: now.UnixNano(), Values: map[string]interface{}{
+				"latitude": 35.6895123, "longitude": 139.6917456, "altitude": 43.12, "altitudeAboveMeanSeaLevel": 4.87,
+				"speed": 1.25, "bearing": 87.5, "horizontalAccuracy": 4.7, "verticalAccuracy": 3.2,
+				"speedAccuracy": 0.5, "bearingAccuracy": 12.3,
+			}},
+			{Name: "location", Time: now.Add(time.Second).UnixNano(), Values: map[string]interface{}{
+				"latitude": 35.6901, "longitude": 139.6925, "altitude": 40.0, "speed": -1.0, "bearing": -1.0,
+				"horizontalAccuracy": 165.0, "verticalAccuracy": 30.0, "speedAccuracy": -1.0, "bearingAccuracy": -1.0,
+			}},
+			{Name: "accelerometer", Time: now.UnixNano(), Values: map[string]interface{}{"x": 0.1, "y": 0.2, "z": 9.8}},
+		},
+	}
+}
+
+func TestLowAccuracyReason(t *testing.T) {
+	tests := []struct {
+		name        string
+		values      map[string]interface{}
+		maxAccuracy float64
+		low         bool
+	}{
+		{"未设置阈值", map[string]interface{}{"horizontalAccuracy": 500.0}, 0, false},
+		{"精度足够", map[string]interface{}{"horizontalAccuracy": 10.0}, 10, false},
+		{"超过阈值", map[string]interface{}{"horizontalAccuracy": 10.5}, 10, true},
+		{"精度无效", map[string]interface{}{"horizontalAccuracy": -1.0}, 10, true},
+		{"没有报告精度", map[string]interface{}{"latitude": 35.6}, 10, false},
+	}
+	for _, tt := range tests {
+		if _, low := lowAccuracyReason(tt.values, tt.maxAccuracy); low != tt.low {
+			t.Errorf("%s: 期望%v，实际为%v", tt.name, tt.low, low)
+		}
+	}
+}
+
+func TestParseLocationAccuracyFields(t *testing.T) {
+	now := time.Now()
+	message := newLocationTestMessage(now)
+
+	values := make(map[string]SensorValue)
+	for _, value := range parseToHumanReadable(message.Payload[0]).Values {
+		values[value.Key] = value
+	}
+	expected := map[string]string{
+		"horizontalAccuracy":        "4.70 米",
+		"verticalAccuracy":          "3.20 米",
+		"speedAccuracy":             "0.50 m/s",
+		"bearingAccuracy":           "12.30 度",
+		"altitudeAboveMeanSeaLevel": "4.87 米",
+	}
+	for key, want := range expected {
+		if got := values[key].Value + " " + values[key].Unit; got != want {
+			t.Errorf("%s: 期望%s，实际为%s", key, want, got)
+		}
+	}
+
+	fix, ok := newLocationFix(message.Payload[1], 50)
+	if !ok || !fix.LowAccuracy || *fix.HorizontalAccuracy != 165 || fix.AltitudeAboveMeanSeaLevel != nil || !fix.Time.Equal(now.Add(time.Second)) {
+		t.Errorf("定位转换不正确: %+v", fix)
+	}
+	if _, ok := newLocationFix(SensorReading{Name: "location", Values: map[string]interface{}{"latitude": 1.0}}, 0); ok {
+		t.Error("缺少经度的读数不是有效定位")
+	}
+}
+
+func TestLocationAccuracyThreshold(t *testing.T) {
+	originalConfig := AppConfig
+	defer func() { AppConfig = originalConfig }()
+	now := time.Now()
+
+	// 未设置阈值时全部定位照常显示
+	AppConfig = defaultConfig
+	if parsed := buildParsedData(newLocationTestMessage(now)); len(parsed.ParsedReadings) != 3 || parsed.LowAccuracyFixes != 0 {
+		t.Errorf("未设置阈值时不应处理定位: %d %d", len(parsed.ParsedReadings), parsed.LowAccuracyFixes)
+	}
+
+	// flag模式下标记低精度定位
+	AppConfig.LocationMaxHorizontalAccuracy = 50
+	parsed := buildParsedData(newLocationTestMessage(now))
+	if len(parsed.ParsedReadings) != 3 || parsed.LowAccuracyFixes != 1 {
+		t.Fatalf("flag模式下应保留全部读数: %d %d", len(parsed.ParsedReadings), parsed.LowAccuracyFixes)
+	}
+	if parsed.ParsedReadings[0].LowAccuracy || !parsed.ParsedReadings[1].LowAccuracy {
+		t.Error("只有超过阈值的定位应被标记")
+	}
+
+	// drop模式下低精度定位不进入可读数据，原始读数保留
+	AppConfig.LocationAccuracyMode = LocationAccuracyDrop
+	parsed = buildParsedData(newLocationTestMessage(now))
+	if len(parsed.ParsedReadings) != 2 || parsed.LowAccuracyFixes != 1 || len(parsed.Payload) != 3 || parsed.TotalReadings != 3 {
+		t.Errorf("drop模式下应丢弃低精度定位的可读数据: %d %d", len(parsed.ParsedReadings), parsed.LowAccuracyFixes)
+	}
+	for _, reading := range parsed.ParsedReadings {
+		if reading.LowAccuracy {
+			t.Error("drop模式下不应有标记的定位")
+		}
+	}
+
+	// 入库校验时记录为数据质量警告
+	report := validateSensorMessage(newLocationTestMessage(now), now, ValidationOptions{Mode: ValidationReject, MaxHorizontalAccuracy: 50})
+	if !report.Valid || report.TotalWarnings != 1 || report.Warnings[0].Code != WarningLocationAccuracy || report.Warnings[0].Index != 1 {
+		t.Errorf("期望1条定位精度警告: %+v %+v", report.Violations, report.Warnings)
+	}
+}
+
+func TestHandleDBLocations(t *testing.T) {
+	setMongoConnection(nil)
+
+	rr := httptest.NewRecorder()
+	handleDBLocations(rr, httptest.NewRequest(http.MethodGet, "/api/db/locations?maxAccuracy=abc", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("无效的maxAccuracy期望400，实际为%d", rr.Code)
+	}
+
+	// 不指定会话时必须指定起始时间
+	for _, target := range []string{"/api/db/locations", "/api/db/locations?device=abc", "/api/db/locations?from=yesterday"} {
+		rr = httptest.NewRecorder()
+		handleDBLocations(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s 期望400，实际为%d", target, rr.Code)
+		}
+	}
+
+	for _, target := range []string{"/api/db/locations?session=abc&maxAccuracy=20", "/api/db/locations?from=2025-07-05"} {
+		rr = httptest.NewRecorder()
+		handleDBLocations(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s 期望503，实际为%d", target, rr.Code)
+		}
+	}
+}
+
+func TestLocationFixesPipeline(t *testing.T) {
+	from := time.Date(2025, 7, 5, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	pipeline := locationFixesPipeline("", "device", from, to, 0, 100, DeviceScope{All: true})
+
+	// 时间范围在展开之前过滤消息
+	first := pipeline[0]["$match"].(bson.M)
+	if first["timeRange.end"] == nil || first["timeRange.start"] == nil || first["deviceId"] != "device" {
+		t.Errorf("第一个$match应包含时间范围: %v", first)
+	}
+	readings := pipeline[3]["$match"].(bson.M)
+	if bound, ok := readings["payload.time"].(bson.M); !ok || bound["$gte"] != from.UnixNano() || bound["$lte"] != to.UnixNano() {
+		t.Errorf("读数时间过滤不正确: %v", readings)
+	}
+
+	// 按时间倒序取最近的定位
+	order := pipeline[4]["$sort"].(bson.D)
+	if order[0].Key != "payload.time" || order[0].Value != -1 || pipeline[5]["$limit"] != 100 {
+		t.Errorf("排序或数量限制不正确: %v %v", pipeline[4], pipeline[5])
+	}
+}
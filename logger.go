@@ -6,12 +6,19 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Logger 全局日志实例
 var Logger *slog.Logger
 
+// logFile 启用文件日志时打开的日志文件，关闭时由 CloseLogger 同步并关闭
+var logFile *os.File
+
+// logFileHandler 写入logFile的多输出处理器，CloseLogger 通过它停止写入文件
+var logFileHandler *MultiHandler
+
 // LogConfig 日志配置
 type LogConfig struct {
 	Level       slog.Level
@@ -118,18 +125,54 @@ func createMultiHandler(opts *slog.HandlerOptions, filePath string) slog.Handler
 		return slog.NewTextHandler(os.Stdout, opts)
 	}
 
+	// 替换之前打开的日志文件（重复初始化时）
+	if logFile != nil {
+		logFileHandler.detachFile()
+		logFile.Close()
+	}
+	logFile = file
+
 	// 创建多输出处理器
-	return &MultiHandler{
+	logFileHandler = &MultiHandler{
 		handlers: []slog.Handler{
 			slog.NewTextHandler(os.Stdout, opts), // 控制台输出
-			slog.NewJSONHandler(file, opts),      // 文件输出（JSON格式）
 		},
+		file:  slog.NewJSONHandler(file, opts), // 文件输出（JSON格式）
+		state: &multiHandlerState{},
 	}
+	return logFileHandler
 }
 
-// MultiHandler 多输出处理器
+// MultiHandler 多输出处理器。文件输出可以在运行中停止（CloseLogger），
+// 仍在运行的协程继续通过同一个处理器只输出到控制台
 type MultiHandler struct {
 	handlers []slog.Handler
+	file     slog.Handler       // 文件输出，为nil时没有
+	state    *multiHandlerState // 由 WithAttrs/WithGroup 派生的处理器共享
+}
+
+// multiHandlerState 文件输出的状态：写入时持有读锁，停止时持有写锁，保证关闭文件时没有正在进行的写入
+type multiHandlerState struct {
+	mutex    sync.RWMutex
+	detached bool
+}
+
+// detachFile 停止写入文件，返回时没有正在进行的文件写入
+func (h *MultiHandler) detachFile() {
+	if h == nil || h.state == nil {
+		return
+	}
+	h.state.mutex.Lock()
+	h.state.detached = true
+	h.state.mutex.Unlock()
+}
+
+// fileHandler 返回仍有效的文件输出（调用方需持有读锁）
+func (h *MultiHandler) fileHandler() slog.Handler {
+	if h.file == nil || h.state.detached {
+		return nil
+	}
+	return h.file
 }
 
 func (h *MultiHandler) Enabled(ctx context.Context, level slog.Level) bool {
@@ -138,6 +181,13 @@ func (h *MultiHandler) Enabled(ctx context.Context, level slog.Level) bool {
 			return true
 		}
 	}
+	if h.file != nil {
+		h.state.mutex.RLock()
+		defer h.state.mutex.RUnlock()
+		if file := h.fileHandler(); file != nil {
+			return file.Enabled(ctx, level)
+		}
+	}
 	return false
 }
 
@@ -149,6 +199,13 @@ func (h *MultiHandler) Handle(ctx context.Context, record slog.Record) error {
 			}
 		}
 	}
+	if h.file != nil {
+		h.state.mutex.RLock()
+		defer h.state.mutex.RUnlock()
+		if file := h.fileHandler(); file != nil && file.Enabled(ctx, record.Level) {
+			return file.Handle(ctx, record)
+		}
+	}
 	return nil
 }
 
@@ -157,7 +214,11 @@ func (h *MultiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	for i, handler := range h.handlers {
 		newHandlers[i] = handler.WithAttrs(attrs)
 	}
-	return &MultiHandler{handlers: newHandlers}
+	derived := &MultiHandler{handlers: newHandlers, state: h.state}
+	if h.file != nil {
+		derived.file = h.file.WithAttrs(attrs)
+	}
+	return derived
 }
 
 func (h *MultiHandler) WithGroup(name string) slog.Handler {
@@ -165,7 +226,11 @@ func (h *MultiHandler) WithGroup(name string) slog.Handler {
 	for i, handler := range h.handlers {
 		newHandlers[i] = handler.WithGroup(name)
 	}
-	return &MultiHandler{handlers: newHandlers}
+	derived := &MultiHandler{handlers: newHandlers, state: h.state}
+	if h.file != nil {
+		derived.file = h.file.WithGroup(name)
+	}
+	return derived
 }
 
 // parseLogLevel 解析日志级别
@@ -228,6 +293,23 @@ func LogDatabaseOperation(operation string, success bool, recordCount int, durat
 	}
 }
 
+// LogDurabilityDecision 记录持久化要求的判定结果
+func LogDurabilityDecision(ingestID, mode string, accepted bool, sinks SinkResults, reason string) {
+	attrs := []any{
+		slog.String("ingest_id", ingestID),
+		slog.String("mode", mode),
+		slog.Bool("accepted", accepted),
+		slog.String("memory", string(sinks.Memory)),
+		slog.String("mongo", string(sinks.Mongo)),
+		slog.String("file", string(sinks.File)),
+	}
+	if accepted {
+		Logger.Debug("持久化判定", attrs...)
+	} else {
+		Logger.Warn("持久化判定", append(attrs, slog.String("reason", reason))...)
+	}
+}
+
 // LogAPIRequest 记录API请求日志
 func LogAPIRequest(method, path, remoteAddr string, statusCode int, duration time.Duration) {
 	Logger.Debug("API请求",
@@ -263,3 +345,23 @@ func LogStartup(serverAddr string, config map[string]interface{}) {
 func LogShutdown(reason string) {
 	Logger.Info("服务器关闭", slog.String("reason", reason))
 }
+
+// CloseLogger 将日志文件同步到磁盘并关闭，之后的日志只输出到控制台。
+// 全局Logger不变，仍在运行的协程可以继续记录日志
+func CloseLogger() error {
+	if logFile == nil {
+		return nil
+	}
+	file := logFile
+	logFile = nil
+
+	// 先停止写入文件，之后关闭文件时不会有正在进行的写入
+	logFileHandler.detachFile()
+	logFileHandler = nil
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
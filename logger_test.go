@@ -2,6 +2,10 @@ package main
 
 import (
 	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -141,3 +145,47 @@ func TestMultiHandlerInterface(t *testing.T) {
 	_ = handler.WithAttrs([]slog.Attr{})
 	_ = handler.WithGroup("test")
 }
+
+func TestCloseLoggerWhileLogging(t *testing.T) {
+	originalLogger, originalDefault := Logger, slog.Default()
+	defer func() {
+		Logger = originalLogger
+		slog.SetDefault(originalDefault)
+	}()
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := InitLogger(LogConfig{Level: slog.LevelInfo, Environment: "production", EnableFile: true, FilePath: path}); err != nil {
+		t.Fatalf("初始化Logger失败: %v", err)
+	}
+	logger := Logger
+
+	// 关闭期间仍在运行的协程继续记录日志（包括派生的Logger），不能写入已关闭的文件
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			derived := Logger.With(slog.Int("worker", worker))
+			for j := 0; j < 50; j++ {
+				derived.Info("关闭期间的日志")
+				Logger.Debug("不输出的日志")
+			}
+		}(i)
+	}
+	if err := CloseLogger(); err != nil {
+		t.Errorf("关闭日志文件失败: %v", err)
+	}
+	wg.Wait()
+
+	if Logger != logger {
+		t.Error("CloseLogger不应替换全局Logger")
+	}
+	Logger.Info("关闭后的日志")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取日志文件失败: %v", err)
+	}
+	if strings.Contains(string(data), "关闭后的日志") {
+		t.Error("关闭后的日志不应写入文件")
+	}
+}
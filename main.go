@@ -1,12 +1,15 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 )
 
 // 版本信息变量（通过构建时注入）
@@ -31,23 +34,116 @@ func main() {
 		os.Exit(1)
 	}
 
-	// 初始化MongoDB连接
-	if err := InitMongoDB(); err != nil {
-		Logger.Error("MongoDB初始化失败", slog.String("error", err.Error()))
-		Logger.Info("将继续运行，但不会保存数据到数据库")
+	// 加载传感器描述文件（导入等子命令同样需要）
+	if err := loadSensorRegistry(); err != nil {
+		Logger.Error("传感器描述加载失败", slog.String("error", err.Error()))
+		os.Exit(1)
 	}
 
-	// 设置优雅关闭
-	setupGracefulShutdown()
+	// 执行子命令（例如导入录制文件）后退出
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// 初始化MongoDB连接（失败时后台按指数退避重连，期间数据写入写前缓冲）
+	mongoSupervisor = NewMongoSupervisor(
+		time.Duration(AppConfig.MongoRetryInitial)*time.Second,
+		time.Duration(AppConfig.MongoRetryMax)*time.Second,
+		time.Duration(AppConfig.MongoHealthInterval)*time.Second)
+	mongoSupervisor.Start()
+	if !mongoAvailable() {
+		Logger.Info("将继续运行，MongoDB恢复后自动连接")
+	}
+
+	// 恢复上次关闭时保存的内存数据
+	if count, err := parsedDataStore.LoadSnapshot(memoryStoreSnapshotPath(), AppConfig.MaxDataStore); err != nil {
+		LogError("恢复内存数据", err)
+	} else if count > 0 {
+		Logger.Info("已恢复内存数据", slog.Int("count", count))
+	}
+
+	// 启动写前缓冲（MongoDB未确认的消息暂存到磁盘，恢复后回放）
+	if AppConfig.EnableSpool {
+		spool, err := NewSpool(filepath.Join(AppConfig.DataDir, "spool"))
+		if err != nil {
+			Logger.Error("写前缓冲初始化失败", slog.String("error", err.Error()))
+		} else {
+			messageSpool = spool
+			messageSpool.Start(time.Duration(AppConfig.SpoolReplayInterval) * time.Second)
+		}
+	}
+
+	// 启动原始数据归档
+	if AppConfig.EnableFileLog {
+		startRawArchive()
+	}
+
+	// 启动MongoDB批量写入
+	bulkWriter = NewBulkWriter(AppConfig.MongoBulkBatchSize, time.Duration(AppConfig.MongoBulkFlushMs)*time.Millisecond)
+	bulkWriter.Start()
+
+	// 按配置创建消息去重器
+	messageDeduplicator = NewMessageDeduplicator(AppConfig.DedupCacheSize)
+
+	// 启动入库流水线
+	ingestPipeline = NewIngestPipeline(AppConfig.IngestWorkers, AppConfig.IngestQueueSize)
+	ingestPipeline.Start()
+
+	// 按设备和来源IP限流
+	ingestRateLimiter = NewIngestRateLimiter(currentRateLimitOptions())
+
+	// 加载设备令牌（MongoDB不可用时使用数据目录中的文件）
+	deviceTokens = NewTokenRegistry(deviceTokenPath())
+	deviceTokens.Start()
+
+	// 加载设备的请求签名密钥
+	signingKeys = NewSigningKeyStore(signingKeyPath())
+	signingKeys.Start()
+
+	// 加载用户账户
+	userAccounts = NewAccountStore(userAccountPath())
+	userAccounts.Start()
+	if AppConfig.EnableAuth && userAccounts.Empty() {
+		Logger.Warn("已启用用户鉴权但还没有任何用户，请使用 user add 命令创建")
+	}
 
 	// 设置路由
-	http.HandleFunc("/data", handleSensorData)
-	http.HandleFunc("/", handleRoot)
-	http.HandleFunc("/dashboard", handleDashboard)
-	http.HandleFunc("/api/data", handleAPIData)
-	http.HandleFunc("/api/db/data", handleDBData)
-	http.HandleFunc("/api/db/devices", handleDeviceInfo)
-	http.HandleFunc("/api/db/stats", handleDBStats)
+	http.HandleFunc("/data", withDeviceAuth(handleSensorData))
+	http.HandleFunc("/api/v1/ingest/bulk", withDeviceAuth(handleBulkIngest))
+	http.HandleFunc("/api/v1/import/sensor-logger", withDeviceAuth(handleRecordingImport))
+	http.HandleFunc("/api/admin/replay", withAdminAuth(handleAdminReplay))
+	http.HandleFunc("/api/admin/archive/{session}/{messageId}", withAdminAuth(handleAdminArchivedMessage))
+	http.HandleFunc("/api/admin/tokens", withAdminAuth(handleAdminTokens))
+	http.HandleFunc("/api/admin/tokens/{id}", withAdminAuth(handleAdminRevokeToken))
+	http.HandleFunc("/login", handleLogin)
+	http.HandleFunc("/logout", handleLogout)
+	http.HandleFunc("/", withAuth(RoleViewer, handleRoot))
+	http.HandleFunc("/dashboard", withAuth(RoleViewer, handleDashboard))
+	http.HandleFunc("/api/data", withAuth(RoleViewer, handleAPIData))
+	http.HandleFunc("/api/v1/sensors", withAuth(RoleViewer, handleSensorCatalog))
+	http.HandleFunc("/api/db/data", withAuth(RoleViewer, handleDBData))
+	http.HandleFunc("/api/db/markers", withAuth(RoleViewer, handleDBMarkers))
+	http.HandleFunc("/api/db/locations", withAuth(RoleViewer, handleDBLocations))
+	http.HandleFunc("/api/db/devices", withAuth(RoleViewer, handleDeviceInfo))
+	http.HandleFunc("/api/db/stats", withAuth(RoleViewer, handleDBStats))
+	http.HandleFunc("/api/db/status", withAuth(RoleOperator, handleDBStatus))
+	http.HandleFunc("/api/ingest/stats", withAuth(RoleOperator, handleIngestStats))
+
+	// 准备HTTPS证书（需要时生成自签名证书）
+	server := &http.Server{Addr: GetServerAddr()}
+	scheme := "http"
+	if AppConfig.EnableTLS {
+		tlsConfig, err := setupTLS()
+		if err != nil {
+			Logger.Error("HTTPS初始化失败", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		server.TLSConfig = tlsConfig
+		scheme = "https"
+	}
+
+	// 设置优雅关闭
+	shutdownExitCode := setupGracefulShutdown(server)
 
 	// 显示启动信息
 	fmt.Println("=== 传感器日志服务器 ===")
@@ -64,54 +160,98 @@ func main() {
 
 	fmt.Println("\n本机IP地址:")
 	getLocalIPs()
-	fmt.Printf("\n请在Sensor Logger应用中设置推送URL为: http://[你的IP地址]:%s/data\n", AppConfig.ServerPort)
+	if serverCertificates != nil {
+		fmt.Printf("\nHTTPS证书指纹 (SHA-256): %s\n", serverCertificates.Fingerprint())
+		if AppConfig.TLSSelfSigned {
+			fmt.Println("自签名证书，请在客户端中核对指纹后信任该证书")
+		}
+	}
+	fmt.Printf("\n请在Sensor Logger应用中设置推送URL为: %s://[你的IP地址]:%s/data\n", scheme, AppConfig.ServerPort)
 	fmt.Println("使用 'Tap to Test Pushing' 按钮测试连接")
-	fmt.Printf("访问 http://[你的IP地址]:%s/dashboard 查看数据仪表板\n", AppConfig.ServerPort)
+	fmt.Printf("访问 %s://[你的IP地址]:%s/dashboard 查看数据仪表板\n", scheme, AppConfig.ServerPort)
 
 	// 显示API端点
 	fmt.Println("\n=== API端点 ===")
-	fmt.Printf("内存数据API: http://[你的IP地址]:%s/api/data\n", AppConfig.ServerPort)
-	fmt.Printf("数据库数据API: http://[你的IP地址]:%s/api/db/data\n", AppConfig.ServerPort)
-	fmt.Printf("设备信息API: http://[你的IP地址]:%s/api/db/devices\n", AppConfig.ServerPort)
-	fmt.Printf("统计信息API: http://[你的IP地址]:%s/api/db/stats\n", AppConfig.ServerPort)
+	fmt.Printf("内存数据API: %s://[你的IP地址]:%s/api/data\n", scheme, AppConfig.ServerPort)
+	fmt.Printf("传感器说明API: %s://[你的IP地址]:%s/api/v1/sensors\n", scheme, AppConfig.ServerPort)
+	fmt.Printf("数据库数据API: %s://[你的IP地址]:%s/api/db/data\n", scheme, AppConfig.ServerPort)
+	fmt.Printf("会话标记API: %s://[你的IP地址]:%s/api/db/markers\n", scheme, AppConfig.ServerPort)
+	fmt.Printf("定位API: %s://[你的IP地址]:%s/api/db/locations\n", scheme, AppConfig.ServerPort)
+	fmt.Printf("设备信息API: %s://[你的IP地址]:%s/api/db/devices\n", scheme, AppConfig.ServerPort)
+	fmt.Printf("统计信息API: %s://[你的IP地址]:%s/api/db/stats\n", scheme, AppConfig.ServerPort)
+	fmt.Printf("数据库状态API: %s://[你的IP地址]:%s/api/db/status\n", scheme, AppConfig.ServerPort)
+	fmt.Printf("入库队列API: %s://[你的IP地址]:%s/api/ingest/stats\n", scheme, AppConfig.ServerPort)
+	fmt.Printf("批量导入API: %s://[你的IP地址]:%s/api/v1/ingest/bulk\n", scheme, AppConfig.ServerPort)
+	fmt.Printf("录制文件导入API: %s://[你的IP地址]:%s/api/v1/import/sensor-logger\n", scheme, AppConfig.ServerPort)
+	fmt.Printf("原始数据回放API: %s://[你的IP地址]:%s/api/admin/replay (需要ADMIN_TOKEN)\n", scheme, AppConfig.ServerPort)
+	fmt.Printf("归档消息查询API: %s://[你的IP地址]:%s/api/admin/archive/{session}/{messageId} (需要ADMIN_TOKEN)\n", scheme, AppConfig.ServerPort)
+	fmt.Printf("设备令牌管理API: %s://[你的IP地址]:%s/api/admin/tokens (需要ADMIN_TOKEN)\n", scheme, AppConfig.ServerPort)
 	fmt.Println("===============")
 
 	// 启动服务器
-	serverAddr := GetServerAddr()
+	serverAddr := server.Addr
 
 	// 记录启动日志
 	configMap := map[string]interface{}{
 		"environment":    AppConfig.Environment,
 		"log_level":      AppConfig.LogLevel,
-		"mongo_enabled":  mongoClient != nil,
+		"mongo_enabled":  mongoAvailable(),
 		"file_log":       AppConfig.EnableFileLog,
 		"max_data_store": AppConfig.MaxDataStore,
+		"tls":            AppConfig.EnableTLS,
 	}
 	LogStartup(serverAddr, configMap)
 
 	Logger.Info("服务器启动完成", slog.String("address", serverAddr))
 
-	if err := http.ListenAndServe(serverAddr, nil); err != nil {
+	var err error
+	if server.TLSConfig != nil {
+		// 证书由TLSConfig.GetCertificate提供，文件变化后自动重新加载
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
 		Logger.Error("服务器启动失败", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
+
+	// Shutdown后ListenAndServe立即返回，等待关闭流程完成
+	os.Exit(<-shutdownExitCode)
 }
 
-// setupGracefulShutdown 设置优雅关闭
-func setupGracefulShutdown() {
-	c := make(chan os.Signal, 1)
+// setupGracefulShutdown 设置优雅关闭：收到信号后按顺序关闭服务器，通过返回的通道给出进程的退出码
+// （全部数据已写出时为0），再次收到信号时立即退出
+func setupGracefulShutdown(server *http.Server) <-chan int {
+	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	exitCode := make(chan int, 1)
 
 	go func() {
 		<-c
 		LogShutdown("收到关闭信号")
 
-		// 关闭MongoDB连接
-		if err := CloseMongoDB(); err != nil {
-			Logger.Error("关闭MongoDB连接失败", slog.String("error", err.Error()))
+		go func() {
+			<-c
+			Logger.Warn("再次收到关闭信号，立即退出")
+			os.Exit(1)
+		}()
+
+		code := 0
+		timeout := time.Duration(AppConfig.ShutdownTimeout) * time.Second
+		if shutdownServer(server, timeout) {
+			Logger.Info("服务器已关闭")
+		} else {
+			Logger.Error("服务器已关闭，部分数据可能未写出")
+			code = 1
 		}
 
-		Logger.Info("服务器已关闭")
-		os.Exit(0)
+		// 最后关闭日志文件
+		if err := CloseLogger(); err != nil {
+			fmt.Printf("关闭日志文件失败: %v\n", err)
+			code = 1
+		}
+		exitCode <- code
 	}()
+	return exitCode
 }
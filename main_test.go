@@ -104,7 +104,7 @@ func TestParseAccelerometer(t *testing.T) {
 		"z": 0.089095,
 	}
 
-	result := parseAccelerometer(values)
+	result := sensorRegistry.ParseValues("accelerometer", values)
 
 	if len(result) != 3 {
 		t.Errorf("期望3个值，实际为%d", len(result))
@@ -129,7 +129,7 @@ func TestParseGyroscope(t *testing.T) {
 		"z": 0.016911,
 	}
 
-	result := parseGyroscope(values)
+	result := sensorRegistry.ParseValues("gyroscope", values)
 
 	if len(result) != 3 {
 		t.Errorf("期望3个值，实际为%d", len(result))
@@ -151,7 +151,7 @@ func TestParseMagnetometer(t *testing.T) {
 		"magneticBearing": 137.27661523593756,
 	}
 
-	result := parseMagnetometer(values)
+	result := sensorRegistry.ParseValues("magnetometer", values)
 
 	if len(result) != 1 {
 		t.Errorf("期望1个值，实际为%d", len(result))
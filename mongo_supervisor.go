@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MongoState MongoDB连接状态
+type MongoState string
+
+const (
+	MongoStateConnected MongoState = "connected" // 连接正常
+	MongoStateDegraded  MongoState = "degraded"  // 健康检查失败但仍在使用，或索引创建失败（集合未暴露，数据写入写前缓冲）
+	MongoStateDown      MongoState = "down"      // 不可用，数据写入写前缓冲
+)
+
+// 连续健康检查失败达到该次数后视为断开，停止对外暴露集合
+const mongoMaxHealthFailures = 3
+
+// 全局MongoDB连接监控器
+var mongoSupervisor *MongoSupervisor
+
+// MongoStatus MongoDB连接状态信息
+type MongoStatus struct {
+	State               MongoState `json:"state"`
+	Since               time.Time  `json:"since"`
+	Database            string     `json:"database"`
+	IndexesReady        bool       `json:"indexesReady"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           string     `json:"lastError,omitempty"`
+	LastErrorAt         time.Time  `json:"lastErrorAt,omitempty"`
+	LastCheckAt         time.Time  `json:"lastCheckAt,omitempty"`
+	NextCheckAt         time.Time  `json:"nextCheckAt,omitempty"`
+}
+
+// MongoSupervisor MongoDB连接监控器：断开时按指数退避重连，只有连接健康时才对外暴露集合
+type MongoSupervisor struct {
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	healthInterval time.Duration
+
+	// 以下字段只在监控协程（以及启动前的首次检查）中访问
+	client  *mongo.Client
+	exposed bool
+	backoff time.Duration
+
+	mutex  sync.RWMutex
+	status MongoStatus
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewMongoSupervisor 创建连接监控器
+func NewMongoSupervisor(initialBackoff, maxBackoff, healthInterval time.Duration) *MongoSupervisor {
+	return &MongoSupervisor{
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		healthInterval: healthInterval,
+		backoff:        initialBackoff,
+		status: MongoStatus{
+			State:    MongoStateDown,
+			Since:    time.Now(),
+			Database: AppConfig.MongoDatabase,
+		},
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// Start 同步进行首次连接，然后启动后台监控协程
+func (s *MongoSupervisor) Start() {
+	wait := s.check()
+	go s.run(wait)
+}
+
+// Status 获取当前连接状态
+func (s *MongoSupervisor) Status() MongoStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.status
+}
+
+// Close 停止监控并断开连接
+func (s *MongoSupervisor) Close() error {
+	close(s.stopCh)
+	<-s.doneCh
+
+	setMongoConnection(nil)
+	s.setState(MongoStateDown)
+	if s.client == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.client.Disconnect(ctx); err != nil {
+		return fmt.Errorf("关闭MongoDB连接失败: %v", err)
+	}
+	Logger.Info("MongoDB连接已关闭")
+	return nil
+}
+
+// run 后台监控协程
+func (s *MongoSupervisor) run(wait time.Duration) {
+	defer close(s.doneCh)
+
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			wait = s.check()
+		case <-s.stopCh:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// check 执行一次连接/健康检查，返回距下次检查的等待时间
+func (s *MongoSupervisor) check() time.Duration {
+	err := s.ping()
+	if err == nil {
+		s.backoff = s.initialBackoff
+		return s.scheduleNext(s.healthInterval)
+	}
+
+	s.recordFailure(err)
+	wait := s.backoff
+	s.backoff *= 2
+	if s.backoff > s.maxBackoff {
+		s.backoff = s.maxBackoff
+	}
+	return s.scheduleNext(wait)
+}
+
+// ping 建立客户端（如尚未建立）并检查连接；首次健康或断开后恢复时先创建索引，索引就绪后才暴露集合
+func (s *MongoSupervisor) ping() error {
+	if s.client == nil {
+		client, err := connectMongoDB()
+		if err != nil {
+			return err
+		}
+		s.client = client
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(AppConfig.MongoTimeout)*time.Second)
+	defer cancel()
+	if err := s.client.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("MongoDB连接测试失败: %v", err)
+	}
+
+	s.mutex.Lock()
+	s.status.ConsecutiveFailures = 0
+	s.mutex.Unlock()
+
+	// 索引在每次（重新）连接后创建；失败时保持降级状态，集合不对外暴露（数据继续写入写前缓冲），
+	// 下次检查时重试
+	if !s.exposed {
+		db := s.client.Database(AppConfig.MongoDatabase)
+		if err := createIndexes(db.Collection("sensor_messages"), db.Collection("device_info")); err != nil {
+			s.mutex.Lock()
+			s.status.LastError = fmt.Sprintf("创建索引失败: %v", err)
+			s.status.LastErrorAt = time.Now()
+			s.mutex.Unlock()
+			s.setState(MongoStateDegraded)
+			LogError("创建MongoDB索引", err)
+			return nil
+		}
+
+		s.mutex.Lock()
+		s.status.IndexesReady = true
+		s.mutex.Unlock()
+
+		setMongoConnection(s.client)
+		s.exposed = true
+		Logger.Info("MongoDB连接成功",
+			slog.String("uri", AppConfig.MongoURI),
+			slog.String("database", AppConfig.MongoDatabase))
+	}
+
+	s.setState(MongoStateConnected)
+	return nil
+}
+
+// recordFailure 记录一次失败；已连接时先进入降级状态，连续失败过多后停止暴露集合
+func (s *MongoSupervisor) recordFailure(err error) {
+	s.mutex.Lock()
+	s.status.ConsecutiveFailures++
+	s.status.LastError = err.Error()
+	s.status.LastErrorAt = time.Now()
+	failures := s.status.ConsecutiveFailures
+	s.mutex.Unlock()
+
+	if s.exposed && failures < mongoMaxHealthFailures {
+		s.setState(MongoStateDegraded)
+		Logger.Warn("MongoDB健康检查失败",
+			slog.Int("failures", failures),
+			slog.String("error", err.Error()))
+		return
+	}
+
+	if s.exposed {
+		setMongoConnection(nil)
+		s.exposed = false
+
+		// 重新连接后需要重新创建索引
+		s.mutex.Lock()
+		s.status.IndexesReady = false
+		s.mutex.Unlock()
+	}
+	if s.setState(MongoStateDown) || failures == 1 {
+		Logger.Error("MongoDB不可用，数据将写入写前缓冲",
+			slog.Int("failures", failures),
+			slog.String("error", err.Error()))
+	}
+}
+
+// setState 更新连接状态，状态变化时记录时间并返回true
+func (s *MongoSupervisor) setState(state MongoState) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.status.State == state {
+		return false
+	}
+	Logger.Info("MongoDB连接状态变化",
+		slog.String("from", string(s.status.State)),
+		slog.String("to", string(state)))
+	s.status.State = state
+	s.status.Since = time.Now()
+	return true
+}
+
+// scheduleNext 记录本次检查时间和下次检查时间
+func (s *MongoSupervisor) scheduleNext(wait time.Duration) time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.status.LastCheckAt = now
+	s.status.NextCheckAt = now.Add(wait)
+	return wait
+}
+
+// currentMongoStatus 获取MongoDB连接状态（监控器未启动时视为不可用）
+func currentMongoStatus() MongoStatus {
+	if mongoSupervisor == nil {
+		return MongoStatus{State: MongoStateDown, Database: AppConfig.MongoDatabase}
+	}
+	return mongoSupervisor.Status()
+}
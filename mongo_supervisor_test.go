@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newUnreachableMongoSupervisor 创建一个连接必定失败的监控器（URI无效，不产生网络请求）
+func newUnreachableMongoSupervisor(t *testing.T) *MongoSupervisor {
+	originalURI := AppConfig.MongoURI
+	AppConfig.MongoURI = "invalid://localhost"
+	t.Cleanup(func() { AppConfig.MongoURI = originalURI })
+
+	return NewMongoSupervisor(time.Second, 5*time.Second, time.Minute)
+}
+
+func TestMongoSupervisorBackoff(t *testing.T) {
+	supervisor := newUnreachableMongoSupervisor(t)
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, want := range expected {
+		if wait := supervisor.check(); wait != want {
+			t.Errorf("第%d次检查后期望等待%v，实际为%v", i+1, want, wait)
+		}
+	}
+
+	status := supervisor.Status()
+	if status.State != MongoStateDown {
+		t.Errorf("期望状态为%s，实际为%s", MongoStateDown, status.State)
+	}
+	if status.ConsecutiveFailures != len(expected) {
+		t.Errorf("期望连续失败%d次，实际为%d", len(expected), status.ConsecutiveFailures)
+	}
+	if status.LastError == "" {
+		t.Error("连接失败时应记录错误")
+	}
+	if !status.NextCheckAt.After(status.LastCheckAt) {
+		t.Error("下次检查时间应晚于本次检查时间")
+	}
+}
+
+func TestMongoCollectionsUnavailable(t *testing.T) {
+	originalClient := mongoClient
+	setMongoConnection(nil)
+	defer func() { mongoClient = originalClient }()
+
+	if mongoAvailable() {
+		t.Error("未设置连接时不应报告可用")
+	}
+	if _, _, err := mongoCollections(); !errors.Is(err, errMongoUnavailable) {
+		t.Errorf("期望返回errMongoUnavailable，实际为%v", err)
+	}
+}
+
+func TestHandleDBStatus(t *testing.T) {
+	originalSupervisor := mongoSupervisor
+	defer func() { mongoSupervisor = originalSupervisor }()
+
+	mongoSupervisor = newUnreachableMongoSupervisor(t)
+	mongoSupervisor.check()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/db/status", nil)
+	w := httptest.NewRecorder()
+	handleDBStatus(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码200，实际为%d", w.Code)
+	}
+
+	var status MongoStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	if status.State != MongoStateDown || status.LastError == "" {
+		t.Errorf("期望返回down状态和错误信息，实际为%+v", status)
+	}
+}
+
+func TestDBHandlersUnavailable(t *testing.T) {
+	originalClient := mongoClient
+	setMongoConnection(nil)
+	defer func() { mongoClient = originalClient }()
+
+	handlers := map[string]http.HandlerFunc{
+		"/api/db/data":    handleDBData,
+		"/api/db/devices": handleDeviceInfo,
+		"/api/db/stats":   handleDBStats,
+	}
+	for path, handler := range handlers {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: 数据库不可用时期望状态码503，实际为%d", path, w.Code)
+		}
+	}
+}
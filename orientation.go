@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// 方向角单位
+const (
+	AngleUnitRadian = "rad" // 弧度（默认，与Sensor Logger推送的一致）
+	AngleUnitDegree = "deg" // 度
+)
+
+// validAngleUnits 支持的方向角单位
+var validAngleUnits = []string{AngleUnitRadian, AngleUnitDegree}
+
+// 四元数的模偏离1超过该值时记录数据质量警告
+const quaternionNormTolerance = 0.01
+
+// 以度显示方向角时的小数位数
+const degreePrecision = 2
+
+// Quaternion 方向四元数
+type Quaternion struct {
+	W float64
+	X float64
+	Y float64
+	Z float64
+}
+
+// EulerAngles 偏航/俯仰/横滚角
+type EulerAngles struct {
+	Yaw   float64 // 偏航角（绕Z轴）
+	Pitch float64 // 俯仰角（绕Y轴）
+	Roll  float64 // 横滚角（绕X轴）
+}
+
+// OrientationData 方向传感器读数的姿态信息。保存时只有四元数和读数中报告的角度（弧度），
+// 其余字段在返回响应时由 Resolve 按请求的单位计算，不写入数据库
+type OrientationData struct {
+	Quaternion Quaternion
+	Reported   *EulerAngles `json:",omitempty" bson:",omitempty"` // 读数中的yaw/pitch/roll，没有时为空
+
+	Norm           float64       `bson:"-"` // 四元数的模，正常应为1
+	Unnormalized   bool          `bson:"-"` // 模偏离1超过容差（数据质量警告）
+	Yaw            float64       `bson:"-"`
+	Pitch          float64       `bson:"-"`
+	Roll           float64       `bson:"-"`
+	AngleUnit      string        `bson:"-"` // 偏航/俯仰/横滚角的单位（rad/deg）
+	Derived        bool          `bson:"-"` // 读数中没有yaw/pitch/roll，由四元数计算
+	RotationMatrix [3][3]float64 `bson:"-"` // 由归一化的四元数计算的旋转矩阵（行优先，设备坐标系到参考坐标系）
+}
+
+// quaternionFromValues 从读数中取出四元数（qw/qx/qy/qz），缺少任一分量时返回false
+func quaternionFromValues(values map[string]interface{}) (Quaternion, bool) {
+	var components [4]float64
+	for i, key := range []string{"qw", "qx", "qy", "qz"} {
+		number, ok := toNumber(values[key])
+		if !ok {
+			return Quaternion{}, false
+		}
+		components[i] = number
+	}
+	return Quaternion{W: components[0], X: components[1], Y: components[2], Z: components[3]}, true
+}
+
+// Norm 四元数的模
+func (q Quaternion) Norm() float64 {
+	return math.Sqrt(q.W*q.W + q.X*q.X + q.Y*q.Y + q.Z*q.Z)
+}
+
+// Normalized 归一化的四元数，模为0时返回原值
+func (q Quaternion) Normalized() Quaternion {
+	norm := q.Norm()
+	if norm == 0 {
+		return q
+	}
+	return Quaternion{W: q.W / norm, X: q.X / norm, Y: q.Y / norm, Z: q.Z / norm}
+}
+
+// EulerAngles 按Z-Y-X（偏航-俯仰-横滚）顺序计算欧拉角（弧度）
+func (q Quaternion) EulerAngles() (yaw, pitch, roll float64) {
+	q = q.Normalized()
+	yaw = math.Atan2(2*(q.W*q.Z+q.X*q.Y), 1-2*(q.Y*q.Y+q.Z*q.Z))
+	// 万向锁附近的舍入误差可能使正弦值略超出[-1, 1]
+	pitch = math.Asin(math.Max(-1, math.Min(1, 2*(q.W*q.Y-q.Z*q.X))))
+	roll = math.Atan2(2*(q.W*q.X+q.Y*q.Z), 1-2*(q.X*q.X+q.Y*q.Y))
+	return yaw, pitch, roll
+}
+
+// RotationMatrix 旋转矩阵（行优先）
+func (q Quaternion) RotationMatrix() [3][3]float64 {
+	q = q.Normalized()
+	w, x, y, z := q.W, q.X, q.Y, q.Z
+	return [3][3]float64{
+		{1 - 2*(y*y+z*z), 2 * (x*y - w*z), 2 * (x*z + w*y)},
+		{2 * (x*y + w*z), 1 - 2*(x*x+z*z), 2 * (y*z - w*x)},
+		{2 * (x*z - w*y), 2 * (y*z + w*x), 1 - 2*(x*x+y*y)},
+	}
+}
+
+// newOrientationData 由方向传感器读数生成保存的姿态信息（四元数和读数中的yaw/pitch/roll）。
+// 读数中没有有效的四元数时返回nil
+func newOrientationData(values map[string]interface{}) *OrientationData {
+	q, ok := quaternionFromValues(values)
+	if !ok {
+		return nil
+	}
+	norm := q.Norm()
+	if norm == 0 || math.IsNaN(norm) || math.IsInf(norm, 0) {
+		return nil
+	}
+
+	data := &OrientationData{Quaternion: q}
+	yaw, yawOK := toNumber(values["yaw"])
+	pitch, pitchOK := toNumber(values["pitch"])
+	roll, rollOK := toNumber(values["roll"])
+	if yawOK && pitchOK && rollOK {
+		data.Reported = &EulerAngles{Yaw: yaw, Pitch: pitch, Roll: roll}
+	}
+	return data
+}
+
+// Resolve 按单位计算完整的姿态信息：优先使用读数中的yaw/pitch/roll，缺少时由四元数计算。返回新的副本
+func (o *OrientationData) Resolve(angleUnit string) *OrientationData {
+	q := o.Quaternion
+	norm := q.Norm()
+	data := &OrientationData{
+		Quaternion:     q,
+		Reported:       o.Reported,
+		Norm:           norm,
+		Unnormalized:   math.Abs(norm-1) > quaternionNormTolerance,
+		AngleUnit:      AngleUnitRadian,
+		RotationMatrix: q.RotationMatrix(),
+	}
+
+	if o.Reported != nil {
+		data.Yaw, data.Pitch, data.Roll = o.Reported.Yaw, o.Reported.Pitch, o.Reported.Roll
+	} else {
+		data.Yaw, data.Pitch, data.Roll = q.EulerAngles()
+		data.Derived = true
+	}
+
+	if angleUnit == AngleUnitDegree {
+		data.Yaw, data.Pitch, data.Roll = radToDeg(data.Yaw), radToDeg(data.Pitch), radToDeg(data.Roll)
+		data.AngleUnit = AngleUnitDegree
+	}
+	return data
+}
+
+// requestAngleUnit 读取请求的方向角单位（查询参数angleUnit，默认为弧度）
+func requestAngleUnit(r *http.Request) (string, error) {
+	unit := strings.ToLower(r.URL.Query().Get("angleUnit"))
+	if unit == "" {
+		return AngleUnitRadian, nil
+	}
+	for _, valid := range validAngleUnits {
+		if unit == valid {
+			return unit, nil
+		}
+	}
+	return "", fmt.Errorf("无效的angleUnit: %s，支持的取值: %v", unit, validAngleUnits)
+}
+
+// presentReadings 生成响应中的可读数据：方向读数按请求的单位补全姿态信息和偏航/俯仰/横滚角。
+// 保存的数据可能被并发读取，需要修改的读数先复制
+func presentReadings(readings []HumanReadableSensorData, angleUnit string) []HumanReadableSensorData {
+	presented := make([]HumanReadableSensorData, len(readings))
+	for i, reading := range readings {
+		if reading.Orientation != nil {
+			reading.Orientation = reading.Orientation.Resolve(angleUnit)
+			reading.Values = orientationValues(append([]SensorValue(nil), reading.Values...), reading.Orientation)
+		}
+		presented[i] = reading
+	}
+	return presented
+}
+
+// radToDeg 弧度转换为度
+func radToDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// orientationValues 用姿态信息中的偏航/俯仰/横滚角替换或补全可读数据中的对应值
+func orientationValues(values []SensorValue, orientation *OrientationData) []SensorValue {
+	descriptor, _ := sensorRegistry.Lookup("orientation")
+	angles := []struct {
+		key   string
+		value float64
+	}{
+		{"yaw", orientation.Yaw},
+		{"pitch", orientation.Pitch},
+		{"roll", orientation.Roll},
+	}
+
+	for _, angle := range angles {
+		field := SensorField{Key: angle.key, Label: angle.key, Precision: 6}
+		for _, f := range descriptor.Fields {
+			if f.Key == angle.key {
+				field = f
+				break
+			}
+		}
+
+		value := SensorValue{
+			Key:         field.Key,
+			Name:        field.Label,
+			Value:       fmt.Sprintf("%.*f", field.Precision, angle.value),
+			Unit:        "rad",
+			Description: field.Description,
+		}
+		if orientation.AngleUnit == AngleUnitDegree {
+			value.Value = fmt.Sprintf("%.*f", degreePrecision, angle.value)
+			value.Unit = "度"
+		}
+		if orientation.Derived {
+			value.Description += "（由四元数计算）"
+		}
+
+		replaced := false
+		for i := range values {
+			if values[i].Key == angle.key {
+				values[i] = value
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			values = append(values, value)
+		}
+	}
+	return values
+}
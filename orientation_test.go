@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// approx 判断两个浮点数是否近似相等
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestQuaternionEulerAngles(t *testing.T) {
+	// 绕Z轴旋转90度
+	half := math.Pi / 4
+	q := Quaternion{W: math.Cos(half), Z: math.Sin(half)}
+	yaw, pitch, roll := q.EulerAngles()
+	if !approx(yaw, math.Pi/2) || !approx(pitch, 0) || !approx(roll, 0) {
+		t.Errorf("欧拉角不正确: yaw=%v pitch=%v roll=%v", yaw, pitch, roll)
+	}
+
+	matrix := q.RotationMatrix()
+	expected := [3][3]float64{{0, -1, 0}, {1, 0, 0}, {0, 0, 1}}
+	for i := range matrix {
+		for j := range matrix[i] {
+			if !approx(matrix[i][j], expected[i][j]) {
+				t.Fatalf("旋转矩阵不正确: %v", matrix)
+			}
+		}
+	}
+
+	// 未归一化的四元数按归一化后计算
+	scaled := Quaternion{W: 2 * q.W, Z: 2 * q.Z}
+	if yaw, _, _ := scaled.EulerAngles(); !approx(yaw, math.Pi/2) {
+		t.Errorf("未归一化的四元数应先归一化: %v", yaw)
+	}
+}
+
+func TestNewOrientationData(t *testing.T) {
+	half := math.Pi / 8
+	values := map[string]interface{}{"qw": math.Cos(half), "qx": math.Sin(half), "qy": 0.0, "qz": 0.0}
+
+	// 缺少yaw/pitch/roll时由四元数计算
+	stored := newOrientationData(values)
+	if stored == nil || stored.Reported != nil {
+		t.Fatalf("保存的姿态信息不正确: %+v", stored)
+	}
+	data := stored.Resolve(AngleUnitRadian)
+	if !data.Derived || !approx(data.Roll, math.Pi/4) || data.Unnormalized || data.AngleUnit != AngleUnitRadian {
+		t.Fatalf("计算的姿态不正确: %+v", data)
+	}
+
+	// 读数中的yaw/pitch/roll优先，按弧度保存，按请求的单位显示
+	values["yaw"], values["pitch"], values["roll"] = 0.1, -0.2, math.Pi/4
+	stored = newOrientationData(values)
+	if stored.Reported == nil || stored.Reported.Roll != math.Pi/4 {
+		t.Fatalf("应保存读数中的角度: %+v", stored)
+	}
+	data = stored.Resolve(AngleUnitDegree)
+	if data.Derived || data.AngleUnit != AngleUnitDegree || !approx(data.Roll, 45) || !approx(data.Yaw, radToDeg(0.1)) {
+		t.Errorf("应使用读数中的角度: %+v", data)
+	}
+	if stored.Yaw != 0 || stored.AngleUnit != "" {
+		t.Error("Resolve不应修改保存的姿态信息")
+	}
+
+	if newOrientationData(map[string]interface{}{"yaw": 0.1}) != nil {
+		t.Error("没有四元数时不应生成姿态信息")
+	}
+	if newOrientationData(map[string]interface{}{"qw": 0.0, "qx": 0.0, "qy": 0.0, "qz": 0.0}) != nil {
+		t.Error("模为0的四元数无效")
+	}
+}
+
+func TestOrientationStoredFields(t *testing.T) {
+	stored := parseToHumanReadable(SensorReading{
+		Name:   "orientation",
+		Time:   1751729987123456000,
+		Values: map[string]interface{}{"qw": 1.0, "qx": 0.0, "qy": 0.0, "qz": 0.0},
+	})
+
+	// 数据库中只保存四元数和读数中的角度
+	raw, err := bson.Marshal(stored.Orientation)
+	if err != nil {
+		t.Fatalf("序列化失败: %v", err)
+	}
+	var fields bson.M
+	bson.Unmarshal(raw, &fields)
+	if len(fields) != 1 || fields["quaternion"] == nil {
+		t.Errorf("保存的字段不正确: %v", fields)
+	}
+	for _, value := range stored.Values {
+		if value.Key == "yaw" {
+			t.Errorf("保存的可读数据不应包含计算的角度: %+v", value)
+		}
+	}
+}
+
+func TestPresentOrientationReading(t *testing.T) {
+	reading := SensorReading{
+		Name:   "orientation",
+		Time:   1751729987123456000,
+		Values: map[string]interface{}{"qw": 0.9238795325, "qx": 0.0, "qy": 0.0, "qz": 0.3826834324},
+	}
+	stored := []HumanReadableSensorData{parseToHumanReadable(reading)}
+
+	result := presentReadings(stored, AngleUnitDegree)[0]
+	if result.Orientation == nil || !result.Orientation.Derived {
+		t.Fatalf("缺少姿态信息: %+v", result)
+	}
+
+	values := make(map[string]SensorValue)
+	for _, value := range result.Values {
+		values[value.Key] = value
+	}
+	if len(result.Values) != 7 {
+		t.Errorf("期望四元数和三个欧拉角共7个值，实际为%d", len(result.Values))
+	}
+	if yaw := values["yaw"]; yaw.Value != "45.00" || yaw.Unit != "度" || yaw.Name != "偏航角" {
+		t.Errorf("偏航角不正确: %+v", yaw)
+	}
+	if len(stored[0].Values) != 4 || stored[0].Orientation.Derived {
+		t.Error("生成响应不应修改保存的数据")
+	}
+
+	// 读数中的角度按弧度原样显示
+	reading.Values["yaw"], reading.Values["pitch"], reading.Values["roll"] = 0.785398, 0.0, 0.0
+	result = presentReadings([]HumanReadableSensorData{parseToHumanReadable(reading)}, AngleUnitRadian)[0]
+	for _, value := range result.Values {
+		if value.Key == "yaw" && (value.Value != "0.785398" || value.Unit != "rad") {
+			t.Errorf("偏航角不正确: %+v", value)
+		}
+	}
+
+	// 其他传感器没有姿态信息
+	if other := parseToHumanReadable(SensorReading{Name: "accelerometer", Values: map[string]interface{}{"x": 1.0}}); other.Orientation != nil {
+		t.Error("只有方向传感器有姿态信息")
+	}
+}
+
+func TestRequestAngleUnit(t *testing.T) {
+	for target, expected := range map[string]string{"/api/data": AngleUnitRadian, "/api/data?angleUnit=DEG": AngleUnitDegree} {
+		if unit, err := requestAngleUnit(httptest.NewRequest(http.MethodGet, target, nil)); err != nil || unit != expected {
+			t.Errorf("%s: 期望%s，实际为%s %v", target, expected, unit, err)
+		}
+	}
+	if _, err := requestAngleUnit(httptest.NewRequest(http.MethodGet, "/api/data?angleUnit=grad", nil)); err == nil {
+		t.Error("无效的单位应返回错误")
+	}
+
+	rr := httptest.NewRecorder()
+	handleAPIData(rr, httptest.NewRequest(http.MethodGet, "/api/data?angleUnit=grad", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("无效的angleUnit期望400，实际为%d", rr.Code)
+	}
+}
@@ -1,20 +1,24 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
 	"sort"
-	"strings"
 	"time"
 )
 
 // parseSensorMessage 解析传感器消息
 func parseSensorMessage(data []byte) (*ParsedSensorData, error) {
-	var message SensorMessage
-	if err := json.Unmarshal(data, &message); err != nil {
+	message, err := decodeSensorMessage(bytes.NewReader(data), 0)
+	if err != nil {
 		return nil, err
 	}
 
+	return buildParsedData(message), nil
+}
+
+// buildParsedData 由已解码的传感器消息生成解析结果
+func buildParsedData(message *SensorMessage) *ParsedSensorData {
 	parsed := &ParsedSensorData{
 		MessageID:      message.MessageID,
 		SessionID:      message.SessionID,
@@ -23,6 +27,7 @@ func parseSensorMessage(data []byte) (*ParsedSensorData, error) {
 		SensorCounts:   make(map[string]int),
 		ParsedReadings: make([]HumanReadableSensorData, 0),
 		ReceivedAt:     time.Now(),
+		Payload:        message.Payload,
 	}
 
 	// 统计传感器类型
@@ -46,8 +51,25 @@ func parseSensorMessage(data []byte) (*ParsedSensorData, error) {
 			}
 		}
 
+		// 标注事件同时作为会话标记
+		if sensorKey(reading.Name) == "annotation" {
+			parsed.Markers = append(parsed.Markers, newSessionMarker(reading))
+		}
+
 		// 解析为人类可读格式
 		humanReadable := parseToHumanReadable(reading)
+
+		// 水平精度超过阈值的定位：drop模式下不进入可读数据（原始读数照常保存），flag模式下标记
+		if isLocationReading(reading) {
+			if _, low := lowAccuracyReason(reading.Values, AppConfig.LocationMaxHorizontalAccuracy); low {
+				parsed.LowAccuracyFixes++
+				if AppConfig.LocationAccuracyMode == LocationAccuracyDrop {
+					continue
+				}
+				humanReadable.LowAccuracy = true
+			}
+		}
+
 		parsed.ParsedReadings = append(parsed.ParsedReadings, humanReadable)
 	}
 
@@ -63,7 +85,7 @@ func parseSensorMessage(data []byte) (*ParsedSensorData, error) {
 		End:   time.Unix(0, maxTime),
 	}
 
-	return parsed, nil
+	return parsed
 }
 
 // parseToHumanReadable 将传感器读数转换为人类可读格式
@@ -78,157 +100,46 @@ func parseToHumanReadable(reading SensorReading) HumanReadableSensorData {
 		Accuracy:     getAccuracyDescription(reading.Accuracy),
 	}
 
-	// 根据传感器类型解析值
-	switch strings.ToLower(reading.Name) {
-	case "accelerometer":
-		result.Values = parseAccelerometer(reading.Values)
-	case "gyroscope":
-		result.Values = parseGyroscope(reading.Values)
-	case "magnetometer":
-		result.Values = parseMagnetometer(reading.Values)
-	case "gravity":
-		result.Values = parseGravity(reading.Values)
-	case "orientation":
-		result.Values = parseOrientation(reading.Values)
-	case "compass":
-		result.Values = parseCompass(reading.Values)
-	case "pedometer":
-		result.Values = parsePedometer(reading.Values)
-	case "magnetometeruncalibrated":
-		result.Values = parseMagnetometerUncalibrated(reading.Values)
-	case "location":
-		result.Values = parseLocation(reading.Values)
-	case "barometer":
-		result.Values = parseBarometer(reading.Values)
-	default:
-		result.Values = parseGeneric(reading.Values)
-	}
-
-	return result
-}
+	// 按传感器描述解析值
+	result.Values = sensorRegistry.ParseValues(reading.Name, reading.Values)
 
-// parseAccelerometer 解析加速度计数据
-func parseAccelerometer(values map[string]interface{}) []SensorValue {
-	return []SensorValue{
-		{Name: "X轴加速度", Value: fmt.Sprintf("%.6f", getFloat64(values["x"])), Unit: "m/s²", Description: "X轴方向的加速度"},
-		{Name: "Y轴加速度", Value: fmt.Sprintf("%.6f", getFloat64(values["y"])), Unit: "m/s²", Description: "Y轴方向的加速度"},
-		{Name: "Z轴加速度", Value: fmt.Sprintf("%.6f", getFloat64(values["z"])), Unit: "m/s²", Description: "Z轴方向的加速度"},
+	// 方向传感器：保存四元数和读数中的角度，偏航/俯仰/横滚角在返回响应时按请求的单位补全
+	if sensorKey(reading.Name) == "orientation" {
+		result.Orientation = newOrientationData(reading.Values)
 	}
-}
-
-// parseGyroscope 解析陀螺仪数据
-func parseGyroscope(values map[string]interface{}) []SensorValue {
-	return []SensorValue{
-		{Name: "X轴角速度", Value: fmt.Sprintf("%.6f", getFloat64(values["x"])), Unit: "rad/s", Description: "绕X轴的角速度"},
-		{Name: "Y轴角速度", Value: fmt.Sprintf("%.6f", getFloat64(values["y"])), Unit: "rad/s", Description: "绕Y轴的角速度"},
-		{Name: "Z轴角速度", Value: fmt.Sprintf("%.6f", getFloat64(values["z"])), Unit: "rad/s", Description: "绕Z轴的角速度"},
-	}
-}
 
-// parseMagnetometer 解析磁力计数据
-func parseMagnetometer(values map[string]interface{}) []SensorValue {
-	if bearing, ok := values["magneticBearing"]; ok {
-		return []SensorValue{
-			{Name: "磁方位角", Value: fmt.Sprintf("%.2f", getFloat64(bearing)), Unit: "度", Description: "相对于磁北的方位角"},
-		}
-	}
-	return []SensorValue{
-		{Name: "X轴磁场", Value: fmt.Sprintf("%.6f", getFloat64(values["x"])), Unit: "μT", Description: "X轴方向的磁场强度"},
-		{Name: "Y轴磁场", Value: fmt.Sprintf("%.6f", getFloat64(values["y"])), Unit: "μT", Description: "Y轴方向的磁场强度"},
-		{Name: "Z轴磁场", Value: fmt.Sprintf("%.6f", getFloat64(values["z"])), Unit: "μT", Description: "Z轴方向的磁场强度"},
-	}
-}
-
-// parseGravity 解析重力传感器数据
-func parseGravity(values map[string]interface{}) []SensorValue {
-	return []SensorValue{
-		{Name: "X轴重力", Value: fmt.Sprintf("%.6f", getFloat64(values["x"])), Unit: "m/s²", Description: "X轴方向的重力分量"},
-		{Name: "Y轴重力", Value: fmt.Sprintf("%.6f", getFloat64(values["y"])), Unit: "m/s²", Description: "Y轴方向的重力分量"},
-		{Name: "Z轴重力", Value: fmt.Sprintf("%.6f", getFloat64(values["z"])), Unit: "m/s²", Description: "Z轴方向的重力分量"},
-	}
-}
-
-// parseOrientation 解析方向传感器数据
-func parseOrientation(values map[string]interface{}) []SensorValue {
-	result := make([]SensorValue, 0)
-	if qw, ok := values["qw"]; ok {
-		result = append(result, SensorValue{Name: "四元数W", Value: fmt.Sprintf("%.6f", getFloat64(qw)), Unit: "", Description: "四元数W分量"})
-	}
-	if qx, ok := values["qx"]; ok {
-		result = append(result, SensorValue{Name: "四元数X", Value: fmt.Sprintf("%.6f", getFloat64(qx)), Unit: "", Description: "四元数X分量"})
-	}
-	if qy, ok := values["qy"]; ok {
-		result = append(result, SensorValue{Name: "四元数Y", Value: fmt.Sprintf("%.6f", getFloat64(qy)), Unit: "", Description: "四元数Y分量"})
-	}
-	if qz, ok := values["qz"]; ok {
-		result = append(result, SensorValue{Name: "四元数Z", Value: fmt.Sprintf("%.6f", getFloat64(qz)), Unit: "", Description: "四元数Z分量"})
-	}
 	return result
 }
 
-// parseCompass 解析指南针数据
-func parseCompass(values map[string]interface{}) []SensorValue {
-	return []SensorValue{
-		{Name: "指南针方位", Value: fmt.Sprintf("%.2f", getFloat64(values["magneticBearing"])), Unit: "度", Description: "指南针方位角"},
-	}
-}
-
-// parsePedometer 解析计步器数据
-func parsePedometer(values map[string]interface{}) []SensorValue {
-	return []SensorValue{
-		{Name: "步数", Value: fmt.Sprintf("%.0f", getFloat64(values["steps"])), Unit: "步", Description: "累计步数"},
-	}
-}
-
-// parseMagnetometerUncalibrated 解析未校准磁力计数据
-func parseMagnetometerUncalibrated(values map[string]interface{}) []SensorValue {
-	return []SensorValue{
-		{Name: "X轴磁场(未校准)", Value: fmt.Sprintf("%.6f", getFloat64(values["x"])), Unit: "μT", Description: "X轴方向的未校准磁场强度"},
-		{Name: "Y轴磁场(未校准)", Value: fmt.Sprintf("%.6f", getFloat64(values["y"])), Unit: "μT", Description: "Y轴方向的未校准磁场强度"},
-		{Name: "Z轴磁场(未校准)", Value: fmt.Sprintf("%.6f", getFloat64(values["z"])), Unit: "μT", Description: "Z轴方向的未校准磁场强度"},
+// newSessionMarker 由标注读数生成会话标记
+func newSessionMarker(reading SensorReading) SessionMarker {
+	marker := SessionMarker{
+		Time:   time.Unix(0, reading.Time),
+		Values: reading.Values,
 	}
-}
-
-// parseLocation 解析位置数据
-func parseLocation(values map[string]interface{}) []SensorValue {
-	result := make([]SensorValue, 0)
-	if lat, ok := values["latitude"]; ok {
-		result = append(result, SensorValue{Name: "纬度", Value: fmt.Sprintf("%.8f", getFloat64(lat)), Unit: "度", Description: "地理纬度"})
-	}
-	if lng, ok := values["longitude"]; ok {
-		result = append(result, SensorValue{Name: "经度", Value: fmt.Sprintf("%.8f", getFloat64(lng)), Unit: "度", Description: "地理经度"})
+	if text, ok := reading.Values["text"].(string); ok {
+		marker.Text = text
 	}
-	if alt, ok := values["altitude"]; ok {
-		result = append(result, SensorValue{Name: "海拔", Value: fmt.Sprintf("%.2f", getFloat64(alt)), Unit: "米", Description: "海拔高度"})
+	if duration, ok := toNumber(reading.Values["millisecond_press_duration"]); ok {
+		marker.Duration = int64(duration)
 	}
-	if speed, ok := values["speed"]; ok {
-		result = append(result, SensorValue{Name: "速度", Value: fmt.Sprintf("%.2f", getFloat64(speed)), Unit: "m/s", Description: "移动速度"})
-	}
-	if bearing, ok := values["bearing"]; ok {
-		result = append(result, SensorValue{Name: "方位角", Value: fmt.Sprintf("%.2f", getFloat64(bearing)), Unit: "度", Description: "移动方位角"})
-	}
-	return result
+	return marker
 }
 
-// parseBarometer 解析气压计数据
-func parseBarometer(values map[string]interface{}) []SensorValue {
-	result := make([]SensorValue, 0)
-	if pressure, ok := values["pressure"]; ok {
-		result = append(result, SensorValue{Name: "气压", Value: fmt.Sprintf("%.2f", getFloat64(pressure)), Unit: "hPa", Description: "大气压力"})
-	}
-	if altitude, ok := values["altitude"]; ok {
-		result = append(result, SensorValue{Name: "气压高度", Value: fmt.Sprintf("%.2f", getFloat64(altitude)), Unit: "米", Description: "基于气压计算的高度"})
+// parseGeneric 解析通用传感器数据（按键名排序，结果稳定）
+func parseGeneric(values map[string]interface{}) []SensorValue {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
 	}
-	return result
-}
+	sort.Strings(keys)
 
-// parseGeneric 解析通用传感器数据
-func parseGeneric(values map[string]interface{}) []SensorValue {
-	result := make([]SensorValue, 0)
-	for key, value := range values {
+	result := make([]SensorValue, 0, len(keys))
+	for _, key := range keys {
 		result = append(result, SensorValue{
+			Key:         key,
 			Name:        key,
-			Value:       fmt.Sprintf("%v", value),
+			Value:       fmt.Sprintf("%v", values[key]),
 			Unit:        "",
 			Description: fmt.Sprintf("%s数值", key),
 		})
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 被限流请求的滚动统计窗口（按分钟分桶）
+const (
+	throttleWindowBuckets = 60
+	throttleBucketWidth   = time.Minute
+)
+
+// 空闲限流桶的清理间隔
+const rateLimitSweepInterval = time.Minute
+
+// 全局入库限流器（未创建时不限流）
+var ingestRateLimiter *IngestRateLimiter
+
+// RateLimit 令牌桶限流参数：每秒补充Rate个令牌，最多累积Burst个。Rate为0表示不限流
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Unlimited 是否不限流
+func (l RateLimit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// tokenBucket 单个键的令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter 按键（设备ID或IP）限流的令牌桶集合，支持为单个键覆盖限流参数
+type RateLimiter struct {
+	limit     RateLimit
+	overrides map[string]RateLimit
+
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter 创建限流器
+func NewRateLimiter(limit RateLimit, overrides map[string]RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limit:     limit,
+		overrides: overrides,
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// limitFor 键适用的限流参数
+func (l *RateLimiter) limitFor(key string) RateLimit {
+	if limit, ok := l.overrides[key]; ok {
+		return limit
+	}
+	return l.limit
+}
+
+// Allow 消耗一个令牌；令牌不足时返回false和下一个令牌可用前需要等待的时间
+func (l *RateLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	limit := l.limitFor(key)
+	if limit.Unlimited() {
+		return true, 0
+	}
+	burst := float64(max(limit.Burst, 1))
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sweep(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = bucket
+	}
+	if elapsed := now.Sub(bucket.last).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(burst, bucket.tokens+elapsed*limit.Rate)
+		bucket.last = now
+	}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep 删除已经补满的桶（与新建的桶等价），避免为大量键长期占用内存
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, bucket := range l.buckets {
+		limit := l.limitFor(key)
+		if limit.Unlimited() || bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate >= float64(max(limit.Burst, 1)) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len 当前跟踪的键数量
+func (l *RateLimiter) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.buckets)
+}
+
+// throttleCounter 按分钟分桶的滚动计数，统计最近一小时被限流的请求
+type throttleCounter struct {
+	buckets [throttleWindowBuckets]int64
+	minutes [throttleWindowBuckets]int64 // 每个桶对应的分钟序号，用于判断桶是否过期
+	last    time.Time
+}
+
+// add 记录一次限流
+func (c *throttleCounter) add(now time.Time) {
+	minute := now.UnixNano() / int64(throttleBucketWidth)
+	i := minute % throttleWindowBuckets
+	if c.minutes[i] != minute {
+		c.minutes[i], c.buckets[i] = minute, 0
+	}
+	c.buckets[i]++
+	c.last = now
+}
+
+// total 窗口内的限流次数
+func (c *throttleCounter) total(now time.Time) int64 {
+	minute := now.UnixNano() / int64(throttleBucketWidth)
+	var total int64
+	for i := range c.buckets {
+		if minute-c.minutes[i] < throttleWindowBuckets {
+			total += c.buckets[i]
+		}
+	}
+	return total
+}
+
+// ThrottleInfo 设备最近被限流的情况
+type ThrottleInfo struct {
+	Requests        int64     `json:"requests"` // 最近一小时被限流的请求数
+	LastThrottledAt time.Time `json:"lastThrottledAt"`
+}
+
+// RateLimitStats 限流统计
+type RateLimitStats struct {
+	Device          RateLimit `json:"device"`
+	IP              RateLimit `json:"ip"`
+	DeviceOverrides int       `json:"deviceOverrides"`
+	ThrottledDevice int64     `json:"throttledDevice"` // 按设备限流的请求总数
+	ThrottledIP     int64     `json:"throttledIp"`     // 按IP限流的请求总数
+	TrackedDevices  int       `json:"trackedDevices"`
+	TrackedIPs      int       `json:"trackedIps"`
+}
+
+// RateLimitOptions 入库限流配置
+type RateLimitOptions struct {
+	Device          RateLimit
+	IP              RateLimit
+	DeviceOverrides map[string]RateLimit
+}
+
+// IngestRateLimiter 数据接收接口的限流：先按来源IP限流（解析请求体之前），
+// 解析出deviceId后再按设备限流。被限流的请求返回429和Retry-After
+type IngestRateLimiter struct {
+	opts    RateLimitOptions
+	devices *RateLimiter
+	ips     *RateLimiter
+
+	mutex           sync.Mutex
+	throttled       map[string]*throttleCounter // 设备ID -> 最近被限流的次数
+	throttledDevice int64
+	throttledIP     int64
+}
+
+// NewIngestRateLimiter 创建入库限流器
+func NewIngestRateLimiter(opts RateLimitOptions) *IngestRateLimiter {
+	return &IngestRateLimiter{
+		opts:      opts,
+		devices:   NewRateLimiter(opts.Device, opts.DeviceOverrides),
+		ips:       NewRateLimiter(opts.IP, nil),
+		throttled: make(map[string]*throttleCounter),
+	}
+}
+
+// AllowIP 按来源IP限流
+func (l *IngestRateLimiter) AllowIP(remoteAddr string) (bool, time.Duration) {
+	allowed, wait := l.ips.Allow(remoteIP(remoteAddr), time.Now())
+	if !allowed {
+		l.mutex.Lock()
+		l.throttledIP++
+		l.mutex.Unlock()
+	}
+	return allowed, wait
+}
+
+// AllowDevice 按设备限流，被限流时计入设备的滚动统计
+func (l *IngestRateLimiter) AllowDevice(deviceID string) (bool, time.Duration) {
+	now := time.Now()
+	allowed, wait := l.devices.Allow(deviceID, now)
+	if !allowed {
+		l.mutex.Lock()
+		l.throttledDevice++
+		counter, ok := l.throttled[deviceID]
+		if !ok {
+			counter = &throttleCounter{}
+			l.throttled[deviceID] = counter
+		}
+		counter.add(now)
+		l.mutex.Unlock()
+	}
+	return allowed, wait
+}
+
+// DeviceThrottleInfo 设备最近一小时被限流的情况
+func (l *IngestRateLimiter) DeviceThrottleInfo(deviceID string) ThrottleInfo {
+	now := time.Now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	counter, ok := l.throttled[deviceID]
+	if !ok {
+		return ThrottleInfo{}
+	}
+	total := counter.total(now)
+	if total == 0 {
+		delete(l.throttled, deviceID)
+	}
+	return ThrottleInfo{Requests: total, LastThrottledAt: counter.last}
+}
+
+// Stats 限流统计
+func (l *IngestRateLimiter) Stats() RateLimitStats {
+	l.mutex.Lock()
+	throttledDevice, throttledIP := l.throttledDevice, l.throttledIP
+	l.mutex.Unlock()
+
+	return RateLimitStats{
+		Device:          l.opts.Device,
+		IP:              l.opts.IP,
+		DeviceOverrides: len(l.opts.DeviceOverrides),
+		ThrottledDevice: throttledDevice,
+		ThrottledIP:     throttledIP,
+		TrackedDevices:  l.devices.Len(),
+		TrackedIPs:      l.ips.Len(),
+	}
+}
+
+// RateLimitErrorResponse 请求被限流时的错误响应
+type RateLimitErrorResponse struct {
+	Error      string `json:"error"`
+	Scope      string `json:"scope"` // device 或 ip
+	RetryAfter int    `json:"retryAfter"`
+	Message    string `json:"message"`
+}
+
+// writeRateLimited 写入429响应，Retry-After向上取整到秒
+func writeRateLimited(w http.ResponseWriter, scope string, wait time.Duration) {
+	retryAfter := max(int(math.Ceil(wait.Seconds())), 1)
+	message := "请求过于频繁，请稍后重试"
+	if scope == "device" {
+		message = "该设备的请求过于频繁，请降低推送频率"
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeJSON(w, http.StatusTooManyRequests, RateLimitErrorResponse{
+		Error:      "rate_limited",
+		Scope:      scope,
+		RetryAfter: retryAfter,
+		Message:    message,
+	})
+}
+
+// remoteIP 取出RemoteAddr中的IP（不含端口）
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// parseRateLimit 解析 "速率" 或 "速率:突发" 形式的限流参数，未指定突发时等于速率（至少为1）
+func parseRateLimit(value string) (RateLimit, error) {
+	rateValue, burstValue, hasBurst := strings.Cut(strings.TrimSpace(value), ":")
+	rate, err := strconv.ParseFloat(rateValue, 64)
+	if err != nil || rate < 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return RateLimit{}, fmt.Errorf("无效的限流速率: %s", value)
+	}
+	limit := RateLimit{Rate: rate, Burst: max(int(math.Ceil(rate)), 1)}
+	if hasBurst {
+		burst, err := strconv.Atoi(burstValue)
+		if err != nil || burst < 1 {
+			return RateLimit{}, fmt.Errorf("无效的限流突发数量: %s", value)
+		}
+		limit.Burst = burst
+	}
+	return limit, nil
+}
+
+// parseRateLimitOverrides 解析按设备覆盖的限流参数：设备ID=速率[:突发]，逗号分隔
+func parseRateLimitOverrides(value string) (map[string]RateLimit, error) {
+	overrides := make(map[string]RateLimit)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		deviceID, limitValue, ok := strings.Cut(entry, "=")
+		deviceID = strings.TrimSpace(deviceID)
+		if !ok || deviceID == "" {
+			return nil, fmt.Errorf("无效的设备限流配置: %s（格式为 设备ID=速率[:突发]）", entry)
+		}
+		limit, err := parseRateLimit(limitValue)
+		if err != nil {
+			return nil, fmt.Errorf("设备%s: %v", deviceID, err)
+		}
+		overrides[deviceID] = limit
+	}
+	return overrides, nil
+}
+
+// formatRateLimit 限流参数的可读形式
+func formatRateLimit(limit RateLimit) string {
+	if limit.Unlimited() {
+		return "不限制"
+	}
+	return fmt.Sprintf("每秒%g次 (突发%d)", limit.Rate, limit.Burst)
+}
+
+// currentRateLimitOptions 根据配置生成入库限流参数（配置已在加载时校验）
+func currentRateLimitOptions() RateLimitOptions {
+	overrides, err := parseRateLimitOverrides(AppConfig.RateLimitDeviceOverrides)
+	if err != nil {
+		LogError("解析设备限流配置", err)
+	}
+	return RateLimitOptions{
+		Device:          RateLimit{Rate: AppConfig.RateLimitDeviceRate, Burst: AppConfig.RateLimitDeviceBurst},
+		IP:              RateLimit{Rate: AppConfig.RateLimitIPRate, Burst: AppConfig.RateLimitIPBurst},
+		DeviceOverrides: overrides,
+	}
+}
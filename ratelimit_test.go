@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{Rate: 2, Burst: 3}, map[string]RateLimit{
+		"slow":      {Rate: 0.5, Burst: 1},
+		"unlimited": {Rate: 0},
+	})
+	now := time.Date(2025, 7, 5, 15, 39, 47, 0, time.UTC)
+
+	// 突发数量用完后被限流
+	for i := 0; i < 3; i++ {
+		if allowed, _ := limiter.Allow("device", now); !allowed {
+			t.Fatalf("第%d个请求不应被限流", i+1)
+		}
+	}
+	allowed, wait := limiter.Allow("device", now)
+	if allowed || wait != 500*time.Millisecond {
+		t.Fatalf("超出突发数量应被限流并等待500ms，实际为%t %v", allowed, wait)
+	}
+
+	// 按速率补充令牌
+	if allowed, _ := limiter.Allow("device", now.Add(500*time.Millisecond)); !allowed {
+		t.Error("补充令牌后不应被限流")
+	}
+
+	// 按设备覆盖的限流参数
+	limiter.Allow("slow", now)
+	if allowed, wait := limiter.Allow("slow", now); allowed || wait != 2*time.Second {
+		t.Errorf("覆盖的限流参数未生效: %t %v", allowed, wait)
+	}
+	for i := 0; i < 100; i++ {
+		if allowed, _ := limiter.Allow("unlimited", now); !allowed {
+			t.Fatal("速率为0的设备不应被限流")
+		}
+	}
+
+	// 补满的桶被清理
+	limiter.Allow("other", now.Add(time.Hour))
+	if n := limiter.Len(); n != 1 {
+		t.Errorf("清理后期望只剩1个桶，实际为%d", n)
+	}
+}
+
+func TestParseRateLimitOverrides(t *testing.T) {
+	overrides, err := parseRateLimitOverrides(" device-a=0.5:2, device-b=10 ,device-c=0")
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	expected := map[string]RateLimit{
+		"device-a": {Rate: 0.5, Burst: 2},
+		"device-b": {Rate: 10, Burst: 10},
+		"device-c": {Rate: 0, Burst: 1},
+	}
+	for deviceID, want := range expected {
+		if got := overrides[deviceID]; got != want {
+			t.Errorf("%s: 期望%+v，实际为%+v", deviceID, want, got)
+		}
+	}
+
+	for _, invalid := range []string{"device-a", "=1", "device-a=-1", "device-a=1:0", "device-a=x"} {
+		if _, err := parseRateLimitOverrides(invalid); err == nil {
+			t.Errorf("%q 应解析失败", invalid)
+		}
+	}
+}
+
+func TestHandleSensorDataRateLimited(t *testing.T) {
+	originalLimiter, originalClient := ingestRateLimiter, mongoClient
+	originalDedup, originalPipeline := messageDeduplicator, ingestPipeline
+	originalLogging := AppConfig.EnableLogging
+	defer func() {
+		ingestRateLimiter, mongoClient = originalLimiter, originalClient
+		messageDeduplicator, ingestPipeline = originalDedup, originalPipeline
+		AppConfig.EnableLogging = originalLogging
+	}()
+	setMongoConnection(nil)
+	messageDeduplicator, ingestPipeline = NewMessageDeduplicator(100), nil
+	AppConfig.EnableLogging = false
+
+	post := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/data", bytes.NewReader(buildTestMessage(1)))
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		handleSensorData(rr, req)
+		return rr
+	}
+
+	// 按设备限流：不同的来源IP共享同一设备的配额
+	ingestRateLimiter = NewIngestRateLimiter(RateLimitOptions{
+		Device: RateLimit{Rate: 0.1, Burst: 1},
+		IP:     RateLimit{Rate: 100, Burst: 100},
+	})
+	if rr := post("10.0.0.1:5000"); rr.Code != http.StatusOK {
+		t.Fatalf("第一个请求期望200，实际为%d", rr.Code)
+	}
+	rr := post("10.0.0.2:5000")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "10" {
+		t.Fatalf("超出设备限流期望429和Retry-After: 10，实际为%d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	var response RateLimitErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || response.Scope != "device" {
+		t.Errorf("限流响应不正确: %s", rr.Body.String())
+	}
+	if info := ingestRateLimiter.DeviceThrottleInfo("decode-device"); info.Requests != 1 || info.LastThrottledAt.IsZero() {
+		t.Errorf("设备的限流统计不正确: %+v", info)
+	}
+
+	// 已认证的请求按令牌绑定的设备限流，伪造的deviceId不会消耗其他设备的配额
+	ingestRateLimiter = NewIngestRateLimiter(RateLimitOptions{
+		Device: RateLimit{Rate: 0.1, Burst: 1},
+		IP:     RateLimit{Rate: 100, Burst: 100},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/data", bytes.NewReader(buildTestMessage(1)))
+	req = req.WithContext(context.WithValue(req.Context(), deviceAuthContextKey{}, DeviceToken{ID: "token", DeviceID: "attacker"}))
+	handleSensorData(httptest.NewRecorder(), req)
+	if rr := post("10.0.0.1:5000"); rr.Code != http.StatusOK {
+		t.Errorf("消息中的设备不应被伪造的请求限流，实际为%d", rr.Code)
+	}
+
+	// 按IP限流：在解析请求体之前拒绝
+	ingestRateLimiter = NewIngestRateLimiter(RateLimitOptions{
+		Device: RateLimit{Rate: 100, Burst: 100},
+		IP:     RateLimit{Rate: 1, Burst: 1},
+	})
+	post("10.0.0.1:5000")
+	if rr := post("10.0.0.1:5001"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("同一IP超出限流期望429，实际为%d", rr.Code)
+	}
+	if rr := post("10.0.0.2:5000"); rr.Code == http.StatusTooManyRequests {
+		t.Error("其他IP不应被限流")
+	}
+	if stats := ingestRateLimiter.Stats(); stats.ThrottledIP != 1 || stats.ThrottledDevice != 0 {
+		t.Errorf("限流统计不正确: %+v", stats)
+	}
+}
+
+func TestThrottleCounterWindow(t *testing.T) {
+	var counter throttleCounter
+	now := time.Date(2025, 7, 5, 15, 0, 0, 0, time.UTC)
+
+	counter.add(now)
+	counter.add(now.Add(30 * time.Minute))
+	if total := counter.total(now.Add(30 * time.Minute)); total != 2 {
+		t.Errorf("窗口内期望2次，实际为%d", total)
+	}
+	// 一小时后最早的记录移出窗口
+	if total := counter.total(now.Add(61 * time.Minute)); total != 1 {
+		t.Errorf("期望只统计最近一小时，实际为%d", total)
+	}
+	// 复用同一个桶时重新计数
+	counter.add(now.Add(2 * time.Hour))
+	if total := counter.total(now.Add(2 * time.Hour)); total != 1 {
+		t.Errorf("过期的桶应重新计数，实际为%d", total)
+	}
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 导入时每条消息包含的默认读数数量
+const defaultImportBatchSize = 500
+
+// 汇总中最多列出的错误数量
+const maxImportErrors = 20
+
+// errMissingDeviceID 导出中没有设备ID且未指定
+var errMissingDeviceID = errors.New("Metadata.csv中没有设备ID，请指定deviceId")
+
+// RecordingMetadata Sensor Logger导出中 Metadata.csv 的内容
+type RecordingMetadata struct {
+	DeviceID           string            `json:"deviceId"`
+	DeviceName         string            `json:"deviceName,omitempty"`
+	Platform           string            `json:"platform,omitempty"`
+	AppVersion         string            `json:"appVersion,omitempty"`
+	RecordingTime      string            `json:"recordingTime,omitempty"`
+	RecordingEpochTime int64             `json:"recordingEpochTime,omitempty"` // 毫秒
+	Fields             map[string]string `json:"fields"`                       // 全部原始字段
+}
+
+// ImportOptions 导入选项
+type ImportOptions struct {
+	DeviceID  string // 覆盖 Metadata.csv 中的设备ID
+	SessionID string // 覆盖由 Metadata.csv 生成的会话ID
+	BatchSize int    // 每条消息包含的读数数量，0表示使用默认值
+
+	// Authorize 确定设备ID后、导入任何数据之前调用，返回错误时中止导入（为nil时不检查）
+	Authorize func(deviceID string) error
+}
+
+// ImportSummary 导入结果
+type ImportSummary struct {
+	SessionID    string             `json:"sessionId"`
+	DeviceID     string             `json:"deviceId"`
+	Metadata     *RecordingMetadata `json:"metadata,omitempty"`
+	Sensors      map[string]int     `json:"sensors"` // 各传感器的读数数量
+	SkippedFiles []string           `json:"skippedFiles"`
+	InvalidRows  int                `json:"invalidRows"` // 时间戳无效而跳过的行数
+	Messages     int                `json:"messages"`
+	Accepted     int                `json:"accepted"`
+	Duplicates   int                `json:"duplicates"`
+	Rejected     int                `json:"rejected"`
+	Failed       int                `json:"failed"`
+	Readings     int                `json:"readings"` // 已接收的读数总数
+	Errors       []string           `json:"errors"`
+	DurationMs   int64              `json:"durationMs"`
+}
+
+// addError 记录一条错误（只保留前若干条）
+func (s *ImportSummary) addError(format string, args ...interface{}) {
+	if len(s.Errors) < maxImportErrors {
+		s.Errors = append(s.Errors, fmt.Sprintf(format, args...))
+	}
+}
+
+// csvSensorStream 逐行读取单个传感器的CSV文件
+type csvSensorStream struct {
+	name    string
+	closer  io.Closer
+	reader  *csv.Reader
+	columns []string
+	timeCol int
+	next    SensorReading
+	done    bool
+}
+
+// openZipEntry 打开zip中的文件，解压后的大小不能超过 BULK_MAX_DECOMPRESSED_BYTES，防止压缩炸弹。
+// 先按zip头部记录的大小拒绝，读取时再用限制读取器兜底
+func openZipEntry(file *zip.File) (io.ReadCloser, error) {
+	maxBytes := AppConfig.BulkMaxDecompressedBytes
+	tooLarge := &BodyTooLargeError{Limit: "bulk_max_decompressed_bytes", Max: maxBytes}
+	if file.UncompressedSize64 > uint64(maxBytes) {
+		return nil, fmt.Errorf("%s 解压后超出限制: %w", file.Name, tooLarge)
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReadCloser{
+		reader:    rc,
+		closers:   []io.Closer{rc},
+		remaining: maxBytes,
+		limit:     tooLarge.Limit,
+		max:       maxBytes,
+	}, nil
+}
+
+// newCSVSensorStream 打开传感器CSV文件并读取表头，文件名（不含扩展名）的小写形式即传感器名称
+func newCSVSensorStream(file *zip.File) (*csvSensorStream, error) {
+	rc, err := openZipEntry(file)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(rc)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		rc.Close()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s 为空", file.Name)
+		}
+		return nil, fmt.Errorf("读取 %s 表头失败: %w", file.Name, err)
+	}
+
+	stream := &csvSensorStream{
+		name:    strings.ToLower(strings.TrimSuffix(path.Base(file.Name), path.Ext(file.Name))),
+		closer:  rc,
+		reader:  reader,
+		columns: make([]string, len(header)),
+		timeCol: -1,
+	}
+	for i, column := range header {
+		column = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		stream.columns[i] = column
+		if column == "time" {
+			stream.timeCol = i
+		}
+	}
+	if stream.timeCol < 0 {
+		rc.Close()
+		return nil, fmt.Errorf("%s 缺少time列", file.Name)
+	}
+	return stream, nil
+}
+
+// advance 读取下一条有效读数，返回跳过的无效行数；读完后 done 为true
+func (s *csvSensorStream) advance() (int, error) {
+	invalid := 0
+	for {
+		record, err := s.reader.Read()
+		if err == io.EOF {
+			s.done = true
+			return invalid, nil
+		}
+		if err != nil {
+			return invalid, err
+		}
+
+		reading, ok := s.parseRecord(record)
+		if !ok {
+			invalid++
+			continue
+		}
+		s.next = reading
+		return invalid, nil
+	}
+}
+
+// parseRecord 将一行CSV转换为读数：列名即 values 的键，数值列转换为数值，true/false转换为布尔值，空值省略
+func (s *csvSensorStream) parseRecord(record []string) (SensorReading, bool) {
+	if s.timeCol >= len(record) {
+		return SensorReading{}, false
+	}
+	timestamp, ok := parseCSVTimestamp(record[s.timeCol])
+	if !ok {
+		return SensorReading{}, false
+	}
+
+	reading := SensorReading{Name: s.name, Time: timestamp, Values: make(map[string]interface{}, len(record))}
+	for i, field := range record {
+		if i >= len(s.columns) || i == s.timeCol {
+			continue
+		}
+		column := s.columns[i]
+		field = strings.TrimSpace(field)
+		if column == "" || column == "seconds_elapsed" || field == "" {
+			continue
+		}
+		if column == "accuracy" {
+			if accuracy, err := strconv.Atoi(field); err == nil {
+				reading.Accuracy = accuracy
+				continue
+			}
+		}
+		// 与 /data 的解码一致：整数保存为int64，其他数值为float64
+		if integer, err := strconv.ParseInt(field, 10, 64); err == nil {
+			reading.Values[column] = integer
+		} else if number, err := strconv.ParseFloat(field, 64); err == nil && !math.IsNaN(number) && !math.IsInf(number, 0) {
+			reading.Values[column] = number
+		} else if field == "true" || field == "false" {
+			reading.Values[column] = field == "true"
+		} else {
+			reading.Values[column] = field
+		}
+	}
+	return reading, true
+}
+
+// parseCSVTimestamp 解析纳秒时间戳（部分导出使用科学计数法）
+func parseCSVTimestamp(field string) (int64, bool) {
+	field = strings.TrimSpace(field)
+	if timestamp, err := strconv.ParseInt(field, 10, 64); err == nil {
+		return timestamp, timestamp > 0
+	}
+	if value, err := strconv.ParseFloat(field, 64); err == nil && value > 0 && value < math.MaxInt64 {
+		return int64(value), true
+	}
+	return 0, false
+}
+
+// readRecordingMetadata 读取 Metadata.csv（表头 + 一行数据）
+func readRecordingMetadata(file *zip.File) (*RecordingMetadata, error) {
+	rc, err := openZipEntry(file)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	reader := csv.NewReader(rc)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("读取 %s 失败: %w", file.Name, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%s 缺少数据行", file.Name)
+	}
+
+	metadata := &RecordingMetadata{Fields: make(map[string]string)}
+	for i, key := range records[0] {
+		if i >= len(records[1]) {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(key, "\ufeff")))
+		value := strings.TrimSpace(records[1][i])
+		metadata.Fields[key] = value
+
+		switch key {
+		case "device id", "deviceid", "device_id":
+			metadata.DeviceID = value
+		case "device name":
+			metadata.DeviceName = value
+		case "platform":
+			metadata.Platform = value
+		case "appversion", "app version":
+			metadata.AppVersion = value
+		case "recording time":
+			metadata.RecordingTime = value
+		case "recording epoch time":
+			metadata.RecordingEpochTime, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return metadata, nil
+}
+
+// recordingSessionID 由设备ID和录制时间生成确定的会话ID（UUID格式），重复导入同一导出时可识别为重复消息
+func recordingSessionID(deviceID string, metadata *RecordingMetadata, firstReading int64) string {
+	recording := strconv.FormatInt(firstReading, 10)
+	if metadata != nil {
+		switch {
+		case metadata.RecordingEpochTime > 0:
+			recording = strconv.FormatInt(metadata.RecordingEpochTime, 10)
+		case metadata.RecordingTime != "":
+			recording = metadata.RecordingTime
+		}
+	}
+
+	sum := sha1.Sum([]byte("sensor-logger-export|" + deviceID + "|" + recording))
+	sum[6] = (sum[6] & 0x0f) | 0x50 // 版本5
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// convertRecordingExport 将Sensor Logger导出的zip转换为传感器消息：
+// 各传感器CSV按时间戳归并排序，每 BatchSize 条读数组成一条消息，messageId从0递增
+func convertRecordingExport(archive *zip.Reader, opts ImportOptions, summary *ImportSummary, emit func(*SensorMessage) error) error {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultImportBatchSize
+	}
+	if AppConfig.MaxReadingsPerMessage > 0 && batchSize > AppConfig.MaxReadingsPerMessage {
+		batchSize = AppConfig.MaxReadingsPerMessage
+	}
+
+	var metadataFile *zip.File
+	sensorFiles := make([]*zip.File, 0)
+	for _, file := range archive.File {
+		base := path.Base(file.Name)
+		switch {
+		case file.FileInfo().IsDir() || strings.HasPrefix(base, ".") || strings.HasPrefix(file.Name, "__MACOSX/"):
+			continue
+		case !strings.EqualFold(path.Ext(base), ".csv"):
+			summary.SkippedFiles = append(summary.SkippedFiles, file.Name)
+		case strings.EqualFold(base, "Metadata.csv"):
+			metadataFile = file
+		default:
+			sensorFiles = append(sensorFiles, file)
+		}
+	}
+	sort.Slice(sensorFiles, func(i, j int) bool { return sensorFiles[i].Name < sensorFiles[j].Name })
+
+	if metadataFile != nil {
+		metadata, err := readRecordingMetadata(metadataFile)
+		if err != nil {
+			return err
+		}
+		summary.Metadata = metadata
+	}
+
+	summary.DeviceID = opts.DeviceID
+	if summary.DeviceID == "" && summary.Metadata != nil {
+		summary.DeviceID = summary.Metadata.DeviceID
+	}
+	if summary.DeviceID == "" {
+		return errMissingDeviceID
+	}
+	if opts.Authorize != nil {
+		if err := opts.Authorize(summary.DeviceID); err != nil {
+			return err
+		}
+	}
+
+	// 打开各传感器CSV并读取第一条读数
+	streams := make([]*csvSensorStream, 0, len(sensorFiles))
+	defer func() {
+		for _, stream := range streams {
+			stream.closer.Close()
+		}
+	}()
+	for _, file := range sensorFiles {
+		stream, err := newCSVSensorStream(file)
+		if err != nil {
+			summary.SkippedFiles = append(summary.SkippedFiles, file.Name)
+			summary.addError("%v", err)
+			continue
+		}
+		streams = append(streams, stream)
+
+		invalid, err := stream.advance()
+		summary.InvalidRows += invalid
+		if err != nil {
+			return fmt.Errorf("读取 %s 失败: %w", file.Name, err)
+		}
+	}
+	if len(streams) == 0 {
+		return errors.New("导出中没有传感器CSV文件")
+	}
+
+	// 会话ID在读取第一条读数后确定（没有录制时间时用最早的读数时间）
+	summary.SessionID = opts.SessionID
+	if summary.SessionID == "" {
+		var first int64
+		for _, stream := range streams {
+			if !stream.done && (first == 0 || stream.next.Time < first) {
+				first = stream.next.Time
+			}
+		}
+		summary.SessionID = recordingSessionID(summary.DeviceID, summary.Metadata, first)
+	}
+
+	message := &SensorMessage{SessionID: summary.SessionID, DeviceID: summary.DeviceID, Payload: make([]SensorReading, 0, batchSize)}
+	flush := func() error {
+		if len(message.Payload) == 0 {
+			return nil
+		}
+		if err := emit(message); err != nil {
+			return err
+		}
+		message = &SensorMessage{
+			MessageID: message.MessageID + 1,
+			SessionID: summary.SessionID,
+			DeviceID:  summary.DeviceID,
+			Payload:   make([]SensorReading, 0, batchSize),
+		}
+		return nil
+	}
+
+	for {
+		var earliest *csvSensorStream
+		for _, stream := range streams {
+			if !stream.done && (earliest == nil || stream.next.Time < earliest.next.Time) {
+				earliest = stream
+			}
+		}
+		if earliest == nil {
+			break
+		}
+
+		message.Payload = append(message.Payload, earliest.next)
+		summary.Sensors[earliest.name]++
+		if len(message.Payload) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		invalid, err := earliest.advance()
+		summary.InvalidRows += invalid
+		if err != nil {
+			return fmt.Errorf("读取 %s.csv 失败: %w", earliest.name, err)
+		}
+	}
+	return flush()
+}
+
+// importRecordingExport 导入Sensor Logger导出的zip，每条消息与实时推送一样经过校验、去重和持久化
+func importRecordingExport(ctx context.Context, archive *zip.Reader, opts ImportOptions) (*ImportSummary, error) {
+	startTime := time.Now()
+	summary := &ImportSummary{
+		Sensors:      make(map[string]int),
+		SkippedFiles: make([]string, 0),
+		Errors:       make([]string, 0),
+	}
+	ingestOpts := backfillIngestOptions()
+
+	err := convertRecordingExport(archive, opts, summary, func(message *SensorMessage) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		body, err := json.Marshal(message)
+		if err != nil {
+			return fmt.Errorf("编码消息失败: %w", err)
+		}
+
+		summary.Messages++
+		outcome := ingestSensorMessage(ctx, message, body, ingestOpts)
+		switch {
+		case outcome.Rejection != nil:
+			summary.Rejected++
+			summary.addError("消息%d: %s", message.MessageID, outcome.Rejection.Message)
+		case outcome.Status == http.StatusOK && outcome.Ack.Duplicate:
+			summary.Duplicates++
+		case outcome.Status == http.StatusOK || outcome.Status == http.StatusAccepted:
+			summary.Accepted++
+			summary.Readings += outcome.Ack.ReadingsAccepted
+		default:
+			summary.Failed++
+			reason := outcome.Error
+			if outcome.Ack != nil {
+				reason = outcome.Ack.Message
+			}
+			summary.addError("消息%d: %s", message.MessageID, reason)
+		}
+		return nil
+	})
+	summary.DurationMs = time.Since(startTime).Milliseconds()
+
+	Logger.Info("导入Sensor Logger导出",
+		slog.String("device_id", summary.DeviceID),
+		slog.String("session_id", summary.SessionID),
+		slog.Int("messages", summary.Messages),
+		slog.Int("accepted", summary.Accepted),
+		slog.Int("duplicates", summary.Duplicates),
+		slog.Int("rejected", summary.Rejected),
+		slog.Int("failed", summary.Failed),
+		slog.Int("invalid_rows", summary.InvalidRows))
+	return summary, err
+}
+
+// handleRecordingImport 上传并导入Sensor Logger导出的zip（请求体为zip文件）
+// 查询参数 deviceId/sessionId 可覆盖导出中的设备和会话，batchSize 指定每条消息的读数数量
+func handleRecordingImport(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusMethodNotAllowed, time.Since(startTime))
+		return
+	}
+
+	opts := ImportOptions{
+		DeviceID:  r.URL.Query().Get("deviceId"),
+		SessionID: r.URL.Query().Get("sessionId"),
+	}
+	if batchSize := r.URL.Query().Get("batchSize"); batchSize != "" {
+		size, err := strconv.Atoi(batchSize)
+		if err != nil || size < 1 {
+			http.Error(w, "无效的batchSize参数", http.StatusBadRequest)
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+			return
+		}
+		opts.BatchSize = size
+	}
+
+	// 使用设备令牌时导入的数据归属令牌绑定的设备
+	if token, ok := authenticatedDeviceToken(r.Context()); ok {
+		if opts.DeviceID == "" {
+			opts.DeviceID = token.DeviceID
+		} else if checkDeviceBinding(r.Context(), opts.DeviceID) != nil {
+			http.Error(w, errDeviceMismatch.Error(), http.StatusForbidden)
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusForbidden, time.Since(startTime))
+			return
+		}
+	}
+
+	// 启用签名校验时在读取请求体的同时计算摘要；请求体在导入前已完整写入临时文件，
+	// 因此可以像 /data 一样校验整个请求体的签名
+	var signatureErr error
+	if AppConfig.SignatureMode != SignatureOff {
+		digest := newBodyDigest(r)
+		opts.Authorize = func(deviceID string) error {
+			signatureErr = checkRequestSignature(r, deviceID, digest)
+			return signatureErr
+		}
+	}
+
+	// zip需要随机访问，先将请求体写入临时文件
+	bodyReader, err := newLimitedBodyReader(r, bodyLimits{
+		BodyLimit:         "bulk_max_body_bytes",
+		MaxBody:           AppConfig.BulkMaxBodyBytes,
+		DecompressedLimit: "bulk_max_decompressed_bytes",
+		MaxDecompressed:   AppConfig.BulkMaxDecompressedBytes,
+	})
+	if err != nil {
+		status, message := http.StatusBadRequest, "解压请求体失败"
+		if errors.Is(err, errUnsupportedEncoding) {
+			status, message = http.StatusUnsupportedMediaType, "不支持的内容编码"
+		}
+		http.Error(w, message, status)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, status, time.Since(startTime))
+		return
+	}
+	defer bodyReader.Close()
+
+	tmpFile, err := os.CreateTemp("", "sensor-logger-import-*.zip")
+	if err != nil {
+		http.Error(w, "创建临时文件失败", http.StatusInternalServerError)
+		LogError("创建导入临时文件", err)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusInternalServerError, time.Since(startTime))
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	size, err := io.Copy(tmpFile, bodyReader)
+	if err != nil {
+		var tooLarge *BodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, newLimitErrorResponse(tooLarge))
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusRequestEntityTooLarge, time.Since(startTime))
+			return
+		}
+		http.Error(w, "读取请求体失败", http.StatusBadRequest)
+		LogError("读取导入文件", err, slog.String("remote_addr", r.RemoteAddr))
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+		return
+	}
+
+	archive, err := zip.NewReader(tmpFile, size)
+	if err != nil {
+		http.Error(w, "无效的zip文件", http.StatusBadRequest)
+		LogError("打开导入文件", err, slog.String("remote_addr", r.RemoteAddr))
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+		return
+	}
+
+	summary, err := importRecordingExport(r.Context(), archive, opts)
+	if signatureErr != nil {
+		w.Header().Set("WWW-Authenticate", `HMAC realm="ingest"`)
+		writeJSON(w, http.StatusUnauthorized, SignatureErrorResponse{Error: "invalid_signature", Message: signatureErr.Error()})
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusUnauthorized, time.Since(startTime))
+		return
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *BodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		if summary.Messages > 0 {
+			// 已导入部分消息，汇总中说明中止原因
+			status = http.StatusOK
+		}
+		summary.addError("导入中止: %v", err)
+		LogError("导入Sensor Logger导出", err, slog.String("remote_addr", r.RemoteAddr))
+		writeJSON(w, status, summary)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, status, time.Since(startTime))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, summary)
+	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// buildRecordingExport 生成一个Sensor Logger导出的zip
+func buildRecordingExport(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, content := range files {
+		file, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("创建zip条目失败: %v", err)
+		}
+		file.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("生成zip失败: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// testRecordingFiles 一次包含加速度计和位置数据的录制
+var testRecordingFiles = map[string]string{
+	"Metadata.csv": "version,device name,recording epoch time,recording time,platform,appVersion,device id,sensors\n" +
+		"3,Pixel,1600000000000,2020-09-13_12-26-40,android,1.30.0,export-device,Accelerometer|Location\n",
+	"Accelerometer.csv": "time,seconds_elapsed,z,y,x\n" +
+		"1600000000000000000,0,9.8,0.1,0.2\n" +
+		"1600000000200000000,0.2,9.7,0.1,0.2\n" +
+		"bad,0.3,9.7,0.1,0.2\n" +
+		"1600000000400000000,0.4,9.6,0.1,0.2\n",
+	"Location.csv": "time,seconds_elapsed,bearingAccuracy,speedAccuracy,verticalAccuracy,horizontalAccuracy,speed,bearing,altitude,longitude,latitude\n" +
+		"1600000000100000000,0.1,,,3,5,1.5,90,12.5,139.7,35.6\n",
+	"Annotation.txt": "ignored",
+}
+
+func TestConvertRecordingExport(t *testing.T) {
+	data := buildRecordingExport(t, testRecordingFiles)
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("打开zip失败: %v", err)
+	}
+
+	summary := &ImportSummary{Sensors: make(map[string]int)}
+	var messages []SensorMessage
+	err = convertRecordingExport(archive, ImportOptions{BatchSize: 3}, summary, func(message *SensorMessage) error {
+		messages = append(messages, *message)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+
+	if summary.DeviceID != "export-device" || summary.SessionID == "" {
+		t.Errorf("设备或会话不正确: %+v", summary)
+	}
+	if summary.InvalidRows != 1 || summary.Sensors["accelerometer"] != 3 || summary.Sensors["location"] != 1 {
+		t.Errorf("读数统计不正确: %+v", summary)
+	}
+	if len(summary.SkippedFiles) != 1 {
+		t.Errorf("期望跳过1个非CSV文件，实际为%v", summary.SkippedFiles)
+	}
+
+	// 4条读数按时间归并，每3条一条消息
+	if len(messages) != 2 || len(messages[0].Payload) != 3 || len(messages[1].Payload) != 1 {
+		t.Fatalf("消息划分不正确: %+v", messages)
+	}
+	if messages[0].MessageID != 0 || messages[1].MessageID != 1 {
+		t.Errorf("messageId应从0递增")
+	}
+	names := []string{messages[0].Payload[0].Name, messages[0].Payload[1].Name, messages[0].Payload[2].Name}
+	if names[0] != "accelerometer" || names[1] != "location" || names[2] != "accelerometer" {
+		t.Errorf("读数未按时间排序: %v", names)
+	}
+
+	location := messages[0].Payload[1]
+	if location.Values["latitude"] != 35.6 || location.Values["longitude"] != 139.7 {
+		t.Errorf("位置数据映射不正确: %v", location.Values)
+	}
+	if _, ok := location.Values["bearingAccuracy"]; ok {
+		t.Error("空值列应省略")
+	}
+	if _, ok := location.Values["seconds_elapsed"]; ok {
+		t.Error("seconds_elapsed不应出现在values中")
+	}
+
+	// 同一导出的会话ID应保持不变，便于重复导入时去重
+	if again := recordingSessionID("export-device", summary.Metadata, 0); again != summary.SessionID {
+		t.Errorf("会话ID不确定: %s != %s", again, summary.SessionID)
+	}
+}
+
+func TestConvertRecordingExportRequiresDevice(t *testing.T) {
+	data := buildRecordingExport(t, map[string]string{
+		"Accelerometer.csv": "time,seconds_elapsed,z,y,x\n1600000000000000000,0,9.8,0.1,0.2\n",
+	})
+	archive, _ := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+
+	summary := &ImportSummary{Sensors: make(map[string]int)}
+	err := convertRecordingExport(archive, ImportOptions{}, summary, func(*SensorMessage) error { return nil })
+	if err != errMissingDeviceID {
+		t.Errorf("缺少设备ID时期望返回errMissingDeviceID，实际为%v", err)
+	}
+}
+
+func TestHandleRecordingImport(t *testing.T) {
+	original := AppConfig
+	originalDedup, originalPipeline := messageDeduplicator, ingestPipeline
+	defer func() {
+		AppConfig = original
+		messageDeduplicator, ingestPipeline = originalDedup, originalPipeline
+	}()
+	AppConfig.DataDir = t.TempDir()
+	AppConfig.EnableFileLog = false
+	AppConfig.EnableLogging = false
+	AppConfig.MaxDataStore = 1000
+	messageDeduplicator = NewMessageDeduplicator(100)
+	ingestPipeline = nil
+
+	data := buildRecordingExport(t, testRecordingFiles)
+	post := func() ImportSummary {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/import/sensor-logger?batchSize=2", bytes.NewReader(data))
+		rr := httptest.NewRecorder()
+		handleRecordingImport(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("期望状态码200，实际为%d: %s", rr.Code, rr.Body.String())
+		}
+		var summary ImportSummary
+		if err := json.Unmarshal(rr.Body.Bytes(), &summary); err != nil {
+			t.Fatalf("解析响应失败: %v", err)
+		}
+		return summary
+	}
+
+	summary := post()
+	if summary.Messages != 2 || summary.Accepted != 2 || summary.Readings != 4 {
+		t.Errorf("导入结果不正确: %+v", summary)
+	}
+
+	// 重复导入同一导出时所有消息都应识别为重复
+	if again := post(); again.Duplicates != 2 || again.Accepted != 0 {
+		t.Errorf("重复导入应全部识别为重复: %+v", again)
+	}
+}
+
+func TestHandleRecordingImportInvalidZip(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/import/sensor-logger", bytes.NewReader([]byte("not a zip")))
+	rr := httptest.NewRecorder()
+	handleRecordingImport(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("期望状态码400，实际为%d", rr.Code)
+	}
+}
+
+func TestCSVRecordBooleanValues(t *testing.T) {
+	stream := &csvSensorStream{name: "battery", columns: []string{"time", "seconds_elapsed", "batteryLevel", "batteryState", "lowPowerMode"}}
+	reading, ok := stream.parseRecord([]string{"1600000000000000000", "0", "0.87", "unplugged", "true"})
+	if !ok {
+		t.Fatal("解析失败")
+	}
+	if reading.Values["batteryLevel"] != 0.87 || reading.Values["batteryState"] != "unplugged" || reading.Values["lowPowerMode"] != true {
+		t.Errorf("值类型不正确: %v", reading.Values)
+	}
+}
+
+func TestCSVRecordIntegerValues(t *testing.T) {
+	stream := &csvSensorStream{name: "location", columns: []string{"time", "horizontalAccuracy", "latitude", "count"}}
+	reading, ok := stream.parseRecord([]string{"1751729987437545000", "5", "35.681236789012345", "9007199254740993"})
+	if !ok {
+		t.Fatal("解析失败")
+	}
+
+	// 导入的读数与 /data 推送的相同消息保存为相同的类型
+	imported, err := bson.Marshal(newSensorMessageDocument(buildParsedData(&SensorMessage{
+		MessageID: 1, SessionID: "s", DeviceID: "d", Payload: []SensorReading{reading},
+	})))
+	if err != nil {
+		t.Fatalf("序列化失败: %v", err)
+	}
+	var doc SensorMessageDocument
+	if err := bson.Unmarshal(imported, &doc); err != nil {
+		t.Fatalf("反序列化失败: %v", err)
+	}
+	values := doc.Payload[0].Values
+	if values["horizontalAccuracy"] != int64(5) || values["count"] != int64(9007199254740993) || values["latitude"] != 35.681236789012345 {
+		t.Errorf("整数列应保存为int64: %#v", values)
+	}
+
+	pushed, err := parseSensorMessage([]byte(`{"messageId":1,"sessionId":"s","deviceId":"d","payload":[` +
+		`{"name":"location","time":1751729987437545000,"values":{"horizontalAccuracy":5,"latitude":35.681236789012345,"count":9007199254740993}}]}`))
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	for key, value := range pushed.Payload[0].Values {
+		if reading.Values[key] != value {
+			t.Errorf("%s: 导入为%#v，推送为%#v", key, reading.Values[key], value)
+		}
+	}
+}
+
+func TestRecordingImportLimitsEntrySize(t *testing.T) {
+	original := AppConfig.BulkMaxDecompressedBytes
+	defer func() { AppConfig.BulkMaxDecompressedBytes = original }()
+	AppConfig.BulkMaxDecompressedBytes = 128
+
+	data := buildRecordingExport(t, map[string]string{
+		"Metadata.csv":      testRecordingFiles["Metadata.csv"] + string(bytes.Repeat([]byte("0"), 1024)),
+		"Accelerometer.csv": "time,seconds_elapsed,z,y,x\n" + string(bytes.Repeat([]byte("1600000000000000000,0,9.8,0.1,0.2\n"), 100)),
+	})
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("打开zip失败: %v", err)
+	}
+
+	for _, file := range archive.File {
+		var err error
+		if file.Name == "Metadata.csv" {
+			_, err = readRecordingMetadata(file)
+		} else {
+			_, err = newCSVSensorStream(file)
+		}
+		var tooLarge *BodyTooLargeError
+		if !errors.As(err, &tooLarge) || tooLarge.Limit != "bulk_max_decompressed_bytes" {
+			t.Errorf("%s 解压后超出限制时期望返回BodyTooLargeError，实际为%v", file.Name, err)
+		}
+	}
+}
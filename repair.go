@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// PayloadRepairStats payload修复结果统计
+type PayloadRepairStats struct {
+	FilesScanned int `json:"filesScanned"`
+	InvalidFiles int `json:"invalidFiles"` // 无法解析的原始文件或归档记录
+	Updated      int `json:"updated"`
+	Unchanged    int `json:"unchanged"`
+	NotFound     int `json:"notFound"`
+	Mismatched   int `json:"mismatched"`
+	Errors       int `json:"errors"`
+}
+
+// scanRawMessageFiles 按顺序读取数据目录中保存的原始消息（旧版本的单文件和归档段）
+// 无法解析的消息记录日志后跳过，返回扫描的文件数和无效的文件或记录数
+func scanRawMessageFiles(dir string, handle func(source string, message *SensorMessage)) (int, int, error) {
+	invalid := 0
+	scanned, corrupt, err := forEachRawMessage(dir, func(raw rawMessage) {
+		message, err := decodeSensorMessage(bytes.NewReader(raw.Data), 0)
+		if err != nil {
+			invalid++
+			LogError("解析原始数据", err, slog.String("path", raw.Source))
+			return
+		}
+		handle(raw.Source, message)
+	})
+	return scanned, invalid + corrupt, err
+}
+
+// repairPayloads 用数据目录中的原始文件修复已保存消息的payload（旧版本由显示文本重建，丢失了键名和精度）
+func repairPayloads(dir string, dryRun bool) (PayloadRepairStats, error) {
+	var stats PayloadRepairStats
+
+	scanned, invalid, err := scanRawMessageFiles(dir, func(path string, message *SensorMessage) {
+		result, err := RepairMessagePayload(message, dryRun)
+		if err != nil {
+			stats.Errors++
+			LogError("修复payload", err,
+				slog.String("path", path),
+				slog.String("session_id", message.SessionID),
+				slog.Int64("message_id", message.MessageID))
+			return
+		}
+
+		switch result {
+		case RepairUpdated:
+			stats.Updated++
+		case RepairUnchanged:
+			stats.Unchanged++
+		case RepairNotFound:
+			stats.NotFound++
+		case RepairMismatch:
+			stats.Mismatched++
+			Logger.Warn("读数数量与已保存的消息不一致，跳过",
+				slog.String("path", path),
+				slog.String("session_id", message.SessionID),
+				slog.Int64("message_id", message.MessageID),
+				slog.Int("readings", len(message.Payload)))
+		}
+	})
+	stats.FilesScanned, stats.InvalidFiles = scanned, invalid
+	return stats, err
+}
+
+// runRepairCommand 修复MongoDB中由旧版本保存的payload
+func runRepairCommand(args []string) int {
+	flags := flag.NewFlagSet("repair-payload", flag.ContinueOnError)
+	dir := flags.String("dir", AppConfig.DataDir, "原始数据文件所在目录")
+	dryRun := flags.Bool("dry-run", false, "只统计需要修复的消息，不修改数据库")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	AppConfig.EnableLogging = false
+	cleanup := startCommandMongo()
+	defer cleanup()
+	if !mongoAvailable() {
+		fmt.Fprintln(os.Stderr, "MongoDB不可用，无法修复")
+		return 1
+	}
+
+	stats, err := repairPayloads(*dir, *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "修复失败: %v\n", err)
+		return 1
+	}
+
+	action := "已修复"
+	if *dryRun {
+		action = "需要修复"
+	}
+	fmt.Printf("扫描文件: %d (无法解析: %d)\n", stats.FilesScanned, stats.InvalidFiles)
+	fmt.Printf("%s: %d, 无需修改: %d, 数据库中不存在: %d, 读数数量不一致: %d, 错误: %d\n",
+		action, stats.Updated, stats.Unchanged, stats.NotFound, stats.Mismatched, stats.Errors)
+	if stats.Errors > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestScanRawMessageFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "sensor_messages_20250705_153947.json"), buildTestMessage(2), 0644)
+	os.WriteFile(filepath.Join(dir, "sensor_messages_20250705_153948.json"), []byte("{broken"), 0644)
+	os.WriteFile(filepath.Join(dir, "other.json"), buildTestMessage(1), 0644)
+
+	var messages []*SensorMessage
+	scanned, invalid, err := scanRawMessageFiles(dir, func(path string, message *SensorMessage) {
+		messages = append(messages, message)
+	})
+	if err != nil {
+		t.Fatalf("扫描失败: %v", err)
+	}
+	if scanned != 2 || invalid != 1 {
+		t.Errorf("期望扫描2个文件（1个无效），实际为%d个（%d个无效）", scanned, invalid)
+	}
+	if len(messages) != 1 || len(messages[0].Payload) != 2 {
+		t.Fatalf("期望读取到1条包含2个读数的消息，实际为%+v", messages)
+	}
+}
+
+func TestRepairPayloadsWithoutDatabase(t *testing.T) {
+	originalClient := mongoClient
+	setMongoConnection(nil)
+	defer func() { mongoClient = originalClient }()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "sensor_messages_20250705_153947.json"), buildTestMessage(1), 0644)
+
+	stats, err := repairPayloads(dir, true)
+	if err != nil {
+		t.Fatalf("修复失败: %v", err)
+	}
+	if stats.FilesScanned != 1 || stats.Errors != 1 || stats.Updated != 0 {
+		t.Errorf("数据库不可用时每条消息都应记为错误: %+v", stats)
+	}
+}
+
+func TestSamePayloadIgnoresFieldOrder(t *testing.T) {
+	payload := []SensorReading{{
+		Name: "accelerometer",
+		Time: 1751702387000000000,
+		Values: map[string]interface{}{
+			"x": 0.1, "y": -0.2, "z": 9.8, "a": int64(1), "b": "text", "c": true,
+			"nested": map[string]interface{}{"p": 1.5, "q": 2.5, "r": 3.5},
+		},
+	}}
+
+	// map的序列化顺序随机，多次序列化的结果都应与原始读数一致
+	for i := 0; i < 20; i++ {
+		valueType, value, err := bson.MarshalValue(payload)
+		if err != nil {
+			t.Fatalf("序列化失败: %v", err)
+		}
+		same, err := samePayload(bson.RawValue{Type: valueType, Value: value}, payload)
+		if err != nil {
+			t.Fatalf("比较失败: %v", err)
+		}
+		if !same {
+			t.Fatal("字段顺序不同的payload应视为一致")
+		}
+	}
+
+	valueType, value, _ := bson.MarshalValue(payload)
+	changed := []SensorReading{{Name: payload[0].Name, Time: payload[0].Time, Values: map[string]interface{}{}}}
+	for key, v := range payload[0].Values {
+		changed[0].Values[key] = v
+	}
+	changed[0].Values["z"] = 9.7
+	same, err := samePayload(bson.RawValue{Type: valueType, Value: value}, changed)
+	if err != nil {
+		t.Fatalf("比较失败: %v", err)
+	}
+	if same {
+		t.Error("读数值不同的payload不应视为一致")
+	}
+}
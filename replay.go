@@ -0,0 +1,390 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 原始数据文件名中的时间格式（sensor_messages_20060102_150405.json）
+const rawFileTimeLayout = "20060102_150405"
+
+// errReplayRunning 已有回放在进行
+var errReplayRunning = errors.New("已有回放任务正在进行")
+
+// replayRunning 同一时间只允许一个回放任务
+var replayRunning atomic.Bool
+
+// ReplayOptions 原始数据回放选项
+type ReplayOptions struct {
+	Dir      string
+	DeviceID string    // 只回放该设备的消息，空表示全部
+	From     time.Time // 只回放读数时间与 [From, To] 有交集的消息，零值表示不限制
+	To       time.Time
+	DryRun   bool // 只统计，不写入数据库
+}
+
+// ReplayStats 原始数据回放结果
+type ReplayStats struct {
+	Files      int    `json:"files"`      // 读取的文件数（旧版本的单文件和归档段）
+	Imported   int    `json:"imported"`   // 已写入（dryRun时为需要写入）的消息
+	Duplicates int    `json:"duplicates"` // 数据库中已存在的消息
+	Corrupt    int    `json:"corrupt"`    // 无法解析的文件或归档记录
+	Filtered   int    `json:"filtered"`   // 不符合设备或时间条件而跳过的消息
+	Failed     int    `json:"failed"`     // 写入失败的消息
+	Readings   int    `json:"readings"`   // 已写入的读数总数
+	DryRun     bool   `json:"dryRun"`
+	LastError  string `json:"lastError,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// rawMessage 数据目录中保存的一条原始消息
+type rawMessage struct {
+	Source     string // 来源文件（归档段中的记录为 段路径:行号）
+	ReceivedAt time.Time
+	Data       []byte
+}
+
+// forEachRawMessage 依次读取数据目录中保存的原始消息：先读旧版本每条消息一个的文件，再按分区顺序读归档段
+// 返回读取的文件数和无法读取的文件或记录数（例如崩溃时写了一半的最后一行）
+func forEachRawMessage(dir string, handle func(message rawMessage)) (int, int, error) {
+	legacyFiles, err := rawArchiveFiles(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	segments, err := archiveSegmentFiles(filepath.Join(dir, "archive"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	corrupt := 0
+	for _, path := range legacyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			corrupt++
+			LogError("读取原始数据文件", err, slog.String("path", path))
+			continue
+		}
+		handle(rawMessage{Source: path, ReceivedAt: rawFileTime(path), Data: data})
+	}
+
+	for _, path := range segments {
+		line := 0
+		invalid, err := readArchiveSegment(path, func(record *ArchiveRecord) {
+			line++
+			handle(rawMessage{Source: fmt.Sprintf("%s:%d", path, line), ReceivedAt: record.ReceivedAt, Data: record.Message})
+		})
+		if invalid > 0 {
+			Logger.Warn("归档段中有无法解析的记录",
+				slog.String("path", path),
+				slog.Int("records", invalid))
+		}
+		corrupt += invalid
+		if err != nil {
+			corrupt++
+			LogError("读取归档段", err, slog.String("path", path))
+		}
+	}
+	return len(legacyFiles) + len(segments), corrupt, nil
+}
+
+// rawArchiveFiles 按文件名（即接收时间）顺序列出数据目录中旧版本保存的原始数据文件
+func rawArchiveFiles(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "sensor_messages_*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// rawFileTime 由文件名得到接收时间，文件名不符合格式时使用修改时间
+func rawFileTime(path string) time.Time {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "sensor_messages_"), ".json")
+	if receivedAt, err := time.ParseInLocation(rawFileTimeLayout, name, time.Local); err == nil {
+		return receivedAt
+	}
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime()
+	}
+	return time.Now()
+}
+
+// matches 判断消息是否符合回放条件
+func (o ReplayOptions) matches(parsedData *ParsedSensorData) bool {
+	if o.DeviceID != "" && parsedData.DeviceID != o.DeviceID {
+		return false
+	}
+	if !o.From.IsZero() && parsedData.TimeRange.End.Before(o.From) {
+		return false
+	}
+	if !o.To.IsZero() && parsedData.TimeRange.Start.After(o.To) {
+		return false
+	}
+	return true
+}
+
+// replayArchive 将数据目录中的原始数据（旧版本的单文件和归档段）写回MongoDB，已存在的消息（sessionId+messageId）跳过
+func replayArchive(opts ReplayOptions) (ReplayStats, error) {
+	startTime := time.Now()
+	stats := ReplayStats{DryRun: opts.DryRun}
+
+	if !replayRunning.CompareAndSwap(false, true) {
+		return stats, errReplayRunning
+	}
+	defer replayRunning.Store(false)
+
+	if !mongoAvailable() {
+		return stats, errMongoUnavailable
+	}
+
+	// MongoDB在回放过程中断开时停止处理剩余的消息
+	var fatalErr error
+	files, corrupt, err := forEachRawMessage(opts.Dir, func(message rawMessage) {
+		if fatalErr != nil {
+			return
+		}
+
+		parsedData, err := parseSensorMessage(message.Data)
+		if err != nil {
+			stats.Corrupt++
+			LogError("解析原始数据", err, slog.String("path", message.Source))
+			return
+		}
+		if !opts.matches(parsedData) {
+			stats.Filtered++
+			return
+		}
+		parsedData.ReceivedAt = message.ReceivedAt
+
+		exists, err := SensorMessageExists(parsedData.SessionID, parsedData.MessageID)
+		if err != nil {
+			if errors.Is(err, errMongoUnavailable) {
+				fatalErr = err
+				return
+			}
+			stats.Failed++
+			stats.LastError = err.Error()
+			return
+		}
+		if exists {
+			stats.Duplicates++
+			return
+		}
+		if opts.DryRun {
+			stats.Imported++
+			stats.Readings += parsedData.TotalReadings
+			return
+		}
+
+		dbStart := time.Now()
+		err = SaveSensorData(parsedData)
+		switch {
+		case errors.Is(err, errDuplicateMessage):
+			stats.Duplicates++
+		case errors.Is(err, errMongoUnavailable):
+			fatalErr = err
+		case err != nil:
+			stats.Failed++
+			stats.LastError = err.Error()
+			LogDatabaseOperation("replay_raw_file", false, parsedData.TotalReadings, time.Since(dbStart))
+			LogError("回放原始数据", err, slog.String("path", message.Source))
+		default:
+			stats.Imported++
+			stats.Readings += parsedData.TotalReadings
+			LogDatabaseOperation("replay_raw_file", true, parsedData.TotalReadings, time.Since(dbStart))
+		}
+	})
+	stats.Files = files
+	stats.Corrupt += corrupt
+	if err == nil {
+		err = fatalErr
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	stats.DurationMs = time.Since(startTime).Milliseconds()
+	Logger.Info("原始数据回放完成",
+		slog.String("dir", opts.Dir),
+		slog.Bool("dry_run", opts.DryRun),
+		slog.Int("files", stats.Files),
+		slog.Int("imported", stats.Imported),
+		slog.Int("duplicates", stats.Duplicates),
+		slog.Int("corrupt", stats.Corrupt),
+		slog.Int("filtered", stats.Filtered),
+		slog.Int("failed", stats.Failed))
+	return stats, nil
+}
+
+// parseTimeFilter 解析时间过滤条件，支持RFC3339和日期（2006-01-02，按本地时间）
+func parseTimeFilter(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("无效的时间: %s（支持RFC3339或2006-01-02）", value)
+}
+
+// handleAdminReplay 管理接口：回放原始数据文件
+// 查询参数：device、from、to（RFC3339或日期）、dryRun
+func handleAdminReplay(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST方法", http.StatusMethodNotAllowed)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusMethodNotAllowed, time.Since(startTime))
+		return
+	}
+
+	query := r.URL.Query()
+	opts := ReplayOptions{Dir: AppConfig.DataDir, DeviceID: query.Get("device")}
+	var err error
+	if opts.From, err = parseTimeFilter(query.Get("from")); err == nil {
+		opts.To, err = parseTimeFilter(query.Get("to"))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+		return
+	}
+	if dryRun := query.Get("dryRun"); dryRun != "" {
+		if opts.DryRun, err = strconv.ParseBool(dryRun); err != nil {
+			http.Error(w, "无效的dryRun参数", http.StatusBadRequest)
+			LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+			return
+		}
+	}
+
+	stats, err := replayArchive(opts)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, errReplayRunning):
+			status = http.StatusConflict
+		case errors.Is(err, errMongoUnavailable):
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, status, time.Since(startTime))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
+}
+
+// ArchivedMessage 归档中的一条消息及其位置
+type ArchivedMessage struct {
+	Location ArchiveLocation `json:"location"`
+	*ArchiveRecord
+}
+
+// handleAdminArchivedMessage 管理接口：按会话ID和消息ID读取归档中的原始消息
+func handleAdminArchivedMessage(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "只支持GET方法", http.StatusMethodNotAllowed)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusMethodNotAllowed, time.Since(startTime))
+		return
+	}
+
+	sessionID := r.PathValue("session")
+	messageID, err := strconv.ParseInt(r.PathValue("messageId"), 10, 64)
+	if err != nil {
+		http.Error(w, "无效的消息ID", http.StatusBadRequest)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusBadRequest, time.Since(startTime))
+		return
+	}
+
+	message, found, err := readArchivedMessage(currentArchiveOptions().Dir, sessionID, messageID)
+	if err != nil {
+		http.Error(w, "读取归档失败", http.StatusInternalServerError)
+		LogError("读取归档消息", err,
+			slog.String("session_id", sessionID),
+			slog.Int64("message_id", messageID))
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusInternalServerError, time.Since(startTime))
+		return
+	}
+	if !found {
+		http.Error(w, "归档中没有该消息", http.StatusNotFound)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusNotFound, time.Since(startTime))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, message)
+	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
+}
+
+// readArchivedMessage 通过会话索引定位并读取归档中的消息
+func readArchivedMessage(dir, sessionID string, messageID int64) (*ArchivedMessage, bool, error) {
+	location, found, err := LocateArchivedMessage(dir, sessionID, messageID)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	record, err := ReadArchivedRecord(dir, location)
+	if err != nil {
+		return nil, false, err
+	}
+	return &ArchivedMessage{Location: location, ArchiveRecord: record}, true, nil
+}
+
+// runReplayCommand 回放原始数据文件到MongoDB
+func runReplayCommand(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	dir := flags.String("dir", AppConfig.DataDir, "原始数据文件所在目录")
+	deviceID := flags.String("device", "", "只回放该设备的消息")
+	from := flags.String("from", "", "只回放读数时间不早于该时间的消息（RFC3339或2006-01-02）")
+	to := flags.String("to", "", "只回放读数时间不晚于该时间的消息（RFC3339或2006-01-02）")
+	dryRun := flags.Bool("dry-run", false, "只统计，不写入数据库")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	opts := ReplayOptions{Dir: *dir, DeviceID: *deviceID, DryRun: *dryRun}
+	var err error
+	if opts.From, err = parseTimeFilter(*from); err == nil {
+		opts.To, err = parseTimeFilter(*to)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	AppConfig.EnableLogging = false
+	cleanup := startCommandMongo()
+	defer cleanup()
+
+	stats, err := replayArchive(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "回放失败: %v\n", err)
+		return 1
+	}
+
+	action := "已导入"
+	if opts.DryRun {
+		action = "需要导入"
+	}
+	fmt.Printf("扫描文件: %d\n", stats.Files)
+	fmt.Printf("%s: %d条消息（%d条读数）, 已存在: %d, 文件损坏: %d, 不符合条件: %d, 失败: %d\n",
+		action, stats.Imported, stats.Readings, stats.Duplicates, stats.Corrupt, stats.Filtered, stats.Failed)
+	if stats.Failed > 0 {
+		return 1
+	}
+	return 0
+}
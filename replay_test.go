@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayOptionsMatches(t *testing.T) {
+	parsed, err := parseSensorMessage(buildTestMessage(2))
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	readingTime := parsed.TimeRange.Start
+
+	tests := []struct {
+		name string
+		opts ReplayOptions
+		want bool
+	}{
+		{"无条件", ReplayOptions{}, true},
+		{"设备匹配", ReplayOptions{DeviceID: "decode-device"}, true},
+		{"设备不匹配", ReplayOptions{DeviceID: "other"}, false},
+		{"时间范围内", ReplayOptions{From: readingTime.Add(-time.Hour), To: readingTime.Add(time.Hour)}, true},
+		{"早于开始时间", ReplayOptions{From: readingTime.Add(time.Hour)}, false},
+		{"晚于结束时间", ReplayOptions{To: readingTime.Add(-time.Hour)}, false},
+	}
+	for _, test := range tests {
+		if got := test.opts.matches(parsed); got != test.want {
+			t.Errorf("%s: 期望%t，实际为%t", test.name, test.want, got)
+		}
+	}
+}
+
+func TestRawFileTime(t *testing.T) {
+	got := rawFileTime(filepath.Join("data", "sensor_messages_20250705_153947.json"))
+	want := time.Date(2025, 7, 5, 15, 39, 47, 0, time.Local)
+	if !got.Equal(want) {
+		t.Errorf("期望%v，实际为%v", want, got)
+	}
+}
+
+func TestParseTimeFilter(t *testing.T) {
+	if value, err := parseTimeFilter(""); err != nil || !value.IsZero() {
+		t.Errorf("空值应返回零值: %v, %v", value, err)
+	}
+	if value, err := parseTimeFilter("2025-07-05T15:39:47Z"); err != nil || value.Unix() != 1751729987 {
+		t.Errorf("RFC3339解析错误: %v, %v", value, err)
+	}
+	if _, err := parseTimeFilter("2025-07-05"); err != nil {
+		t.Errorf("日期解析错误: %v", err)
+	}
+	if _, err := parseTimeFilter("yesterday"); err == nil {
+		t.Error("无效时间应返回错误")
+	}
+}
+
+func TestReplayArchiveWithoutDatabase(t *testing.T) {
+	originalClient := mongoClient
+	setMongoConnection(nil)
+	defer func() { mongoClient = originalClient }()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "sensor_messages_20250705_153947.json"), buildTestMessage(1), 0644)
+
+	if _, err := replayArchive(ReplayOptions{Dir: dir}); err != errMongoUnavailable {
+		t.Errorf("数据库不可用时期望返回errMongoUnavailable，实际为%v", err)
+	}
+	if replayRunning.Load() {
+		t.Error("回放结束后应释放运行标记")
+	}
+}
+
+func TestWithAdminAuth(t *testing.T) {
+	original := AppConfig.AdminToken
+	defer func() { AppConfig.AdminToken = original }()
+
+	handler := withAdminAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	request := func(authorization string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/replay", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+
+	AppConfig.AdminToken = ""
+	if code := request("Bearer anything"); code != http.StatusForbidden {
+		t.Errorf("未配置令牌时期望403，实际为%d", code)
+	}
+
+	AppConfig.AdminToken = "secret"
+	tests := map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Basic secret":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusNoContent,
+		"bearer secret": http.StatusNoContent,
+	}
+	for authorization, want := range tests {
+		if code := request(authorization); code != want {
+			t.Errorf("Authorization=%q: 期望%d，实际为%d", authorization, want, code)
+		}
+	}
+}
+
+func TestHandleAdminArchivedMessage(t *testing.T) {
+	original := AppConfig.DataDir
+	defer func() { AppConfig.DataDir = original }()
+	AppConfig.DataDir = t.TempDir()
+
+	archive, err := NewArchive(currentArchiveOptions())
+	if err != nil {
+		t.Fatalf("创建归档失败: %v", err)
+	}
+	location := appendTestMessage(t, archive, "device-a", "session-1", 7)
+	archive.Close()
+
+	request := func(sessionID, messageID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/archive/"+sessionID+"/"+messageID, nil)
+		req.SetPathValue("session", sessionID)
+		req.SetPathValue("messageId", messageID)
+		rr := httptest.NewRecorder()
+		handleAdminArchivedMessage(rr, req)
+		return rr
+	}
+
+	rr := request("session-1", "7")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("期望状态码200，实际为%d: %s", rr.Code, rr.Body.String())
+	}
+	var message ArchivedMessage
+	if err := json.Unmarshal(rr.Body.Bytes(), &message); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	if message.Location != location || message.ArchiveRecord == nil {
+		t.Fatalf("归档位置不正确: %+v", message)
+	}
+	if parsedData, err := parseSensorMessage(message.Message); err != nil || parsedData.TotalReadings != 2 {
+		t.Errorf("返回的原始消息不完整: %v", err)
+	}
+
+	if rr := request("session-1", "8"); rr.Code != http.StatusNotFound {
+		t.Errorf("不存在的消息期望404，实际为%d", rr.Code)
+	}
+	if rr := request("session-1", "abc"); rr.Code != http.StatusBadRequest {
+		t.Errorf("无效的消息ID期望400，实际为%d", rr.Code)
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 显示精度的上限（小数位数）
+const maxSensorFieldPrecision = 12
+
+// 字段的值类型
+const (
+	FieldTypeNumber  = "number"  // 数值（默认）
+	FieldTypeString  = "string"  // 字符串，例如电池的充电状态
+	FieldTypeBoolean = "boolean" // 布尔值
+)
+
+// validFieldTypes 支持的字段类型（空表示数值）
+var validFieldTypes = []string{"", FieldTypeNumber, FieldTypeString, FieldTypeBoolean}
+
+// FieldRange 字段的合理取值范围（闭区间）
+type FieldRange struct {
+	Min float64 `json:"min" yaml:"min"`
+	Max float64 `json:"max" yaml:"max"`
+}
+
+// SensorField 传感器字段的描述
+type SensorField struct {
+	Key         string      `json:"key" yaml:"key"`                                     // values中的键名
+	Label       string      `json:"label" yaml:"label"`                                 // 显示名称
+	Type        string      `json:"type,omitempty" yaml:"type,omitempty"`               // 值类型，为空表示数值
+	Unit        string      `json:"unit,omitempty" yaml:"unit,omitempty"`               // 单位
+	Precision   int         `json:"precision" yaml:"precision"`                         // 数值显示的小数位数
+	Description string      `json:"description,omitempty" yaml:"description,omitempty"` // 说明
+	Required    bool        `json:"required,omitempty" yaml:"required,omitempty"`       // 校验时是否必填
+	Range       *FieldRange `json:"range,omitempty" yaml:"range,omitempty"`             // 校验时的取值范围，为空表示只要求为数值
+}
+
+// SensorDescriptor 传感器的描述：名称和各字段的含义
+type SensorDescriptor struct {
+	Name        string        `json:"name" yaml:"name"` // Sensor Logger推送的传感器名称（不区分大小写，忽略空格、下划线和连字符）
+	Label       string        `json:"label" yaml:"label"`
+	Description string        `json:"description,omitempty" yaml:"description,omitempty"`
+	Fields      []SensorField `json:"fields" yaml:"fields"`
+}
+
+// SensorRegistry 传感器描述的登记表，用于生成可读数据和校验读数
+type SensorRegistry struct {
+	descriptors map[string]SensorDescriptor // sensorKey(传感器名称) -> 描述
+}
+
+// 全局传感器登记表（启动时可由 SENSOR_DESCRIPTORS_FILE 覆盖）
+var sensorRegistry = NewSensorRegistry(builtinSensorDescriptors)
+
+// NewSensorRegistry 创建传感器登记表
+func NewSensorRegistry(descriptors []SensorDescriptor) *SensorRegistry {
+	registry := &SensorRegistry{descriptors: make(map[string]SensorDescriptor, len(descriptors))}
+	for _, descriptor := range descriptors {
+		registry.descriptors[sensorKey(descriptor.Name)] = descriptor
+	}
+	return registry
+}
+
+// sensorKey 登记表中的传感器键：小写并去掉空格、下划线和连字符，
+// 使 "heart rate"、"heart_rate" 和 "heartrate" 对应同一个传感器
+func sensorKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '_', '-':
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, strings.TrimSpace(name))
+}
+
+// Lookup 按传感器名称查找描述
+func (r *SensorRegistry) Lookup(name string) (SensorDescriptor, bool) {
+	descriptor, ok := r.descriptors[sensorKey(name)]
+	return descriptor, ok
+}
+
+// List 按名称列出所有传感器描述
+func (r *SensorRegistry) List() []SensorDescriptor {
+	descriptors := make([]SensorDescriptor, 0, len(r.descriptors))
+	for _, descriptor := range r.descriptors {
+		descriptors = append(descriptors, descriptor)
+	}
+	sort.Slice(descriptors, func(i, j int) bool {
+		return descriptors[i].Name < descriptors[j].Name
+	})
+	return descriptors
+}
+
+// Merge 返回合并了覆盖项的新登记表：同名传感器的字段按键名替换，新的字段和传感器追加在后面
+func (r *SensorRegistry) Merge(overrides []SensorDescriptor) *SensorRegistry {
+	merged := &SensorRegistry{descriptors: make(map[string]SensorDescriptor, len(r.descriptors)+len(overrides))}
+	for name, descriptor := range r.descriptors {
+		merged.descriptors[name] = descriptor
+	}
+
+	for _, override := range overrides {
+		name := sensorKey(override.Name)
+		base, ok := merged.descriptors[name]
+		if !ok {
+			merged.descriptors[name] = override
+			continue
+		}
+
+		if override.Label != "" {
+			base.Label = override.Label
+		}
+		if override.Description != "" {
+			base.Description = override.Description
+		}
+		fields := append([]SensorField(nil), base.Fields...)
+		for _, field := range override.Fields {
+			replaced := false
+			for i := range fields {
+				if fields[i].Key == field.Key {
+					fields[i], replaced = field, true
+					break
+				}
+			}
+			if !replaced {
+				fields = append(fields, field)
+			}
+		}
+		base.Fields = fields
+		merged.descriptors[name] = base
+	}
+	return merged
+}
+
+// ParseValues 按传感器描述将读数值转换为可读格式：只包含读数中存在的字段，顺序与描述一致。
+// 未登记的传感器按通用方式处理
+func (r *SensorRegistry) ParseValues(sensorName string, values map[string]interface{}) []SensorValue {
+	descriptor, ok := r.Lookup(sensorName)
+	if !ok {
+		return parseGeneric(values)
+	}
+
+	result := make([]SensorValue, 0, len(descriptor.Fields))
+	for _, field := range descriptor.Fields {
+		value, ok := values[field.Key]
+		if !ok {
+			continue
+		}
+		result = append(result, SensorValue{
+			Key:         field.Key,
+			Name:        field.Label,
+			Value:       field.format(value),
+			Unit:        field.Unit,
+			Description: field.Description,
+		})
+	}
+	return result
+}
+
+// format 按字段类型格式化值
+func (f SensorField) format(value interface{}) string {
+	switch f.Type {
+	case FieldTypeString:
+		return fmt.Sprintf("%v", value)
+	case FieldTypeBoolean:
+		if b, ok := value.(bool); ok {
+			if b {
+				return "是"
+			}
+			return "否"
+		}
+		return fmt.Sprintf("%v", value)
+	default:
+		return fmt.Sprintf("%.*f", f.Precision, getFloat64(value))
+	}
+}
+
+// bounds 校验时的取值范围，未设置范围时不限制
+func (f SensorField) bounds() (float64, float64) {
+	if f.Range == nil {
+		return math.Inf(-1), math.Inf(1)
+	}
+	return f.Range.Min, f.Range.Max
+}
+
+// validateSensorDescriptors 检查描述文件中的传感器描述
+func validateSensorDescriptors(descriptors []SensorDescriptor) error {
+	seen := make(map[string]bool)
+	for i, descriptor := range descriptors {
+		name := sensorKey(descriptor.Name)
+		if name == "" {
+			return fmt.Errorf("第%d个传感器缺少名称", i+1)
+		}
+		if seen[name] {
+			return fmt.Errorf("传感器 %s 重复", descriptor.Name)
+		}
+		seen[name] = true
+
+		keys := make(map[string]bool)
+		for _, field := range descriptor.Fields {
+			if field.Key == "" {
+				return fmt.Errorf("传感器 %s 的字段缺少key", descriptor.Name)
+			}
+			if keys[field.Key] {
+				return fmt.Errorf("传感器 %s 的字段 %s 重复", descriptor.Name, field.Key)
+			}
+			keys[field.Key] = true
+			if field.Label == "" {
+				return fmt.Errorf("传感器 %s 的字段 %s 缺少label", descriptor.Name, field.Key)
+			}
+			if !slices.Contains(validFieldTypes, field.Type) {
+				return fmt.Errorf("传感器 %s 的字段 %s 类型无效: %s，支持的取值: %v", descriptor.Name, field.Key, field.Type, validFieldTypes[1:])
+			}
+			if field.Precision < 0 || field.Precision > maxSensorFieldPrecision {
+				return fmt.Errorf("传感器 %s 的字段 %s 精度无效: %d（0-%d）", descriptor.Name, field.Key, field.Precision, maxSensorFieldPrecision)
+			}
+			if field.Range != nil && field.Range.Min > field.Range.Max {
+				return fmt.Errorf("传感器 %s 的字段 %s 范围无效: [%v, %v]", descriptor.Name, field.Key, field.Range.Min, field.Range.Max)
+			}
+		}
+	}
+	return nil
+}
+
+// loadSensorDescriptorFile 读取传感器描述文件（.json 按JSON解析，其他扩展名按YAML解析）
+func loadSensorDescriptorFile(path string) ([]SensorDescriptor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取传感器描述文件失败: %v", err)
+	}
+
+	var descriptors []SensorDescriptor
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &descriptors)
+	} else {
+		err = yaml.Unmarshal(data, &descriptors)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析传感器描述文件失败: %v", err)
+	}
+	if err := validateSensorDescriptors(descriptors); err != nil {
+		return nil, fmt.Errorf("传感器描述文件无效: %v", err)
+	}
+	return descriptors, nil
+}
+
+// loadSensorRegistry 按配置加载传感器描述文件，与内置描述合并后替换全局登记表
+func loadSensorRegistry() error {
+	if AppConfig.SensorDescriptorsFile == "" {
+		return nil
+	}
+	descriptors, err := loadSensorDescriptorFile(AppConfig.SensorDescriptorsFile)
+	if err != nil {
+		return err
+	}
+	sensorRegistry = NewSensorRegistry(builtinSensorDescriptors).Merge(descriptors)
+	Logger.Info("已加载传感器描述文件",
+		slog.String("path", AppConfig.SensorDescriptorsFile),
+		slog.Int("sensors", len(descriptors)))
+	return nil
+}
+
+// SensorCatalogResponse /api/v1/sensors 的响应
+type SensorCatalogResponse struct {
+	Sensors []SensorDescriptor `json:"sensors"`
+}
+
+// handleSensorCatalog 返回所有已登记传感器的字段说明
+func handleSensorCatalog(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	setCORSHeaders(w, r)
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "只支持GET请求", http.StatusMethodNotAllowed)
+		LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusMethodNotAllowed, time.Since(startTime))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SensorCatalogResponse{Sensors: sensorRegistry.List()})
+	LogAPIRequest(r.Method, r.URL.Path, r.RemoteAddr, http.StatusOK, time.Since(startTime))
+}
+
+// axisFields 三轴传感器的字段，label和description中的%s替换为轴名（X/Y/Z）
+func axisFields(label, unit, description string, required bool) []SensorField {
+	fields := make([]SensorField, 0, 3)
+	for _, axis := range []string{"x", "y", "z"} {
+		upper := strings.ToUpper(axis)
+		fields = append(fields, SensorField{
+			Key:         axis,
+			Label:       fmt.Sprintf(label, upper),
+			Unit:        unit,
+			Precision:   6,
+			Description: fmt.Sprintf(description, upper),
+			Required:    required,
+		})
+	}
+	return fields
+}
+
+// 内置的传感器描述
+var builtinSensorDescriptors = []SensorDescriptor{
+	{
+		Name:   "accelerometer",
+		Label:  "加速度计",
+		Fields: axisFields("%s轴加速度", "m/s²", "%s轴方向的加速度", true),
+	},
+	{
+		Name:   "gyroscope",
+		Label:  "陀螺仪",
+		Fields: axisFields("%s轴角速度", "rad/s", "绕%s轴的角速度", true),
+	},
+	{
+		Name:  "magnetometer",
+		Label: "磁力计",
+		Fields: append(axisFields("%s轴磁场", "μT", "%s轴方向的磁场强度", false),
+			SensorField{Key: "magneticBearing", Label: "磁方位角", Unit: "度", Precision: 2, Description: "相对于磁北的方位角", Range: &FieldRange{0, 360}}),
+	},
+	{
+		Name:   "gravity",
+		Label:  "重力传感器",
+		Fields: axisFields("%s轴重力", "m/s²", "%s轴方向的重力分量", true),
+	},
+	{
+		Name:  "orientation",
+		Label: "方向传感器",
+		Fields: []SensorField{
+			{Key: "qw", Label: "四元数W", Precision: 6, Description: "四元数W分量", Required: true, Range: &FieldRange{-1, 1}},
+			{Key: "qx", Label: "四元数X", Precision: 6, Description: "四元数X分量", Required: true, Range: &FieldRange{-1, 1}},
+			{Key: "qy", Label: "四元数Y", Precision: 6, Description: "四元数Y分量", Required: true, Range: &FieldRange{-1, 1}},
+			{Key: "qz", Label: "四元数Z", Precision: 6, Description: "四元数Z分量", Required: true, Range: &FieldRange{-1, 1}},
+			{Key: "yaw", Label: "偏航角", Unit: "rad", Precision: 6, Description: "绕竖直轴的旋转角度", Range: &FieldRange{-2 * math.Pi, 2 * math.Pi}},
+			{Key: "pitch", Label: "俯仰角", Unit: "rad", Precision: 6, Description: "绕横轴的旋转角度", Range: &FieldRange{-2 * math.Pi, 2 * math.Pi}},
+			{Key: "roll", Label: "横滚角", Unit: "rad", Precision: 6, Description: "绕纵轴的旋转角度", Range: &FieldRange{-2 * math.Pi, 2 * math.Pi}},
+		},
+	},
+	{
+		Name:  "compass",
+		Label: "指南针",
+		Fields: []SensorField{
+			{Key: "magneticBearing", Label: "指南针方位", Unit: "度", Precision: 2, Description: "指南针方位角", Required: true, Range: &FieldRange{0, 360}},
+		},
+	},
+	{
+		Name:  "pedometer",
+		Label: "计步器",
+		Fields: []SensorField{
+			{Key: "steps", Label: "步数", Unit: "步", Precision: 0, Description: "累计步数", Required: true, Range: &FieldRange{0, math.MaxInt32}},
+		},
+	},
+	{
+		Name:   "magnetometeruncalibrated",
+		Label:  "未校准磁力计",
+		Fields: axisFields("%s轴磁场(未校准)", "μT", "%s轴方向的未校准磁场强度", true),
+	},
+	{
+		Name:  "location",
+		Label: "位置",
+		Fields: []SensorField{
+			{Key: "latitude", Label: "纬度", Unit: "度", Precision: 8, Description: "地理纬度", Required: true, Range: &FieldRange{-90, 90}},
+			{Key: "longitude", Label: "经度", Unit: "度", Precision: 8, Description: "地理经度", Required: true, Range: &FieldRange{-180, 180}},
+			{Key: "altitude", Label: "海拔", Unit: "米", Precision: 2, Description: "海拔高度", Range: &FieldRange{-1000, 100000}},
+			{Key: "speed", Label: "速度", Unit: "m/s", Precision: 2, Description: "移动速度", Range: &FieldRange{-1, 1000}}, // iOS在速度无效时报告-1
+			{Key: "bearing", Label: "方位角", Unit: "度", Precision: 2, Description: "移动方位角", Range: &FieldRange{-1, 360}},
+			{Key: "altitudeAboveMeanSeaLevel", Label: "平均海平面高度", Unit: "米", Precision: 2, Description: "相对于平均海平面的高度（altitude为相对于WGS84椭球的高度）", Range: &FieldRange{-1000, 100000}},
+			// 精度为定位误差的估计值（越小越准确），iOS在对应的值无效时报告-1
+			{Key: "horizontalAccuracy", Label: "水平精度", Unit: "米", Precision: 2, Description: "经纬度的误差半径", Range: &FieldRange{-1, 100000}},
+			{Key: "verticalAccuracy", Label: "垂直精度", Unit: "米", Precision: 2, Description: "海拔的误差", Range: &FieldRange{-1, 100000}},
+			{Key: "speedAccuracy", Label: "速度精度", Unit: "m/s", Precision: 2, Description: "速度的误差", Range: &FieldRange{-1, 1000}},
+			{Key: "bearingAccuracy", Label: "方位角精度", Unit: "度", Precision: 2, Description: "方位角的误差", Range: &FieldRange{-1, 360}},
+		},
+	},
+	{
+		Name:  "barometer",
+		Label: "气压计",
+		Fields: []SensorField{
+			{Key: "pressure", Label: "气压", Unit: "hPa", Precision: 2, Description: "大气压力", Required: true, Range: &FieldRange{1, 2000}},
+			{Key: "relativeAltitude", Label: "相对高度", Unit: "米", Precision: 2, Description: "相对于开始记录时的高度变化", Range: &FieldRange{-10000, 10000}},
+			{Key: "altitude", Label: "气压高度", Unit: "米", Precision: 2, Description: "基于气压计算的高度"},
+		},
+	},
+	{
+		Name:        "totalacceleration",
+		Label:       "总加速度",
+		Description: "包含重力的加速度（加速度计与重力之和）",
+		Fields:      axisFields("%s轴总加速度", "m/s²", "%s轴方向包含重力的加速度", true),
+	},
+	{
+		Name:   "accelerometeruncalibrated",
+		Label:  "未校准加速度计",
+		Fields: axisFields("%s轴加速度(未校准)", "m/s²", "%s轴方向的未校准加速度", true),
+	},
+	{
+		Name:   "gyroscopeuncalibrated",
+		Label:  "未校准陀螺仪",
+		Fields: axisFields("%s轴角速度(未校准)", "rad/s", "绕%s轴的未校准角速度", true),
+	},
+	{
+		Name:  "microphone",
+		Label: "麦克风",
+		Fields: []SensorField{
+			{Key: "dBFS", Label: "声音强度", Unit: "dBFS", Precision: 2, Description: "相对于满量程的声音强度，0为最大值", Required: true, Range: &FieldRange{-160, 0}},
+		},
+	},
+	{
+		Name:  "light",
+		Label: "光线传感器",
+		Fields: []SensorField{
+			{Key: "lux", Label: "照度", Unit: "lx", Precision: 1, Description: "环境光照度", Required: true, Range: &FieldRange{0, 200000}},
+		},
+	},
+	{
+		Name:  "battery",
+		Label: "电池",
+		Fields: []SensorField{
+			{Key: "batteryLevel", Label: "电量", Precision: 2, Description: "剩余电量（0-1）", Required: true, Range: &FieldRange{0, 1}},
+			{Key: "batteryState", Label: "充电状态", Type: FieldTypeString, Description: "charging、unplugged、full或unknown"},
+			{Key: "lowPowerMode", Label: "低电量模式", Type: FieldTypeBoolean, Description: "是否开启了低电量模式"},
+		},
+	},
+	{
+		Name:  "brightness",
+		Label: "屏幕亮度",
+		Fields: []SensorField{
+			{Key: "brightness", Label: "亮度", Precision: 3, Description: "屏幕亮度（0-1）", Required: true, Range: &FieldRange{0, 1}},
+		},
+	},
+	{
+		Name:  "network",
+		Label: "网络",
+		Fields: []SensorField{
+			{Key: "type", Label: "网络类型", Type: FieldTypeString, Description: "wifi、cellular、none等", Required: true},
+			{Key: "isConnected", Label: "已连接", Type: FieldTypeBoolean, Description: "是否连接到网络"},
+			{Key: "isInternetReachable", Label: "可访问互联网", Type: FieldTypeBoolean, Description: "是否可以访问互联网"},
+		},
+	},
+	{
+		Name:        "heartrate",
+		Label:       "心率",
+		Description: "配对手表测量的心率",
+		Fields: []SensorField{
+			{Key: "bpm", Label: "心率", Unit: "次/分", Precision: 0, Description: "每分钟心跳次数", Required: true, Range: &FieldRange{20, 300}},
+		},
+	},
+	{
+		Name:        "wristmotion",
+		Label:       "手腕运动",
+		Description: "配对手表的运动数据",
+		Fields: []SensorField{
+			{Key: "rotationRateX", Label: "X轴角速度", Unit: "rad/s", Precision: 6, Description: "绕X轴的角速度"},
+			{Key: "rotationRateY", Label: "Y轴角速度", Unit: "rad/s", Precision: 6, Description: "绕Y轴的角速度"},
+			{Key: "rotationRateZ", Label: "Z轴角速度", Unit: "rad/s", Precision: 6, Description: "绕Z轴的角速度"},
+			{Key: "gravityX", Label: "X轴重力", Unit: "m/s²", Precision: 6, Description: "X轴方向的重力分量"},
+			{Key: "gravityY", Label: "Y轴重力", Unit: "m/s²", Precision: 6, Description: "Y轴方向的重力分量"},
+			{Key: "gravityZ", Label: "Z轴重力", Unit: "m/s²", Precision: 6, Description: "Z轴方向的重力分量"},
+			{Key: "accelerationX", Label: "X轴加速度", Unit: "m/s²", Precision: 6, Description: "X轴方向的加速度（不含重力）"},
+			{Key: "accelerationY", Label: "Y轴加速度", Unit: "m/s²", Precision: 6, Description: "Y轴方向的加速度（不含重力）"},
+			{Key: "accelerationZ", Label: "Z轴加速度", Unit: "m/s²", Precision: 6, Description: "Z轴方向的加速度（不含重力）"},
+			{Key: "quaternionW", Label: "四元数W", Precision: 6, Description: "四元数W分量", Range: &FieldRange{-1, 1}},
+			{Key: "quaternionX", Label: "四元数X", Precision: 6, Description: "四元数X分量", Range: &FieldRange{-1, 1}},
+			{Key: "quaternionY", Label: "四元数Y", Precision: 6, Description: "四元数Y分量", Range: &FieldRange{-1, 1}},
+			{Key: "quaternionZ", Label: "四元数Z", Precision: 6, Description: "四元数Z分量", Range: &FieldRange{-1, 1}},
+		},
+	},
+	{
+		Name:        "annotation",
+		Label:       "标注",
+		Description: "记录过程中添加的标注，同时保存为会话标记",
+		Fields: []SensorField{
+			{Key: "text", Label: "标注内容", Type: FieldTypeString, Description: "标注的文字"},
+			{Key: "millisecond_press_duration", Label: "按压时长", Unit: "毫秒", Precision: 0, Description: "添加标注时按住按钮的时长", Range: &FieldRange{0, math.MaxInt32}},
+		},
+	},
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSensorRegistryParseValues(t *testing.T) {
+	registry := NewSensorRegistry(builtinSensorDescriptors)
+
+	// 只包含读数中存在的字段，顺序与描述一致，按描述的精度格式化
+	values := registry.ParseValues("Location", map[string]interface{}{
+		"longitude": 139.6917,
+		"latitude":  35.6895,
+		"speed":     1.234,
+	})
+	if len(values) != 3 || values[0].Key != "latitude" || values[1].Key != "longitude" || values[2].Key != "speed" {
+		t.Fatalf("字段或顺序不正确: %+v", values)
+	}
+	if values[0].Value != "35.68950000" || values[0].Unit != "度" || values[2].Value != "1.23" {
+		t.Errorf("格式不正确: %+v", values)
+	}
+
+	// 未登记的传感器按通用方式处理
+	if generic := registry.ParseValues("unknown", map[string]interface{}{"level": 0.5}); len(generic) != 1 || generic[0].Key != "level" {
+		t.Errorf("未登记的传感器应按通用方式处理: %+v", generic)
+	}
+}
+
+func TestSensorRegistryMerge(t *testing.T) {
+	base := NewSensorRegistry(builtinSensorDescriptors)
+	merged := base.Merge([]SensorDescriptor{
+		{Name: "Barometer", Fields: []SensorField{
+			{Key: "pressure", Label: "大气压", Unit: "kPa", Precision: 3, Required: true},
+			{Key: "temperature", Label: "温度", Unit: "°C", Precision: 1},
+		}},
+		{Name: "light", Label: "光线传感器", Fields: []SensorField{{Key: "lux", Label: "照度", Unit: "lx", Precision: 0}}},
+	})
+
+	barometer, _ := merged.Lookup("barometer")
+	if barometer.Label != "气压计" || len(barometer.Fields) != 4 {
+		t.Fatalf("同名传感器应按键名合并字段: %+v", barometer)
+	}
+	if barometer.Fields[0].Label != "大气压" || barometer.Fields[0].Range != nil || barometer.Fields[3].Key != "temperature" {
+		t.Errorf("字段替换或追加不正确: %+v", barometer.Fields)
+	}
+	if _, ok := merged.Lookup("light"); !ok {
+		t.Error("新传感器应加入登记表")
+	}
+
+	// 原登记表不受影响
+	if original, _ := base.Lookup("barometer"); original.Fields[0].Label != "气压" {
+		t.Errorf("合并不应修改原登记表: %+v", original.Fields[0])
+	}
+}
+
+func TestLoadSensorDescriptorFile(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "sensors.yaml")
+	os.WriteFile(yamlPath, []byte(`
+- name: light
+  label: 光线传感器
+  fields:
+    - key: lux
+      label: 照度
+      unit: lx
+      precision: 0
+      required: true
+      range: {min: 0, max: 200000}
+`), 0644)
+	descriptors, err := loadSensorDescriptorFile(yamlPath)
+	if err != nil {
+		t.Fatalf("加载YAML描述文件失败: %v", err)
+	}
+	if len(descriptors) != 1 || descriptors[0].Fields[0].Range == nil || descriptors[0].Fields[0].Range.Max != 200000 {
+		t.Errorf("YAML描述解析不正确: %+v", descriptors)
+	}
+
+	jsonPath := filepath.Join(dir, "sensors.json")
+	os.WriteFile(jsonPath, []byte(`[{"name":"light","label":"光线","fields":[{"key":"lux","label":"照度","precision":0}]}]`), 0644)
+	if descriptors, err := loadSensorDescriptorFile(jsonPath); err != nil || descriptors[0].Fields[0].Key != "lux" {
+		t.Errorf("加载JSON描述文件失败: %v %+v", err, descriptors)
+	}
+
+	invalid := map[string]string{
+		"缺少名称": `[{"label":"x","fields":[]}]`,
+		"缺少标签": `[{"name":"light","fields":[{"key":"lux","precision":0}]}]`,
+		"字段重复": `[{"name":"light","fields":[{"key":"lux","label":"a"},{"key":"lux","label":"b"}]}]`,
+		"精度无效": `[{"name":"light","fields":[{"key":"lux","label":"a","precision":-1}]}]`,
+		"范围无效": `[{"name":"light","fields":[{"key":"lux","label":"a","range":{"min":10,"max":1}}]}]`,
+		"格式错误": `{"name":"light"}`,
+	}
+	for name, content := range invalid {
+		os.WriteFile(jsonPath, []byte(content), 0644)
+		if _, err := loadSensorDescriptorFile(jsonPath); err == nil {
+			t.Errorf("%s: 期望加载失败", name)
+		}
+	}
+}
+
+func TestSensorDescriptorsDriveValidation(t *testing.T) {
+	originalRegistry := sensorRegistry
+	defer func() { sensorRegistry = originalRegistry }()
+
+	now := time.Now()
+	message := &SensorMessage{
+		MessageID: 1,
+		SessionID: "session",
+		DeviceID:  "device",
+		Payload: []SensorReading{
+			{Name: "thermometer", Time: now.UnixNano(), Values: map[string]interface{}{"temperature": -500.0}},
+		},
+	}
+	opts := ValidationOptions{Mode: ValidationReject}
+
+	if report := validateSensorMessage(message, now, opts); !report.Valid {
+		t.Fatalf("未登记的传感器只校验通用字段: %+v", report.Violations)
+	}
+
+	sensorRegistry = sensorRegistry.Merge([]SensorDescriptor{
+		{Name: "thermometer", Label: "温度计", Fields: []SensorField{{Key: "temperature", Label: "温度", Required: true, Range: &FieldRange{-273.15, 100}}}},
+	})
+	report := validateSensorMessage(message, now, opts)
+	if report.Valid || report.Violations[0].Code != ViolationRange {
+		t.Errorf("登记的范围应参与校验: %+v", report.Violations)
+	}
+}
+
+// fullCatalogueMessage Sensor Logger开启全部传感器（包括配对手表）时发送的消息
+const fullCatalogueMessage = `{
+  "messageId": 12,
+  "sessionId": "6b7f3a0e-5a1c-4f0e-9d55-2c1e8f3b9a47",
+  "deviceId": "b1f2c3d4-e5f6-4711-8899-aabbccddeeff",
+  "payload": [
+    {"name": "totalacceleration", "time": 1751729987123456000, "values": {"z": -9.7862, "y": 0.2113, "x": -0.1375}},
+    {"name": "accelerometeruncalibrated", "time": 1751729987123456000, "values": {"z": -9.7911, "y": 0.2087, "x": -0.1402}},
+    {"name": "gyroscopeuncalibrated", "time": 1751729987124000000, "values": {"z": 0.0021, "y": -0.0134, "x": 0.0049}},
+    {"name": "microphone", "time": 1751729987200000000, "values": {"dBFS": -42.318}},
+    {"name": "light", "time": 1751729987210000000, "values": {"lux": 312.56}},
+    {"name": "battery", "time": 1751729987220000000, "values": {"batteryLevel": 0.87, "batteryState": "unplugged", "lowPowerMode": false}},
+    {"name": "brightness", "time": 1751729987230000000, "values": {"brightness": 0.6243}},
+    {"name": "network", "time": 1751729987240000000, "values": {"type": "wifi", "isConnected": true, "isInternetReachable": true}},
+    {"name": "heart rate", "time": 1751729987300000000, "values": {"bpm": 72}},
+    {"name": "wrist motion", "time": 1751729987310000000, "values": {
+      "rotationRateX": 0.0312, "rotationRateY": -0.0147, "rotationRateZ": 0.0059,
+      "gravityX": -0.1211, "gravityY": -0.9803, "gravityZ": -0.1562,
+      "accelerationX": 0.0041, "accelerationY": -0.0123, "accelerationZ": 0.0087,
+      "quaternionW": 0.7071, "quaternionX": 0.0123, "quaternionY": -0.0211, "quaternionZ": 0.7066}},
+    {"name": "annotation", "time": 1751729987400000000, "values": {"text": "开始上楼", "millisecond_press_duration": 450}},
+    {"name": "ambient", "time": 1751729987500000000, "values": {"zeta": 3, "alpha": 1, "mid": 2}}
+  ]
+}`
+
+func TestParseFullSensorCatalogue(t *testing.T) {
+	parsed, err := parseSensorMessage([]byte(fullCatalogueMessage))
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+
+	values := make(map[string]SensorValue)
+	for _, reading := range parsed.ParsedReadings {
+		for _, value := range reading.Values {
+			values[reading.SensorType+"."+value.Key] = value
+		}
+	}
+
+	expected := []struct {
+		key, name, value, unit string
+	}{
+		{"totalacceleration.z", "Z轴总加速度", "-9.786200", "m/s²"},
+		{"accelerometeruncalibrated.x", "X轴加速度(未校准)", "-0.140200", "m/s²"},
+		{"gyroscopeuncalibrated.y", "Y轴角速度(未校准)", "-0.013400", "rad/s"},
+		{"microphone.dBFS", "声音强度", "-42.32", "dBFS"},
+		{"light.lux", "照度", "312.6", "lx"},
+		{"battery.batteryLevel", "电量", "0.87", ""},
+		{"battery.batteryState", "充电状态", "unplugged", ""},
+		{"battery.lowPowerMode", "低电量模式", "否", ""},
+		{"brightness.brightness", "亮度", "0.624", ""},
+		{"network.type", "网络类型", "wifi", ""},
+		{"network.isConnected", "已连接", "是", ""},
+		{"heart rate.bpm", "心率", "72", "次/分"},
+		{"wrist motion.rotationRateX", "X轴角速度", "0.031200", "rad/s"},
+		{"wrist motion.quaternionZ", "四元数Z", "0.706600", ""},
+		{"annotation.text", "标注内容", "开始上楼", ""},
+		{"annotation.millisecond_press_duration", "按压时长", "450", "毫秒"},
+	}
+	for _, want := range expected {
+		got, ok := values[want.key]
+		if !ok {
+			t.Errorf("缺少 %s", want.key)
+			continue
+		}
+		if got.Name != want.name || got.Value != want.value || got.Unit != want.unit {
+			t.Errorf("%s: 期望 %s=%s%s，实际为 %+v", want.key, want.name, want.value, want.unit, got)
+		}
+	}
+
+	// 未登记的传感器按键名排序输出
+	generic := parsed.ParsedReadings[len(parsed.ParsedReadings)-1].Values
+	if len(generic) != 3 || generic[0].Key != "alpha" || generic[1].Key != "mid" || generic[2].Key != "zeta" {
+		t.Errorf("通用解析应按键名排序: %+v", generic)
+	}
+
+	// 标注保存为会话标记
+	if len(parsed.Markers) != 1 {
+		t.Fatalf("期望1个会话标记，实际为%d", len(parsed.Markers))
+	}
+	marker := parsed.Markers[0]
+	if marker.Text != "开始上楼" || marker.Duration != 450 || !marker.Time.Equal(time.Unix(0, 1751729987400000000)) {
+		t.Errorf("会话标记不正确: %+v", marker)
+	}
+	if doc := newSensorMessageDocument(parsed); len(doc.Markers) != 1 {
+		t.Error("会话标记应随消息文档保存")
+	}
+
+	// 完整的消息应通过校验
+	var message SensorMessage
+	if err := json.Unmarshal([]byte(fullCatalogueMessage), &message); err != nil {
+		t.Fatalf("解码失败: %v", err)
+	}
+	received := time.Unix(0, 1751729988000000000)
+	if report := validateSensorMessage(&message, received, ValidationOptions{Mode: ValidationReject}); !report.Valid {
+		t.Errorf("真实数据不应产生校验错误: %+v", report.Violations)
+	}
+}
+
+func TestSensorFieldTypeValidation(t *testing.T) {
+	now := time.Now()
+	message := &SensorMessage{
+		MessageID: 1,
+		SessionID: "session",
+		DeviceID:  "device",
+		Payload: []SensorReading{
+			{Name: "network", Time: now.UnixNano(), Values: map[string]interface{}{"type": 1.0, "isConnected": "yes"}},
+		},
+	}
+
+	report := validateSensorMessage(message, now, ValidationOptions{Mode: ValidationReject})
+	if report.Valid || len(report.Violations) != 2 {
+		t.Fatalf("期望2个类型错误: %+v", report.Violations)
+	}
+	for _, violation := range report.Violations {
+		if violation.Code != ViolationType {
+			t.Errorf("期望类型错误: %+v", violation)
+		}
+	}
+
+	invalid := []SensorDescriptor{{Name: "x", Fields: []SensorField{{Key: "a", Label: "a", Type: "date"}}}}
+	if err := validateSensorDescriptors(invalid); err == nil {
+		t.Error("未知的字段类型应被拒绝")
+	}
+}
+
+func TestHandleDBMarkersWithoutMongo(t *testing.T) {
+	setMongoConnection(nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/db/markers?session=abc", nil)
+	rr := httptest.NewRecorder()
+	handleDBMarkers(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("期望503，实际为%d", rr.Code)
+	}
+}
+
+func TestHandleSensorCatalog(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/sensors", nil)
+	rr := httptest.NewRecorder()
+	handleSensorCatalog(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("期望200，实际为%d", rr.Code)
+	}
+	var response SensorCatalogResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	if len(response.Sensors) != len(builtinSensorDescriptors) {
+		t.Errorf("期望%d个传感器，实际为%d", len(builtinSensorDescriptors), len(response.Sensors))
+	}
+	for i := 1; i < len(response.Sensors); i++ {
+		if response.Sensors[i-1].Name > response.Sensors[i].Name {
+			t.Error("传感器应按名称排序")
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// shutdownStep 关闭流程中的一步
+type shutdownStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// runShutdownSteps 按顺序执行关闭步骤，全部步骤在ctx截止前完成且没有出错时返回true。
+// 某一步超时后不再等待它和剩余的步骤（进程随后退出）
+func runShutdownSteps(ctx context.Context, steps []shutdownStep) bool {
+	ok := true
+	for i, step := range steps {
+		start := time.Now()
+		done := make(chan error, 1)
+		go func() {
+			done <- step.run(ctx)
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				ok = false
+				Logger.Error("关闭步骤失败",
+					slog.String("step", step.name),
+					slog.String("error", err.Error()))
+				continue
+			}
+			Logger.Debug("关闭步骤完成",
+				slog.String("step", step.name),
+				slog.Duration("duration", time.Since(start)))
+		case <-ctx.Done():
+			skipped := make([]string, 0, len(steps)-i-1)
+			for _, rest := range steps[i+1:] {
+				skipped = append(skipped, rest.name)
+			}
+			Logger.Error("优雅关闭超时",
+				slog.String("step", step.name),
+				slog.Any("skipped", skipped))
+			return false
+		}
+	}
+	return ok
+}
+
+// shutdownServer 按顺序关闭服务器：先停止接收新请求并等待处理中的请求，再依次排空入库流水线、
+// 批量写入，保存内存数据，关闭归档、写前缓冲和MongoDB连接。全部数据都已写出时返回true
+func shutdownServer(server *http.Server, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	steps := []shutdownStep{
+		{"HTTP服务器", func(ctx context.Context) error {
+			// 停止接收新连接，等待处理中的请求（包括等待持久化确认的请求）完成
+			if server == nil {
+				return nil
+			}
+			return server.Shutdown(ctx)
+		}},
+		{"入库流水线", func(context.Context) error {
+			if ingestPipeline != nil {
+				return ingestPipeline.Close()
+			}
+			return nil
+		}},
+		{"MongoDB批量写入", func(context.Context) error {
+			// 写入失败的消息由回调写入写前缓冲，因此缓冲在之后关闭
+			if bulkWriter != nil {
+				return bulkWriter.Close()
+			}
+			return nil
+		}},
+		{"内存数据", func(context.Context) error {
+			return parsedDataStore.SaveSnapshot(memoryStoreSnapshotPath())
+		}},
+		{"后台任务", func(context.Context) error {
+			// 停止重新加载用户账户、签名密钥、设备令牌和证书
+			if userAccounts != nil {
+				userAccounts.Stop()
+			}
+			if signingKeys != nil {
+				signingKeys.Stop()
+			}
+			if deviceTokens != nil {
+				deviceTokens.Stop()
+			}
+			if serverCertificates != nil {
+				serverCertificates.Stop()
+			}
+			return nil
+		}},
+		{"原始数据归档", func(context.Context) error {
+			// 同步并压缩正在写入的段
+			if rawArchive != nil {
+				return rawArchive.Close()
+			}
+			return nil
+		}},
+		{"写前缓冲", func(context.Context) error {
+			// 未回放的缓冲数据保留在磁盘上，下次启动时继续
+			if messageSpool != nil {
+				messageSpool.Stop()
+			}
+			return nil
+		}},
+		{"MongoDB连接", func(context.Context) error {
+			if mongoSupervisor != nil {
+				return mongoSupervisor.Close()
+			}
+			return nil
+		}},
+	}
+
+	return runShutdownSteps(ctx, steps)
+}
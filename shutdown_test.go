@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunShutdownSteps(t *testing.T) {
+	var order []string
+	step := func(name string, err error) shutdownStep {
+		return shutdownStep{name, func(context.Context) error {
+			order = append(order, name)
+			return err
+		}}
+	}
+
+	// 某一步出错时继续执行剩余步骤，但结果为未完成
+	ok := runShutdownSteps(context.Background(), []shutdownStep{
+		step("a", nil), step("b", errors.New("失败")), step("c", nil),
+	})
+	if ok || len(order) != 3 || order[2] != "c" {
+		t.Errorf("期望按顺序执行全部步骤并返回false，实际为%v %v", ok, order)
+	}
+
+	// 超时后不再等待剩余步骤
+	order = nil
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	blocked := shutdownStep{"blocked", func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}}
+	if runShutdownSteps(ctx, []shutdownStep{blocked, step("after", nil)}) {
+		t.Error("超时应返回false")
+	}
+	if len(order) != 0 {
+		t.Errorf("超时后不应执行剩余步骤: %v", order)
+	}
+}
+
+func TestShutdownServerDrainsRequests(t *testing.T) {
+	originalPipeline, originalWriter := ingestPipeline, bulkWriter
+	originalArchive, originalSpool, originalSupervisor := rawArchive, messageSpool, mongoSupervisor
+	originalAccounts, originalKeys, originalTokens := userAccounts, signingKeys, deviceTokens
+	originalCerts, originalStore, originalDataDir := serverCertificates, parsedDataStore, AppConfig.DataDir
+	defer func() {
+		ingestPipeline, bulkWriter = originalPipeline, originalWriter
+		rawArchive, messageSpool, mongoSupervisor = originalArchive, originalSpool, originalSupervisor
+		userAccounts, signingKeys, deviceTokens = originalAccounts, originalKeys, originalTokens
+		serverCertificates, parsedDataStore, AppConfig.DataDir = originalCerts, originalStore, originalDataDir
+	}()
+	rawArchive, messageSpool, mongoSupervisor = nil, nil, nil
+	userAccounts, signingKeys, deviceTokens, serverCertificates = nil, nil, nil, nil
+	bulkWriter = nil
+	AppConfig.DataDir = t.TempDir()
+
+	ingestPipeline = NewIngestPipeline(1, 10)
+	ingestPipeline.Start()
+	parsedDataStore = NewThreadSafeDataStore()
+	parsedDataStore.Add(ParsedSensorData{MessageID: 1, DeviceID: "device-a"})
+
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "ok")
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	go server.Serve(listener)
+
+	result := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "ok" {
+				err = errors.New("响应不完整: " + string(body))
+			}
+		}
+		result <- err
+	}()
+	<-started
+
+	if !shutdownServer(server, 5*time.Second) {
+		t.Error("全部步骤完成时应返回true")
+	}
+	if err := <-result; err != nil {
+		t.Errorf("处理中的请求应正常完成: %v", err)
+	}
+	if err := ingestPipeline.Enqueue(&IngestJob{}); !errors.Is(err, errIngestClosed) {
+		t.Errorf("关闭后入库流水线应拒绝新消息，实际为%v", err)
+	}
+
+	// 内存数据已保存，下次启动时恢复
+	restored := NewThreadSafeDataStore()
+	if count, err := restored.LoadSnapshot(filepath.Join(AppConfig.DataDir, "memory_store.json"), 100); err != nil || count != 1 {
+		t.Errorf("期望恢复1条内存数据，实际为%d: %v", count, err)
+	}
+}
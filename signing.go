@@ -0,0 +1,541 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sensor-logger-server/client"
+)
+
+// 请求签名校验模式
+const (
+	SignatureOff     = "off"     // 不校验签名（默认）
+	SignatureWarn    = "warn"    // 校验签名，未通过时只记录日志
+	SignatureEnforce = "enforce" // 配置了签名密钥的设备必须签名，未通过返回401
+)
+
+// validSignatureModes 支持的签名校验模式
+var validSignatureModes = []string{SignatureOff, SignatureWarn, SignatureEnforce}
+
+// 签名密钥前缀
+const signingSecretPrefix = "sls_"
+
+var (
+	// errSignatureMissing 设备配置了签名密钥但请求未签名
+	errSignatureMissing = errors.New("缺少签名或时间戳")
+	// errSignatureMalformed 签名或时间戳格式错误
+	errSignatureMalformed = errors.New("签名或时间戳格式错误")
+	// errSignatureExpired 时间戳超出允许的时间窗口
+	errSignatureExpired = errors.New("时间戳超出允许的时间窗口")
+	// errSignatureReplayed 同一签名在时间窗口内重复使用
+	errSignatureReplayed = errors.New("签名已被使用")
+	// errSignatureNoKey 请求带有签名但设备没有签名密钥
+	errSignatureNoKey = errors.New("设备没有签名密钥")
+	// errSignatureMismatch 签名不正确
+	errSignatureMismatch = errors.New("签名不正确")
+	// errSignatureUnsupported 设备配置了签名密钥，但接口无法逐条校验消息的签名
+	errSignatureUnsupported = errors.New("设备配置了签名密钥，请通过 /data 提交签名的消息")
+	// errSigningKeyNotFound 签名密钥不存在
+	errSigningKeyNotFound = errors.New("签名密钥不存在")
+)
+
+// 全局签名密钥存储
+var signingKeys *SigningKeyStore
+
+// 全局已使用签名的记录，用于拒绝时间窗口内的重放
+var signatureReplays = newReplayCache()
+
+// SigningKey 设备的签名密钥（HMAC需要原始密钥，因此保存明文，文件权限为0600）
+type SigningKey struct {
+	ID        string     `json:"id"`
+	DeviceID  string     `json:"deviceId"`
+	Secret    string     `json:"secret"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// SigningKeyStore 签名密钥存储（数据目录中的JSON文件），文件被命令行修改后由后台检查重新加载。
+// 同一设备可以有多个有效密钥，便于轮换
+type SigningKeyStore struct {
+	path string
+
+	mutex    sync.RWMutex
+	keys     []SigningKey
+	byDevice map[string][]SigningKey // 设备ID -> 有效密钥
+	modTime  time.Time
+
+	// 串行化文件的读写
+	fileMutex sync.Mutex
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewSigningKeyStore 创建签名密钥存储
+func NewSigningKeyStore(path string) *SigningKeyStore {
+	return &SigningKeyStore{
+		path:     path,
+		byDevice: make(map[string][]SigningKey),
+	}
+}
+
+// Start 加载密钥，文件修改后在后台重新加载
+func (s *SigningKeyStore) Start() {
+	if err := s.Reload(); err != nil {
+		LogError("加载签名密钥", err)
+	}
+
+	s.stopCh = make(chan struct{})
+	s.doneCh = make(chan struct{})
+	go func() {
+		defer close(s.doneCh)
+
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stopCh:
+				return
+			case <-ticker.C:
+				if err := s.reloadIfChanged(); err != nil {
+					LogError("重新加载签名密钥", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop 停止后台检查
+func (s *SigningKeyStore) Stop() {
+	if s.stopCh != nil {
+		close(s.stopCh)
+		<-s.doneCh
+	}
+}
+
+// Reload 从文件加载密钥
+func (s *SigningKeyStore) Reload() error {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+
+	keys, modTime, err := readSigningKeyFile(s.path)
+	if err != nil {
+		return err
+	}
+	s.setKeys(keys, modTime)
+	return nil
+}
+
+// reloadIfChanged 文件修改时间变化时重新加载
+func (s *SigningKeyStore) reloadIfChanged() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mutex.RLock()
+	unchanged := info.ModTime().Equal(s.modTime)
+	s.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+	return s.Reload()
+}
+
+// setKeys 替换内存中的密钥
+func (s *SigningKeyStore) setKeys(keys []SigningKey, modTime time.Time) {
+	byDevice := make(map[string][]SigningKey)
+	for _, key := range keys {
+		if key.RevokedAt == nil {
+			byDevice[key.DeviceID] = append(byDevice[key.DeviceID], key)
+		}
+	}
+
+	s.mutex.Lock()
+	s.keys, s.byDevice, s.modTime = keys, byDevice, modTime
+	s.mutex.Unlock()
+}
+
+// DeviceKeys 设备的有效密钥
+func (s *SigningKeyStore) DeviceKeys(deviceID string) []SigningKey {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.byDevice[deviceID]
+}
+
+// List 按创建时间列出密钥（不含密钥本身），deviceID不为空时只列出该设备的密钥
+func (s *SigningKeyStore) List(deviceID string) []SigningKey {
+	s.mutex.RLock()
+	keys := make([]SigningKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		if deviceID == "" || key.DeviceID == deviceID {
+			key.Secret = ""
+			keys = append(keys, key)
+		}
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+// Create 为设备创建签名密钥（已有的密钥保持有效，轮换完成后再吊销）
+func (s *SigningKeyStore) Create(deviceID string) (SigningKey, error) {
+	if deviceID == "" {
+		return SigningKey{}, errors.New("deviceId不能为空")
+	}
+
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return SigningKey{}, fmt.Errorf("生成签名密钥失败: %v", err)
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return SigningKey{}, fmt.Errorf("生成签名密钥失败: %v", err)
+	}
+
+	key := SigningKey{
+		ID:        hex.EncodeToString(id[:]),
+		DeviceID:  deviceID,
+		Secret:    signingSecretPrefix + hex.EncodeToString(secret[:]),
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	err := s.update(func(keys []SigningKey) ([]SigningKey, error) {
+		return append(keys, key), nil
+	})
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return key, nil
+}
+
+// Revoke 吊销签名密钥
+func (s *SigningKeyStore) Revoke(id string) (SigningKey, error) {
+	var revoked SigningKey
+	err := s.update(func(keys []SigningKey) ([]SigningKey, error) {
+		for i, key := range keys {
+			if key.ID != id {
+				continue
+			}
+			if key.RevokedAt == nil {
+				revokedAt := time.Now().UTC().Truncate(time.Second)
+				keys[i].RevokedAt = &revokedAt
+			}
+			revoked = keys[i]
+			revoked.Secret = ""
+			return keys, nil
+		}
+		return nil, errSigningKeyNotFound
+	})
+	return revoked, err
+}
+
+// update 读取最新的密钥文件，修改后写回
+func (s *SigningKeyStore) update(modify func(keys []SigningKey) ([]SigningKey, error)) error {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+
+	keys, _, err := readSigningKeyFile(s.path)
+	if err != nil {
+		return err
+	}
+	if keys, err = modify(keys); err != nil {
+		return err
+	}
+	modTime, err := writeSigningKeyFile(s.path, keys)
+	if err != nil {
+		return err
+	}
+	s.setKeys(keys, modTime)
+	return nil
+}
+
+// readSigningKeyFile 读取密钥文件，文件不存在时返回空列表
+func readSigningKeyFile(path string) ([]SigningKey, time.Time, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("读取签名密钥文件失败: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("读取签名密钥文件失败: %v", err)
+	}
+
+	var keys []SigningKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, time.Time{}, fmt.Errorf("解析签名密钥文件失败: %v", err)
+	}
+	return keys, info.ModTime(), nil
+}
+
+// writeSigningKeyFile 写入密钥文件（先写临时文件再替换），返回新的修改时间
+func writeSigningKeyFile(path string, keys []SigningKey) (time.Time, error) {
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return time.Time{}, fmt.Errorf("创建数据目录失败: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return time.Time{}, fmt.Errorf("写入签名密钥文件失败: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return time.Time{}, fmt.Errorf("写入签名密钥文件失败: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// signingKeyPath 签名密钥文件的路径
+func signingKeyPath() string {
+	return filepath.Join(AppConfig.DataDir, "signing_keys.json")
+}
+
+// replayCache 时间窗口内已使用的签名
+type replayCache struct {
+	mutex   sync.Mutex
+	seen    map[string]time.Time // 签名 -> 过期时间
+	lastGC  time.Time
+	maxSize int
+}
+
+// newReplayCache 创建重放记录
+func newReplayCache() *replayCache {
+	return &replayCache{seen: make(map[string]time.Time), maxSize: 1 << 20}
+}
+
+// Add 记录签名，签名在过期前已被记录时返回false
+func (c *replayCache) Add(signature string, expiresAt, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now.Sub(c.lastGC) > time.Minute || len(c.seen) >= c.maxSize {
+		for key, expiry := range c.seen {
+			if now.After(expiry) {
+				delete(c.seen, key)
+			}
+		}
+		c.lastGC = now
+	}
+
+	if expiry, ok := c.seen[signature]; ok && !now.After(expiry) {
+		return false
+	}
+	c.seen[signature] = expiresAt
+	return true
+}
+
+// bodyDigest 在读取请求体的同时计算传输编码后字节的SHA-256摘要
+type bodyDigest struct {
+	io.ReadCloser
+	hash hash.Hash
+}
+
+func (d *bodyDigest) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	d.hash.Write(p[:n])
+	return n, err
+}
+
+// newBodyDigest 替换请求体，使后续读取同时计算摘要
+func newBodyDigest(r *http.Request) *bodyDigest {
+	digest := &bodyDigest{ReadCloser: r.Body, hash: sha256.New()}
+	r.Body = digest
+	return digest
+}
+
+// Sum 读完剩余的请求体（解码器可能没有读到结尾），返回摘要的十六进制
+func (d *bodyDigest) Sum() string {
+	io.Copy(io.Discard, io.LimitReader(d, AppConfig.MaxBodyBytes))
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// verifyRequestSignature 校验请求签名：设备有有效密钥时必须签名；
+// 签名为 HMAC-SHA256(密钥, 时间戳 + "\n" + 请求体SHA-256的十六进制)，时间戳需在 SIGNATURE_MAX_SKEW 秒以内且签名不能重复使用
+func verifyRequestSignature(r *http.Request, deviceID string, digest *bodyDigest, now time.Time) error {
+	timestampValue := r.Header.Get(client.TimestampHeader)
+	signatureValue := r.Header.Get(client.SignatureHeader)
+
+	var keys []SigningKey
+	if signingKeys != nil {
+		keys = signingKeys.DeviceKeys(deviceID)
+	}
+	if len(keys) == 0 {
+		if signatureValue != "" {
+			return errSignatureNoKey
+		}
+		return nil
+	}
+	if timestampValue == "" || signatureValue == "" {
+		return errSignatureMissing
+	}
+
+	timestamp, err := strconv.ParseInt(timestampValue, 10, 64)
+	signature, found := strings.CutPrefix(signatureValue, client.SignatureVersion+"=")
+	if err != nil || !found {
+		return errSignatureMalformed
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return errSignatureMalformed
+	}
+
+	window := time.Duration(AppConfig.SignatureMaxSkew) * time.Second
+	signedAt := time.Unix(timestamp, 0)
+	if signedAt.Before(now.Add(-window)) || signedAt.After(now.Add(window)) {
+		return errSignatureExpired
+	}
+
+	stringToSign := timestampValue + "\n" + digest.Sum()
+	for _, key := range keys {
+		mac := hmac.New(sha256.New, []byte(key.Secret))
+		mac.Write([]byte(stringToSign))
+		if hmac.Equal(mac.Sum(nil), expected) {
+			if !signatureReplays.Add(signature, signedAt.Add(window), now) {
+				return errSignatureReplayed
+			}
+			return nil
+		}
+	}
+	return errSignatureMismatch
+}
+
+// checkRequestSignature 按 SIGNATURE_MODE 处理签名校验的结果，需要拒绝请求时返回错误
+func checkRequestSignature(r *http.Request, deviceID string, digest *bodyDigest) error {
+	err := verifyRequestSignature(r, deviceID, digest, time.Now())
+	if err == nil {
+		return nil
+	}
+
+	Logger.Warn("请求签名校验失败",
+		slog.String("device_id", deviceID),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("reason", err.Error()),
+		slog.String("mode", AppConfig.SignatureMode))
+	if AppConfig.SignatureMode == SignatureEnforce {
+		return err
+	}
+	return nil
+}
+
+// checkUnsignedMessage 用于无法校验签名的接口（批量导入逐条处理请求体中的消息，整个请求体的签名
+// 要读完才能校验）：设备配置了签名密钥时按 SIGNATURE_MODE 记录日志或拒绝该消息
+func checkUnsignedMessage(r *http.Request, deviceID string) error {
+	if AppConfig.SignatureMode == SignatureOff || signingKeys == nil || len(signingKeys.DeviceKeys(deviceID)) == 0 {
+		return nil
+	}
+
+	Logger.Warn("请求签名校验失败",
+		slog.String("device_id", deviceID),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("reason", errSignatureUnsupported.Error()),
+		slog.String("mode", AppConfig.SignatureMode))
+	if AppConfig.SignatureMode == SignatureEnforce {
+		return errSignatureUnsupported
+	}
+	return nil
+}
+
+// SignatureErrorResponse 签名校验失败时的错误响应
+type SignatureErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// runSigningKeyCommand 管理设备签名密钥：signing-key create|list|revoke
+func runSigningKeyCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "用法: signing-key create -device ID | signing-key list [-device ID] | signing-key revoke <密钥ID>")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("signing-key "+args[0], flag.ContinueOnError)
+	deviceID := flags.String("device", "", "设备ID")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	AppConfig.EnableLogging = false
+	store := NewSigningKeyStore(signingKeyPath())
+	if err := store.Reload(); err != nil {
+		fmt.Fprintf(os.Stderr, "加载签名密钥失败: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "create":
+		if *deviceID == "" {
+			fmt.Fprintln(os.Stderr, "请使用 -device 指定设备ID")
+			return 2
+		}
+		key, err := store.Create(*deviceID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "创建签名密钥失败: %v\n", err)
+			return 1
+		}
+		fmt.Printf("密钥ID: %s\n设备ID: %s\n签名密钥: %s\n", key.ID, key.DeviceID, key.Secret)
+		fmt.Println("客户端请使用 sensor-logger-server/client 包签名请求，请妥善保管密钥")
+
+	case "list":
+		for _, key := range store.List(*deviceID) {
+			status := "有效"
+			if key.RevokedAt != nil {
+				status = "已吊销 " + key.RevokedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%s  %-24s  %s  %s\n",
+				key.ID, key.DeviceID, key.CreatedAt.Local().Format("2006-01-02 15:04:05"), status)
+		}
+
+	case "revoke":
+		if flags.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "请指定要吊销的密钥ID")
+			return 2
+		}
+		key, err := store.Revoke(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "吊销签名密钥失败: %v\n", err)
+			return 1
+		}
+		fmt.Printf("已吊销签名密钥 %s（设备 %s）\n", key.ID, key.DeviceID)
+
+	default:
+		fmt.Fprintf(os.Stderr, "未知的signing-key子命令: %s\n", args[0])
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sensor-logger-server/client"
+)
+
+func TestSigningKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing_keys.json")
+	store := NewSigningKeyStore(path)
+
+	first, err := store.Create("device-a")
+	if err != nil {
+		t.Fatalf("创建签名密钥失败: %v", err)
+	}
+	store.Create("device-a")
+	if keys := store.DeviceKeys("device-a"); len(keys) != 2 {
+		t.Fatalf("轮换期间两个密钥都应有效，实际为%d个", len(keys))
+	}
+
+	// 其他进程（命令行）的修改在重新加载后可见
+	reloaded := NewSigningKeyStore(path)
+	if err := reloaded.Reload(); err != nil {
+		t.Fatalf("重新加载失败: %v", err)
+	}
+	if _, err := reloaded.Revoke(first.ID); err != nil {
+		t.Fatalf("吊销签名密钥失败: %v", err)
+	}
+	store.Reload()
+	if keys := store.DeviceKeys("device-a"); len(keys) != 1 || keys[0].ID == first.ID {
+		t.Errorf("吊销后只应剩下新密钥: %+v", keys)
+	}
+	if keys := store.List(""); len(keys) != 2 || keys[0].Secret != "" {
+		t.Errorf("列表不应包含密钥本身: %+v", keys)
+	}
+	if _, err := store.Revoke("missing"); err != errSigningKeyNotFound {
+		t.Errorf("吊销不存在的密钥应返回errSigningKeyNotFound，实际为%v", err)
+	}
+}
+
+func TestHandleSensorDataSignature(t *testing.T) {
+	originalMode, originalStore := AppConfig.SignatureMode, signingKeys
+	originalClient := mongoClient
+	originalDedup, originalPipeline := messageDeduplicator, ingestPipeline
+	originalLogging, originalSkew := AppConfig.EnableLogging, AppConfig.SignatureMaxSkew
+	defer func() {
+		AppConfig.SignatureMode, signingKeys = originalMode, originalStore
+		mongoClient = originalClient
+		messageDeduplicator, ingestPipeline = originalDedup, originalPipeline
+		AppConfig.EnableLogging, AppConfig.SignatureMaxSkew = originalLogging, originalSkew
+	}()
+	setMongoConnection(nil)
+	ingestPipeline = nil
+	AppConfig.EnableLogging = false
+	AppConfig.SignatureMode, AppConfig.SignatureMaxSkew = SignatureEnforce, 300
+
+	signingKeys = NewSigningKeyStore(filepath.Join(t.TempDir(), "signing_keys.json"))
+	key, _ := signingKeys.Create("decode-device")
+
+	post := func(body []byte, sign func(req *http.Request)) int {
+		// 每个请求使用新的去重器，避免重复消息掩盖签名校验的结果
+		messageDeduplicator = NewMessageDeduplicator(100)
+		req := httptest.NewRequest(http.MethodPost, "/data", bytes.NewReader(body))
+		if sign != nil {
+			sign(req)
+		}
+		rr := httptest.NewRecorder()
+		handleSensorData(rr, req)
+		return rr.Code
+	}
+	body := buildTestMessage(1)
+	now := time.Now()
+
+	if code := post(body, nil); code != http.StatusUnauthorized {
+		t.Errorf("配置了签名密钥的设备未签名期望401，实际为%d", code)
+	}
+
+	signed := func(req *http.Request) { client.Sign(req, key.Secret, body, now) }
+	if code := post(body, signed); code != http.StatusOK {
+		t.Fatalf("正确签名期望200，实际为%d", code)
+	}
+	if code := post(body, signed); code != http.StatusUnauthorized {
+		t.Errorf("重放的签名期望401，实际为%d", code)
+	}
+
+	tests := map[string]func(req *http.Request){
+		"过期的时间戳": func(req *http.Request) { client.Sign(req, key.Secret, body, now.Add(-10*time.Minute)) },
+		"错误的密钥":  func(req *http.Request) { client.Sign(req, "sls_wrong", body, now.Add(time.Second)) },
+		"篡改的请求体": func(req *http.Request) { client.Sign(req, key.Secret, buildTestMessage(2), now.Add(2*time.Second)) },
+		"格式错误": func(req *http.Request) {
+			req.Header.Set(client.TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+			req.Header.Set(client.SignatureHeader, "deadbeef")
+		},
+	}
+	for name, sign := range tests {
+		if code := post(body, sign); code != http.StatusUnauthorized {
+			t.Errorf("%s: 期望401，实际为%d", name, code)
+		}
+	}
+
+	// 压缩的请求体按传输的字节签名
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(body)
+	zw.Close()
+	messageDeduplicator = NewMessageDeduplicator(100)
+	req := httptest.NewRequest(http.MethodPost, "/data", bytes.NewReader(compressed.Bytes()))
+	req.Header.Set("Content-Encoding", "gzip")
+	client.Sign(req, key.Secret, compressed.Bytes(), now.Add(3*time.Second))
+	rr := httptest.NewRecorder()
+	handleSensorData(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("压缩请求体的签名期望200，实际为%d", rr.Code)
+	}
+
+	// 没有签名密钥的设备（例如手机应用）不受影响
+	signingKeys = NewSigningKeyStore(filepath.Join(t.TempDir(), "signing_keys.json"))
+	if code := post(body, nil); code != http.StatusOK {
+		t.Errorf("没有签名密钥的设备期望200，实际为%d", code)
+	}
+
+	// warn模式只记录日志
+	signingKeys.Create("decode-device")
+	AppConfig.SignatureMode = SignatureWarn
+	if code := post(body, nil); code != http.StatusOK {
+		t.Errorf("warn模式下未签名期望200，实际为%d", code)
+	}
+}
+
+func TestImportEndpointsRequireSignature(t *testing.T) {
+	original, originalKeys := AppConfig, signingKeys
+	originalDedup, originalPipeline, originalStore := messageDeduplicator, ingestPipeline, parsedDataStore
+	defer func() {
+		AppConfig, signingKeys = original, originalKeys
+		messageDeduplicator, ingestPipeline, parsedDataStore = originalDedup, originalPipeline, originalStore
+	}()
+	AppConfig.DataDir = t.TempDir()
+	AppConfig.EnableFileLog = false
+	AppConfig.EnableLogging = false
+	AppConfig.MaxDataStore = 1000
+	AppConfig.SignatureMode, AppConfig.SignatureMaxSkew = SignatureEnforce, 300
+	ingestPipeline = nil
+
+	signingKeys = NewSigningKeyStore(filepath.Join(t.TempDir(), "signing_keys.json"))
+	key, _ := signingKeys.Create("signed-device")
+
+	// 批量导入无法逐条校验签名，配置了签名密钥的设备的消息被拒绝，其他设备不受影响
+	results, summary := postBulk(t, strings.Join([]string{
+		bulkTestMessage(1),
+		strings.Replace(bulkTestMessage(2), "bulk-device", "signed-device", 1),
+	}, "\n"))
+	if summary.Accepted != 1 || summary.Rejected != 1 {
+		t.Errorf("期望接收1条、拒绝1条，实际为%+v", summary)
+	}
+	if len(results) != 2 || results[1].HTTPStatus != http.StatusUnauthorized {
+		t.Errorf("配置了签名密钥的设备的消息期望401: %+v", results)
+	}
+
+	parsedDataStore = NewThreadSafeDataStore()
+	data := buildRecordingExport(t, testRecordingFiles)
+	post := func(sign func(req *http.Request)) *httptest.ResponseRecorder {
+		messageDeduplicator = NewMessageDeduplicator(100)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/import/sensor-logger?deviceId=signed-device", bytes.NewReader(data))
+		if sign != nil {
+			sign(req)
+		}
+		rr := httptest.NewRecorder()
+		handleRecordingImport(rr, req)
+		return rr
+	}
+
+	// 录音导入与 /data 一样校验整个请求体的签名，未通过时不导入任何数据
+	if rr := post(nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("未签名的导入期望401，实际为%d: %s", rr.Code, rr.Body.String())
+	}
+	if count := parsedDataStore.Len(); count != 0 {
+		t.Errorf("签名校验失败时不应导入数据，实际内存中有%d条", count)
+	}
+	signed := func(req *http.Request) { client.Sign(req, key.Secret, data, time.Now()) }
+	if rr := post(signed); rr.Code != http.StatusOK {
+		t.Errorf("正确签名的导入期望200，实际为%d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// 全局写前缓冲（为nil时不缓冲，MongoDB写入失败的数据只记录日志）
+var messageSpool *Spool
+
+const (
+	// 命令行工具的缓冲目录所在的子目录，服务器回放时并入自己的缓冲
+	spoolHandoffDir = "handoff"
+	// 命令行工具运行期间缓冲目录带有该后缀，退出时去掉
+	spoolWritingExt = ".writing"
+	// 超过该时间没有修改的 .writing 目录视为命令行工具崩溃遗留
+	spoolAbandonAge = 24 * time.Hour
+	// MongoDB单个文档的最大大小，缓冲文件中更大的长度说明文件已损坏
+	maxSpoolDocumentBytes = 16 << 20
+)
+
+// SpoolStats 写前缓冲统计信息
+type SpoolStats struct {
+	Dir             string    `json:"dir"`
+	PendingSessions int       `json:"pendingSessions"`
+	PendingMessages int64     `json:"pendingMessages"`
+	Spooled         int64     `json:"spooled"`
+	Replayed        int64     `json:"replayed"`
+	Duplicates      int64     `json:"duplicates"`
+	LastReplayAt    time.Time `json:"lastReplayAt,omitempty"`
+	LastError       string    `json:"lastError,omitempty"`
+}
+
+// spoolSession 单个会话的缓冲文件
+type spoolSession struct {
+	mutex   sync.Mutex
+	path    string
+	pending int64
+}
+
+// Spool 写前缓冲：按会话把MongoDB未确认的消息文档（BSON）追加到 DataDir/spool 下，
+// 数据库恢复后由后台协程按写入顺序回放。会话锁只在进程内有效，命令行工具使用 handoff 下各自的目录，
+// 退出后由服务器并入自己的缓冲
+type Spool struct {
+	dir     string
+	handoff string // 命令行工具的缓冲目录（带 .writing 后缀），服务器为空
+
+	mutex    sync.Mutex
+	sessions map[string]*spoolSession
+
+	spooled    atomic.Int64
+	replayed   atomic.Int64
+	duplicates atomic.Int64
+
+	statusMutex  sync.Mutex
+	lastReplayAt time.Time
+	lastError    string
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+
+	// insert 回放时写入数据库的函数（默认为InsertSpooledMessage）
+	insert func(raw bson.Raw) error
+}
+
+// NewSpool 创建写前缓冲，并加载目录中尚未回放的缓冲文件
+func NewSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建缓冲目录失败: %v", err)
+	}
+
+	s := &Spool{
+		dir:      dir,
+		sessions: make(map[string]*spoolSession),
+		insert:   InsertSpooledMessage,
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.bson"))
+	if err != nil {
+		return nil, fmt.Errorf("扫描缓冲目录失败: %v", err)
+	}
+	for _, path := range files {
+		docs, err := readSpoolFile(path)
+		if err != nil {
+			Logger.Warn("缓冲文件损坏，已读取有效部分",
+				slog.String("path", path),
+				slog.String("error", err.Error()))
+		}
+		if len(docs) == 0 {
+			os.Remove(path)
+			continue
+		}
+		sessionID := docs[0].Lookup("sessionId").StringValue()
+		s.sessions[sessionID] = &spoolSession{path: path, pending: int64(len(docs))}
+	}
+	s.adoptHandoffs()
+
+	if len(s.sessions) > 0 {
+		Logger.Info("发现待回放的缓冲数据",
+			slog.Int("sessions", len(s.sessions)),
+			slog.Int64("messages", s.pendingMessages()))
+	}
+	return s, nil
+}
+
+// NewCommandSpool 为命令行工具在 root/handoff 下创建独立的缓冲目录，避免与同时运行的服务器写同一个会话文件；
+// 退出时调用 Handoff 交给服务器回放
+func NewCommandSpool(root string) (*Spool, error) {
+	name := time.Now().UTC().Format("20060102T150405") + "-" + strconv.Itoa(os.Getpid()) + spoolWritingExt
+	spool, err := NewSpool(filepath.Join(root, spoolHandoffDir, name))
+	if err != nil {
+		return nil, err
+	}
+	spool.handoff = spool.dir
+	return spool, nil
+}
+
+// Handoff 命令行工具退出时去掉缓冲目录的 .writing 后缀，服务器下次回放时并入；没有缓冲数据时删除目录
+func (s *Spool) Handoff() error {
+	if s.handoff == "" {
+		return nil
+	}
+	if os.Remove(s.handoff) == nil {
+		return nil // 空目录
+	}
+	return os.Rename(s.handoff, strings.TrimSuffix(s.handoff, spoolWritingExt))
+}
+
+// adoptHandoffs 将命令行工具交出的缓冲文件追加到本缓冲对应会话的文件中；
+// 仍在写入的目录跳过，超过 spoolAbandonAge 没有修改的视为崩溃遗留一并处理
+func (s *Spool) adoptHandoffs() {
+	if s.handoff != "" {
+		return
+	}
+	dirs, err := filepath.Glob(filepath.Join(s.dir, spoolHandoffDir, "*"))
+	if err != nil {
+		return
+	}
+	for _, dir := range dirs {
+		if strings.HasSuffix(dir, spoolWritingExt) && !spoolAbandoned(dir) {
+			continue
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "*.bson"))
+		for _, path := range files {
+			if err := s.adoptFile(path); err != nil {
+				Logger.Warn("并入命令行工具的缓冲文件失败",
+					slog.String("path", path),
+					slog.String("error", err.Error()))
+			}
+		}
+		os.Remove(dir) // 只有全部并入后目录才为空
+	}
+}
+
+// adoptFile 将一个缓冲文件追加到对应会话的文件末尾后删除；中途失败时重复并入的消息回放时按重复处理
+func (s *Spool) adoptFile(path string) error {
+	docs, err := readSpoolFile(path)
+	if err != nil {
+		Logger.Warn("缓冲文件损坏，已读取有效部分",
+			slog.String("path", path),
+			slog.String("error", err.Error()))
+	}
+	if len(docs) > 0 {
+		session := s.lockSession(docs[0].Lookup("sessionId").StringValue())
+		err := appendSpoolDocs(session.path, docs)
+		if err == nil {
+			session.pending += int64(len(docs))
+		}
+		session.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// spoolAbandoned 判断 .writing 目录是否长时间没有修改
+func spoolAbandoned(dir string) bool {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return false
+	}
+	latest := info.ModTime()
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return time.Since(latest) > spoolAbandonAge
+}
+
+// spoolFileName 根据会话ID生成安全的文件名
+func spoolFileName(sessionID string) string {
+	return safeFileComponent(sessionID) + ".bson"
+}
+
+// session 获取会话对应的缓冲文件（不存在时创建记录）
+func (s *Spool) session(sessionID string) *spoolSession {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		session = &spoolSession{path: filepath.Join(s.dir, spoolFileName(sessionID))}
+		s.sessions[sessionID] = session
+	}
+	return session
+}
+
+// lockSession 获取并锁定会话对应的缓冲文件。回放排空会话后会将其移出映射，
+// 加锁后需确认会话仍在映射中，否则写入会落在已移除的记录上而不会被回放
+func (s *Spool) lockSession(sessionID string) *spoolSession {
+	for {
+		session := s.session(sessionID)
+		session.mutex.Lock()
+		if s.isCurrent(sessionID, session) {
+			return session
+		}
+		session.mutex.Unlock()
+	}
+}
+
+// isCurrent 判断会话记录是否仍在映射中
+func (s *Spool) isCurrent(sessionID string, session *spoolSession) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sessions[sessionID] == session
+}
+
+// HasPending 判断会话是否有待回放的消息；为保持会话内顺序，此时新消息也应写入缓冲
+func (s *Spool) HasPending(sessionID string) bool {
+	s.mutex.Lock()
+	session, ok := s.sessions[sessionID]
+	s.mutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.pending > 0
+}
+
+// Append 追加一条消息文档并同步到磁盘
+func (s *Spool) Append(parsedData *ParsedSensorData) error {
+	raw, err := bson.Marshal(newSensorMessageDocument(parsedData))
+	if err != nil {
+		return fmt.Errorf("序列化缓冲消息失败: %v", err)
+	}
+
+	session := s.lockSession(parsedData.SessionID)
+	defer session.mutex.Unlock()
+
+	if err := appendSpoolDocs(session.path, []bson.Raw{raw}); err != nil {
+		return err
+	}
+
+	session.pending++
+	s.spooled.Add(1)
+	Logger.Debug("消息已写入缓冲",
+		slog.String("session_id", parsedData.SessionID),
+		slog.Int64("message_id", parsedData.MessageID),
+		slog.Int64("pending", session.pending))
+	return nil
+}
+
+// appendSpoolDocs 将文档追加到缓冲文件末尾并同步到磁盘（调用方持有会话锁）
+func appendSpoolDocs(path string, docs []bson.Raw) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("打开缓冲文件失败: %v", err)
+	}
+	defer file.Close()
+
+	for _, doc := range docs {
+		if _, err := file.Write(doc); err != nil {
+			return fmt.Errorf("写入缓冲文件失败: %v", err)
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("同步缓冲文件失败: %v", err)
+	}
+	return nil
+}
+
+// Start 启动后台回放协程
+func (s *Spool) Start(interval time.Duration) {
+	s.stopCh = make(chan struct{})
+	s.doneCh = make(chan struct{})
+
+	go func() {
+		defer close(s.doneCh)
+
+		// 启动时先回放上次运行遗留的缓冲数据
+		if mongoAvailable() {
+			s.Replay()
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if mongoAvailable() {
+					s.Replay()
+				}
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止后台回放协程
+func (s *Spool) Stop() {
+	if s.stopCh == nil {
+		return
+	}
+	close(s.stopCh)
+	<-s.doneCh
+}
+
+// Replay 先并入命令行工具交出的缓冲，再按会话回放所有缓冲消息，返回成功回放的数量；遇到非重复错误时停止本轮回放
+func (s *Spool) Replay() int {
+	s.adoptHandoffs()
+
+	s.mutex.Lock()
+	sessionIDs := make([]string, 0, len(s.sessions))
+	for sessionID := range s.sessions {
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	s.mutex.Unlock()
+	sort.Strings(sessionIDs)
+
+	total := 0
+	var replayErr error
+	for _, sessionID := range sessionIDs {
+		count, err := s.replaySession(sessionID)
+		total += count
+		if err != nil {
+			replayErr = err
+			break
+		}
+	}
+
+	s.statusMutex.Lock()
+	s.lastReplayAt = time.Now()
+	if replayErr != nil {
+		s.lastError = replayErr.Error()
+	} else {
+		s.lastError = ""
+	}
+	s.statusMutex.Unlock()
+
+	if total > 0 || replayErr != nil {
+		attrs := []any{slog.Int("replayed", total), slog.Int64("pending", s.pendingMessages())}
+		if replayErr != nil {
+			attrs = append(attrs, slog.String("error", replayErr.Error()))
+		}
+		Logger.Info("缓冲数据回放", attrs...)
+	}
+	return total
+}
+
+// replaySession 按写入顺序回放一个会话的缓冲文件，失败时保留未回放的部分
+func (s *Spool) replaySession(sessionID string) (int, error) {
+	s.mutex.Lock()
+	session, ok := s.sessions[sessionID]
+	s.mutex.Unlock()
+	if !ok {
+		return 0, nil
+	}
+
+	// 回放期间持有会话锁，新消息会等待回放结束后追加到文件末尾，保证顺序
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if !s.isCurrent(sessionID, session) {
+		return 0, nil
+	}
+
+	docs, readErr := readSpoolFile(session.path)
+	if readErr != nil && !errors.Is(readErr, os.ErrNotExist) {
+		Logger.Warn("缓冲文件损坏，已读取有效部分",
+			slog.String("path", session.path),
+			slog.String("error", readErr.Error()))
+	}
+
+	replayed := 0
+	var replayErr error
+	for _, doc := range docs {
+		dbStart := time.Now()
+		err := s.insert(doc)
+		if errors.Is(err, errDuplicateMessage) {
+			s.duplicates.Add(1)
+		} else if err != nil {
+			LogDatabaseOperation("replay_spooled_message", false, 1, time.Since(dbStart))
+			replayErr = err
+			break
+		} else {
+			LogDatabaseOperation("replay_spooled_message", true, 1, time.Since(dbStart))
+			s.replayed.Add(1)
+		}
+		replayed++
+	}
+
+	if err := rewriteSpoolFile(session.path, docs[replayed:]); err != nil {
+		return replayed, fmt.Errorf("更新缓冲文件失败: %v", err)
+	}
+	session.pending = int64(len(docs) - replayed)
+
+	// 仍持有会话锁，pending不会再变化；只移除映射中的同一条记录
+	if session.pending == 0 {
+		s.mutex.Lock()
+		if s.sessions[sessionID] == session {
+			delete(s.sessions, sessionID)
+		}
+		s.mutex.Unlock()
+	}
+	return replayed, replayErr
+}
+
+// Stats 获取统计信息
+func (s *Spool) Stats() SpoolStats {
+	s.mutex.Lock()
+	pendingSessions := len(s.sessions)
+	s.mutex.Unlock()
+
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+
+	return SpoolStats{
+		Dir:             s.dir,
+		PendingSessions: pendingSessions,
+		PendingMessages: s.pendingMessages(),
+		Spooled:         s.spooled.Load(),
+		Replayed:        s.replayed.Load(),
+		Duplicates:      s.duplicates.Load(),
+		LastReplayAt:    s.lastReplayAt,
+		LastError:       s.lastError,
+	}
+}
+
+// pendingMessages 待回放的消息总数
+func (s *Spool) pendingMessages() int64 {
+	s.mutex.Lock()
+	sessions := make([]*spoolSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mutex.Unlock()
+
+	var total int64
+	for _, session := range sessions {
+		session.mutex.Lock()
+		total += session.pending
+		session.mutex.Unlock()
+	}
+	return total
+}
+
+// readSpoolFile 读取缓冲文件中的所有BSON文档；文件末尾不完整的文档（写入中断）会被忽略并返回错误
+func readSpoolFile(path string) ([]bson.Raw, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	docs := make([]bson.Raw, 0)
+	for {
+		var lengthBytes [4]byte
+		if _, err := io.ReadFull(reader, lengthBytes[:]); err != nil {
+			if err == io.EOF {
+				return docs, nil
+			}
+			return docs, fmt.Errorf("读取文档长度失败: %v", err)
+		}
+
+		length := int32(binary.LittleEndian.Uint32(lengthBytes[:]))
+		if length < 5 || length > maxSpoolDocumentBytes {
+			return docs, fmt.Errorf("无效的文档长度: %d", length)
+		}
+
+		doc := make([]byte, length)
+		copy(doc, lengthBytes[:])
+		if _, err := io.ReadFull(reader, doc[4:]); err != nil {
+			return docs, fmt.Errorf("读取文档失败: %v", err)
+		}
+		if err := bson.Raw(doc).Validate(); err != nil {
+			return docs, fmt.Errorf("文档校验失败: %v", err)
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// rewriteSpoolFile 用剩余文档原子替换缓冲文件，没有剩余文档时删除文件
+func rewriteSpoolFile(path string, docs []bson.Raw) error {
+	if len(docs) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if _, err := file.Write(doc); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
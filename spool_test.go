@@ -0,0 +1,262 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSpoolAppendAndReload(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := NewSpool(dir)
+	if err != nil {
+		t.Fatalf("创建缓冲失败: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := spool.Append(newTestIngestJob(int64(i)).Parsed); err != nil {
+			t.Fatalf("写入缓冲失败: %v", err)
+		}
+	}
+	if !spool.HasPending("ingest-session") {
+		t.Error("写入后会话应有待回放消息")
+	}
+	if spool.HasPending("other-session") {
+		t.Error("未写入的会话不应有待回放消息")
+	}
+
+	// 重新打开目录时应恢复待回放的消息
+	reloaded, err := NewSpool(dir)
+	if err != nil {
+		t.Fatalf("重新加载缓冲失败: %v", err)
+	}
+	stats := reloaded.Stats()
+	if stats.PendingSessions != 1 || stats.PendingMessages != 3 {
+		t.Fatalf("期望1个会话3条消息，实际为%d个会话%d条消息", stats.PendingSessions, stats.PendingMessages)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.bson"))
+	if len(files) != 1 {
+		t.Fatalf("期望1个缓冲文件，实际为%d", len(files))
+	}
+	docs, err := readSpoolFile(files[0])
+	if err != nil {
+		t.Fatalf("读取缓冲文件失败: %v", err)
+	}
+	// 文件中的顺序应与写入顺序一致
+	for i, doc := range docs {
+		if messageID := doc.Lookup("messageId").Int64(); messageID != int64(i) {
+			t.Errorf("第%d条文档的messageId期望为%d，实际为%d", i, i, messageID)
+		}
+	}
+}
+
+func TestReadSpoolFileIgnoresTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := NewSpool(dir)
+	if err != nil {
+		t.Fatalf("创建缓冲失败: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := spool.Append(newTestIngestJob(int64(i)).Parsed); err != nil {
+			t.Fatalf("写入缓冲失败: %v", err)
+		}
+	}
+
+	// 模拟写入中途崩溃：文件末尾只有半条文档
+	path := filepath.Join(dir, spoolFileName("ingest-session"))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("打开缓冲文件失败: %v", err)
+	}
+	file.Write([]byte{0x40, 0x00, 0x00, 0x00, 0x02})
+	file.Close()
+
+	docs, err := readSpoolFile(path)
+	if err == nil {
+		t.Error("不完整的文档应返回错误")
+	}
+	if len(docs) != 2 {
+		t.Errorf("期望读取到2条完整文档，实际为%d", len(docs))
+	}
+
+	// 损坏的长度超过文档大小上限时直接报错，不按长度分配内存
+	os.WriteFile(path, []byte{0xff, 0xff, 0xff, 0x7f, 0x00}, 0644)
+	if docs, err := readSpoolFile(path); err == nil || len(docs) != 0 {
+		t.Errorf("超过上限的文档长度应返回错误: %d %v", len(docs), err)
+	}
+}
+
+func TestCommandSpoolHandoff(t *testing.T) {
+	root := t.TempDir()
+
+	server, err := NewSpool(root)
+	if err != nil {
+		t.Fatalf("创建缓冲失败: %v", err)
+	}
+	if err := server.Append(newTestIngestJob(1).Parsed); err != nil {
+		t.Fatalf("写入缓冲失败: %v", err)
+	}
+
+	// 命令行工具使用独立的目录，运行期间服务器不处理
+	command, err := NewCommandSpool(root)
+	if err != nil {
+		t.Fatalf("创建命令行缓冲失败: %v", err)
+	}
+	if command.dir == root {
+		t.Fatal("命令行工具不应使用服务器的缓冲目录")
+	}
+	for i := int64(2); i <= 3; i++ {
+		if err := command.Append(newTestIngestJob(i).Parsed); err != nil {
+			t.Fatalf("写入缓冲失败: %v", err)
+		}
+	}
+
+	var replayed []int64
+	server.insert = func(raw bson.Raw) error {
+		replayed = append(replayed, raw.Lookup("messageId").Int64())
+		return nil
+	}
+	server.Replay()
+	if len(replayed) != 1 {
+		t.Fatalf("命令行工具运行期间只应回放服务器自己的消息，实际为%v", replayed)
+	}
+
+	// 命令行工具退出后并入服务器的缓冲
+	if err := command.Handoff(); err != nil {
+		t.Fatalf("交出缓冲失败: %v", err)
+	}
+	server.Replay()
+	if len(replayed) != 3 || replayed[1] != 2 || replayed[2] != 3 {
+		t.Errorf("交出的消息未按顺序回放: %v", replayed)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, spoolHandoffDir)); len(entries) != 0 {
+		t.Errorf("并入后应删除命令行工具的目录，实际剩余%d个", len(entries))
+	}
+	if stats := server.Stats(); stats.PendingMessages != 0 {
+		t.Errorf("回放后不应有待回放消息: %+v", stats)
+	}
+
+	// 没有缓冲数据的命令行工具退出时删除目录
+	empty, _ := NewCommandSpool(root)
+	if err := empty.Handoff(); err != nil {
+		t.Fatalf("交出空缓冲失败: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, spoolHandoffDir)); len(entries) != 0 {
+		t.Errorf("空的命令行缓冲目录应删除，实际剩余%d个", len(entries))
+	}
+}
+
+func TestSpoolReplayKeepsMessagesWhenDatabaseUnavailable(t *testing.T) {
+	originalClient := mongoClient
+	mongoClient = nil
+	defer func() { mongoClient = originalClient }()
+
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建缓冲失败: %v", err)
+	}
+	if err := spool.Append(newTestIngestJob(1).Parsed); err != nil {
+		t.Fatalf("写入缓冲失败: %v", err)
+	}
+
+	if replayed := spool.Replay(); replayed != 0 {
+		t.Errorf("数据库不可用时不应回放成功，实际回放%d条", replayed)
+	}
+	stats := spool.Stats()
+	if stats.PendingMessages != 1 {
+		t.Errorf("回放失败后消息应保留在缓冲中，实际待回放%d条", stats.PendingMessages)
+	}
+	if stats.LastError == "" {
+		t.Error("回放失败时应记录错误")
+	}
+}
+
+func TestSpoolAppendDuringReplayIsNotLost(t *testing.T) {
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建缓冲失败: %v", err)
+	}
+	if err := spool.Append(newTestIngestJob(1).Parsed); err != nil {
+		t.Fatalf("写入缓冲失败: %v", err)
+	}
+
+	// 回放第一条消息时并发写入第二条：写入方拿到会话记录后等待回放释放会话锁，
+	// 回放排空并移除会话记录后，第二条消息不能落在已移除的记录上
+	var wg sync.WaitGroup
+	var inserted atomic.Int64
+	spool.insert = func(raw bson.Raw) error {
+		if inserted.Add(1) == 1 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := spool.Append(newTestIngestJob(2).Parsed); err != nil {
+					t.Errorf("写入缓冲失败: %v", err)
+				}
+			}()
+			time.Sleep(50 * time.Millisecond)
+		}
+		return nil
+	}
+
+	if replayed := spool.Replay(); replayed != 1 {
+		t.Fatalf("期望回放1条消息，实际回放%d条", replayed)
+	}
+	wg.Wait()
+
+	if !spool.HasPending("ingest-session") {
+		t.Fatal("回放期间写入的消息应计入待回放")
+	}
+	if replayed := spool.Replay(); replayed != 1 {
+		t.Errorf("期望再回放1条消息，实际回放%d条", replayed)
+	}
+	if stats := spool.Stats(); stats.PendingSessions != 0 || stats.PendingMessages != 0 {
+		t.Errorf("回放后不应有待回放数据，实际%d个会话、%d条消息", stats.PendingSessions, stats.PendingMessages)
+	}
+}
+
+func TestPersistSensorDataSpoolsWithoutDatabase(t *testing.T) {
+	originalClient, originalSpool := mongoClient, messageSpool
+	originalFileLog, originalLogging := AppConfig.EnableFileLog, AppConfig.EnableLogging
+	mongoClient = nil
+	AppConfig.EnableFileLog, AppConfig.EnableLogging = false, false
+	defer func() {
+		mongoClient, messageSpool = originalClient, originalSpool
+		AppConfig.EnableFileLog, AppConfig.EnableLogging = originalFileLog, originalLogging
+	}()
+
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建缓冲失败: %v", err)
+	}
+	messageSpool = spool
+
+	sinks, err := persistSensorData(newTestIngestJob(42).Parsed, nil)
+	if err != nil {
+		t.Fatalf("持久化失败: %v", err)
+	}
+	if sinks.Mongo != SinkSpooled {
+		t.Errorf("期望MongoDB写入结果为%s，实际为%s", SinkSpooled, sinks.Mongo)
+	}
+	if stats := spool.Stats(); stats.Spooled != 1 || stats.PendingMessages != 1 {
+		t.Errorf("期望缓冲1条消息，实际已缓冲%d条、待回放%d条", stats.Spooled, stats.PendingMessages)
+	}
+}
+
+func TestSpoolFileName(t *testing.T) {
+	name := spoolFileName("../../etc/passwd")
+	if strings.ContainsAny(name, "/\\") || strings.Contains(name, "..") {
+		t.Errorf("文件名包含路径字符: %s", name)
+	}
+	if spoolFileName("a/b") == spoolFileName("a_b") {
+		t.Error("不同会话ID不应映射到同一文件")
+	}
+}
@@ -1,6 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"sync"
 )
 
@@ -97,3 +103,53 @@ func (ts *ThreadSafeDataStore) GetAllForRead() []ParsedSensorData {
 	defer ts.mutex.RUnlock()
 	return ts.data
 }
+
+// SaveSnapshot 将内存中的数据写入文件（先写临时文件再替换），重启后由 LoadSnapshot 恢复
+func (ts *ThreadSafeDataStore) SaveSnapshot(path string) error {
+	data, err := json.Marshal(ts.Get())
+	if err != nil {
+		return fmt.Errorf("编码内存数据失败: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建数据目录失败: %v", err)
+	}
+	// 快照包含设备上传的原始读数，只允许本用户读写；先删除上次遗留的临时文件，确保按0600创建
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("写入内存数据快照失败: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("写入内存数据快照失败: %v", err)
+	}
+	return nil
+}
+
+// LoadSnapshot 从快照文件恢复数据（只保留最新的maxSize条），返回恢复的数量；文件不存在时不做任何修改
+func (ts *ThreadSafeDataStore) LoadSnapshot(path string, maxSize int) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("读取内存数据快照失败: %v", err)
+	}
+
+	var snapshot []ParsedSensorData
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("解析内存数据快照失败: %v", err)
+	}
+	if len(snapshot) > maxSize {
+		snapshot = snapshot[len(snapshot)-maxSize:]
+	}
+
+	ts.mutex.Lock()
+	ts.data = snapshot
+	ts.mutex.Unlock()
+	return len(snapshot), nil
+}
+
+// memoryStoreSnapshotPath 内存数据快照的路径
+func memoryStoreSnapshotPath() string {
+	return filepath.Join(AppConfig.DataDir, "memory_store.json")
+}
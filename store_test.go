@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -241,3 +243,48 @@ func BenchmarkThreadSafeDataStoreGet(b *testing.B) {
 		store.Get()
 	}
 }
+
+// TestDataStoreSnapshot 测试内存数据快照的保存和恢复
+func TestDataStoreSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory_store.json")
+	store := NewThreadSafeDataStore()
+
+	// 快照不存在时不做修改
+	if count, err := store.LoadSnapshot(path, 10); err != nil || count != 0 {
+		t.Errorf("快照不存在时期望恢复0条，实际为%d: %v", count, err)
+	}
+
+	for i := 1; i <= 5; i++ {
+		store.Add(ParsedSensorData{
+			MessageID:    int64(i),
+			DeviceID:     "test-device",
+			SensorCounts: map[string]int{"accelerometer": i},
+			ReceivedAt:   time.Now().UTC(),
+		})
+	}
+	if err := store.SaveSnapshot(path); err != nil {
+		t.Fatalf("保存快照失败: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("保存后不应留下临时文件")
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatalf("快照文件不存在: %v", err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("快照文件权限应为0600，实际为%v", info.Mode().Perm())
+	}
+
+	// 只恢复最新的数据
+	restored := NewThreadSafeDataStore()
+	count, err := restored.LoadSnapshot(path, 3)
+	if err != nil || count != 3 {
+		t.Fatalf("期望恢复3条，实际为%d: %v", count, err)
+	}
+	latest, _ := restored.GetLatestOne()
+	if latest.MessageID != 5 || latest.SensorCounts["accelerometer"] != 5 {
+		t.Errorf("恢复的数据不正确: %+v", latest)
+	}
+	if first := restored.Get()[0]; first.MessageID != 3 {
+		t.Errorf("期望保留最新的3条，第一条为%d", first.MessageID)
+	}
+}
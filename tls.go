@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 客户端证书（双向TLS）模式
+const (
+	TLSClientAuthNone     = "none"     // 不要求客户端证书（默认）
+	TLSClientAuthOptional = "optional" // 客户端提供证书时必须由 TLS_CLIENT_CA_FILE 签发，手机应用可以不提供
+	TLSClientAuthRequire  = "require"  // 所有客户端都必须提供有效证书
+)
+
+// validTLSClientAuthModes 支持的客户端证书模式
+var validTLSClientAuthModes = []string{TLSClientAuthNone, TLSClientAuthOptional, TLSClientAuthRequire}
+
+// 检查证书文件是否变化的间隔
+const tlsReloadInterval = 10 * time.Second
+
+// 自签名证书的有效期
+const selfSignedValidity = 5 * 365 * 24 * time.Hour
+
+// 全局服务器证书（启用HTTPS时创建）
+var serverCertificates *CertReloader
+
+// CertReloader 服务器证书，证书或私钥文件变化后在后台重新加载，新的连接使用新证书。
+// 重新加载失败时继续使用原证书
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex       sync.RWMutex
+	cert        *tls.Certificate
+	fingerprint string
+	certModTime time.Time
+	keyModTime  time.Time
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewCertReloader 加载证书
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	reloader := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Start 在后台检查证书文件的变化
+func (c *CertReloader) Start(interval time.Duration) {
+	c.stopCh = make(chan struct{})
+	c.doneCh = make(chan struct{})
+	go func() {
+		defer close(c.doneCh)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stopCh:
+				return
+			case <-ticker.C:
+				if err := c.reloadIfChanged(); err != nil {
+					LogError("重新加载TLS证书", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop 停止后台检查
+func (c *CertReloader) Stop() {
+	if c.stopCh != nil {
+		close(c.stopCh)
+		<-c.doneCh
+	}
+}
+
+// Reload 加载证书和私钥
+func (c *CertReloader) Reload() error {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return fmt.Errorf("读取TLS证书失败: %v", err)
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return fmt.Errorf("读取TLS私钥失败: %v", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("加载TLS证书失败: %v", err)
+	}
+
+	c.mutex.Lock()
+	c.cert = &cert
+	c.fingerprint = certificateFingerprint(cert.Certificate[0])
+	c.certModTime, c.keyModTime = certInfo.ModTime(), keyInfo.ModTime()
+	c.mutex.Unlock()
+	return nil
+}
+
+// reloadIfChanged 证书或私钥文件的修改时间变化时重新加载
+func (c *CertReloader) reloadIfChanged() error {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.RLock()
+	unchanged := certInfo.ModTime().Equal(c.certModTime) && keyInfo.ModTime().Equal(c.keyModTime)
+	c.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	// 证书和私钥可能不是同时替换的，不匹配时等待下次检查
+	if err := c.Reload(); err != nil {
+		return err
+	}
+	Logger.Info("TLS证书已重新加载",
+		slog.String("cert_file", c.certFile),
+		slog.String("fingerprint", c.Fingerprint()))
+	return nil
+}
+
+// GetCertificate 供 tls.Config 使用，返回当前的证书
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cert, nil
+}
+
+// Fingerprint 当前证书的SHA-256指纹
+func (c *CertReloader) Fingerprint() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.fingerprint
+}
+
+// certificateFingerprint 证书的SHA-256指纹（冒号分隔的十六进制）
+func certificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// tlsFiles 证书和私钥的路径，使用自签名证书且未指定路径时保存在数据目录中
+func tlsFiles() (string, string) {
+	certFile, keyFile := AppConfig.TLSCertFile, AppConfig.TLSKeyFile
+	if certFile == "" {
+		certFile = filepath.Join(AppConfig.DataDir, "tls", "server.crt")
+	}
+	if keyFile == "" {
+		keyFile = filepath.Join(AppConfig.DataDir, "tls", "server.key")
+	}
+	return certFile, keyFile
+}
+
+// ensureSelfSignedCertificate 证书或私钥不存在时生成自签名证书并保存，返回是否新生成
+func ensureSelfSignedCertificate(certFile, keyFile string) (bool, error) {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if certErr == nil && keyErr == nil {
+		return false, nil
+	}
+	if (certErr != nil && !errors.Is(certErr, fs.ErrNotExist)) || (keyErr != nil && !errors.Is(keyErr, fs.ErrNotExist)) {
+		return false, fmt.Errorf("读取TLS证书失败: %v", errors.Join(certErr, keyErr))
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return false, fmt.Errorf("生成私钥失败: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return false, fmt.Errorf("生成证书序列号失败: %v", err)
+	}
+
+	// 证书中包含本机的主机名和IP，便于客户端按地址校验
+	dnsNames := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		dnsNames = append(dnsNames, hostname)
+	}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	if addrs, err := localIPv4Addrs(); err == nil {
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "sensor-logger-server", Organization: []string{"Sensor Logger Server"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		IPAddresses:           ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return false, fmt.Errorf("生成自签名证书失败: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return false, fmt.Errorf("编码私钥失败: %v", err)
+	}
+
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return false, fmt.Errorf("创建证书目录失败: %v", err)
+		}
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return false, fmt.Errorf("保存私钥失败: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return false, fmt.Errorf("保存证书失败: %v", err)
+	}
+	return true, nil
+}
+
+// loadClientCAs 读取用于校验客户端证书的CA
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取客户端CA失败: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("客户端CA文件中没有有效的证书: %s", path)
+	}
+	return pool, nil
+}
+
+// setupTLS 按配置准备服务器证书（需要时生成自签名证书），启动证书重新加载并返回TLS配置
+func setupTLS() (*tls.Config, error) {
+	certFile, keyFile := tlsFiles()
+	if AppConfig.TLSSelfSigned {
+		created, err := ensureSelfSignedCertificate(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			Logger.Info("已生成自签名证书",
+				slog.String("cert_file", certFile),
+				slog.String("key_file", keyFile))
+		}
+	}
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if AppConfig.TLSClientAuth != TLSClientAuthNone {
+		pool, err := loadClientCAs(AppConfig.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if AppConfig.TLSClientAuth == TLSClientAuthRequire {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	reloader.Start(tlsReloadInterval)
+	serverCertificates = reloader
+	return config, nil
+}
+
+// clientCertificateDevice 已通过校验的客户端证书对应的设备（证书的CommonName为设备ID），
+// 用于不便携带设备令牌的客户端
+func clientCertificateDevice(r *http.Request) (DeviceToken, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return DeviceToken{}, false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return DeviceToken{}, false
+	}
+	return DeviceToken{
+		ID:        "cert:" + cert.SerialNumber.Text(16),
+		DeviceID:  cert.Subject.CommonName,
+		Name:      "客户端证书",
+		CreatedAt: cert.NotBefore,
+	}, true
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelfSignedCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls", "server.crt"), filepath.Join(dir, "tls", "server.key")
+
+	created, err := ensureSelfSignedCertificate(certFile, keyFile)
+	if err != nil || !created {
+		t.Fatalf("生成自签名证书失败: %v", err)
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("私钥文件权限应为0600: %v", err)
+	}
+	// 已存在时不重新生成，指纹保持不变
+	if created, err := ensureSelfSignedCertificate(certFile, keyFile); err != nil || created {
+		t.Errorf("证书已存在时不应重新生成: %v", err)
+	}
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("加载证书失败: %v", err)
+	}
+	cert, _ := reloader.GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("解析证书失败: %v", err)
+	}
+	if err := leaf.VerifyHostname("localhost"); err != nil {
+		t.Errorf("证书应包含localhost: %v", err)
+	}
+	if reloader.Fingerprint() != certificateFingerprint(cert.Certificate[0]) || len(reloader.Fingerprint()) != 95 {
+		t.Errorf("指纹格式不正确: %s", reloader.Fingerprint())
+	}
+
+	// 替换证书后重新加载
+	original := reloader.Fingerprint()
+	os.Remove(certFile)
+	os.Remove(keyFile)
+	if _, err := ensureSelfSignedCertificate(certFile, keyFile); err != nil {
+		t.Fatalf("重新生成证书失败: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+	if err := reloader.reloadIfChanged(); err != nil {
+		t.Fatalf("重新加载证书失败: %v", err)
+	}
+	if reloader.Fingerprint() == original {
+		t.Error("证书替换后指纹应变化")
+	}
+
+	// 加载失败时继续使用原证书
+	os.WriteFile(keyFile, []byte("invalid"), 0600)
+	os.Chtimes(keyFile, later.Add(time.Minute), later.Add(time.Minute))
+	current := reloader.Fingerprint()
+	if err := reloader.reloadIfChanged(); err == nil {
+		t.Error("无效的私钥应返回错误")
+	}
+	if reloader.Fingerprint() != current {
+		t.Error("加载失败时应继续使用原证书")
+	}
+}
+
+func TestClientCertificateDeviceAuth(t *testing.T) {
+	originalMode, originalRegistry := AppConfig.DeviceAuthMode, deviceTokens
+	originalClient := mongoClient
+	originalDedup, originalPipeline := messageDeduplicator, ingestPipeline
+	originalLogging := AppConfig.EnableLogging
+	defer func() {
+		AppConfig.DeviceAuthMode, deviceTokens = originalMode, originalRegistry
+		mongoClient = originalClient
+		messageDeduplicator, ingestPipeline = originalDedup, originalPipeline
+		AppConfig.EnableLogging = originalLogging
+	}()
+	setMongoConnection(nil)
+	ingestPipeline = nil
+	AppConfig.EnableLogging = false
+	AppConfig.DeviceAuthMode = DeviceAuthEnforce
+	deviceTokens = NewTokenRegistry(filepath.Join(t.TempDir(), "device_tokens.json"))
+
+	handler := withDeviceAuth(handleSensorData)
+	post := func(commonName string) int {
+		messageDeduplicator = NewMessageDeduplicator(100)
+		req := httptest.NewRequest(http.MethodPost, "/data", bytes.NewReader(buildTestMessage(1)))
+		if commonName != "" {
+			// 模拟已通过TLS握手校验的客户端证书
+			cert := &x509.Certificate{SerialNumber: big.NewInt(42), Subject: pkix.Name{CommonName: commonName}}
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+
+	if code := post("decode-device"); code != http.StatusOK {
+		t.Errorf("证书与设备一致期望200，实际为%d", code)
+	}
+	if code := post("other-device"); code != http.StatusForbidden {
+		t.Errorf("证书与设备不一致期望403，实际为%d", code)
+	}
+	if code := post(""); code != http.StatusUnauthorized {
+		t.Errorf("没有证书和令牌期望401，实际为%d", code)
+	}
+}
@@ -31,6 +31,23 @@ type ParsedSensorData struct {
 	TimeRange      TimeRange
 	ParsedReadings []HumanReadableSensorData
 	ReceivedAt     time.Time
+
+	// 标注事件，作为会话中的标记保存
+	Markers []SessionMarker
+
+	// 水平精度超过阈值的定位数（drop模式下这些定位不在ParsedReadings中）
+	LowAccuracyFixes int
+
+	// 原始读数（与客户端发送的values一致），持久化时原样保存
+	Payload []SensorReading `json:"-"`
+}
+
+// SessionMarker 会话中的标记（由标注事件生成）
+type SessionMarker struct {
+	Time     time.Time              `bson:"time"`
+	Text     string                 `bson:"text"`
+	Duration int64                  `bson:"duration,omitempty"` // 按压时长（毫秒）
+	Values   map[string]interface{} `bson:"values"`
 }
 
 // TimeRange 表示时间范围
@@ -46,10 +63,17 @@ type HumanReadableSensorData struct {
 	ReadableTime string
 	Values       []SensorValue
 	Accuracy     string
+
+	// 方向传感器的姿态信息（保存四元数和读数中的角度，响应中补全欧拉角、旋转矩阵），其他传感器为空
+	Orientation *OrientationData `json:",omitempty" bson:",omitempty"`
+
+	// 水平精度超过阈值的定位（flag模式）
+	LowAccuracy bool `json:",omitempty" bson:",omitempty"`
 }
 
 // SensorValue 表示传感器值
 type SensorValue struct {
+	Key         string // values中的键名
 	Name        string
 	Value       string
 	Unit        string
@@ -64,6 +88,7 @@ type DashboardData struct {
 	SensorTypeCount int
 	DeviceCount     int
 	LatestData      []HumanReadableSensorData
+	Username        string // 已登录的用户（未启用鉴权时为空）
 }
 
 
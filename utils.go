@@ -1,18 +1,27 @@
 package main
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"net"
+	"strings"
 )
 
-// getLocalIPs 获取本机IP地址
-func getLocalIPs() {
+// localAddr 本机网络接口的地址
+type localAddr struct {
+	IP        net.IP
+	Interface string
+}
+
+// localIPv4Addrs 获取已启用的非回环接口的IPv4地址
+func localIPv4Addrs() ([]localAddr, error) {
 	interfaces, err := net.Interfaces()
 	if err != nil {
-		fmt.Printf("获取网络接口失败: %v\n", err)
-		return
+		return nil, err
 	}
 
+	var result []localAddr
 	for _, iface := range interfaces {
 		// 跳过回环接口和未启用的接口
 		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
@@ -34,12 +43,25 @@ func getLocalIPs() {
 				ip = v.IP
 			}
 
-			// 只显示IPv4地址
+			// 只保留IPv4地址
 			if ip != nil && ip.To4() != nil {
-				fmt.Printf("  %s (%s)\n", ip.String(), iface.Name)
+				result = append(result, localAddr{IP: ip, Interface: iface.Name})
 			}
 		}
 	}
+	return result, nil
+}
+
+// getLocalIPs 显示本机IP地址
+func getLocalIPs() {
+	addrs, err := localIPv4Addrs()
+	if err != nil {
+		fmt.Printf("获取网络接口失败: %v\n", err)
+		return
+	}
+	for _, addr := range addrs {
+		fmt.Printf("  %s (%s)\n", addr.IP.String(), addr.Interface)
+	}
 }
 
 // getFloat64 安全地获取float64值
@@ -73,3 +95,20 @@ func getAccuracyDescription(accuracy int) string {
 		return "未知"
 	}
 }
+
+// safeFileComponent 将任意字符串（会话ID、设备ID等）转换为安全的文件名片段：
+// 非字母数字字符替换为下划线并截断，再附加原值的哈希以免不同的值映射到同一文件名
+func safeFileComponent(value string) string {
+	safe := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, value)
+	if len(safe) > 64 {
+		safe = safe[:64]
+	}
+
+	sum := sha1.Sum([]byte(value))
+	return fmt.Sprintf("%s_%s", safe, hex.EncodeToString(sum[:4]))
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+)
+
+// 校验模式
+const (
+	ValidationReject = "reject" // 存在任何违规时拒绝整条消息
+	ValidationStrip  = "strip"  // 丢弃违规的读数，保留其余读数
+	ValidationWarn   = "warn"   // 只记录违规，照常接收（默认）
+)
+
+// validValidationModes 支持的校验模式
+var validValidationModes = []string{ValidationReject, ValidationStrip, ValidationWarn}
+
+// 响应中最多列出的违规数量
+const maxReportedViolations = 100
+
+// 违规类型
+const (
+	ViolationMissing    = "missing"     // 缺少必填字段
+	ViolationType       = "type"        // 字段类型错误（例如数值字段为字符串）
+	ViolationRange      = "range"       // 数值超出范围
+	ViolationTimeWindow = "time_window" // 时间戳超出允许的时间窗口
+)
+
+// 数据质量警告类型（只记录，不影响校验结果）
+const (
+	WarningQuaternionNorm   = "quaternion_norm"   // 方向四元数未归一化
+	WarningLocationAccuracy = "location_accuracy" // 定位的水平精度超过阈值或无效
+)
+
+// Violation 单条校验违规
+type Violation struct {
+	Index   int    `json:"index"` // 读数下标，消息级字段为-1
+	Path    string `json:"path"`  // 字段路径，例如 payload[3].values.x
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationReport 校验结果
+type ValidationReport struct {
+	Mode            string      `json:"mode"`
+	Valid           bool        `json:"valid"`
+	TotalViolations int         `json:"totalViolations"`
+	Violations      []Violation `json:"violations"`
+	TotalWarnings   int         `json:"totalWarnings,omitempty"`
+	Warnings        []Violation `json:"warnings,omitempty"` // 数据质量警告，任何模式下都不会导致读数被拒绝
+
+	messageInvalid  bool             // 消息级字段违规，无法通过丢弃读数修复
+	invalidReadings map[int]struct{} // 存在违规的读数下标
+}
+
+// ValidationOptions 校验选项
+type ValidationOptions struct {
+	Mode    string
+	MaxAge  time.Duration // 读数时间早于接收时间的最大间隔，0表示不检查
+	MaxSkew time.Duration // 读数时间晚于接收时间的最大间隔，0表示不检查
+
+	MaxHorizontalAccuracy float64 // 定位水平精度的阈值（米），超过时记录数据质量警告，0表示不检查
+}
+
+// ValidationErrorResponse 消息未通过校验时的响应
+type ValidationErrorResponse struct {
+	Error   string           `json:"error"`
+	Message string           `json:"message"`
+	Report  ValidationReport `json:"report"`
+}
+
+// currentValidationOptions 根据配置生成校验选项
+func currentValidationOptions() ValidationOptions {
+	return ValidationOptions{
+		Mode:    AppConfig.ValidationMode,
+		MaxAge:  time.Duration(AppConfig.ValidationMaxAgeHours) * time.Hour,
+		MaxSkew: time.Duration(AppConfig.ValidationMaxSkewSeconds) * time.Second,
+
+		MaxHorizontalAccuracy: AppConfig.LocationMaxHorizontalAccuracy,
+	}
+}
+
+// validateSensorMessage 校验传感器消息，receivedAt 用于判断读数时间戳是否合理
+func validateSensorMessage(message *SensorMessage, receivedAt time.Time, opts ValidationOptions) *ValidationReport {
+	mode := opts.Mode
+	if mode == "" {
+		mode = ValidationWarn
+	}
+	report := &ValidationReport{
+		Mode:            mode,
+		Violations:      make([]Violation, 0),
+		invalidReadings: make(map[int]struct{}),
+	}
+
+	// 消息级字段
+	if strings.TrimSpace(message.DeviceID) == "" {
+		report.add(-1, "deviceId", ViolationMissing, "deviceId不能为空")
+	}
+	if strings.TrimSpace(message.SessionID) == "" {
+		report.add(-1, "sessionId", ViolationMissing, "sessionId不能为空")
+	}
+	if message.MessageID < 0 {
+		report.add(-1, "messageId", ViolationRange, fmt.Sprintf("messageId不能为负数: %d", message.MessageID))
+	}
+
+	var earliest, latest int64
+	if opts.MaxAge > 0 {
+		earliest = receivedAt.Add(-opts.MaxAge).UnixNano()
+	}
+	if opts.MaxSkew > 0 {
+		latest = receivedAt.Add(opts.MaxSkew).UnixNano()
+	}
+
+	for i, reading := range message.Payload {
+		prefix := fmt.Sprintf("payload[%d]", i)
+
+		if strings.TrimSpace(reading.Name) == "" {
+			report.add(i, prefix+".name", ViolationMissing, "传感器名称不能为空")
+		}
+
+		switch {
+		case reading.Time <= 0:
+			report.add(i, prefix+".time", ViolationMissing, "时间戳缺失或无效")
+		case earliest != 0 && reading.Time < earliest:
+			report.add(i, prefix+".time", ViolationTimeWindow,
+				fmt.Sprintf("时间戳 %s 早于允许的时间窗口", time.Unix(0, reading.Time).Format(time.RFC3339)))
+		case latest != 0 && reading.Time > latest:
+			report.add(i, prefix+".time", ViolationTimeWindow,
+				fmt.Sprintf("时间戳 %s 晚于允许的时间窗口", time.Unix(0, reading.Time).Format(time.RFC3339)))
+		}
+
+		// 按传感器描述校验字段，未登记的传感器只校验通用字段
+		descriptor, _ := sensorRegistry.Lookup(reading.Name)
+		for _, field := range descriptor.Fields {
+			path := prefix + ".values." + field.Key
+			value, ok := reading.Values[field.Key]
+			if !ok || value == nil {
+				if field.Required {
+					report.add(i, path, ViolationMissing, fmt.Sprintf("缺少必填字段 %s", field.Key))
+				}
+				continue
+			}
+
+			switch field.Type {
+			case FieldTypeString:
+				if _, ok := value.(string); !ok {
+					report.add(i, path, ViolationType, fmt.Sprintf("%s 必须是字符串，实际为 %T", field.Key, value))
+				}
+				continue
+			case FieldTypeBoolean:
+				if _, ok := value.(bool); !ok {
+					report.add(i, path, ViolationType, fmt.Sprintf("%s 必须是布尔值，实际为 %T", field.Key, value))
+				}
+				continue
+			}
+
+			number, ok := toNumber(value)
+			if !ok {
+				report.add(i, path, ViolationType, fmt.Sprintf("%s 必须是数值，实际为 %T", field.Key, value))
+				continue
+			}
+			minValue, maxValue := field.bounds()
+			if math.IsNaN(number) || number < minValue || number > maxValue {
+				report.add(i, path, ViolationRange,
+					fmt.Sprintf("%s=%v 超出范围 [%v, %v]", field.Key, number, minValue, maxValue))
+			}
+		}
+
+		// 方向四元数应为单位四元数
+		if sensorKey(reading.Name) == "orientation" {
+			if q, ok := quaternionFromValues(reading.Values); ok {
+				if norm := q.Norm(); math.Abs(norm-1) > quaternionNormTolerance {
+					report.warn(i, prefix+".values", WarningQuaternionNorm,
+						fmt.Sprintf("四元数的模为 %.4f，偏离1超过 %v", norm, quaternionNormTolerance))
+				}
+			}
+		}
+
+		// 低精度定位
+		if isLocationReading(reading) {
+			if reason, low := lowAccuracyReason(reading.Values, opts.MaxHorizontalAccuracy); low {
+				report.warn(i, prefix+".values.horizontalAccuracy", WarningLocationAccuracy, reason)
+			}
+		}
+	}
+
+	report.Valid = report.TotalViolations == 0
+	return report
+}
+
+// add 记录一条违规
+func (r *ValidationReport) add(index int, path, code, message string) {
+	r.TotalViolations++
+	if index < 0 {
+		r.messageInvalid = true
+	} else {
+		r.invalidReadings[index] = struct{}{}
+	}
+	if len(r.Violations) < maxReportedViolations {
+		r.Violations = append(r.Violations, Violation{Index: index, Path: path, Code: code, Message: message})
+	}
+}
+
+// warn 记录一条数据质量警告
+func (r *ValidationReport) warn(index int, path, code, message string) {
+	r.TotalWarnings++
+	if len(r.Warnings) < maxReportedViolations {
+		r.Warnings = append(r.Warnings, Violation{Index: index, Path: path, Code: code, Message: message})
+	}
+}
+
+// Rejects 判断按当前模式是否应拒绝整条消息
+func (r *ValidationReport) Rejects() bool {
+	switch r.Mode {
+	case ValidationReject:
+		return !r.Valid
+	case ValidationStrip:
+		// 消息级字段无法通过丢弃读数修复
+		return r.messageInvalid
+	default:
+		return false
+	}
+}
+
+// Apply 按当前模式处理消息：strip模式下丢弃违规读数，返回被丢弃的读数数量
+func (r *ValidationReport) Apply(message *SensorMessage) int {
+	if r.Mode != ValidationStrip || len(r.invalidReadings) == 0 {
+		return 0
+	}
+
+	kept := make([]SensorReading, 0, len(message.Payload)-len(r.invalidReadings))
+	for i, reading := range message.Payload {
+		if _, invalid := r.invalidReadings[i]; !invalid {
+			kept = append(kept, reading)
+		}
+	}
+	rejected := len(message.Payload) - len(kept)
+	message.Payload = kept
+	return rejected
+}
+
+// LogViolations 记录违规和数据质量警告日志
+func (r *ValidationReport) LogViolations(message *SensorMessage) {
+	if r.TotalWarnings > 0 {
+		first := r.Warnings[0]
+		Logger.Warn("传感器数据质量警告",
+			slog.String("device_id", message.DeviceID),
+			slog.Int64("message_id", message.MessageID),
+			slog.Int("warnings", r.TotalWarnings),
+			slog.String("first_path", first.Path),
+			slog.String("first_warning", first.Message))
+	}
+	if r.Valid {
+		return
+	}
+
+	attrs := []any{
+		slog.String("mode", r.Mode),
+		slog.String("device_id", message.DeviceID),
+		slog.Int64("message_id", message.MessageID),
+		slog.Int("violations", r.TotalViolations),
+		slog.Int("invalid_readings", len(r.invalidReadings)),
+	}
+	if len(r.Violations) > 0 {
+		first := r.Violations[0]
+		attrs = append(attrs, slog.String("first_path", first.Path), slog.String("first_error", first.Message))
+	}
+	Logger.Warn("传感器数据校验未通过", attrs...)
+}
+
+// toNumber 将JSON解码得到的值转换为数值，非数值类型返回false
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		number, err := v.Float64()
+		return number, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newValidationTestMessage 创建一条包含两个读数的有效消息
+func newValidationTestMessage(now time.Time) *SensorMessage {
+	return &SensorMessage{
+		MessageID: 1,
+		SessionID: "validation-session",
+		DeviceID:  "validation-device",
+		Payload: []SensorReading{
+			{Name: "accelerometer", Time: now.UnixNano(), Values: map[string]interface{}{"x": 0.1, "y": 0.2, "z": 9.8}},
+			{Name: "location", Time: now.UnixNano(), Values: map[string]interface{}{"latitude": 35.6, "longitude": 139.7}},
+		},
+	}
+}
+
+func TestValidateSensorMessage(t *testing.T) {
+	now := time.Now()
+	opts := ValidationOptions{Mode: ValidationReject, MaxAge: time.Hour, MaxSkew: time.Minute}
+
+	tests := []struct {
+		name   string
+		modify func(message *SensorMessage)
+		index  int
+		path   string
+		code   string
+	}{
+		{"空设备ID", func(m *SensorMessage) { m.DeviceID = "" }, -1, "deviceId", ViolationMissing},
+		{"缺少字段", func(m *SensorMessage) { delete(m.Payload[0].Values, "z") }, 0, "payload[0].values.z", ViolationMissing},
+		{"字符串数值", func(m *SensorMessage) { m.Payload[0].Values["x"] = "abc" }, 0, "payload[0].values.x", ViolationType},
+		{"纬度越界", func(m *SensorMessage) { m.Payload[1].Values["latitude"] = 91.0 }, 1, "payload[1].values.latitude", ViolationRange},
+		{"时间戳为0", func(m *SensorMessage) { m.Payload[1].Time = 0 }, 1, "payload[1].time", ViolationMissing},
+		{"时间戳过旧", func(m *SensorMessage) { m.Payload[0].Time = now.Add(-2 * time.Hour).UnixNano() }, 0, "payload[0].time", ViolationTimeWindow},
+		{"时间戳超前", func(m *SensorMessage) { m.Payload[0].Time = now.Add(time.Hour).UnixNano() }, 0, "payload[0].time", ViolationTimeWindow},
+	}
+
+	if report := validateSensorMessage(newValidationTestMessage(now), now, opts); !report.Valid {
+		t.Fatalf("有效消息不应有违规: %+v", report.Violations)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := newValidationTestMessage(now)
+			tt.modify(message)
+
+			report := validateSensorMessage(message, now, opts)
+			if report.Valid || len(report.Violations) != 1 {
+				t.Fatalf("期望1条违规，实际为%+v", report.Violations)
+			}
+			violation := report.Violations[0]
+			if violation.Index != tt.index || violation.Path != tt.path || violation.Code != tt.code {
+				t.Errorf("期望违规 {%d %s %s}，实际为 %+v", tt.index, tt.path, tt.code, violation)
+			}
+			if !report.Rejects() {
+				t.Error("reject模式下存在违规时应拒绝消息")
+			}
+		})
+	}
+}
+
+func TestValidationReportStrip(t *testing.T) {
+	now := time.Now()
+	message := newValidationTestMessage(now)
+	message.Payload[0].Values["x"] = "abc"
+
+	report := validateSensorMessage(message, now, ValidationOptions{Mode: ValidationStrip})
+	if report.Rejects() {
+		t.Fatal("strip模式下读数违规不应拒绝整条消息")
+	}
+	if rejected := report.Apply(message); rejected != 1 {
+		t.Errorf("期望丢弃1条读数，实际为%d", rejected)
+	}
+	if len(message.Payload) != 1 || message.Payload[0].Name != "location" {
+		t.Errorf("丢弃后剩余读数不正确: %+v", message.Payload)
+	}
+
+	// 消息级字段违规无法通过丢弃读数修复
+	message.DeviceID = ""
+	if report := validateSensorMessage(message, now, ValidationOptions{Mode: ValidationStrip}); !report.Rejects() {
+		t.Error("strip模式下消息级违规应拒绝消息")
+	}
+}
+
+func TestStripModeArchivesStrippedMessage(t *testing.T) {
+	originalMode, originalFileLog, originalLogging := AppConfig.ValidationMode, AppConfig.EnableFileLog, AppConfig.EnableLogging
+	originalClient, originalArchive, originalStore := mongoClient, rawArchive, parsedDataStore
+	originalDedup, originalPipeline := messageDeduplicator, ingestPipeline
+	defer func() {
+		AppConfig.ValidationMode, AppConfig.EnableFileLog, AppConfig.EnableLogging = originalMode, originalFileLog, originalLogging
+		mongoClient, rawArchive, parsedDataStore = originalClient, originalArchive, originalStore
+		messageDeduplicator, ingestPipeline = originalDedup, originalPipeline
+	}()
+	AppConfig.ValidationMode, AppConfig.EnableFileLog, AppConfig.EnableLogging = ValidationStrip, true, false
+	mongoClient, ingestPipeline = nil, nil
+	messageDeduplicator = NewMessageDeduplicator(100)
+	parsedDataStore = NewThreadSafeDataStore()
+	rawArchive = newTestArchive(t, 1<<20, ArchiveCompressNone)
+
+	message := newValidationTestMessage(time.Now())
+	message.Payload[0].Values["x"] = "abc"
+	body, _ := json.Marshal(message)
+	rr := httptest.NewRecorder()
+	handleSensorData(rr, httptest.NewRequest(http.MethodPost, "/data", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("期望状态码200，实际为%d: %s", rr.Code, rr.Body.String())
+	}
+	rawArchive.Close()
+
+	// 归档中只有保留的读数，回放时不会重新写入被丢弃的读数
+	location, found, err := LocateArchivedMessage(rawArchive.opts.Dir, message.SessionID, message.MessageID)
+	if err != nil || !found {
+		t.Fatalf("查找归档消息失败: found=%t err=%v", found, err)
+	}
+	record, err := ReadArchivedRecord(rawArchive.opts.Dir, location)
+	if err != nil {
+		t.Fatalf("读取归档记录失败: %v", err)
+	}
+	archived, err := parseSensorMessage(record.Message)
+	if err != nil {
+		t.Fatalf("解析归档消息失败: %v", err)
+	}
+	if archived.TotalReadings != 1 || archived.Payload[0].Name != "location" {
+		t.Errorf("归档的消息应只包含保留的读数: %+v", archived.Payload)
+	}
+}
+
+func TestValidationReportWarn(t *testing.T) {
+	now := time.Now()
+	message := newValidationTestMessage(now)
+	message.DeviceID = ""
+
+	report := validateSensorMessage(message, now, ValidationOptions{Mode: ValidationWarn})
+	if report.Valid || report.Rejects() {
+		t.Error("warn模式下应报告违规但不拒绝消息")
+	}
+	if rejected := report.Apply(message); rejected != 0 || len(message.Payload) != 2 {
+		t.Error("warn模式下不应丢弃读数")
+	}
+}
+
+func TestValidationQuaternionNormWarning(t *testing.T) {
+	now := time.Now()
+	message := newValidationTestMessage(now)
+	message.Payload = append(message.Payload, SensorReading{
+		Name:   "orientation",
+		Time:   now.UnixNano(),
+		Values: map[string]interface{}{"qw": 0.9, "qx": 0.1, "qy": 0.1, "qz": 0.1},
+	})
+
+	report := validateSensorMessage(message, now, ValidationOptions{Mode: ValidationReject})
+	if !report.Valid || report.Rejects() {
+		t.Fatalf("数据质量警告不应导致拒绝: %+v", report.Violations)
+	}
+	if report.TotalWarnings != 1 || report.Warnings[0].Code != WarningQuaternionNorm || report.Warnings[0].Index != 2 {
+		t.Errorf("期望1条四元数警告: %+v", report.Warnings)
+	}
+
+	// 归一化的四元数没有警告
+	message.Payload[2].Values = map[string]interface{}{"qw": 0.5, "qx": 0.5, "qy": 0.5, "qz": 0.5}
+	if report := validateSensorMessage(message, now, ValidationOptions{Mode: ValidationReject}); report.TotalWarnings != 0 {
+		t.Errorf("不应有警告: %+v", report.Warnings)
+	}
+}
+
+func TestHandleSensorDataValidationReject(t *testing.T) {
+	originalMode := AppConfig.ValidationMode
+	AppConfig.ValidationMode = ValidationReject
+	defer func() { AppConfig.ValidationMode = originalMode }()
+
+	body := []byte(fmt.Sprintf(`{"messageId":1,"sessionId":"s","deviceId":"","payload":[{"name":"accelerometer","time":%d,"values":{"x":"abc","y":0,"z":0}}]}`, time.Now().UnixNano()))
+	req := httptest.NewRequest("POST", "/data", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	handleSensorData(rr, req)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("期望状态码422，实际为%d", rr.Code)
+	}
+
+	var response ValidationErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("响应不是JSON: %v", err)
+	}
+	if response.Error != "validation_failed" || response.Report.TotalViolations != 2 {
+		t.Errorf("校验响应不正确: %+v", response)
+	}
+}